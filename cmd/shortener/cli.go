@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urlshortener/internal/service"
+	"github.com/urlshortener/internal/transfer"
+)
+
+const usage = `usage: shortener [command] [flags]
+
+commands:
+  serve                        run the HTTP server (default)
+  export [-format csv|jsonl] [-workspace slug] [-o file]
+                               write a workspace's links to a file or stdout
+  import [-format csv|jsonl] [-workspace slug] [-policy skip|overwrite|rename] [-dry-run] [file]
+                               read links into a workspace from a file or stdin
+`
+
+// runCommand runs a one-shot subcommand and returns the process exit status
+func runCommand(command string, args []string, urlService service.URLService, workspaces service.WorkspaceService) int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch command {
+	case "export":
+		err = runExport(ctx, args, urlService, workspaces)
+	case "import":
+		err = runImport(ctx, args, urlService, workspaces)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		return 1
+	}
+	return 0
+}
+
+// runExport implements the export subcommand
+func runExport(ctx context.Context, args []string, urlService service.URLService, workspaces service.WorkspaceService) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatName := flags.String("format", "csv", "output format: csv or jsonl")
+	workspace := flags.String("workspace", "", "workspace slug (default the default workspace)")
+	output := flags.String("o", "", "output file (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := transfer.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	if err := checkWorkspace(ctx, workspaces, *workspace); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := urlService.ExportLinks(ctx, *workspace, transfer.NewEncoder(w, format)); err != nil {
+		return err
+	}
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		return file.Close()
+	}
+	return nil
+}
+
+// runImport implements the import subcommand
+func runImport(ctx context.Context, args []string, urlService service.URLService, workspaces service.WorkspaceService) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	formatName := flags.String("format", "csv", "input format: csv or jsonl")
+	workspace := flags.String("workspace", "", "workspace slug (default the default workspace)")
+	policyName := flags.String("policy", "skip", "conflict policy: skip, overwrite or rename")
+	dryRun := flags.Bool("dry-run", false, "validate and report without storing anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := transfer.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	policy, err := service.ParseConflictPolicy(*policyName)
+	if err != nil {
+		return err
+	}
+	if err := checkWorkspace(ctx, workspaces, *workspace); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	dec, err := transfer.NewDecoder(r, format)
+	if err != nil {
+		return err
+	}
+	report, err := urlService.ImportLinks(ctx, *workspace, dec, service.ImportOptions{Policy: policy, DryRun: *dryRun})
+	if report != nil {
+		printImportReport(report)
+	}
+	return err
+}
+
+// checkWorkspace makes sure a workspace named on the command line exists;
+// the default workspace always does
+func checkWorkspace(ctx context.Context, workspaces service.WorkspaceService, slug string) error {
+	if slug == "" {
+		return nil
+	}
+	_, err := workspaces.GetWorkspace(ctx, slug)
+	return err
+}
+
+// printImportReport writes a human-readable import summary to stdout
+func printImportReport(report *service.ImportReport) {
+	if report.DryRun {
+		fmt.Println("dry run: nothing was stored")
+	}
+	fmt.Printf("total %d, created %d, skipped %d, overwritten %d, renamed %d, failed %d\n",
+		report.Total, report.Created, report.Skipped, report.Overwritten, report.Renamed, report.Failed)
+	for _, item := range report.Items {
+		switch {
+		case item.Err != nil:
+			fmt.Printf("line %d: %s %s: %v\n", item.Line, item.Outcome, item.Code, item.Err)
+		case item.NewCode != "":
+			fmt.Printf("line %d: %s %s -> %s\n", item.Line, item.Outcome, item.Code, item.NewCode)
+		default:
+			fmt.Printf("line %d: %s %s\n", item.Line, item.Outcome, item.Code)
+		}
+	}
+	if report.Truncated {
+		fmt.Println("(further items omitted)")
+	}
+}
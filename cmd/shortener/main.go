@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,11 +22,16 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/urlshortener/configs"
+	"github.com/urlshortener/internal/geoip"
 	"github.com/urlshortener/internal/handler"
+	"github.com/urlshortener/internal/metadata"
 	"github.com/urlshortener/internal/metrics"
 	middlewareMetrics "github.com/urlshortener/internal/middleware"
 	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/security"
 	"github.com/urlshortener/internal/service"
+	"github.com/urlshortener/internal/urlcanon"
+	"github.com/urlshortener/internal/webhook"
 )
 
 func main() {
@@ -31,6 +39,16 @@ func main() {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
 	logger.SetLevel(logrus.InfoLevel)
+
+	// Any subcommand other than serve runs once and exits; keep its output
+	// free of startup noise
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	if command != "serve" {
+		logger.SetLevel(logrus.WarnLevel)
+	}
 	
 	logger.Info("Starting URL Shortener application...")
 	
@@ -54,7 +72,10 @@ func main() {
 
 	// Initialize repository
 	logger.Info("Initializing repository...")
-	repository, err := repo.NewSQLiteRepository(config.DBPath, metricsInstance)
+	repository, err := repo.NewSQLiteRepository(config.DBPath, metricsInstance, repo.Timeouts{
+		Read:  config.DBReadTimeout,
+		Write: config.DBWriteTimeout,
+	})
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to initialize repository")
 	}
@@ -70,10 +91,99 @@ func main() {
 
 	// Initialize service
 	logger.Info("Initializing service and handler...")
-	urlService := service.NewURLService(repository, config.BaseURL)
+	canonicalizer := urlcanon.New(urlcanon.Options{
+		MaxLength:           config.MaxURLLength,
+		StripFragment:       config.URLStripFragment,
+		SortQuery:           config.URLSortQuery,
+		StripTrackingParams: config.URLStripTracking,
+	})
+	securityConfig := security.DefaultSecurityConfig()
+	securityConfig.MaxURLLength = config.MaxURLLength
+	if config.BlockedDomains != nil {
+		securityConfig.BlockedDomains = config.BlockedDomains
+	}
+	securityConfig.AllowedDomains = config.AllowedDomains
+	securityConfig.RequireHTTPS = config.RequireHTTPS
+	// Destinations are only fetched while serving, by workers started below
+	var metadataService service.MetadataService
+	if command == "serve" && config.MetadataFetch {
+		metadataService = service.NewMetadataService(repository, metadata.NewFetcher(metadata.Options{
+			Timeout:  config.MetadataTimeout,
+			MaxBytes: int64(config.MetadataMaxBytes),
+		}), config.MetadataQueueSize, logger)
+	}
+	quotaService := service.NewQuotaService(repository, metricsInstance)
+	// Events are queued by every command, but only delivered while serving
+	webhookService := service.NewWebhookService(repository, webhook.NewSender(webhook.Options{
+		Timeout:      config.WebhookTimeout,
+		AllowPrivate: config.WebhookAllowPrivate,
+	}), service.WebhookOptions{
+		Timeout:     config.WebhookTimeout,
+		MaxAttempts: config.WebhookMaxAttempts,
+		Retention:   config.WebhookRetention,
+		QueueSize:   config.WebhookQueueSize,
+	}, metricsInstance, logger)
+	urlService := service.NewURLService(repository, service.Config{
+		BaseURL:               config.BaseURL,
+		Canonicalizer:         canonicalizer,
+		Validator:             security.NewURLValidator(securityConfig, canonicalizer),
+		DefaultRedirectStatus: config.RedirectStatus,
+		UTMTemplates:          repository,
+		Domains:               repository,
+		AlwaysInterstitial:    config.AlwaysInterstitial,
+		Metadata:              metadataService,
+		Quotas:                quotaService,
+		Events:                webhookService,
+	})
+
+	workspaceService := service.NewWorkspaceService(repository)
+
+	if command != "serve" {
+		status := runCommand(command, os.Args[2:], urlService, workspaceService)
+		repository.Close()
+		os.Exit(status)
+	}
 
 	// Initialize handler
-	urlHandler := handler.NewURLHandler(urlService, metricsInstance, logger)
+	accessSigner, err := security.NewTokenSigner([]byte(config.LinkAccessSecret))
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to create link access signer")
+	}
+	if config.LinkAccessSecret == "" {
+		logger.Warn("LINK_ACCESS_SECRET is not set; unlocked links must be unlocked again after a restart")
+	}
+	trustedProxies, err := security.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid TRUSTED_PROXIES")
+	}
+	var geoDB *geoip.DB
+	var geoLocator handler.GeoLocator
+	if config.GeoIPDBPath != "" {
+		if geoDB, err = geoip.Open(config.GeoIPDBPath); err != nil {
+			logger.WithError(err).Fatal("Failed to load GeoIP database")
+		}
+		geoLocator = geoDB
+		logger.WithField("path", config.GeoIPDBPath).Info("GeoIP database loaded")
+	}
+	urlHandler := handler.NewURLHandler(urlService, metricsInstance, logger, handler.RedirectConfig{
+		AccessSigner:     accessSigner,
+		AccessTTL:        config.LinkAccessTTL,
+		PasswordAttempts: config.LinkPasswordAttempts,
+		SecureCookies:    strings.HasPrefix(config.BaseURL, "https://"),
+		Metadata:         metadataService,
+		GeoIP:            geoLocator,
+		TrustedProxies:   trustedProxies,
+	})
+	batchHandler := handler.NewBatchHandler(urlService, metricsInstance, logger, config.BatchMaxSize)
+	adminHandler := handler.NewAdminHandler(urlService, logger)
+	idempotencyService := service.NewIdempotencyService(repository, config.IdempotencyTTL)
+	utmTemplateHandler := handler.NewUTMTemplateHandler(service.NewUTMTemplateService(repository), logger)
+	catalogHandler := handler.NewCatalogHandler(service.NewCatalogService(repository), logger)
+	domainHandler := handler.NewDomainHandler(service.NewDomainService(repository, config.BaseURL), logger)
+	qrHandler := handler.NewQRHandler(urlService, logger)
+	workspaceHandler := handler.NewWorkspaceHandler(workspaceService, logger)
+	quotaHandler := handler.NewQuotaHandler(quotaService, logger)
+	webhookHandler := handler.NewWebhookHandler(webhookService, logger)
 	logger.Info("Service and handler initialized")
 
 	// Set up router
@@ -104,15 +214,88 @@ func main() {
 	// Metrics endpoint
 	r.Handle("/metrics", promhttp.Handler())
 
-	// API routes
-	r.Post("/shorten", urlHandler.ShortenURL)
-	r.Get("/{code}", urlHandler.RedirectURL)
+	// API routes. Links created with an API key belong to its workspace.
+	authenticate := handler.Authenticate(workspaceService, config.AdminToken, logger)
+	r.With(authenticate, handler.Idempotency(idempotencyService, logger)).Post("/shorten", urlHandler.ShortenURL)
+	r.With(authenticate).Post("/api/v1/links/batch", batchHandler.CreateBatch)
+	r.With(authenticate).Get("/api/v1/usage", quotaHandler.GetUsage)
+	if metadataService != nil {
+		r.With(authenticate).Get("/api/v1/links/{code}/metadata", handler.NewMetadataHandler(metadataService, logger).GetMetadata)
+	}
+
+	// Admin routes
+	r.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(authenticate)
+		r.Group(func(r chi.Router) {
+			r.Use(handler.RequireAdmin(logger))
+			r.Get("/links", adminHandler.ListLinks)
+			r.Get("/links/export", adminHandler.ExportLinks)
+			r.Post("/links/import", adminHandler.ImportLinks)
+			r.Patch("/links/{code}", adminHandler.UpdateLink)
+			r.Delete("/links/{code}", adminHandler.DeleteLink)
+			r.Get("/links/{code}/stats", adminHandler.GetClickStats)
+			r.Patch("/links/{code}/variants", adminHandler.SetVariantWeights)
+			r.Get("/folders", catalogHandler.ListFolders)
+			r.Post("/folders", catalogHandler.CreateFolder)
+			r.Patch("/folders/{name}", catalogHandler.RenameFolder)
+			r.Delete("/folders/{name}", catalogHandler.DeleteFolder)
+			r.Get("/tags", catalogHandler.ListTags)
+			r.Get("/tags/{tag}/stats", catalogHandler.GetTagStats)
+			r.Delete("/tags/{tag}", catalogHandler.DeleteTag)
+			r.Get("/domains", domainHandler.ListDomains)
+			r.Post("/domains", domainHandler.CreateDomain)
+			r.Get("/domains/{host}", domainHandler.GetDomain)
+			r.Put("/domains/{host}", domainHandler.UpdateDomain)
+			r.Delete("/domains/{host}", domainHandler.DeleteDomain)
+			r.Get("/teams/{team}/utm-templates", utmTemplateHandler.ListTemplates)
+			r.Get("/teams/{team}/utm-templates/{name}", utmTemplateHandler.GetTemplate)
+			r.Put("/teams/{team}/utm-templates/{name}", utmTemplateHandler.SaveTemplate)
+			r.Delete("/teams/{team}/utm-templates/{name}", utmTemplateHandler.DeleteTemplate)
+			r.Get("/usage", quotaHandler.ListUsage)
+			r.Get("/webhooks", webhookHandler.ListWebhooks)
+			r.Post("/webhooks", webhookHandler.CreateWebhook)
+			r.Get("/webhooks/{id}", webhookHandler.GetWebhook)
+			r.Delete("/webhooks/{id}", webhookHandler.DeleteWebhook)
+			r.Get("/webhooks/{id}/deliveries", webhookHandler.ListDeliveries)
+		})
+		// Workspace admins may not raise their own quotas
+		r.Route("/quotas", func(r chi.Router) {
+			r.Use(security.AdminAuth(config.AdminToken, logger))
+			r.Put("/workspace", quotaHandler.SaveQuota)
+			r.Delete("/workspace", quotaHandler.DeleteQuota)
+			r.Put("/members/{email}", quotaHandler.SaveQuota)
+			r.Delete("/members/{email}", quotaHandler.DeleteQuota)
+		})
+		// Only the admin token manages workspaces
+		r.Route("/workspaces", func(r chi.Router) {
+			r.Use(security.AdminAuth(config.AdminToken, logger))
+			r.Get("/", workspaceHandler.ListWorkspaces)
+			r.Post("/", workspaceHandler.CreateWorkspace)
+			r.Get("/{slug}", workspaceHandler.GetWorkspace)
+			r.Delete("/{slug}", workspaceHandler.DeleteWorkspace)
+			r.Get("/{slug}/members", workspaceHandler.ListMembers)
+			r.Put("/{slug}/members/{email}", workspaceHandler.SaveMember)
+			r.Delete("/{slug}/members/{email}", workspaceHandler.RemoveMember)
+			r.Get("/{slug}/keys", workspaceHandler.ListAPIKeys)
+			r.Post("/{slug}/keys", workspaceHandler.CreateAPIKey)
+			r.Delete("/{slug}/keys/{id}", workspaceHandler.DeleteAPIKey)
+		})
+	})
+	r.Get("/{code}/qr", qrHandler.QRCode)
+	// Any method is redirected so that 307/308 links can forward POSTs
+	r.HandleFunc("/{code}", urlHandler.RedirectURL)
+	r.HandleFunc("/{code}/*", urlHandler.RedirectURL)
+
+	// Start server. Request contexts derive from baseCtx so that in-flight
+	// database queries can be cancelled if graceful shutdown times out.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
 
-	// Start server
 	serverAddr := fmt.Sprintf(":%s", config.ServerPort)
 	server := &http.Server{
-		Addr:    serverAddr,
-		Handler: r,
+		Addr:        serverAddr,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
 
 	// Channel to listen for interrupt signal to terminate server
@@ -130,6 +313,27 @@ func main() {
 		}
 	}()
 
+	// Start fetching destination metadata, delivering webhooks and watching
+	// the GeoIP database
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	if geoDB != nil {
+		go geoDB.Watch(workersCtx, config.GeoIPReloadInterval, logger)
+	}
+	workersDone := make(chan struct{})
+	go func() {
+		defer close(workersDone)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webhookService.Run(workersCtx, config.WebhookWorkers)
+		}()
+		if metadataService != nil {
+			metadataService.Run(workersCtx, config.MetadataWorkers)
+		}
+		wg.Wait()
+	}()
+
 	logger.Info("Server started successfully. Press Ctrl+C to stop.")
 
 	// Wait for interrupt signal
@@ -143,7 +347,13 @@ func main() {
 	// Attempt graceful shutdown
 	if err := server.Shutdown(ctx); err != nil {
 		logger.WithError(err).Error("Server shutdown error")
+		// Abort any queries still running past the deadline
+		cancelBase()
 	}
+	// Links still queued for metadata are left without it; webhook
+	// deliveries stay queued for the next start
+	stopWorkers()
+	<-workersDone
 	logger.Info("Server stopped gracefully")
 }
 
@@ -2,13 +2,62 @@ package configs
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
-	ServerPort string
-	DBPath     string
-	BaseURL    string
+	ServerPort     string
+	DBPath         string
+	BaseURL        string
+	DBReadTimeout  time.Duration
+	DBWriteTimeout time.Duration
+	BatchMaxSize   int
+	AdminToken     string
+	IdempotencyTTL time.Duration
+	RedirectStatus int
+
+	// Destination URL canonicalisation
+	MaxURLLength     int
+	URLStripFragment bool
+	URLSortQuery     bool
+	URLStripTracking bool
+
+	// Destination URL policy. BlockedDomains is nil when unset, leaving
+	// the default list in place.
+	BlockedDomains []string
+	AllowedDomains []string
+	RequireHTTPS   bool
+
+	// Password-protected links
+	LinkAccessSecret     string
+	LinkAccessTTL        time.Duration
+	LinkPasswordAttempts int
+
+	// Link previews
+	AlwaysInterstitial bool
+
+	// Destination metadata fetching
+	MetadataFetch     bool
+	MetadataTimeout   time.Duration
+	MetadataMaxBytes  int
+	MetadataWorkers   int
+	MetadataQueueSize int
+
+	// Outbound webhooks
+	WebhookWorkers      int
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookRetention    time.Duration
+	WebhookAllowPrivate bool
+	WebhookQueueSize    int
+
+	// Geo-targeting and client addresses
+	GeoIPDBPath         string
+	GeoIPReloadInterval time.Duration
+	TrustedProxies      []string
 }
 
 // LoadConfig loads configuration from environment variables
@@ -16,11 +65,80 @@ func LoadConfig() *Config {
 	serverPort := getEnv("PORT", "8080")
 	dbPath := getEnv("DB_PATH", "./urlshortener.db")
 	baseURL := getEnv("BASE_URL", "http://localhost:8080")
+	dbReadTimeout := getEnvDuration("DB_READ_TIMEOUT", 2*time.Second)
+	dbWriteTimeout := getEnvDuration("DB_WRITE_TIMEOUT", 5*time.Second)
+	batchMaxSize := getEnvInt("BATCH_MAX_SIZE", 1000)
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	idempotencyTTL := getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	redirectStatus := getEnvInt("REDIRECT_STATUS", 302)
+	maxURLLength := getEnvInt("MAX_URL_LENGTH", 2048)
+	urlStripFragment := getEnvBool("URL_STRIP_FRAGMENT", false)
+	urlSortQuery := getEnvBool("URL_SORT_QUERY", false)
+	urlStripTracking := getEnvBool("URL_STRIP_TRACKING_PARAMS", false)
+	blockedDomains := getEnvList("BLOCKED_DOMAINS")
+	allowedDomains := getEnvList("ALLOWED_DOMAINS")
+	requireHTTPS := getEnvBool("REQUIRE_HTTPS", false)
+	linkAccessSecret := os.Getenv("LINK_ACCESS_SECRET")
+	linkAccessTTL := getEnvDuration("LINK_ACCESS_TTL", time.Hour)
+	linkPasswordAttempts := getEnvInt("LINK_PASSWORD_ATTEMPTS", 5)
+	alwaysInterstitial := getEnvBool("ALWAYS_INTERSTITIAL", false)
+	metadataFetch := getEnvBool("METADATA_FETCH", true)
+	metadataTimeout := getEnvDuration("METADATA_TIMEOUT", 5*time.Second)
+	metadataMaxBytes := getEnvInt("METADATA_MAX_BYTES", 1<<20)
+	metadataWorkers := getEnvInt("METADATA_WORKERS", 2)
+	metadataQueueSize := getEnvInt("METADATA_QUEUE_SIZE", 100)
+	webhookWorkers := getEnvInt("WEBHOOK_WORKERS", 2)
+	webhookTimeout := getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	webhookMaxAttempts := getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10)
+	webhookRetention := getEnvDuration("WEBHOOK_RETENTION", 7*24*time.Hour)
+	webhookAllowPrivate := getEnvBool("WEBHOOK_ALLOW_PRIVATE", false)
+	webhookQueueSize := getEnvInt("WEBHOOK_QUEUE_SIZE", 1000)
+	geoIPDBPath := os.Getenv("GEOIP_DB_PATH")
+	geoIPReloadInterval := getEnvDuration("GEOIP_RELOAD_INTERVAL", time.Minute)
+	trustedProxies := getEnvList("TRUSTED_PROXIES")
 
 	return &Config{
-		ServerPort: serverPort,
-		DBPath:     dbPath,
-		BaseURL:    baseURL,
+		ServerPort:     serverPort,
+		DBPath:         dbPath,
+		BaseURL:        baseURL,
+		DBReadTimeout:  dbReadTimeout,
+		DBWriteTimeout: dbWriteTimeout,
+		BatchMaxSize:   batchMaxSize,
+		AdminToken:     adminToken,
+		IdempotencyTTL: idempotencyTTL,
+		RedirectStatus: redirectStatus,
+
+		MaxURLLength:     maxURLLength,
+		URLStripFragment: urlStripFragment,
+		URLSortQuery:     urlSortQuery,
+		URLStripTracking: urlStripTracking,
+
+		BlockedDomains: blockedDomains,
+		AllowedDomains: allowedDomains,
+		RequireHTTPS:   requireHTTPS,
+
+		LinkAccessSecret:     linkAccessSecret,
+		LinkAccessTTL:        linkAccessTTL,
+		LinkPasswordAttempts: linkPasswordAttempts,
+
+		AlwaysInterstitial: alwaysInterstitial,
+
+		MetadataFetch:     metadataFetch,
+		MetadataTimeout:   metadataTimeout,
+		MetadataMaxBytes:  metadataMaxBytes,
+		MetadataWorkers:   metadataWorkers,
+		MetadataQueueSize: metadataQueueSize,
+
+		WebhookWorkers:      webhookWorkers,
+		WebhookTimeout:      webhookTimeout,
+		WebhookMaxAttempts:  webhookMaxAttempts,
+		WebhookRetention:    webhookRetention,
+		WebhookAllowPrivate: webhookAllowPrivate,
+		WebhookQueueSize:    webhookQueueSize,
+
+		GeoIPDBPath:         geoIPDBPath,
+		GeoIPReloadInterval: geoIPReloadInterval,
+		TrustedProxies:      trustedProxies,
 	}
 }
 
@@ -31,4 +149,46 @@ func getEnv(key, defaultValue string) string {
 		return defaultValue
 	}
 	return value
-}
\ No newline at end of file
+}
+
+// getEnvDuration retrieves a duration such as "500ms" or "2s" from an
+// environment variable, falling back to the default if unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt retrieves a positive integer from an environment variable,
+// falling back to the default if unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvBool retrieves a boolean such as "true" or "0" from an environment
+// variable, falling back to the default if unset or invalid
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList retrieves a comma-separated list from an environment variable,
+// dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
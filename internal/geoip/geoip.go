@@ -0,0 +1,182 @@
+// Package geoip locates IP addresses using an offline database in the
+// MaxMind DB format, such as GeoLite2 Country or City. The database is read
+// into memory and can be swapped for a newer file while in use.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+)
+
+// Continent codes, as used by MaxMind databases
+var continents = map[string]bool{
+	"AF": true, // Africa
+	"AN": true, // Antarctica
+	"AS": true, // Asia
+	"EU": true, // Europe
+	"NA": true, // North America
+	"OC": true, // Oceania
+	"SA": true, // South America
+}
+
+// Location is where an address is. Empty fields are unknown.
+type Location struct {
+	// Country is an ISO 3166-1 alpha-2 code such as "DE"
+	Country string
+	// Continent is a two-letter code such as "EU"
+	Continent string
+}
+
+// record is the part of a database entry that is read
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry is used when the country an address is in is
+	// unknown, as for some anycast and satellite networks
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+}
+
+// DB looks up addresses in a database file. It is safe for concurrent use,
+// including while the file is reloaded.
+type DB struct {
+	path string
+
+	mu     sync.RWMutex
+	reader *maxminddb.Reader
+	loaded fileStamp
+}
+
+// fileStamp identifies a version of the database file
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// stat returns the stamp of the file at path
+func stat(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
+
+// Open loads the database at path
+func Open(path string) (*DB, error) {
+	db := &DB{path: path}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload reads the database file again. If the new file cannot be read the
+// database already loaded stays in use.
+func (db *DB) Reload() error {
+	stamp, err := stat(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	// The file is read into memory rather than mapped, so that it can be
+	// replaced on disk without affecting lookups in progress
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return fmt.Errorf("failed to load GeoIP database %s: %w", db.path, err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.reader = reader
+	db.loaded = stamp
+	return nil
+}
+
+// Watch reloads the database whenever its file changes, checking every
+// interval until ctx is done. A file that fails to load is reported once
+// and retried when it changes again.
+func (db *DB) Watch(ctx context.Context, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var failed fileStamp
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// A file being replaced may briefly be missing; keep the old one
+			stamp, err := stat(db.path)
+			db.mu.RLock()
+			loaded := db.loaded
+			db.mu.RUnlock()
+			if err != nil || stamp == loaded || stamp == failed {
+				continue
+			}
+			if err := db.Reload(); err != nil {
+				failed = stamp
+				logger.WithError(err).Error("Failed to reload GeoIP database; keeping the previous one")
+				continue
+			}
+			logger.WithField("path", db.path).Info("GeoIP database reloaded")
+		}
+	}
+}
+
+// Lookup returns the location of addr. An address the database does not
+// cover has an empty location.
+func (db *DB) Lookup(addr netip.Addr) (Location, error) {
+	if !addr.IsValid() {
+		return Location{}, nil
+	}
+	db.mu.RLock()
+	reader := db.reader
+	db.mu.RUnlock()
+
+	var rec record
+	if err := reader.Lookup(net.IP(addr.Unmap().AsSlice()), &rec); err != nil {
+		return Location{}, fmt.Errorf("failed to look up %s: %w", addr, err)
+	}
+	country := rec.Country.ISOCode
+	if country == "" {
+		country = rec.RegisteredCountry.ISOCode
+	}
+	return Location{Country: strings.ToUpper(country), Continent: strings.ToUpper(rec.Continent.Code)}, nil
+}
+
+// ParseCountry parses an ISO 3166-1 alpha-2 country code such as "de",
+// returning it in upper case. Only the form of the code is checked.
+func ParseCountry(name string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(name))
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return "", fmt.Errorf("invalid country code %q (want two letters, such as DE)", name)
+	}
+	return code, nil
+}
+
+// ParseContinent parses a continent code such as "eu", returning it in
+// upper case
+func ParseContinent(name string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(name))
+	if !continents[code] {
+		return "", fmt.Errorf("unknown continent %q (want AF, AN, AS, EU, NA, OC or SA)", name)
+	}
+	return code, nil
+}
@@ -0,0 +1,212 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNetwork is an entry written to a test database
+type testNetwork struct {
+	prefix    string
+	country   string
+	continent string
+}
+
+// writeTestDB writes a minimal IPv6 MaxMind DB with 24-bit records holding
+// the given networks, mapping IPv4 networks into ::/96 as MaxMind does
+func writeTestDB(t *testing.T, path string, networks ...testNetwork) {
+	t.Helper()
+
+	// Build the search tree as a binary trie. Records hold a node index,
+	// or -1 for no data, or -2-i for the data of networks[i].
+	type node struct{ records [2]int }
+	nodes := []node{{records: [2]int{-1, -1}}}
+	for i, network := range networks {
+		prefix := netip.MustParsePrefix(network.prefix)
+		addr, bits := prefix.Addr().As16(), prefix.Bits()
+		if prefix.Addr().Is4() {
+			// ::a.b.c.d, not the ::ffff:a.b.c.d that As16 returns
+			v4 := prefix.Addr().As4()
+			addr = [16]byte{12: v4[0], 13: v4[1], 14: v4[2], 15: v4[3]}
+			bits += 96
+		}
+		current := 0
+		for depth := 0; depth < bits; depth++ {
+			bit := (addr[depth/8] >> (7 - depth%8)) & 1
+			if depth == bits-1 {
+				nodes[current].records[bit] = -2 - i
+				break
+			}
+			next := nodes[current].records[bit]
+			if next < 0 {
+				nodes = append(nodes, node{records: [2]int{-1, -1}})
+				next = len(nodes) - 1
+				nodes[current].records[bit] = next
+			}
+			current = next
+		}
+	}
+
+	var data bytes.Buffer
+	offsets := make([]int, len(networks))
+	for i, network := range networks {
+		offsets[i] = data.Len()
+		writeMap(&data, map[string]interface{}{
+			"country":   map[string]interface{}{"iso_code": network.country},
+			"continent": map[string]interface{}{"code": network.continent},
+		})
+	}
+
+	var out bytes.Buffer
+	nodeCount := len(nodes)
+	for _, n := range nodes {
+		for _, record := range n.records {
+			value := record
+			switch {
+			case record == -1:
+				value = nodeCount
+			case record <= -2:
+				value = nodeCount + 16 + offsets[-2-record]
+			}
+			out.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+	writeMap(&out, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint32(24),
+		"ip_version":                  uint32(6),
+		"database_type":               "Test-Country",
+		"binary_format_major_version": uint32(2),
+		"binary_format_minor_version": uint32(0),
+		"build_epoch":                 uint32(time.Now().Unix()),
+	})
+	require.NoError(t, os.WriteFile(path, out.Bytes(), 0o644))
+}
+
+// writeMap encodes a map of strings, maps and uint32s in the MaxMind DB
+// data format
+func writeMap(w *bytes.Buffer, m map[string]interface{}) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w.WriteByte(7<<5 | byte(len(m)))
+	for _, key := range keys {
+		writeString(w, key)
+		switch value := m[key].(type) {
+		case string:
+			writeString(w, value)
+		case uint32:
+			w.WriteByte(6<<5 | 4)
+			w.Write([]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)})
+		case map[string]interface{}:
+			writeMap(w, value)
+		}
+	}
+}
+
+func writeString(w *bytes.Buffer, s string) {
+	w.WriteByte(2<<5 | byte(len(s)))
+	w.WriteString(s)
+}
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestDB(t, path,
+		testNetwork{"81.2.69.0/24", "GB", "EU"},
+		testNetwork{"2.125.160.0/20", "gb", "eu"},
+		testNetwork{"2001:218::/32", "JP", "AS"},
+	)
+	db, err := Open(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		addr     string
+		expected Location
+	}{
+		{"81.2.69.142", Location{Country: "GB", Continent: "EU"}},
+		{"2.125.160.216", Location{Country: "GB", Continent: "EU"}},
+		{"::ffff:81.2.69.1", Location{Country: "GB", Continent: "EU"}},
+		{"2001:218:1::1", Location{Country: "JP", Continent: "AS"}},
+		{"8.8.8.8", Location{}},
+		{"2001:db8::1", Location{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			location, err := db.Lookup(netip.MustParseAddr(tt.addr))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, location)
+		})
+	}
+
+	location, err := db.Lookup(netip.Addr{})
+	require.NoError(t, err)
+	assert.Equal(t, Location{}, location)
+}
+
+func TestOpenInvalid(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "garbage.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o644))
+	_, err = Open(path)
+	assert.Error(t, err)
+}
+
+func TestWatchReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestDB(t, path, testNetwork{"81.2.69.0/24", "GB", "EU"})
+	db, err := Open(path)
+	require.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.Watch(ctx, 10*time.Millisecond, logger)
+
+	// A broken file is ignored and the loaded database kept
+	require.NoError(t, os.WriteFile(path, []byte("truncated"), 0o644))
+	time.Sleep(50 * time.Millisecond)
+	location, err := db.Lookup(netip.MustParseAddr("81.2.69.1"))
+	require.NoError(t, err)
+	assert.Equal(t, "GB", location.Country)
+
+	writeTestDB(t, path, testNetwork{"81.2.69.0/24", "IE", "EU"})
+	assert.Eventually(t, func() bool {
+		location, err := db.Lookup(netip.MustParseAddr("81.2.69.1"))
+		return err == nil && location.Country == "IE"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestParseCodes(t *testing.T) {
+	country, err := ParseCountry(" de ")
+	require.NoError(t, err)
+	assert.Equal(t, "DE", country)
+	for _, bad := range []string{"", "D", "DEU", "D1"} {
+		_, err := ParseCountry(bad)
+		assert.Error(t, err, bad)
+	}
+
+	continent, err := ParseContinent("eu")
+	require.NoError(t, err)
+	assert.Equal(t, "EU", continent)
+	_, err = ParseContinent("XX")
+	assert.Error(t, err)
+}
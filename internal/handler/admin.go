@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+	"github.com/urlshortener/internal/transfer"
+)
+
+// AdminHandler handles administrative endpoints. Routes using it must be
+// protected by RequireAdmin.
+type AdminHandler struct {
+	service service.URLService
+	logger  *logrus.Logger
+}
+
+// NewAdminHandler creates a new AdminHandler
+func NewAdminHandler(service service.URLService, logger *logrus.Logger) *AdminHandler {
+	return &AdminHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// ImportItemResponse describes a link that was not simply created
+type ImportItemResponse struct {
+	Line    int      `json:"line"`
+	Code    string   `json:"code,omitempty"`
+	NewCode string   `json:"new_code,omitempty"`
+	Outcome string   `json:"outcome"`
+	Error   *Problem `json:"error,omitempty"`
+}
+
+// ImportResponse represents the response body for an import
+type ImportResponse struct {
+	DryRun      bool                 `json:"dry_run"`
+	Policy      string               `json:"policy"`
+	Total       int                  `json:"total"`
+	Created     int                  `json:"created"`
+	Skipped     int                  `json:"skipped"`
+	Overwritten int                  `json:"overwritten"`
+	Renamed     int                  `json:"renamed"`
+	Failed      int                  `json:"failed"`
+	Items       []ImportItemResponse `json:"items"`
+	Truncated   bool                 `json:"truncated,omitempty"`
+}
+
+// ClickStatsResponse represents a link's click statistics. Countries
+// counts clicks by ISO 3166-1 alpha-2 code; clicks from unknown countries
+// are only in Clicks.
+type ClickStatsResponse struct {
+	Code          string           `json:"code"`
+	Clicks        int64            `json:"clicks"`
+	LastClickedAt *time.Time       `json:"last_clicked_at,omitempty"`
+	Countries     map[string]int64 `json:"countries"`
+	Variants      []VariantBody    `json:"variants,omitempty"`
+}
+
+// GetClickStats handles GET /api/v1/admin/links/{code}/stats. Links on a
+// branded domain are named with ?domain=.
+func (h *AdminHandler) GetClickStats(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	stats, err := h.service.GetClickStats(r.Context(), workspaceOf(r), r.URL.Query().Get("domain"), code)
+	if err != nil {
+		problem := problemFromError(err)
+		if problem.Status >= http.StatusInternalServerError {
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Error("Failed to get click stats")
+		}
+		respondWithProblem(w, r, problem)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	respondWithJSON(w, http.StatusOK, ClickStatsResponse{
+		Code:          code,
+		Clicks:        stats.Clicks,
+		LastClickedAt: stats.LastClickedAt,
+		Countries:     stats.Countries,
+		Variants:      newVariantBodies(stats.Variants, true),
+	})
+}
+
+// ExportLinks handles the GET /api/v1/admin/links/export endpoint. The
+// format is chosen by ?format=csv|jsonl or the Accept header, defaulting
+// to CSV, and rows are streamed as they are read.
+func (h *AdminHandler) ExportLinks(w http.ResponseWriter, r *http.Request) {
+	format, err := requestFormat(r, r.Header.Get("Accept"))
+	if err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	filename := fmt.Sprintf("links-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// Headers are sent with the first row, so a failure part way through can
+	// only be logged; the truncated file will fail to re-import cleanly
+	if err := h.service.ExportLinks(r.Context(), workspaceOf(r), transfer.NewEncoder(w, format)); err != nil {
+		h.logger.WithFields(logrus.Fields{
+			"error":     err.Error(),
+			"format":    format,
+			"remote_ip": r.RemoteAddr,
+		}).Error("Link export failed")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"format":    format,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Links exported")
+}
+
+// ImportLinks handles the POST /api/v1/admin/links/import endpoint. The
+// body is CSV or JSON Lines, chosen by ?format= or Content-Type. Conflicts
+// are resolved by ?policy=skip|overwrite|rename and ?dry_run=true reports
+// what would happen without storing anything.
+func (h *AdminHandler) ImportLinks(w http.ResponseWriter, r *http.Request) {
+	format, err := requestFormat(r, r.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, err.Error()))
+		return
+	}
+	policy, err := service.ParseConflictPolicy(r.URL.Query().Get("policy"))
+	if err != nil {
+		respondWithError(w, r, err)
+		return
+	}
+	dryRun, err := parseOptionalBool(r.URL.Query().Get("dry_run"))
+	if err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "dry_run must be true or false"))
+		return
+	}
+
+	dec, err := transfer.NewDecoder(r.Body, format)
+	if err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	report, err := h.service.ImportLinks(r.Context(), workspaceOf(r), dec, service.ImportOptions{Policy: policy, DryRun: dryRun})
+	entry := h.logger.WithFields(logrus.Fields{
+		"format":    format,
+		"policy":    policy,
+		"dry_run":   dryRun,
+		"remote_ip": r.RemoteAddr,
+	})
+	if err != nil {
+		entry.WithError(err).Error("Link import failed")
+		respondWithError(w, r, err)
+		return
+	}
+
+	entry.WithFields(logrus.Fields{
+		"total":   report.Total,
+		"created": report.Created,
+		"failed":  report.Failed,
+	}).Info("Links imported")
+	respondWithJSON(w, http.StatusOK, newImportResponse(report))
+}
+
+// newImportResponse converts an import report into its response body
+func newImportResponse(report *service.ImportReport) ImportResponse {
+	response := ImportResponse{
+		DryRun:      report.DryRun,
+		Policy:      string(report.Policy),
+		Total:       report.Total,
+		Created:     report.Created,
+		Skipped:     report.Skipped,
+		Overwritten: report.Overwritten,
+		Renamed:     report.Renamed,
+		Failed:      report.Failed,
+		Items:       make([]ImportItemResponse, len(report.Items)),
+		Truncated:   report.Truncated,
+	}
+	for i, item := range report.Items {
+		response.Items[i] = ImportItemResponse{
+			Line:    item.Line,
+			Code:    item.Code,
+			NewCode: item.NewCode,
+			Outcome: string(item.Outcome),
+		}
+		if item.Err != nil {
+			problem := problemFromError(item.Err)
+			response.Items[i].Error = &problem
+		}
+	}
+	return response
+}
+
+// requestFormat picks the transfer format from ?format= or, failing that,
+// the given media type header, defaulting to CSV
+func requestFormat(r *http.Request, header string) (transfer.Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return transfer.ParseFormat(name)
+	}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		if format, ok := transfer.FormatForMediaType(mediaType); ok {
+			return format, nil
+		}
+	}
+	return transfer.FormatCSV, nil
+}
+
+// parseOptionalBool parses a boolean query parameter, treating "" as false
+func parseOptionalBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
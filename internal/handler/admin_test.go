@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/service"
+	"github.com/urlshortener/internal/transfer"
+)
+
+func TestExportLinks(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewAdminHandler(mockService, newTestLogger())
+
+	mockService.On("ExportLinks", "", mock.Anything).Run(func(args mock.Arguments) {
+		enc := args.Get(1).(transfer.Encoder)
+		enc.Encode(&repo.Link{Code: "abc", OriginalURL: "https://example.com"})
+		enc.Flush()
+	}).Return(nil).Once()
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/links/export", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	w := httptest.NewRecorder()
+
+	handler.ExportLinks(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".jsonl")
+	assert.JSONEq(t, `{"code":"abc","original_url":"https://example.com","clicks":0}`, w.Body.String())
+	mockService.AssertExpectations(t)
+}
+
+func TestImportLinks(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewAdminHandler(mockService, newTestLogger())
+
+	t.Run("report", func(t *testing.T) {
+		mockService.On("ImportLinks", "", mock.Anything, service.ImportOptions{Policy: service.ConflictRename, DryRun: true}).Return(&service.ImportReport{
+			DryRun:  true,
+			Policy:  service.ConflictRename,
+			Total:   3,
+			Created: 1,
+			Renamed: 1,
+			Failed:  1,
+			Items: []service.ImportItem{
+				{Line: 3, Code: "abc", NewCode: "Xy12ab", Outcome: repo.ImportRenamed},
+				{Line: 4, Code: "bad", Outcome: repo.ImportFailed, Err: &service.URLError{Reason: "missing host", Err: service.ErrInvalidURL}},
+			},
+		}, nil).Once()
+
+		body := "code,original_url\nabc,https://example.com\n"
+		req := httptest.NewRequest("POST", "/api/v1/admin/links/import?policy=rename&dry_run=true", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+
+		handler.ImportLinks(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var response ImportResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.True(t, response.DryRun)
+		assert.Equal(t, "rename", response.Policy)
+		assert.Equal(t, 3, response.Total)
+		require.Len(t, response.Items, 2)
+		assert.Equal(t, "Xy12ab", response.Items[0].NewCode)
+		assert.Nil(t, response.Items[0].Error)
+		assert.Equal(t, ProblemTypeInvalidURL, response.Items[1].Error.Type)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, target := range []string{
+			"/api/v1/admin/links/import?policy=merge",
+			"/api/v1/admin/links/import?dry_run=maybe",
+			"/api/v1/admin/links/import?format=xml",
+		} {
+			req := httptest.NewRequest("POST", target, strings.NewReader("code,original_url\n"))
+			w := httptest.NewRecorder()
+
+			handler.ImportLinks(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, target)
+			assert.Equal(t, ProblemTypeInvalidRequest, decodeProblem(t, w).Type)
+		}
+	})
+
+	t.Run("missing CSV header column", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/admin/links/import", strings.NewReader("code,url\n"))
+		w := httptest.NewRecorder()
+
+		handler.ImportLinks(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("storage failure", func(t *testing.T) {
+		mockService.On("ImportLinks", "", mock.Anything, mock.Anything).Return(&service.ImportReport{}, errors.New("disk full")).Once()
+
+		req := httptest.NewRequest("POST", "/api/v1/admin/links/import", strings.NewReader("code,original_url\na,https://a.com\n"))
+		w := httptest.NewRecorder()
+
+		handler.ImportLinks(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		mockService.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/security"
+	"github.com/urlshortener/internal/service"
+)
+
+// WorkspaceHeader names the workspace a request made with the admin token
+// acts in; without it the default workspace is used
+const WorkspaceHeader = "X-Workspace"
+
+// principalKey is the context key under which Authenticate stores the
+// request's principal
+type principalKey struct{}
+
+// PrincipalFrom returns who the request in ctx acts for, or nil for an
+// anonymous request
+func PrincipalFrom(ctx context.Context) *service.Principal {
+	principal, _ := ctx.Value(principalKey{}).(*service.Principal)
+	return principal
+}
+
+// workspaceOf returns the workspace r acts in. Anonymous requests act in
+// the default workspace.
+func workspaceOf(r *http.Request) string {
+	if principal := PrincipalFrom(r.Context()); principal != nil {
+		return principal.Workspace
+	}
+	return ""
+}
+
+// memberOf returns the email of the member whose API key r was made with,
+// or "" for the admin token and anonymous requests
+func memberOf(r *http.Request) string {
+	if principal := PrincipalFrom(r.Context()); principal != nil {
+		return principal.Member
+	}
+	return ""
+}
+
+// Authenticate returns middleware that identifies the caller from a bearer
+// credential. The admin token acts as an admin of the workspace named by
+// the X-Workspace header; an API key acts for the member it was issued to,
+// in their workspace and with their role. Requests without credentials
+// pass through anonymously. Invalid credentials are rejected with 401.
+func Authenticate(workspaces service.WorkspaceService, adminToken string, logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			credential := strings.TrimPrefix(header, "Bearer ")
+			requested := r.Header.Get(WorkspaceHeader)
+
+			var principal *service.Principal
+			switch {
+			case service.IsAPIKey(credential):
+				var err error
+				principal, err = workspaces.Authenticate(r.Context(), credential)
+				if errors.Is(err, service.ErrInvalidAPIKey) {
+					rejectCredential(w, r, logger)
+					return
+				}
+				if err != nil {
+					logger.WithError(err).Error("Failed to check API key")
+					respondWithError(w, r, err)
+					return
+				}
+				if requested != "" && requested != principal.Workspace {
+					respondWithProblem(w, r, newProblem(ProblemTypeForbidden, http.StatusForbidden, "this API key belongs to another workspace"))
+					return
+				}
+			case adminToken != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(adminToken)) == 1:
+				if requested != "" {
+					if _, err := workspaces.GetWorkspace(r.Context(), requested); err != nil {
+						problem := problemFromError(err)
+						if problem.Status == http.StatusNotFound {
+							problem.Detail = "no workspace exists with this slug"
+						}
+						respondWithProblem(w, r, problem)
+						return
+					}
+				}
+				principal = &service.Principal{Workspace: requested, Role: service.RoleAdmin}
+			default:
+				rejectCredential(w, r, logger)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+		})
+	}
+}
+
+// RequireAdmin returns middleware restricting a route to admins of the
+// request's workspace. It must run after Authenticate.
+func RequireAdmin(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := PrincipalFrom(r.Context())
+			if principal == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				respondWithProblem(w, r, newProblem(ProblemTypeUnauthorized, http.StatusUnauthorized, "an admin token or API key is required"))
+				return
+			}
+			if !principal.IsAdmin() {
+				security.LogSecurityEvent(logger, "admin_access_denied", r.RemoteAddr,
+					fmt.Sprintf("Workspace: %s, Member: %s, Path: %s", principal.Workspace, principal.Member, r.URL.Path))
+				respondWithProblem(w, r, newProblem(ProblemTypeForbidden, http.StatusForbidden, "this API key may only create links"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectCredential answers a request whose bearer credential is neither
+// the admin token nor a current API key
+func rejectCredential(w http.ResponseWriter, r *http.Request, logger *logrus.Logger) {
+	security.LogSecurityEvent(logger, "auth_failed", r.RemoteAddr,
+		fmt.Sprintf("Path: %s, Method: %s", r.URL.Path, r.Method))
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	respondWithProblem(w, r, newProblem(ProblemTypeUnauthorized, http.StatusUnauthorized, "the bearer credential is not valid"))
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+// maxBatchItemBytes bounds the encoded size of a single batch item
+const maxBatchItemBytes = 8 << 10
+
+// errBatchTooLarge is returned when a batch exceeds the configured size
+var errBatchTooLarge = errors.New("batch too large")
+
+// BatchHandler handles bulk link creation
+type BatchHandler struct {
+	service  service.URLService
+	metrics  *metrics.Metrics
+	logger   *logrus.Logger
+	maxItems int
+}
+
+// NewBatchHandler creates a new BatchHandler accepting at most maxItems per request
+func NewBatchHandler(service service.URLService, metrics *metrics.Metrics, logger *logrus.Logger, maxItems int) *BatchHandler {
+	return &BatchHandler{
+		service:  service,
+		metrics:  metrics,
+		logger:   logger,
+		maxItems: maxItems,
+	}
+}
+
+// BatchItem is a single entry of a batch request. It may be given either
+// as a full object or as a bare URL string.
+type BatchItem ShortenURLRequest
+
+// UnmarshalJSON accepts either "https://..." or {"url": "https://...", ...}
+func (b *BatchItem) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &b.URL)
+	}
+	return json.Unmarshal(data, (*ShortenURLRequest)(b))
+}
+
+// BatchItemResult is the outcome of one batch item. On success the fields
+// of ShortenURLResponse are present; on failure Error holds a problem.
+type BatchItemResult struct {
+	Index int `json:"index"`
+	*ShortenURLResponse
+	Error *Problem `json:"error,omitempty"`
+}
+
+// BatchResponse represents the response body for a batch request
+type BatchResponse struct {
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// CreateBatch handles the POST /api/v1/links/batch endpoint. The body is
+// either a JSON array or, with Content-Type application/x-ndjson, one JSON
+// value per line.
+func (h *BatchHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, int64(h.maxItems)*maxBatchItemBytes)
+	items, err := decodeBatch(body, isNDJSON(r), h.maxItems)
+	if err != nil {
+		h.logger.WithFields(logrus.Fields{
+			"error":      err.Error(),
+			"remote_ip":  r.RemoteAddr,
+			"user_agent": r.UserAgent(),
+		}).Warn("Invalid batch request")
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.Is(err, errBatchTooLarge) || errors.As(err, &maxBytesErr) {
+			respondWithProblem(w, r, newProblem(ProblemTypeBatchTooLarge, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("a batch may contain at most %d items", h.maxItems)))
+			return
+		}
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	reqs := make([]service.ShortenRequest, len(items))
+	for i, item := range items {
+		reqs[i] = ShortenURLRequest(item).toService()
+		reqs[i].Workspace = workspaceOf(r)
+		reqs[i].Member = memberOf(r)
+	}
+
+	results, err := h.service.ShortenBatch(r.Context(), reqs)
+	if err != nil {
+		h.logger.WithFields(logrus.Fields{
+			"error":     err.Error(),
+			"items":     len(reqs),
+			"remote_ip": r.RemoteAddr,
+		}).Error("Failed to process batch")
+		respondWithError(w, r, err)
+		return
+	}
+
+	response := BatchResponse{
+		Total:   len(results),
+		Results: make([]BatchItemResult, len(results)),
+	}
+	for i, result := range results {
+		response.Results[i].Index = i
+		if result.Err != nil {
+			problem := problemFromError(result.Err)
+			response.Results[i].Error = &problem
+			response.Failed++
+			continue
+		}
+		created := newShortenURLResponse(result.Result)
+		response.Results[i].ShortenURLResponse = &created
+		response.Succeeded++
+		h.metrics.RecordURLShortened()
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"total":      response.Total,
+		"succeeded":  response.Succeeded,
+		"failed":     response.Failed,
+		"remote_ip":  r.RemoteAddr,
+		"user_agent": r.UserAgent(),
+	}).Info("Batch processed")
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// isNDJSON reports whether the request body is newline-delimited JSON
+func isNDJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-ndjson" || mediaType == "application/jsonl"
+}
+
+// decodeBatch reads up to maxItems batch items from body
+func decodeBatch(body io.Reader, ndjson bool, maxItems int) ([]BatchItem, error) {
+	var items []BatchItem
+	var err error
+	if ndjson {
+		items, err = decodeNDJSON(body, maxItems)
+	} else {
+		items, err = decodeJSONArray(body, maxItems)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("batch must contain at least one item")
+	}
+	return items, nil
+}
+
+// decodeJSONArray streams items out of a JSON array
+func decodeJSONArray(body io.Reader, maxItems int) ([]BatchItem, error) {
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("request body must be a JSON array")
+	}
+
+	var items []BatchItem
+	for dec.More() {
+		if len(items) == maxItems {
+			return nil, errBatchTooLarge
+		}
+		var item BatchItem
+		if err := dec.Decode(&item); err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(items), err)
+		}
+		items = append(items, item)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("unterminated JSON array: %w", err)
+	}
+	return items, nil
+}
+
+// decodeNDJSON reads one item per non-blank line
+func decodeNDJSON(body io.Reader, maxItems int) ([]BatchItem, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxBatchItemBytes)
+
+	var items []BatchItem
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(items) == maxItems {
+			return nil, errBatchTooLarge
+		}
+		var item BatchItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line exceeds %d bytes", maxBatchItemBytes)
+		}
+		return nil, err
+	}
+	return items, nil
+}
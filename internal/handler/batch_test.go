@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+func TestCreateBatch(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewBatchHandler(mockService, metrics.NewMetrics(), newTestLogger(), 3)
+
+	results := []service.BatchResult{
+		{Result: &service.ShortenResult{Code: "abc123", ShortURL: "http://localhost:8081/abc123", OriginalURL: "http://example.com"}},
+		{Err: &service.URLError{Reason: "missing host", Err: service.ErrInvalidURL}},
+	}
+
+	t.Run("JSON array with strings and objects", func(t *testing.T) {
+		mockService.On("ShortenBatch", []service.ShortenRequest{
+			{URL: "http://example.com"},
+			{URL: "bad", Alias: "mine", Tags: []string{"email"}},
+		}).Return(results, nil).Once()
+
+		body := `["http://example.com", {"url": "bad", "alias": "mine", "tags": ["email"]}]`
+		req := httptest.NewRequest("POST", "/api/v1/links/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		handler.CreateBatch(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var response BatchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 2, response.Total)
+		assert.Equal(t, 1, response.Succeeded)
+		assert.Equal(t, 1, response.Failed)
+		assert.Equal(t, "abc123", response.Results[0].Code)
+		assert.Nil(t, response.Results[0].Error)
+		assert.Equal(t, 1, response.Results[1].Index)
+		assert.Nil(t, response.Results[1].ShortenURLResponse)
+		assert.Equal(t, ProblemTypeInvalidURL, response.Results[1].Error.Type)
+
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("NDJSON stream", func(t *testing.T) {
+		mockService.On("ShortenBatch", []service.ShortenRequest{
+			{URL: "http://example.com"},
+			{URL: "bad"},
+		}).Return(results, nil).Once()
+
+		body := "{\"url\": \"http://example.com\"}\n\n\"bad\"\n"
+		req := httptest.NewRequest("POST", "/api/v1/links/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+
+		handler.CreateBatch(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("batch too large", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/links/batch", strings.NewReader(`["a.com", "b.com", "c.com", "d.com"]`))
+		w := httptest.NewRecorder()
+
+		handler.CreateBatch(w, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		problem := decodeProblem(t, w)
+		assert.Equal(t, ProblemTypeBatchTooLarge, problem.Type)
+	})
+
+	t.Run("malformed bodies", func(t *testing.T) {
+		for _, tc := range []struct{ contentType, body string }{
+			{"application/json", `{"url": "http://example.com"}`},
+			{"application/json", `[]`},
+			{"application/json", `["a.com", `},
+			{"application/x-ndjson", "{\"url\": \"a.com\"}\nnot json\n"},
+		} {
+			req := httptest.NewRequest("POST", "/api/v1/links/batch", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+
+			handler.CreateBatch(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, tc.body)
+			problem := decodeProblem(t, w)
+			assert.Equal(t, ProblemTypeInvalidRequest, problem.Type)
+		}
+	})
+
+	t.Run("service failure", func(t *testing.T) {
+		mockService.On("ShortenBatch", mock.Anything).Return(nil, errors.New("boom")).Once()
+
+		req := httptest.NewRequest("POST", "/api/v1/links/batch", strings.NewReader(`["a.com"]`))
+		w := httptest.NewRecorder()
+
+		handler.CreateBatch(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		mockService.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,362 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// LinkSummaryBody represents a link in a listing
+type LinkSummaryBody struct {
+	Code          string     `json:"code"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	Tags          []string   `json:"tags,omitempty"`
+	Folder        string     `json:"folder,omitempty"`
+	Domain        string     `json:"domain,omitempty"`
+	Clicks        int64      `json:"clicks"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastClickedAt *time.Time `json:"last_clicked_at,omitempty"`
+}
+
+// LinkListResponse represents one page of a link listing
+type LinkListResponse struct {
+	Links []LinkSummaryBody `json:"links"`
+	// NextCursor is passed as ?cursor= to fetch the following page;
+	// omitted on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// LinkUpdateRequest represents the request body for reorganising a link.
+// Omitted fields are left as they are.
+type LinkUpdateRequest struct {
+	// Tags replaces all of the link's tags
+	Tags *[]string `json:"tags"`
+	// Folder moves the link into a folder; "" takes it out of its folder
+	Folder *string `json:"folder"`
+}
+
+// ListLinks handles GET /api/v1/admin/links. Links are filtered by every
+// ?tag= given and by ?folder=, newest first.
+func (h *AdminHandler) ListLinks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := service.LinkFilter{
+		Workspace: workspaceOf(r),
+		Tags:      query["tag"],
+		Folder:    query.Get("folder"),
+		Cursor:    query.Get("cursor"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid limit: must be a number"))
+			return
+		}
+	}
+
+	page, err := h.service.ListLinks(r.Context(), filter)
+	if err != nil {
+		problem := problemFromError(err)
+		if problem.Status >= http.StatusInternalServerError {
+			h.logger.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Failed to list links")
+		}
+		respondWithProblem(w, r, problem)
+		return
+	}
+
+	response := LinkListResponse{Links: make([]LinkSummaryBody, len(page.Links)), NextCursor: page.NextCursor}
+	for i, link := range page.Links {
+		response.Links[i] = LinkSummaryBody{
+			Code:          link.Code,
+			ShortURL:      link.ShortURL,
+			OriginalURL:   link.OriginalURL,
+			Tags:          link.Tags,
+			Folder:        link.Folder,
+			Domain:        link.Domain,
+			Clicks:        link.Clicks,
+			CreatedAt:     link.CreatedAt,
+			LastClickedAt: link.LastClickedAt,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// UpdateLink handles PATCH /api/v1/admin/links/{code}, replacing the
+// link's tags or moving it to another folder. Links on a branded domain
+// are named with ?domain=.
+func (h *AdminHandler) UpdateLink(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	var body LinkUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	result, err := h.service.UpdateLink(r.Context(), workspaceOf(r), r.URL.Query().Get("domain"), code, service.LinkUpdate{Tags: body.Tags, Folder: body.Folder})
+	if err != nil {
+		problem := problemFromError(err)
+		if problem.Status >= http.StatusInternalServerError {
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Error("Failed to update link")
+		}
+		respondWithProblem(w, r, problem)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"code":      code,
+		"tags":      result.Tags,
+		"folder":    result.Folder,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Link updated")
+	respondWithJSON(w, http.StatusOK, newShortenURLResponse(result))
+}
+
+// DeleteLink handles DELETE /api/v1/admin/links/{code}, removing the link
+// and its clicks. Links on a branded domain are named with ?domain=.
+func (h *AdminHandler) DeleteLink(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	result, err := h.service.DeleteLink(r.Context(), workspaceOf(r), r.URL.Query().Get("domain"), code)
+	if err != nil {
+		problem := problemFromError(err)
+		if problem.Status >= http.StatusInternalServerError {
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Error("Failed to delete link")
+		}
+		respondWithProblem(w, r, problem)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"code":         code,
+		"domain":       result.Domain,
+		"original_url": result.OriginalURL,
+		"remote_ip":    r.RemoteAddr,
+	}).Info("Link deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CatalogHandler handles the folder and tag endpoints. Routes using it
+// must be protected by RequireAdmin.
+type CatalogHandler struct {
+	service service.CatalogService
+	logger  *logrus.Logger
+}
+
+// NewCatalogHandler creates a new CatalogHandler
+func NewCatalogHandler(service service.CatalogService, logger *logrus.Logger) *CatalogHandler {
+	return &CatalogHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// FolderRequest represents the request body for creating or renaming a
+// folder
+type FolderRequest struct {
+	Name string `json:"name"`
+}
+
+// FolderResponse represents a folder
+type FolderResponse struct {
+	Name      string    `json:"name"`
+	Links     int64     `json:"links"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FolderListResponse represents every folder
+type FolderListResponse struct {
+	Folders []FolderResponse `json:"folders"`
+}
+
+// TagStatsResponse represents a tag with the combined clicks of its links
+type TagStatsResponse struct {
+	Name          string     `json:"name"`
+	Links         int64      `json:"links"`
+	Clicks        int64      `json:"clicks"`
+	LastClickedAt *time.Time `json:"last_clicked_at,omitempty"`
+}
+
+// TagClickStatsResponse represents a single tag's statistics. Countries
+// counts clicks by ISO 3166-1 alpha-2 code; clicks from unknown countries
+// are only in Clicks.
+type TagClickStatsResponse struct {
+	TagStatsResponse
+	Countries map[string]int64 `json:"countries"`
+}
+
+// TagListResponse represents every tag
+type TagListResponse struct {
+	Tags []TagStatsResponse `json:"tags"`
+}
+
+// ListFolders handles GET /api/v1/admin/folders
+func (h *CatalogHandler) ListFolders(w http.ResponseWriter, r *http.Request) {
+	folders, err := h.service.ListFolders(r.Context(), workspaceOf(r))
+	if err != nil {
+		h.respondWithError(w, r, err, "folder")
+		return
+	}
+
+	response := FolderListResponse{Folders: make([]FolderResponse, len(folders))}
+	for i, folder := range folders {
+		response.Folders[i] = newFolderResponse(folder)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// CreateFolder handles POST /api/v1/admin/folders
+func (h *CatalogHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	var body FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	folder, err := h.service.CreateFolder(r.Context(), workspaceOf(r), body.Name)
+	if err != nil {
+		h.respondWithError(w, r, err, "folder")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"folder":    folder.Name,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Folder created")
+	respondWithJSON(w, http.StatusCreated, newFolderResponse(folder))
+}
+
+// RenameFolder handles PATCH /api/v1/admin/folders/{name}
+func (h *CatalogHandler) RenameFolder(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	var body FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	folder, err := h.service.RenameFolder(r.Context(), workspaceOf(r), name, body.Name)
+	if err != nil {
+		h.respondWithError(w, r, err, "folder")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"folder":    name,
+		"new_name":  folder.Name,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Folder renamed")
+	respondWithJSON(w, http.StatusOK, newFolderResponse(folder))
+}
+
+// DeleteFolder handles DELETE /api/v1/admin/folders/{name}. The folder's
+// links are kept.
+func (h *CatalogHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := h.service.DeleteFolder(r.Context(), workspaceOf(r), name); err != nil {
+		h.respondWithError(w, r, err, "folder")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"folder":    name,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Folder deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListTags handles GET /api/v1/admin/tags
+func (h *CatalogHandler) ListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.service.ListTags(r.Context(), workspaceOf(r))
+	if err != nil {
+		h.respondWithError(w, r, err, "tag")
+		return
+	}
+
+	response := TagListResponse{Tags: make([]TagStatsResponse, len(tags))}
+	for i, tag := range tags {
+		response.Tags[i] = newTagStatsResponse(tag)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// GetTagStats handles GET /api/v1/admin/tags/{tag}/stats
+func (h *CatalogHandler) GetTagStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.service.GetTagStats(r.Context(), workspaceOf(r), chi.URLParam(r, "tag"))
+	if err != nil {
+		h.respondWithError(w, r, err, "tag")
+		return
+	}
+
+	response := TagClickStatsResponse{TagStatsResponse: newTagStatsResponse(stats), Countries: stats.Countries}
+	if response.Countries == nil {
+		response.Countries = map[string]int64{}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// DeleteTag handles DELETE /api/v1/admin/tags/{tag}, removing the tag
+// from every link carrying it
+func (h *CatalogHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	tag := chi.URLParam(r, "tag")
+	if err := h.service.DeleteTag(r.Context(), workspaceOf(r), tag); err != nil {
+		h.respondWithError(w, r, err, "tag")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"tag":       tag,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Tag deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithError logs unexpected failures and sends the problem for err,
+// which concerns a folder or tag as named by kind
+func (h *CatalogHandler) respondWithError(w http.ResponseWriter, r *http.Request, err error, kind string) {
+	problem := problemFromError(err)
+	if problem.Status >= http.StatusInternalServerError {
+		h.logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"path":  r.URL.Path,
+		}).Error("Catalog request failed")
+	}
+	switch problem.Status {
+	case http.StatusNotFound:
+		problem.Detail = fmt.Sprintf("no %s exists with this name", kind)
+	case http.StatusConflict:
+		problem.Detail = fmt.Sprintf("a %s with this name already exists", kind)
+	}
+	respondWithProblem(w, r, problem)
+}
+
+// newFolderResponse converts a folder into its response body
+func newFolderResponse(folder *service.Folder) FolderResponse {
+	return FolderResponse{
+		Name:      folder.Name,
+		Links:     folder.Links,
+		CreatedAt: folder.CreatedAt,
+	}
+}
+
+// newTagStatsResponse converts a tag's statistics into its response body
+func newTagStatsResponse(stats *service.TagStats) TagStatsResponse {
+	return TagStatsResponse{
+		Name:          stats.Name,
+		Links:         stats.Links,
+		Clicks:        stats.Clicks,
+		LastClickedAt: stats.LastClickedAt,
+	}
+}
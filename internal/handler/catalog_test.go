@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockCatalogService is a mock implementation of service.CatalogService
+type MockCatalogService struct {
+	mock.Mock
+}
+
+func (m *MockCatalogService) ListFolders(ctx context.Context, workspace string) ([]*service.Folder, error) {
+	args := m.Called(workspace)
+	folders, _ := args.Get(0).([]*service.Folder)
+	return folders, args.Error(1)
+}
+
+func (m *MockCatalogService) CreateFolder(ctx context.Context, workspace, name string) (*service.Folder, error) {
+	args := m.Called(workspace, name)
+	folder, _ := args.Get(0).(*service.Folder)
+	return folder, args.Error(1)
+}
+
+func (m *MockCatalogService) RenameFolder(ctx context.Context, workspace, name, newName string) (*service.Folder, error) {
+	args := m.Called(workspace, name, newName)
+	folder, _ := args.Get(0).(*service.Folder)
+	return folder, args.Error(1)
+}
+
+func (m *MockCatalogService) DeleteFolder(ctx context.Context, workspace, name string) error {
+	return m.Called(workspace, name).Error(0)
+}
+
+func (m *MockCatalogService) ListTags(ctx context.Context, workspace string) ([]*service.TagStats, error) {
+	args := m.Called(workspace)
+	tags, _ := args.Get(0).([]*service.TagStats)
+	return tags, args.Error(1)
+}
+
+func (m *MockCatalogService) GetTagStats(ctx context.Context, workspace, name string) (*service.TagStats, error) {
+	args := m.Called(workspace, name)
+	stats, _ := args.Get(0).(*service.TagStats)
+	return stats, args.Error(1)
+}
+
+func (m *MockCatalogService) DeleteTag(ctx context.Context, workspace, name string) error {
+	return m.Called(workspace, name).Error(0)
+}
+
+func newCatalogRouter(h *CatalogHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/folders", h.ListFolders)
+	r.Post("/folders", h.CreateFolder)
+	r.Patch("/folders/{name}", h.RenameFolder)
+	r.Delete("/folders/{name}", h.DeleteFolder)
+	r.Get("/tags", h.ListTags)
+	r.Get("/tags/{tag}/stats", h.GetTagStats)
+	r.Delete("/tags/{tag}", h.DeleteTag)
+	return r
+}
+
+func TestListLinksHandler(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewAdminHandler(mockService, newTestLogger())
+
+	t.Run("filters and pages", func(t *testing.T) {
+		mockService.On("ListLinks", service.LinkFilter{Tags: []string{"email", "spring"}, Folder: "launch", Cursor: "9", Limit: 2}).
+			Return(&service.LinkPage{
+				Links:      []service.LinkSummary{{Code: "abc", ShortURL: "http://localhost:8080/abc", Tags: []string{"email", "spring"}, Folder: "launch", Clicks: 4}},
+				NextCursor: "5",
+			}, nil).Once()
+
+		w := httptest.NewRecorder()
+		handler.ListLinks(w, httptest.NewRequest("GET", "/api/v1/admin/links?tag=email&tag=spring&folder=launch&cursor=9&limit=2", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response LinkListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Links, 1)
+		assert.Equal(t, "launch", response.Links[0].Folder)
+		assert.Equal(t, int64(4), response.Links[0].Clicks)
+		assert.Equal(t, "5", response.NextCursor)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("an empty listing is an empty array", func(t *testing.T) {
+		mockService.On("ListLinks", service.LinkFilter{Folder: "empty"}).Return(&service.LinkPage{}, nil).Once()
+
+		w := httptest.NewRecorder()
+		handler.ListLinks(w, httptest.NewRequest("GET", "/api/v1/admin/links?folder=empty", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"links":[]}`, w.Body.String())
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ListLinks(w, httptest.NewRequest("GET", "/api/v1/admin/links?limit=lots", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	})
+}
+
+func TestUpdateLinkHandler(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewAdminHandler(mockService, newTestLogger())
+	update := func(code, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/admin/links/"+code, strings.NewReader(body))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("code", code)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handler.UpdateLink(w, req)
+		return w
+	}
+
+	t.Run("only the fields given change", func(t *testing.T) {
+		folder := ""
+		mockService.On("UpdateLink", "", "", "abc", service.LinkUpdate{Folder: &folder}).
+			Return(&service.ShortenResult{Code: "abc", Tags: []string{"email"}}, nil).Once()
+
+		w := update("abc", `{"folder": ""}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"tags":["email"]`)
+		assert.NotContains(t, w.Body.String(), `"folder"`)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("missing link", func(t *testing.T) {
+		tags := []string{"email"}
+		mockService.On("UpdateLink", "", "", "gone", service.LinkUpdate{Tags: &tags}).
+			Return(nil, fmt.Errorf("%w for code: gone", service.ErrNotFound)).Once()
+
+		w := update("gone", `{"tags": ["email"]}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		w := update("abc", `{`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestDeleteLinkHandler(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewAdminHandler(mockService, newTestLogger())
+	remove := func(target, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", target, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("code", code)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handler.DeleteLink(w, req)
+		return w
+	}
+
+	t.Run("deleted", func(t *testing.T) {
+		mockService.On("DeleteLink", "", "go.example.com", "abc").
+			Return(&service.ShortenResult{Code: "abc", Domain: "go.example.com"}, nil).Once()
+
+		w := remove("/api/v1/admin/links/abc?domain=go.example.com", "abc")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("missing link", func(t *testing.T) {
+		mockService.On("DeleteLink", "", "", "gone").
+			Return(nil, fmt.Errorf("%w for code: gone", service.ErrNotFound)).Once()
+
+		w := remove("/api/v1/admin/links/gone", "gone")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+	mockService.AssertExpectations(t)
+}
+
+func TestCatalogHandler(t *testing.T) {
+	mockService := new(MockCatalogService)
+	router := newCatalogRouter(NewCatalogHandler(mockService, newTestLogger()))
+
+	t.Run("create folder", func(t *testing.T) {
+		mockService.On("CreateFolder", "", "launch").Return(&service.Folder{Name: "launch"}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/folders", strings.NewReader(`{"name":"launch"}`)))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"name":"launch"`)
+	})
+
+	t.Run("folder name taken", func(t *testing.T) {
+		mockService.On("RenameFolder", "", "launch", "drafts").Return(nil, fmt.Errorf("%w: folder drafts", service.ErrConflict)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PATCH", "/folders/launch", strings.NewReader(`{"name":"drafts"}`)))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "a folder with this name already exists")
+	})
+
+	t.Run("delete folder", func(t *testing.T) {
+		mockService.On("DeleteFolder", "", "launch").Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/folders/launch", nil))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("list tags", func(t *testing.T) {
+		mockService.On("ListTags", "").Return([]*service.TagStats{{Name: "email", Links: 2, Clicks: 7}}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/tags", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"tags":[{"name":"email","links":2,"clicks":7}]}`, w.Body.String())
+	})
+
+	t.Run("tag stats", func(t *testing.T) {
+		mockService.On("GetTagStats", "", "email").Return(&service.TagStats{
+			Name: "email", Links: 2, Clicks: 7, Countries: map[string]int64{"DE": 5},
+		}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/tags/email/stats", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"name":"email","links":2,"clicks":7,"countries":{"DE":5}}`, w.Body.String())
+	})
+
+	t.Run("tag stats without countries", func(t *testing.T) {
+		mockService.On("GetTagStats", "", "new").Return(&service.TagStats{Name: "new"}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/tags/new/stats", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"name":"new","links":0,"clicks":0,"countries":{}}`, w.Body.String())
+	})
+
+	t.Run("missing tag", func(t *testing.T) {
+		mockService.On("DeleteTag", "", "gone").Return(fmt.Errorf("%w for tag: gone", service.ErrNotFound)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/tags/gone", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "no tag exists with this name")
+	})
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/urlshortener/internal/service"
+)
+
+// DeviceRuleBody holds one of a link's device rules in request and response
+// bodies
+type DeviceRuleBody struct {
+	OS          []string `json:"os,omitempty"`
+	Device      []string `json:"device,omitempty"`
+	URL         string   `json:"url"`
+	FallbackURL string   `json:"fallback_url,omitempty"`
+}
+
+// deviceRulesToService converts rule bodies into service rules
+func deviceRulesToService(bodies []DeviceRuleBody) []service.DeviceRule {
+	if len(bodies) == 0 {
+		return nil
+	}
+	rules := make([]service.DeviceRule, len(bodies))
+	for i, body := range bodies {
+		rules[i] = service.DeviceRule{OS: body.OS, Device: body.Device, URL: body.URL, FallbackURL: body.FallbackURL}
+	}
+	return rules
+}
+
+// newDeviceRuleBodies returns the bodies for rules, or nil if there are none
+func newDeviceRuleBodies(rules []service.DeviceRule) []DeviceRuleBody {
+	if len(rules) == 0 {
+		return nil
+	}
+	bodies := make([]DeviceRuleBody, len(rules))
+	for i, rule := range rules {
+		bodies[i] = DeviceRuleBody{OS: rule.OS, Device: rule.Device, URL: rule.URL, FallbackURL: rule.FallbackURL}
+	}
+	return bodies
+}
+
+// openAppPageData fills the "open-app" page
+type openAppPageData struct {
+	// DeepLink was checked when the link was created, so it is trusted
+	// despite its custom scheme
+	DeepLink    template.URL
+	FallbackURL string
+}
+
+// serveOpenApp sends the visitor to an app through a page, since a custom
+// scheme cannot be followed as a redirect. The page tries the deep link
+// and moves on to the web destination if the app did not open.
+func serveOpenApp(w http.ResponseWriter, r *http.Request, redirect *service.Redirect) {
+	w.Header().Set("Cache-Control", "private, no-store")
+	renderPage(w, r, http.StatusOK, "open-app", openAppPageData{
+		DeepLink:    template.URL(redirect.DeepLink),
+		FallbackURL: redirect.URL,
+	})
+}
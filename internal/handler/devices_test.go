@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+const iPhoneUA = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+
+func TestDeviceRuleBody(t *testing.T) {
+	var req ShortenURLRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"url": "https://example.com", "device_rules": [
+		{"os": ["ios"], "url": "myapp://home", "fallback_url": "https://apps.apple.com/app/id1"},
+		{"device": ["mobile"], "url": "https://m.example.com"}]}`), &req))
+	assert.Equal(t, []service.DeviceRule{
+		{OS: []string{"ios"}, URL: "myapp://home", FallbackURL: "https://apps.apple.com/app/id1"},
+		{Device: []string{"mobile"}, URL: "https://m.example.com"},
+	}, req.toService().DeviceRules)
+
+	body, err := json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc",
+		DeviceRules: []service.DeviceRule{{OS: []string{"android"}, URL: "https://play.google.com/store"}}}))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"device_rules":[{"os":["android"],"url":"https://play.google.com/store"}]`)
+
+	body, err = json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc"}))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "device_rules")
+}
+
+func TestDeviceAwareRedirect(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+
+	visit := func(code, userAgent string) *httptest.ResponseRecorder {
+		req := newRedirectRequest("GET", "/"+code, code, nil)
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, req)
+		return w
+	}
+
+	t.Run("the user agent is passed to the service", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://play.google.com/store", Status: http.StatusMovedPermanently, DeviceAware: true}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "app", UserAgent: browserUA, ClientID: testClientID + browserUA}).Return(redirect, nil).Once()
+		mockService.On("RecordClick", "", "app", service.Click{}).Return(nil).Once()
+
+		w := visit("app", browserUA)
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+		assert.Equal(t, "https://play.google.com/store", w.Header().Get("Location"))
+		assert.Equal(t, "User-Agent", w.Header().Get("Vary"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("deep links open from a page that falls back to the web", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://apps.apple.com/app/id1", Status: http.StatusFound, DeviceAware: true,
+			DeepLink: "myapp://item/42?ref=short"}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "app", UserAgent: iPhoneUA, ClientID: testClientID + iPhoneUA}).Return(redirect, nil).Once()
+		mockService.On("RecordClick", "", "app", service.Click{}).Return(nil).Once()
+
+		w := visit("app", iPhoneUA)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		assert.Equal(t, "User-Agent", w.Header().Get("Vary"))
+		body := w.Body.String()
+		assert.Contains(t, body, `href="myapp://item/42?ref=short"`)
+		assert.Contains(t, body, `href="https://apps.apple.com/app/id1"`)
+		assert.Contains(t, body, `window.location.href = "myapp://item/42?ref=short"`)
+	})
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// DomainHandler handles the branded domain endpoints. Routes using it must
+// be protected by RequireAdmin.
+type DomainHandler struct {
+	service service.DomainService
+	logger  *logrus.Logger
+}
+
+// NewDomainHandler creates a new DomainHandler
+func NewDomainHandler(service service.DomainService, logger *logrus.Logger) *DomainHandler {
+	return &DomainHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// DomainRequest represents the request body for registering a domain or
+// replacing its settings
+type DomainRequest struct {
+	// Host is the domain name; ignored on update, where the path names it
+	Host string `json:"host"`
+	// NotFoundURL is where visitors asking for an unknown code are sent
+	// instead of the standard 404 page
+	NotFoundURL string `json:"not_found_url,omitempty"`
+	// RedirectStatus is given to links created on the domain without a
+	// redirect_status of their own
+	RedirectStatus int `json:"redirect_status,omitempty"`
+}
+
+// DomainResponse represents a branded domain
+type DomainResponse struct {
+	Host           string    `json:"host"`
+	NotFoundURL    string    `json:"not_found_url,omitempty"`
+	RedirectStatus int       `json:"redirect_status,omitempty"`
+	Links          int64     `json:"links"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// DomainListResponse represents every branded domain
+type DomainListResponse struct {
+	Domains []DomainResponse `json:"domains"`
+}
+
+// ListDomains handles GET /api/v1/admin/domains
+func (h *DomainHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	domains, err := h.service.ListDomains(r.Context(), workspaceOf(r))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	response := DomainListResponse{Domains: make([]DomainResponse, len(domains))}
+	for i, domain := range domains {
+		response.Domains[i] = newDomainResponse(domain)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// CreateDomain handles POST /api/v1/admin/domains. The host must already
+// point at this server for links on it to work.
+func (h *DomainHandler) CreateDomain(w http.ResponseWriter, r *http.Request) {
+	var body DomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	domain := &service.Domain{Host: body.Host, Workspace: workspaceOf(r), NotFoundURL: body.NotFoundURL, RedirectStatus: body.RedirectStatus}
+	if err := h.service.CreateDomain(r.Context(), domain); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"domain":    domain.Host,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Domain created")
+	respondWithJSON(w, http.StatusCreated, newDomainResponse(domain))
+}
+
+// GetDomain handles GET /api/v1/admin/domains/{host}
+func (h *DomainHandler) GetDomain(w http.ResponseWriter, r *http.Request) {
+	domain, err := h.service.GetDomain(r.Context(), workspaceOf(r), chi.URLParam(r, "host"))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newDomainResponse(domain))
+}
+
+// UpdateDomain handles PUT /api/v1/admin/domains/{host}, replacing the
+// domain's settings. Links already created on it keep their redirect
+// status.
+func (h *DomainHandler) UpdateDomain(w http.ResponseWriter, r *http.Request) {
+	var body DomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	domain := &service.Domain{Host: chi.URLParam(r, "host"), Workspace: workspaceOf(r), NotFoundURL: body.NotFoundURL, RedirectStatus: body.RedirectStatus}
+	if err := h.service.UpdateDomain(r.Context(), domain); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"domain":    domain.Host,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Domain updated")
+	respondWithJSON(w, http.StatusOK, newDomainResponse(domain))
+}
+
+// DeleteDomain handles DELETE /api/v1/admin/domains/{host}. Domains with
+// links cannot be deleted.
+func (h *DomainHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	host := chi.URLParam(r, "host")
+	if err := h.service.DeleteDomain(r.Context(), workspaceOf(r), host); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"domain":    host,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Domain deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithError logs unexpected failures and sends the problem for err,
+// which concerns a domain
+func (h *DomainHandler) respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := problemFromError(err)
+	if problem.Status >= http.StatusInternalServerError {
+		h.logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"path":  r.URL.Path,
+		}).Error("Domain request failed")
+	}
+	switch {
+	case problem.Status == http.StatusNotFound:
+		problem.Detail = "no domain exists with this host"
+	case problem.Status == http.StatusConflict && r.Method == http.MethodDelete:
+		problem.Detail = "this domain still has links"
+	case problem.Status == http.StatusConflict:
+		problem.Detail = "a domain with this host already exists"
+	}
+	respondWithProblem(w, r, problem)
+}
+
+// newDomainResponse converts a domain into its response body
+func newDomainResponse(domain *service.Domain) DomainResponse {
+	return DomainResponse{
+		Host:           domain.Host,
+		NotFoundURL:    domain.NotFoundURL,
+		RedirectStatus: domain.RedirectStatus,
+		Links:          domain.Links,
+		CreatedAt:      domain.CreatedAt,
+	}
+}
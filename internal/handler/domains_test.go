@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockDomainService is a mock implementation of service.DomainService
+type MockDomainService struct {
+	mock.Mock
+}
+
+func (m *MockDomainService) ListDomains(ctx context.Context, workspace string) ([]*service.Domain, error) {
+	args := m.Called(workspace)
+	domains, _ := args.Get(0).([]*service.Domain)
+	return domains, args.Error(1)
+}
+
+func (m *MockDomainService) GetDomain(ctx context.Context, workspace, host string) (*service.Domain, error) {
+	args := m.Called(workspace, host)
+	domain, _ := args.Get(0).(*service.Domain)
+	return domain, args.Error(1)
+}
+
+func (m *MockDomainService) CreateDomain(ctx context.Context, domain *service.Domain) error {
+	return m.Called(*domain).Error(0)
+}
+
+func (m *MockDomainService) UpdateDomain(ctx context.Context, domain *service.Domain) error {
+	return m.Called(*domain).Error(0)
+}
+
+func (m *MockDomainService) DeleteDomain(ctx context.Context, workspace, host string) error {
+	return m.Called(workspace, host).Error(0)
+}
+
+func newDomainRouter(h *DomainHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/domains", h.ListDomains)
+	r.Post("/domains", h.CreateDomain)
+	r.Get("/domains/{host}", h.GetDomain)
+	r.Put("/domains/{host}", h.UpdateDomain)
+	r.Delete("/domains/{host}", h.DeleteDomain)
+	return r
+}
+
+func TestDomainHandler(t *testing.T) {
+	mockService := new(MockDomainService)
+	router := newDomainRouter(NewDomainHandler(mockService, newTestLogger()))
+
+	t.Run("create domain", func(t *testing.T) {
+		mockService.On("CreateDomain", service.Domain{Host: "go.example.com", RedirectStatus: http.StatusMovedPermanently}).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/domains", strings.NewReader(`{"host":"go.example.com","redirect_status":301}`)))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"host":"go.example.com"`)
+		assert.Contains(t, w.Body.String(), `"redirect_status":301`)
+	})
+
+	t.Run("host taken", func(t *testing.T) {
+		mockService.On("CreateDomain", service.Domain{Host: "go.example.com"}).Return(fmt.Errorf("%w: domain go.example.com", service.ErrConflict)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/domains", strings.NewReader(`{"host":"go.example.com"}`)))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "a domain with this host already exists")
+	})
+
+	t.Run("update takes the host from the path", func(t *testing.T) {
+		mockService.On("UpdateDomain", service.Domain{Host: "go.example.com", NotFoundURL: "https://example.com/404"}).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/domains/go.example.com", strings.NewReader(`{"host":"other.example.com","not_found_url":"https://example.com/404"}`)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"not_found_url":"https://example.com/404"`)
+	})
+
+	t.Run("list domains", func(t *testing.T) {
+		mockService.On("ListDomains", "").Return([]*service.Domain{{Host: "go.example.com", Links: 3}}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/domains", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"links":3`)
+	})
+
+	t.Run("domains with links are kept", func(t *testing.T) {
+		mockService.On("DeleteDomain", "", "go.example.com").Return(fmt.Errorf("%w: domain go.example.com has 3 links", service.ErrConflict)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/domains/go.example.com", nil))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "this domain still has links")
+	})
+
+	t.Run("missing domain", func(t *testing.T) {
+		mockService.On("GetDomain", "", "gone.example.com").Return(nil, fmt.Errorf("%w for domain: gone.example.com", service.ErrNotFound)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/domains/gone.example.com", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "no domain exists with this host")
+	})
+}
+
+func TestRedirectOnDomain(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+
+	t.Run("the host is passed to the service", func(t *testing.T) {
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: "go.example.com", Code: "abc", ClientID: testClientID}).
+			Return(&service.Redirect{URL: "https://example.com/branded", Status: http.StatusFound, Domain: "go.example.com"}, nil).Once()
+		mockService.On("RecordClick", "go.example.com", "abc", service.Click{}).Return(nil).Once()
+
+		req := newRedirectRequest("GET", "/abc", "abc", nil)
+		req.Host = "go.example.com"
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, req)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/branded", w.Header().Get("Location"))
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("unknown codes go to the domain's 404 page", func(t *testing.T) {
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: "go.example.com", Code: "nope", ClientID: testClientID}).
+			Return(nil, &service.MissingLinkError{Code: "nope", NotFoundURL: "https://example.com/404"}).Once()
+
+		req := newRedirectRequest("GET", "/nope", "nope", nil)
+		req.Host = "go.example.com"
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, req)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/404", w.Header().Get("Location"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	})
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// GeoLocator finds where an address is
+type GeoLocator interface {
+	Lookup(addr netip.Addr) (service.Location, error)
+}
+
+// GeoRuleBody holds one of a link's geo rules in request and response bodies
+type GeoRuleBody struct {
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	URL        string   `json:"url"`
+}
+
+// geoRulesToService converts rule bodies into service rules
+func geoRulesToService(bodies []GeoRuleBody) []service.GeoRule {
+	if len(bodies) == 0 {
+		return nil
+	}
+	rules := make([]service.GeoRule, len(bodies))
+	for i, body := range bodies {
+		rules[i] = service.GeoRule{Countries: body.Countries, Continents: body.Continents, URL: body.URL}
+	}
+	return rules
+}
+
+// newGeoRuleBodies returns the bodies for rules, or nil if there are none
+func newGeoRuleBodies(rules []service.GeoRule) []GeoRuleBody {
+	if len(rules) == 0 {
+		return nil
+	}
+	bodies := make([]GeoRuleBody, len(rules))
+	for i, rule := range rules {
+		bodies[i] = GeoRuleBody{Countries: rule.Countries, Continents: rule.Continents, URL: rule.URL}
+	}
+	return bodies
+}
+
+// locate returns where the visitor making r is. Without a GeoIP database,
+// or if the lookup fails, the location is unknown.
+func (h *URLHandler) locate(r *http.Request) service.Location {
+	if h.geo == nil {
+		return service.Location{}
+	}
+	addr := h.proxies.ClientIP(r)
+	location, err := h.geo.Lookup(addr)
+	if err != nil {
+		h.logger.WithFields(logrus.Fields{
+			"client_ip": addr.String(),
+			"error":     err.Error(),
+		}).Warn("Failed to look up client location")
+		return service.Location{}
+	}
+	return location
+}
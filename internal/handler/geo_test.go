@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/security"
+	"github.com/urlshortener/internal/service"
+)
+
+// stubLocator locates addresses from a fixed table
+type stubLocator map[string]service.Location
+
+func (l stubLocator) Lookup(addr netip.Addr) (service.Location, error) {
+	if addr.String() == "203.0.113.99" {
+		return service.Location{}, errors.New("corrupt database")
+	}
+	return l[addr.String()], nil
+}
+
+func TestGeoRuleBody(t *testing.T) {
+	var req ShortenURLRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"url": "https://example.com", "geo_rules": [
+		{"countries": ["de", "at"], "url": "https://example.de"},
+		{"continents": ["eu"], "url": "https://example.eu"}]}`), &req))
+	assert.Equal(t, []service.GeoRule{
+		{Countries: []string{"de", "at"}, URL: "https://example.de"},
+		{Continents: []string{"eu"}, URL: "https://example.eu"},
+	}, req.toService().GeoRules)
+
+	body, err := json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc",
+		GeoRules: []service.GeoRule{{Countries: []string{"DE"}, URL: "https://example.de"}}}))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"geo_rules":[{"countries":["DE"],"url":"https://example.de"}]`)
+
+	body, err = json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc"}))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "geo_rules")
+}
+
+func TestGeoAwareRedirect(t *testing.T) {
+	proxies, err := security.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	locator := stubLocator{
+		"198.51.100.7": {Country: "DE", Continent: "EU"},
+		"10.1.2.3":     {Country: "ZZ"},
+	}
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{GeoIP: locator, TrustedProxies: proxies})
+
+	visit := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := newRedirectRequest("GET", "/shop", "shop", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, req)
+		return w
+	}
+	germany := service.Location{Country: "DE", Continent: "EU"}
+	redirect := &service.Redirect{URL: "https://example.de", Status: http.StatusFound, GeoAware: true}
+
+	t.Run("the client behind a trusted proxy is located", func(t *testing.T) {
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "shop", Location: germany, ClientID: "198.51.100.7 "}).Return(redirect, nil).Once()
+		mockService.On("RecordClick", "", "shop", service.Click{Country: "DE"}).Return(nil).Once()
+
+		w := visit("10.1.2.3:4000", "198.51.100.7")
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.de", w.Header().Get("Location"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("forwarding headers from others are ignored", func(t *testing.T) {
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "shop", Location: germany, ClientID: "198.51.100.7 "}).Return(redirect, nil).Once()
+		mockService.On("RecordClick", "", "shop", service.Click{Country: "DE"}).Return(nil).Once()
+
+		w := visit("198.51.100.7:4000", "10.1.2.3")
+		assert.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("failed lookups leave the location unknown", func(t *testing.T) {
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "shop", ClientID: "203.0.113.99 "}).Return(redirect, nil).Once()
+		mockService.On("RecordClick", "", "shop", service.Click{}).Return(nil).Once()
+
+		w := visit("203.0.113.99:4000", "")
+		assert.Equal(t, http.StatusFound, w.Code)
+	})
+	mockService.AssertExpectations(t)
+}
+
+func TestGetClickStats(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewAdminHandler(mockService, newTestLogger())
+	lastClicked := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	mockService.On("GetClickStats", "", "", "shop").Return(&service.ClickStats{
+		Clicks: 5, LastClickedAt: &lastClicked, Countries: map[string]int64{"DE": 3, "AT": 1},
+	}, nil)
+	mockService.On("GetClickStats", "", "", "missing").Return(nil, service.ErrNotFound)
+
+	get := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/admin/links/"+code+"/stats", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("code", code)
+		w := httptest.NewRecorder()
+		handler.GetClickStats(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		return w
+	}
+
+	w := get("shop")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"code":"shop","clicks":5,"last_clicked_at":"2024-05-01T08:00:00Z","countries":{"DE":3,"AT":1}}`, w.Body.String())
+
+	w = get("missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockService.AssertExpectations(t)
+}
@@ -2,48 +2,174 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
 	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/security"
 	"github.com/urlshortener/internal/service"
 )
 
+const (
+	// permanentRedirectMaxAge bounds how long a 301 or 308 may be cached, so
+	// that an edited link is eventually picked up by clients that saw it
+	permanentRedirectMaxAge = 24 * time.Hour
+	// defaultAccessTTL is how long an unlocked protected link stays unlocked
+	defaultAccessTTL = time.Hour
+	// defaultPasswordAttempts is how many passwords may be tried per link
+	// per minute
+	defaultPasswordAttempts = 5
+)
+
+// RedirectConfig holds settings for following short links. The zero value
+// is usable.
+type RedirectConfig struct {
+	// AccessSigner signs the cookies that remember an unlocked
+	// password-protected link; nil uses a random key, so visitors must
+	// unlock links again after a restart
+	AccessSigner *security.TokenSigner
+	// AccessTTL is how long an unlocked link stays unlocked
+	AccessTTL time.Duration
+	// PasswordAttempts is how many passwords may be tried per link per
+	// minute
+	PasswordAttempts int
+	// SecureCookies marks cookies Secure; set it when served over HTTPS
+	SecureCookies bool
+	// Metadata describes destinations on preview pages; nil shows the
+	// destination URL alone
+	Metadata service.MetadataService
+	// GeoIP locates visitors for geo rules and click analytics; nil leaves
+	// every visitor's location unknown
+	GeoIP GeoLocator
+	// TrustedProxies are believed about the client address they forward;
+	// nil trusts none and uses the address of the connection
+	TrustedProxies *security.TrustedProxies
+}
+
+// linkAccess holds what the password gate needs
+type linkAccess struct {
+	signer        *security.TokenSigner
+	csrf          *security.CSRFProtection
+	limiter       *security.KeyedLimiter
+	ttl           time.Duration
+	secureCookies bool
+}
+
 // URLHandler handles HTTP requests for URL shortening
 type URLHandler struct {
 	service service.URLService
 	metrics *metrics.Metrics
 	logger  *logrus.Logger
+	access  linkAccess
+	// metadata is optional
+	metadata service.MetadataService
+	// geo is optional
+	geo     GeoLocator
+	proxies *security.TrustedProxies
 }
 
 // NewURLHandler creates a new URLHandler
-func NewURLHandler(service service.URLService, metrics *metrics.Metrics, logger *logrus.Logger) *URLHandler {
+func NewURLHandler(service service.URLService, metrics *metrics.Metrics, logger *logrus.Logger, config RedirectConfig) *URLHandler {
+	if config.AccessSigner == nil {
+		signer, err := security.NewTokenSigner(nil)
+		if err != nil {
+			// Only possible if the system's random source fails
+			panic(err)
+		}
+		config.AccessSigner = signer
+	}
+	if config.AccessTTL <= 0 {
+		config.AccessTTL = defaultAccessTTL
+	}
+	if config.PasswordAttempts <= 0 {
+		config.PasswordAttempts = defaultPasswordAttempts
+	}
+
 	return &URLHandler{
 		service: service,
 		metrics: metrics,
 		logger:  logger,
+		access: linkAccess{
+			signer:        config.AccessSigner,
+			csrf:          security.NewCSRFProtection(security.DefaultSecurityConfig()),
+			limiter:       security.NewKeyedLimiter(time.Minute/time.Duration(config.PasswordAttempts), config.PasswordAttempts),
+			ttl:           config.AccessTTL,
+			secureCookies: config.SecureCookies,
+		},
+		metadata: config.Metadata,
+		geo:      config.GeoIP,
+		proxies:  config.TrustedProxies,
 	}
 }
 
 // ShortenURLRequest represents the request body for shortening a URL
 type ShortenURLRequest struct {
-	URL string `json:"url"`
+	URL            string      `json:"url"`
+	Alias          string      `json:"alias,omitempty"`
+	Tags           []string    `json:"tags,omitempty"`
+	Folder         string      `json:"folder,omitempty"`
+	Dedupe         bool        `json:"dedupe,omitempty"`
+	RedirectStatus int         `json:"redirect_status,omitempty"`
+	PassQuery      bool        `json:"pass_query,omitempty"`
+	PassPath       bool        `json:"pass_path,omitempty"`
+	UTM            *UTMRequest `json:"utm,omitempty"`
+	Password       string      `json:"password,omitempty"`
+	MaxClicks      int         `json:"max_clicks,omitempty"`
+	ActiveFrom     *time.Time  `json:"active_from,omitempty"`
+	ActiveUntil    *time.Time  `json:"active_until,omitempty"`
+	FallbackURL    string      `json:"fallback_url,omitempty"`
+	Interstitial   bool        `json:"interstitial,omitempty"`
+	// Social is shown to crawlers building link previews instead of the
+	// redirect
+	Social *SocialCardBody `json:"social,omitempty"`
+	// DeviceRules send visitors elsewhere depending on their operating
+	// system and device type; the first matching rule wins
+	DeviceRules []DeviceRuleBody `json:"device_rules,omitempty"`
+	// GeoRules send visitors elsewhere depending on their country or
+	// continent, when no device rule matched
+	GeoRules []GeoRuleBody `json:"geo_rules,omitempty"`
+	// Variants split visitors matching no rule between several
+	// destinations by weight
+	Variants []VariantBody `json:"variants,omitempty"`
+	// Domain is the host of the registered domain to create the link on;
+	// omitted uses the default domain
+	Domain string `json:"domain,omitempty"`
 }
 
-// ShortenURLResponse represents the response body for a shortened URL
-type ShortenURLResponse struct {
-	Code     string `json:"code"`
-	ShortURL string `json:"short_url"`
+// UTMRequest holds the UTM parameters to add to a destination. Template
+// names a stored template as "team/name"; other fields override it.
+type UTMRequest struct {
+	Template string `json:"template,omitempty"`
+	UTMParamsBody
 }
 
-// ErrorResponse represents an error response
-type ErrorResponse struct {
-	Error string `json:"error"`
+// ShortenURLResponse represents the response body for a shortened URL
+type ShortenURLResponse struct {
+	Code              string           `json:"code"`
+	ShortURL          string           `json:"short_url"`
+	OriginalURL       string           `json:"original_url"`
+	Tags              []string         `json:"tags,omitempty"`
+	Folder            string           `json:"folder,omitempty"`
+	Domain            string           `json:"domain,omitempty"`
+	Deduplicated      bool             `json:"deduplicated,omitempty"`
+	RedirectStatus    int              `json:"redirect_status"`
+	PassQuery         bool             `json:"pass_query"`
+	PassPath          bool             `json:"pass_path"`
+	PasswordProtected bool             `json:"password_protected"`
+	MaxClicks         int              `json:"max_clicks,omitempty"`
+	ActiveFrom        *time.Time       `json:"active_from,omitempty"`
+	ActiveUntil       *time.Time       `json:"active_until,omitempty"`
+	FallbackURL       string           `json:"fallback_url,omitempty"`
+	Interstitial      bool             `json:"interstitial,omitempty"`
+	Social            *SocialCardBody  `json:"social,omitempty"`
+	DeviceRules       []DeviceRuleBody `json:"device_rules,omitempty"`
+	GeoRules          []GeoRuleBody    `json:"geo_rules,omitempty"`
+	Variants          []VariantBody    `json:"variants,omitempty"`
 }
 
 // HealthResponse represents a health check response
@@ -63,7 +189,7 @@ func (h *URLHandler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 			"remote_ip":  r.RemoteAddr,
 			"user_agent": r.UserAgent(),
 		}).Warn("Invalid request body for URL shortening")
-		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
 		return
 	}
 
@@ -73,32 +199,31 @@ func (h *URLHandler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 			"remote_ip":  r.RemoteAddr,
 			"user_agent": r.UserAgent(),
 		}).Warn("Empty URL provided for shortening")
-		respondWithError(w, http.StatusBadRequest, "URL is required")
-		return
-	}
-
-	// Validate URL format
-	if err := validateURLFormat(req.URL); err != nil {
-		h.logger.WithFields(logrus.Fields{
-			"url":        req.URL,
-			"error":      err.Error(),
-			"remote_ip":  r.RemoteAddr,
-			"user_agent": r.UserAgent(),
-		}).Warn("Invalid URL format provided")
-		respondWithError(w, http.StatusBadRequest, "please provide a valid URL")
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "URL is required"))
 		return
 	}
 
-	// Shorten URL
-	code, shortURL, err := h.service.ShortenURL(req.URL)
+	// Shorten URL in the caller's workspace
+	sreq := req.toService()
+	sreq.Workspace = workspaceOf(r)
+	sreq.Member = memberOf(r)
+	result, err := h.service.ShortenURL(r.Context(), sreq)
 	if err != nil {
-		h.logger.WithFields(logrus.Fields{
+		problem := problemFromError(err)
+		entry := h.logger.WithFields(logrus.Fields{
 			"url":        req.URL,
 			"error":      err.Error(),
 			"remote_ip":  r.RemoteAddr,
 			"user_agent": r.UserAgent(),
-		}).Error("Failed to shorten URL")
-		respondWithError(w, http.StatusBadRequest, err.Error())
+		})
+		if problem.Status >= http.StatusInternalServerError {
+			h.metrics.RecordInternalError()
+			entry.Error("Failed to shorten URL")
+		} else {
+			entry.Warn("URL rejected for shortening")
+		}
+		setRetryAfter(w, err)
+		respondWithProblem(w, r, problem)
 		return
 	}
 
@@ -108,20 +233,73 @@ func (h *URLHandler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 	// Log successful URL shortening
 	h.logger.WithFields(logrus.Fields{
 		"original_url": req.URL,
-		"short_code":   code,
-		"short_url":    shortURL,
+		"short_code":   result.Code,
+		"short_url":    result.ShortURL,
 		"remote_ip":    r.RemoteAddr,
 		"user_agent":   r.UserAgent(),
 	}).Info("URL shortened successfully")
 
 	// Respond with shortened URL
-	respondWithJSON(w, http.StatusOK, ShortenURLResponse{
-		Code:     code,
-		ShortURL: shortURL,
-	})
+	respondWithJSON(w, http.StatusOK, newShortenURLResponse(result))
+}
+
+// toService converts the request body into a service request
+func (req ShortenURLRequest) toService() service.ShortenRequest {
+	sreq := service.ShortenRequest{
+		URL:            req.URL,
+		Alias:          req.Alias,
+		Tags:           req.Tags,
+		Folder:         req.Folder,
+		Domain:         req.Domain,
+		Dedupe:         req.Dedupe,
+		RedirectStatus: req.RedirectStatus,
+		PassQuery:      req.PassQuery,
+		PassPath:       req.PassPath,
+		Password:       req.Password,
+		MaxClicks:      req.MaxClicks,
+		ActiveFrom:     req.ActiveFrom,
+		ActiveUntil:    req.ActiveUntil,
+		FallbackURL:    req.FallbackURL,
+		Interstitial:   req.Interstitial,
+		Social:         req.Social.toService(),
+		DeviceRules:    deviceRulesToService(req.DeviceRules),
+		GeoRules:       geoRulesToService(req.GeoRules),
+		Variants:       variantsToService(req.Variants),
+	}
+	if req.UTM != nil {
+		sreq.UTM = req.UTM.UTMParamsBody.toService()
+		sreq.UTMTemplate = req.UTM.Template
+	}
+	return sreq
 }
 
-// RedirectURL handles the GET /{code} endpoint
+// newShortenURLResponse builds the response body for a created link
+func newShortenURLResponse(result *service.ShortenResult) ShortenURLResponse {
+	return ShortenURLResponse{
+		Code:              result.Code,
+		ShortURL:          result.ShortURL,
+		OriginalURL:       result.OriginalURL,
+		Tags:              result.Tags,
+		Folder:            result.Folder,
+		Domain:            result.Domain,
+		Deduplicated:      result.Deduplicated,
+		RedirectStatus:    result.RedirectStatus,
+		PassQuery:         result.PassQuery,
+		PassPath:          result.PassPath,
+		PasswordProtected: result.PasswordProtected,
+		MaxClicks:         result.MaxClicks,
+		ActiveFrom:        result.ActiveFrom,
+		ActiveUntil:       result.ActiveUntil,
+		FallbackURL:       result.FallbackURL,
+		Interstitial:      result.Interstitial,
+		Social:            newSocialCardBody(result.Social),
+		DeviceRules:       newDeviceRuleBodies(result.DeviceRules),
+		GeoRules:          newGeoRuleBodies(result.GeoRules),
+		Variants:          newVariantBodies(result.Variants, false),
+	}
+}
+
+// RedirectURL handles requests to /{code} and /{code}/*
 func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 	// Get code from URL
 	code := chi.URLParam(r, "code")
@@ -131,37 +309,67 @@ func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 			"user_agent": r.UserAgent(),
 			"path":       r.URL.Path,
 		}).Warn("Empty code provided for redirect")
-		w.WriteHeader(http.StatusBadRequest)
-		http.ServeFile(w, r, "./web/500.html")
+		serveErrorPage(w, r, http.StatusBadRequest)
 		return
 	}
 
-	// Get original URL
-	originalURL, err := h.service.GetOriginalURL(code)
+	// Resolve the destination
+	path := extraPath(r, code)
+	code, rawQuery, preview := splitPreview(code, r.URL.RawQuery)
+	req := service.RedirectRequest{Host: r.Host, Code: code, Path: path, RawQuery: rawQuery, UserAgent: r.UserAgent(), Location: h.locate(r),
+		Variant: assignedVariant(r), ClientID: h.clientID(r)}
+	redirect, err := h.service.ResolveRedirect(r.Context(), req)
 	if err != nil {
-		if isNotFoundError(err) {
-			h.metrics.RecordURLNotFound()
-			h.logger.WithFields(logrus.Fields{
-				"code":       code,
-				"remote_ip":  r.RemoteAddr,
-				"user_agent": r.UserAgent(),
-				"referer":    r.Header.Get("Referer"),
-			}).Warn("URL not found for redirect")
-			w.WriteHeader(http.StatusNotFound)
-			http.ServeFile(w, r, "./web/404.html")
+		h.serveRedirectError(w, r, code, err)
+		return
+	}
+	if preview {
+		h.servePreview(w, r, req, redirect, false)
+		return
+	}
+
+	// Where a link with a social card or device rules goes depends on the
+	// user agent. Crawlers building link previews get the card, if any.
+	if !redirect.Social.IsZero() || redirect.DeviceAware {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	if wantsSocialCard(r, redirect) {
+		h.serveSocialCard(w, r, req, redirect)
+		return
+	}
+
+	// Protected links need a password first
+	if redirect.Protected && !h.hasAccess(r, redirect.Domain, code) {
+		h.servePasswordGate(w, r, redirect.Domain, code)
+		return
+	}
+
+	// Interstitial links show where they go, and redirect once the visitor
+	// follows the page's Continue link
+	if redirect.Interstitial {
+		if !h.hasContinued(r, redirect.Domain, code) {
+			h.servePreview(w, r, req, redirect, true)
 			return
 		}
-		// Internal server error
-		h.metrics.RecordInternalError()
-		h.logger.WithFields(logrus.Fields{
-			"code":       code,
-			"error":      err.Error(),
-			"remote_ip":  r.RemoteAddr,
-			"user_agent": r.UserAgent(),
-		}).Error("Internal error during URL redirect")
-		w.WriteHeader(http.StatusInternalServerError)
-		http.ServeFile(w, r, "./web/500.html")
-		return
+		h.clearContinue(w, code)
+	}
+
+	// Count the visit. A limited link only redirects once its click has
+	// been counted; for others a failure to count is not worth a failed
+	// redirect. Visits sent to a fallback outside the active window are
+	// not visits to the link's destination and are not counted.
+	if !redirect.Fallback {
+		if err := h.service.RecordClick(r.Context(), redirect.Domain, code, service.Click{Country: req.Location.Country, Variant: redirect.Variant}); err != nil {
+			status := problemFromError(err).Status
+			if redirect.Limited || status == http.StatusNotFound || status == http.StatusGone {
+				h.serveRedirectError(w, r, code, err)
+				return
+			}
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Warn("Failed to record click")
+		}
 	}
 
 	// Record metrics
@@ -170,14 +378,98 @@ func (h *URLHandler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 	// Log successful redirect
 	h.logger.WithFields(logrus.Fields{
 		"code":         code,
-		"original_url": originalURL,
+		"domain":       redirect.Domain,
+		"original_url": redirect.URL,
+		"status":       redirect.Status,
+		"fallback":     redirect.Fallback,
+		"deep_link":    redirect.DeepLink,
+		"country":      req.Location.Country,
+		"variant":      redirect.Variant,
 		"remote_ip":    r.RemoteAddr,
 		"user_agent":   r.UserAgent(),
 		"referer":      r.Header.Get("Referer"),
 	}).Info("URL redirect successful")
 
+	// Deep links into apps are opened from a page
+	h.rememberVariant(w, req, redirect)
+	if redirect.DeepLink != "" {
+		serveOpenApp(w, r, redirect)
+		return
+	}
+
 	// Redirect to original URL
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	w.Header().Set("Cache-Control", redirectCacheControl(redirect))
+	http.Redirect(w, r, redirect.URL, redirect.Status)
+}
+
+// serveRedirectError serves the error page for a link that cannot be
+// followed: 404 or 410 for missing and expired links, a 404 "coming soon"
+// page for links whose active window has not opened, otherwise 500.
+// Unknown codes on a domain with its own 404 page are redirected there.
+func (h *URLHandler) serveRedirectError(w http.ResponseWriter, r *http.Request, code string, err error) {
+	status := problemFromError(err).Status
+	if status == http.StatusNotFound || status == http.StatusGone {
+		h.metrics.RecordURLNotFound()
+		h.logger.WithFields(logrus.Fields{
+			"code":       code,
+			"status":     status,
+			"remote_ip":  r.RemoteAddr,
+			"user_agent": r.UserAgent(),
+			"referer":    r.Header.Get("Referer"),
+		}).Warn("URL not available for redirect")
+		var missing *service.MissingLinkError
+		if errors.As(err, &missing) {
+			w.Header().Set("Cache-Control", "private, no-store")
+			http.Redirect(w, r, missing.NotFoundURL, http.StatusFound)
+			return
+		}
+		if errors.Is(err, service.ErrNotActive) {
+			// The link starts working without any change on our side, so
+			// the page must not be cached
+			w.Header().Set("Cache-Control", "private, no-store")
+			renderPage(w, r, status, "coming-soon", nil)
+			return
+		}
+		serveErrorPage(w, r, status)
+		return
+	}
+	// Internal server error
+	h.metrics.RecordInternalError()
+	h.logger.WithFields(logrus.Fields{
+		"code":       code,
+		"error":      err.Error(),
+		"remote_ip":  r.RemoteAddr,
+		"user_agent": r.UserAgent(),
+	}).Error("Internal error during URL redirect")
+	serveErrorPage(w, r, status)
+}
+
+// extraPath returns the escaped path following the code, or "" when there
+// is none. A lone trailing slash is not treated as a path.
+func extraPath(r *http.Request, code string) string {
+	rest, found := strings.CutPrefix(r.URL.EscapedPath(), "/"+code)
+	if !found || rest == "/" {
+		return ""
+	}
+	return rest
+}
+
+// redirectCacheControl returns the Cache-Control header for a redirect.
+// Permanent redirects may be cached, by browsers and shared caches alike,
+// for permanentRedirectMaxAge; temporary ones must reach the server on
+// every visit so that the link can change and clicks are seen. Protected,
+// click-limited and scheduled links are never cached, or a cache would hand
+// them out without the password, past the limit or outside the window;
+// neither are links with device or geo rules, which redirect per user agent
+// or location, or split links, which redirect per visitor.
+func redirectCacheControl(redirect *service.Redirect) string {
+	if !redirect.Cacheable() {
+		return "private, no-store"
+	}
+	if redirect.Status == http.StatusMovedPermanently || redirect.Status == http.StatusPermanentRedirect {
+		return fmt.Sprintf("public, max-age=%d", int(permanentRedirectMaxAge.Seconds()))
+	}
+	return "private, no-store"
 }
 
 // respondWithJSON sends a JSON response
@@ -187,9 +479,15 @@ func respondWithJSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-// respondWithError sends an error response
-func respondWithError(w http.ResponseWriter, status int, message string) {
-	respondWithJSON(w, status, ErrorResponse{Error: message})
+// serveErrorPage renders the static HTML error page matching status.
+// Redirects are followed by browsers, so they get HTML rather than JSON.
+func serveErrorPage(w http.ResponseWriter, r *http.Request, status int) {
+	page := "./web/500.html"
+	if status == http.StatusNotFound || status == http.StatusGone {
+		page = "./web/404.html"
+	}
+	w.WriteHeader(status)
+	http.ServeFile(w, r, page)
 }
 
 // HealthCheck handles the GET /health endpoint
@@ -210,35 +508,3 @@ func (h *URLHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	
 	respondWithJSON(w, http.StatusOK, response)
 }
-
-// validateURLFormat validates the format of a URL
-func validateURLFormat(rawURL string) error {
-	// Check if URL is empty or just whitespace
-	if strings.TrimSpace(rawURL) == "" {
-		return fmt.Errorf("URL cannot be empty")
-	}
-	
-	// Add scheme if missing
-	testURL := rawURL
-	if !strings.HasPrefix(testURL, "http://") && !strings.HasPrefix(testURL, "https://") {
-		testURL = "http://" + testURL
-	}
-	
-	// Parse URL
-	parsedURL, err := url.ParseRequestURI(testURL)
-	if err != nil {
-		return err
-	}
-	
-	// Check if URL has a valid host
-	if parsedURL.Host == "" {
-		return fmt.Errorf("URL must have a valid host")
-	}
-	
-	return nil
-}
-
-// isNotFoundError checks if an error indicates a "not found" condition
-func isNotFoundError(err error) bool {
-	return strings.Contains(err.Error(), "not found")
-}
\ No newline at end of file
@@ -5,15 +5,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/security"
+	"github.com/urlshortener/internal/service"
+	"github.com/urlshortener/internal/transfer"
+	"github.com/urlshortener/internal/urlcanon"
 )
 
 // MockURLService is a mock implementation of URLService
@@ -21,22 +28,116 @@ type MockURLService struct {
 	mock.Mock
 }
 
-func (m *MockURLService) ShortenURL(originalURL string) (string, string, error) {
-	args := m.Called(originalURL)
-	return args.String(0), args.String(1), args.Error(2)
+func (m *MockURLService) ShortenURL(ctx context.Context, req service.ShortenRequest) (*service.ShortenResult, error) {
+	args := m.Called(req.URL)
+	result, _ := args.Get(0).(*service.ShortenResult)
+	return result, args.Error(1)
 }
 
-func (m *MockURLService) GetOriginalURL(code string) (string, error) {
-	args := m.Called(code)
+func (m *MockURLService) ShortenBatch(ctx context.Context, reqs []service.ShortenRequest) ([]service.BatchResult, error) {
+	args := m.Called(reqs)
+	results, _ := args.Get(0).([]service.BatchResult)
+	return results, args.Error(1)
+}
+
+func (m *MockURLService) ExportLinks(ctx context.Context, workspace string, enc transfer.Encoder) error {
+	args := m.Called(workspace, enc)
+	return args.Error(0)
+}
+
+func (m *MockURLService) ImportLinks(ctx context.Context, workspace string, dec transfer.Decoder, opts service.ImportOptions) (*service.ImportReport, error) {
+	args := m.Called(workspace, dec, opts)
+	report, _ := args.Get(0).(*service.ImportReport)
+	return report, args.Error(1)
+}
+
+func (m *MockURLService) GetOriginalURL(ctx context.Context, domain, code string) (string, error) {
+	args := m.Called(domain, code)
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockURLService) ShortURL(ctx context.Context, host, code string) (string, error) {
+	args := m.Called(host, code)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockURLService) ResolveRedirect(ctx context.Context, req service.RedirectRequest) (*service.Redirect, error) {
+	args := m.Called(req)
+	redirect, _ := args.Get(0).(*service.Redirect)
+	return redirect, args.Error(1)
+}
+
+func (m *MockURLService) RecordClick(ctx context.Context, domain, code string, click service.Click) error {
+	return m.Called(domain, code, click).Error(0)
+}
+
+func (m *MockURLService) SetVariantWeights(ctx context.Context, workspace, domain, code string, weights map[string]int) ([]service.Variant, error) {
+	args := m.Called(workspace, domain, code, weights)
+	variants, _ := args.Get(0).([]service.Variant)
+	return variants, args.Error(1)
+}
+
+func (m *MockURLService) ListLinks(ctx context.Context, filter service.LinkFilter) (*service.LinkPage, error) {
+	args := m.Called(filter)
+	page, _ := args.Get(0).(*service.LinkPage)
+	return page, args.Error(1)
+}
+
+func (m *MockURLService) UpdateLink(ctx context.Context, workspace, domain, code string, update service.LinkUpdate) (*service.ShortenResult, error) {
+	args := m.Called(workspace, domain, code, update)
+	result, _ := args.Get(0).(*service.ShortenResult)
+	return result, args.Error(1)
+}
+
+func (m *MockURLService) DeleteLink(ctx context.Context, workspace, domain, code string) (*service.ShortenResult, error) {
+	args := m.Called(workspace, domain, code)
+	result, _ := args.Get(0).(*service.ShortenResult)
+	return result, args.Error(1)
+}
+
+func (m *MockURLService) GetClickStats(ctx context.Context, workspace, domain, code string) (*service.ClickStats, error) {
+	args := m.Called(workspace, domain, code)
+	stats, _ := args.Get(0).(*service.ClickStats)
+	return stats, args.Error(1)
+}
+
+func (m *MockURLService) UnlockLink(ctx context.Context, domain, code, password string) error {
+	return m.Called(domain, code, password).Error(0)
+}
+
+// testClientID identifies the visitor of test requests, which come from
+// httptest's default address without a User-Agent
+const testClientID = "192.0.2.1 "
+
+// testHost is the Host of test requests, httptest's default
+const testHost = "example.com"
+
+// newTestLogger returns a logger that discards its output
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// decodeProblem decodes a problem+json response body
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) Problem {
+	t.Helper()
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	return problem
+}
+
 func TestShortenURL(t *testing.T) {
 	mockService := new(MockURLService)
-	handler := NewURLHandler(mockService, metrics.NewMetrics())
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
 
 	t.Run("successful URL shortening", func(t *testing.T) {
-		mockService.On("ShortenURL", "http://example.com").Return("abc123", "http://localhost:8081/abc123", nil).Once()
+		mockService.On("ShortenURL", "http://example.com").Return(&service.ShortenResult{
+			Code:        "abc123",
+			ShortURL:    "http://localhost:8081/abc123",
+			OriginalURL: "http://example.com",
+		}, nil).Once()
 
 		reqBody := ShortenURLRequest{URL: "http://example.com"}
 		jsonBody, _ := json.Marshal(reqBody)
@@ -54,6 +155,7 @@ func TestShortenURL(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "abc123", response.Code)
 		assert.Contains(t, response.ShortURL, "abc123")
+		assert.Equal(t, "http://example.com", response.OriginalURL)
 
 		mockService.AssertExpectations(t)
 	})
@@ -67,10 +169,32 @@ func TestShortenURL(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 
-		var response ErrorResponse
-		err := json.Unmarshal(w.Body.Bytes(), &response)
-		assert.NoError(t, err)
-		assert.Equal(t, "Invalid request body", response.Error)
+		problem := decodeProblem(t, w)
+		assert.Equal(t, ProblemTypeInvalidRequest, problem.Type)
+		assert.Equal(t, http.StatusBadRequest, problem.Status)
+		assert.Equal(t, "invalid request body", problem.Detail)
+		assert.Equal(t, "/shorten", problem.Instance)
+	})
+
+	t.Run("deduplicated link", func(t *testing.T) {
+		mockService.On("ShortenURL", "http://example.com/dup").Return(&service.ShortenResult{
+			Code:         "abc123",
+			ShortURL:     "http://localhost:8081/abc123",
+			OriginalURL:  "http://example.com/dup",
+			Deduplicated: true,
+		}, nil).Once()
+
+		req := httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(`{"url":"http://example.com/dup","dedupe":true}`))
+		w := httptest.NewRecorder()
+
+		handler.ShortenURL(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response ShortenURLResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.True(t, response.Deduplicated)
+		assert.True(t, ShortenURLRequest{URL: "http://example.com/dup", Dedupe: true}.toService().Dedupe)
+		mockService.AssertExpectations(t)
 	})
 
 	t.Run("empty URL", func(t *testing.T) {
@@ -85,14 +209,13 @@ func TestShortenURL(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 
-		var response ErrorResponse
-		err := json.Unmarshal(w.Body.Bytes(), &response)
-		assert.NoError(t, err)
-		assert.Equal(t, "URL is required", response.Error)
+		problem := decodeProblem(t, w)
+		assert.Equal(t, ProblemTypeInvalidRequest, problem.Type)
+		assert.Equal(t, "URL is required", problem.Detail)
 	})
 
-	t.Run("service error", func(t *testing.T) {
-		mockService.On("ShortenURL", "example.com").Return("", "", errors.New("service error")).Once()
+	t.Run("internal service error is not leaked", func(t *testing.T) {
+		mockService.On("ShortenURL", "example.com").Return(nil, errors.New("disk I/O error")).Once()
 
 		req, _ := http.NewRequest("POST", "/shorten", strings.NewReader(`{"url":"example.com"}`))
 		req.Header.Set("Content-Type", "application/json")
@@ -100,21 +223,72 @@ func TestShortenURL(t *testing.T) {
 
 		handler.ShortenURL(rr, req)
 
-		assert.Equal(t, http.StatusBadRequest, rr.Code)
-		var response map[string]string
-		json.Unmarshal(rr.Body.Bytes(), &response)
-		assert.Equal(t, "service error", response["error"])
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		problem := decodeProblem(t, rr)
+		assert.Equal(t, ProblemTypeInternal, problem.Type)
+		assert.NotContains(t, problem.Detail, "disk")
+
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("typed service errors map to status codes", func(t *testing.T) {
+		cases := []struct {
+			err         error
+			status      int
+			problemType string
+		}{
+			{&service.URLError{Reason: "missing host", Err: service.ErrInvalidURL}, http.StatusBadRequest, ProblemTypeInvalidURL},
+			{fmt.Errorf("wrapped: %w", service.ErrBlocked), http.StatusForbidden, ProblemTypeBlockedURL},
+			{fmt.Errorf("wrapped: %w", service.ErrConflict), http.StatusConflict, ProblemTypeConflict},
+			{&service.InputError{Field: "alias", Reason: "is reserved"}, http.StatusBadRequest, ProblemTypeInvalidRequest},
+		}
+		for _, tc := range cases {
+			mockService.On("ShortenURL", "http://example.org").Return(nil, tc.err).Once()
+
+			req := httptest.NewRequest("POST", "/shorten", strings.NewReader(`{"url":"http://example.org"}`))
+			rr := httptest.NewRecorder()
+
+			handler.ShortenURL(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			problem := decodeProblem(t, rr)
+			assert.Equal(t, tc.problemType, problem.Type)
+			assert.Equal(t, tc.status, problem.Status)
+		}
 
 		mockService.AssertExpectations(t)
 	})
 }
 
+func TestShortenURLBlockedHost(t *testing.T) {
+	// Destinations are checked before the repository is used
+	canonicalizer := urlcanon.New(urlcanon.Options{})
+	urlService := service.NewURLService(nil, service.Config{
+		BaseURL:       "http://localhost:8081",
+		Canonicalizer: canonicalizer,
+		Validator:     security.NewURLValidator(security.DefaultSecurityConfig(), canonicalizer),
+	})
+	handler := NewURLHandler(urlService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+
+	req := httptest.NewRequest("POST", "/shorten", strings.NewReader(`{"url":"http://LOCALHOST:8080/admin"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler.ShortenURL(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	problem := decodeProblem(t, w)
+	assert.Equal(t, ProblemTypeBlockedURL, problem.Type)
+	assert.Equal(t, "domain is blocked: localhost", problem.Detail)
+}
+
 func TestRedirectURL(t *testing.T) {
 	mockService := new(MockURLService)
-	handler := NewURLHandler(mockService, metrics.NewMetrics())
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+	mockService.On("RecordClick", "", mock.MatchedBy(func(code string) bool { return code != "once" && code != "busy" && code != "launch" }), service.Click{}).Return(nil)
 
 	t.Run("successful redirect", func(t *testing.T) {
-		mockService.On("GetOriginalURL", "abc123").Return("http://example.com", nil).Once()
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "abc123", ClientID: testClientID}).Return(&service.Redirect{URL: "http://example.com", Status: http.StatusFound}, nil).Once()
 
 		req := httptest.NewRequest("GET", "/abc123", nil)
 		rctx := chi.NewRouteContext()
@@ -124,14 +298,123 @@ func TestRedirectURL(t *testing.T) {
 
 		handler.RedirectURL(w, req)
 
-		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+		assert.Equal(t, http.StatusFound, w.Code)
 		assert.Equal(t, "http://example.com", w.Header().Get("Location"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
 
 		mockService.AssertExpectations(t)
 	})
 
+	t.Run("per-link redirect status", func(t *testing.T) {
+		for _, tc := range []struct {
+			method       string
+			status       int
+			cacheControl string
+		}{
+			{"GET", http.StatusMovedPermanently, "public, max-age=86400"},
+			{"GET", http.StatusPermanentRedirect, "public, max-age=86400"},
+			{"POST", http.StatusTemporaryRedirect, "private, no-store"},
+		} {
+			mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "api", ClientID: testClientID}).Return(&service.Redirect{URL: "https://api.example.com/v1", Status: tc.status}, nil).Once()
+
+			req := httptest.NewRequest(tc.method, "/api", nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("code", "api")
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+
+			handler.RedirectURL(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, "https://api.example.com/v1", w.Header().Get("Location"))
+			assert.Equal(t, tc.cacheControl, w.Header().Get("Cache-Control"))
+		}
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("path and query are passed to the service", func(t *testing.T) {
+		for _, tc := range []struct {
+			target string
+			want   service.RedirectRequest
+		}{
+			{"/abc123?utm_source=x", service.RedirectRequest{Host: testHost, Code: "abc123", RawQuery: "utm_source=x", ClientID: testClientID}},
+			{"/abc123/", service.RedirectRequest{Host: testHost, Code: "abc123", ClientID: testClientID}},
+			{"/abc123/docs/a%20b?q=1", service.RedirectRequest{Host: testHost, Code: "abc123", Path: "/docs/a%20b", RawQuery: "q=1", ClientID: testClientID}},
+		} {
+			mockService.On("ResolveRedirect", tc.want).Return(&service.Redirect{URL: "https://example.com/", Status: http.StatusFound}, nil).Once()
+
+			req := httptest.NewRequest("GET", tc.target, nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("code", "abc123")
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+
+			handler.RedirectURL(w, req)
+
+			assert.Equal(t, http.StatusFound, w.Code, tc.target)
+		}
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("click limited links", func(t *testing.T) {
+		limited := &service.Redirect{URL: "https://example.com/once", Status: http.StatusMovedPermanently, Limited: true}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "once", ClientID: testClientID}).Return(limited, nil).Twice()
+		mockService.On("RecordClick", "", "once", service.Click{}).Return(nil).Once()
+		mockService.On("RecordClick", "", "once", service.Click{}).Return(fmt.Errorf("%w: click limit reached", service.ErrExpired)).Once()
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/once", "once", nil))
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+		w = httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/once", "once", nil))
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
+	})
+
+	t.Run("failing to count an unlimited link still redirects", func(t *testing.T) {
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "busy", ClientID: testClientID}).Return(&service.Redirect{URL: "https://example.com/", Status: http.StatusFound}, nil).Once()
+		mockService.On("RecordClick", "", "busy", service.Click{}).Return(errors.New("database is locked")).Once()
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/busy", "busy", nil))
+		assert.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("scheduled links", func(t *testing.T) {
+		fallback := &service.Redirect{URL: "https://example.com/waitlist", Status: http.StatusFound, Scheduled: true, Fallback: true}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "launch", ClientID: testClientID}).Return(fallback, nil).Once()
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "launch", ClientID: testClientID}).Return(nil, fmt.Errorf("%w for code: launch", service.ErrNotActive)).Once()
+
+		// Outside the window the fallback is followed without counting a click
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/launch", "launch", nil))
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/waitlist", w.Header().Get("Location"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		mockService.AssertNotCalled(t, "RecordClick", "launch", service.Click{})
+
+		// Without a fallback visitors get the coming soon page
+		w = httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/launch", "launch", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		assert.Contains(t, w.Body.String(), "Coming soon")
+	})
+
+	t.Run("scheduled permanent redirects are not cached", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://example.com/sale", Status: http.StatusMovedPermanently, Scheduled: true}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "sale", ClientID: testClientID}).Return(redirect, nil).Once()
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/sale", "sale", nil))
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	})
+
 	t.Run("URL not found", func(t *testing.T) {
-		mockService.On("GetOriginalURL", "notfound").Return("", errors.New("not found")).Once()
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "notfound", ClientID: testClientID}).Return(nil, fmt.Errorf("%w for code: notfound", service.ErrNotFound)).Once()
 
 		req := httptest.NewRequest("GET", "/notfound", nil)
 		rctx := chi.NewRouteContext()
@@ -143,10 +426,21 @@ func TestRedirectURL(t *testing.T) {
 
 		assert.Equal(t, http.StatusNotFound, w.Code)
 
-		var response ErrorResponse
-		err := json.Unmarshal(w.Body.Bytes(), &response)
-		assert.NoError(t, err)
-		assert.Equal(t, "URL not found", response.Error)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("error mentioning not found is not a 404", func(t *testing.T) {
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "broken", ClientID: testClientID}).Return(nil, errors.New("table not found")).Once()
+
+		req := httptest.NewRequest("GET", "/broken", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("code", "broken")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+
+		handler.RedirectURL(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
 
 		mockService.AssertExpectations(t)
 	})
@@ -161,11 +455,6 @@ func TestRedirectURL(t *testing.T) {
 		handler.RedirectURL(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
-
-		var response ErrorResponse
-		err := json.Unmarshal(w.Body.Bytes(), &response)
-		assert.NoError(t, err)
-		assert.Equal(t, "Code is required", response.Error)
 	})
 }
 
@@ -187,17 +476,18 @@ func TestRespondWithJSON(t *testing.T) {
 }
 
 func TestRespondWithError(t *testing.T) {
-	t.Run("error response", func(t *testing.T) {
+	t.Run("problem response", func(t *testing.T) {
 		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/abc123", nil)
 
-		respondWithError(w, http.StatusBadRequest, "Bad request")
+		respondWithError(w, r, fmt.Errorf("lookup: %w", service.ErrExpired))
 
-		assert.Equal(t, http.StatusBadRequest, w.Code)
-		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, http.StatusGone, w.Code)
 
-		var response ErrorResponse
-		err := json.Unmarshal(w.Body.Bytes(), &response)
-		assert.NoError(t, err)
-		assert.Equal(t, "Bad request", response.Error)
+		problem := decodeProblem(t, w)
+		assert.Equal(t, ProblemTypeExpired, problem.Type)
+		assert.Equal(t, "Gone", problem.Title)
+		assert.Equal(t, http.StatusGone, problem.Status)
+		assert.Equal(t, "/abc123", problem.Instance)
 	})
 }
\ No newline at end of file
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client-chosen key for a request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayHeader marks a response replayed from storage
+	idempotentReplayHeader = "Idempotent-Replayed"
+	// maxIdempotentBodyBytes bounds the request body buffered for fingerprinting
+	maxIdempotentBodyBytes = 1 << 20
+)
+
+// Idempotency returns middleware that honours the Idempotency-Key header.
+// The first response for a key is stored and replayed verbatim for retries;
+// reusing a key with a different caller, method, path or body is rejected
+// with 422. Server errors and 429 responses are not stored, so the request
+// can be retried under the same key. Keys belong to the request's
+// workspace, so Authenticate must run first. Requests without the header
+// pass through untouched.
+func Idempotency(svc service.IdempotencyService, logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+			if err != nil {
+				respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "request body could not be read"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			workspace := workspaceOf(r)
+			entry := logger.WithFields(logrus.Fields{
+				"idempotency_key": key,
+				"workspace":       workspace,
+				"remote_ip":       r.RemoteAddr,
+			})
+			claim, stored, err := svc.Begin(r.Context(), workspace, key, requestFingerprint(r, body))
+			if err != nil {
+				problem := problemFromError(err)
+				if problem.Status >= http.StatusInternalServerError {
+					entry.WithError(err).Error("Failed to check idempotency key")
+				} else {
+					entry.WithError(err).Warn("Idempotency key rejected")
+				}
+				respondWithProblem(w, r, problem)
+				return
+			}
+			if stored != nil {
+				entry.Info("Replaying stored response for idempotency key")
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set(idempotentReplayHeader, "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			}
+
+			// The response must be stored even if the client has gone away,
+			// since that is exactly when it will retry
+			storeCtx := context.WithoutCancel(r.Context())
+			recorder := &recordingWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				// Free the key if the handler panicked or failed so that the
+				// retry is processed rather than told to wait
+				if err := svc.Release(storeCtx, claim); err != nil {
+					entry.WithError(err).Error("Failed to release idempotency key")
+				}
+			}()
+
+			next.ServeHTTP(recorder, r)
+
+			if recorder.statusCode >= http.StatusInternalServerError || recorder.statusCode == http.StatusTooManyRequests {
+				return
+			}
+			if err := svc.Complete(storeCtx, claim, service.StoredResponse{
+				StatusCode:  recorder.statusCode,
+				ContentType: recorder.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			}); err != nil {
+				entry.WithError(err).Error("Failed to store idempotent response")
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// requestFingerprint identifies a request by caller, method, path and body
+// so that a reused key can be told apart from a genuine retry, and one
+// member of a workspace cannot replay another's responses
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, callerOf(r)+"\n")
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// callerOf names who made r: the member whose API key was used, the admin
+// token, or nobody for anonymous requests
+func callerOf(r *http.Request) string {
+	principal := PrincipalFrom(r.Context())
+	switch {
+	case principal == nil:
+		return "anonymous"
+	case principal.IsAdmin() && principal.Member == "":
+		return "admin"
+	default:
+		return "member:" + principal.Member
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.statusCode = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
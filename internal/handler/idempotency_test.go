@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockIdempotencyService is a mock implementation of service.IdempotencyService
+type MockIdempotencyService struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyService) Begin(ctx context.Context, workspace, key, fingerprint string) (*service.IdempotencyClaim, *service.StoredResponse, error) {
+	args := m.Called(workspace, key, fingerprint)
+	claim, _ := args.Get(0).(*service.IdempotencyClaim)
+	response, _ := args.Get(1).(*service.StoredResponse)
+	return claim, response, args.Error(2)
+}
+
+func (m *MockIdempotencyService) Complete(ctx context.Context, claim *service.IdempotencyClaim, response service.StoredResponse) error {
+	return m.Called(claim, response).Error(0)
+}
+
+func (m *MockIdempotencyService) Release(ctx context.Context, claim *service.IdempotencyClaim) error {
+	return m.Called(claim).Error(0)
+}
+
+func TestIdempotency(t *testing.T) {
+	const body = `{"url":"https://example.com"}`
+	calls := 0
+	status := http.StatusOK
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		respondWithJSON(w, status, map[string]string{"code": "abc123"})
+	})
+	newRequest := func(key string) *http.Request {
+		req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		return req
+	}
+	fingerprint := requestFingerprint(newRequest(""), []byte(body))
+	claimOf := func(key string) *service.IdempotencyClaim {
+		return &service.IdempotencyClaim{Key: key, ReservedAt: time.Unix(1700000000, 0)}
+	}
+
+	t.Run("without key", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		calls = 0
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(next).ServeHTTP(w, newRequest(""))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, calls)
+		mockService.AssertNotCalled(t, "Begin", mock.Anything, mock.Anything)
+	})
+
+	t.Run("first request is stored", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		calls = 0
+		mockService.On("Begin", "", "k1", fingerprint).Return(claimOf("k1"), nil, nil).Once()
+		mockService.On("Complete", claimOf("k1"), service.StoredResponse{
+			StatusCode:  http.StatusOK,
+			ContentType: "application/json",
+			Body:        []byte("{\"code\":\"abc123\"}\n"),
+		}).Return(nil).Once()
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(next).ServeHTTP(w, newRequest("k1"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, calls)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("retry is replayed", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		calls = 0
+		mockService.On("Begin", "", "k1", fingerprint).Return(nil, &service.StoredResponse{
+			StatusCode:  http.StatusOK,
+			ContentType: "application/json",
+			Body:        []byte(`{"code":"abc123"}`),
+		}, nil).Once()
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(next).ServeHTTP(w, newRequest("k1"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 0, calls)
+		assert.Equal(t, `{"code":"abc123"}`, w.Body.String())
+		assert.Equal(t, "true", w.Header().Get(idempotentReplayHeader))
+	})
+
+	t.Run("reused key", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		mockService.On("Begin", "", "k1", fingerprint).Return(nil, nil, service.ErrIdempotencyKeyReused).Once()
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(next).ServeHTTP(w, newRequest("k1"))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, ProblemTypeKeyReused, decodeProblem(t, w).Type)
+	})
+
+	t.Run("key in use", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		mockService.On("Begin", "", "k1", fingerprint).Return(nil, nil, service.ErrIdempotencyKeyInUse).Once()
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(next).ServeHTTP(w, newRequest("k1"))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, ProblemTypeKeyInUse, decodeProblem(t, w).Type)
+	})
+
+	t.Run("server error releases key", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		status = http.StatusInternalServerError
+		defer func() { status = http.StatusOK }()
+		mockService.On("Begin", "", "k2", fingerprint).Return(claimOf("k2"), nil, nil).Once()
+		mockService.On("Release", claimOf("k2")).Return(nil).Once()
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(next).ServeHTTP(w, newRequest("k2"))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		mockService.AssertExpectations(t)
+		mockService.AssertNotCalled(t, "Complete", mock.Anything, mock.Anything)
+	})
+
+	t.Run("quota refusals release key", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		status = http.StatusTooManyRequests
+		defer func() { status = http.StatusOK }()
+		mockService.On("Begin", "", "k4", fingerprint).Return(claimOf("k4"), nil, nil).Once()
+		mockService.On("Release", claimOf("k4")).Return(nil).Once()
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(next).ServeHTTP(w, newRequest("k4"))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		mockService.AssertExpectations(t)
+		mockService.AssertNotCalled(t, "Complete", mock.Anything, mock.Anything)
+	})
+
+	t.Run("body reaches the handler", func(t *testing.T) {
+		mockService := new(MockIdempotencyService)
+		mockService.On("Begin", "", "k3", fingerprint).Return(claimOf("k3"), nil, nil).Once()
+		mockService.On("Complete", claimOf("k3"), mock.Anything).Return(nil).Once()
+		var received string
+		echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			received = string(data)
+			w.WriteHeader(http.StatusCreated)
+		})
+		w := httptest.NewRecorder()
+
+		Idempotency(mockService, newTestLogger())(echo).ServeHTTP(w, newRequest("k3"))
+
+		assert.Equal(t, body, received)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("callers are told apart", func(t *testing.T) {
+		withPrincipal := func(principal *service.Principal) *http.Request {
+			req := newRequest("k5")
+			return req.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+		}
+		alice := requestFingerprint(withPrincipal(&service.Principal{Member: "alice@example.com", Role: service.RoleMember}), []byte(body))
+		bob := requestFingerprint(withPrincipal(&service.Principal{Member: "bob@example.com", Role: service.RoleMember}), []byte(body))
+		admin := requestFingerprint(withPrincipal(&service.Principal{Role: service.RoleAdmin}), []byte(body))
+
+		assert.NotEqual(t, alice, bob)
+		assert.NotEqual(t, alice, admin)
+		assert.NotEqual(t, fingerprint, admin)
+		assert.Equal(t, alice, requestFingerprint(withPrincipal(&service.Principal{Member: "alice@example.com", Role: service.RoleMember}), []byte(body)))
+	})
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// Values of MetadataResponse.Status
+const (
+	metadataPending = "pending"
+	metadataOK      = "ok"
+	metadataFailed  = "failed"
+)
+
+// MetadataHandler serves what was found on links' destination pages
+type MetadataHandler struct {
+	service service.MetadataService
+	logger  *logrus.Logger
+}
+
+// NewMetadataHandler creates a new MetadataHandler
+func NewMetadataHandler(service service.MetadataService, logger *logrus.Logger) *MetadataHandler {
+	return &MetadataHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// MetadataResponse represents a link's destination metadata. Status is
+// "pending" until the destination has been fetched, then "ok", or "failed"
+// if it could not be read.
+type MetadataResponse struct {
+	Code        string     `json:"code"`
+	Status      string     `json:"status"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	ImageURL    string     `json:"image_url,omitempty"`
+	FetchedAt   *time.Time `json:"fetched_at,omitempty"`
+}
+
+// GetMetadata handles GET /api/v1/links/{code}/metadata, with ?domain=
+// for links on a branded domain. Only links of the caller's workspace are
+// found. Why a fetch failed is logged but not returned, since it can
+// reveal how internal names resolve.
+func (h *MetadataHandler) GetMetadata(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	meta, err := h.service.GetMetadata(r.Context(), workspaceOf(r), r.URL.Query().Get("domain"), code)
+	if err != nil {
+		problem := problemFromError(err)
+		if problem.Status >= http.StatusInternalServerError {
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Error("Failed to get link metadata")
+		}
+		respondWithProblem(w, r, problem)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	respondWithJSON(w, http.StatusOK, newMetadataResponse(code, meta))
+}
+
+func newMetadataResponse(code string, meta *service.LinkMetadata) MetadataResponse {
+	response := MetadataResponse{Code: code, Status: metadataPending}
+	if meta.FetchedAt.IsZero() {
+		return response
+	}
+	fetchedAt := meta.FetchedAt
+	response.FetchedAt = &fetchedAt
+	if meta.Error != "" {
+		response.Status = metadataFailed
+		return response
+	}
+	response.Status = metadataOK
+	response.Title = meta.Title
+	response.Description = meta.Description
+	response.ImageURL = meta.ImageURL
+	return response
+}
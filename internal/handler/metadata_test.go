@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockMetadataService is a mock implementation of MetadataService
+type MockMetadataService struct {
+	mock.Mock
+}
+
+func (m *MockMetadataService) Enqueue(domain, code, url string) bool {
+	return m.Called(domain, code, url).Bool(0)
+}
+
+func (m *MockMetadataService) GetMetadata(ctx context.Context, workspace, domain, code string) (*service.LinkMetadata, error) {
+	args := m.Called(workspace, domain, code)
+	meta, _ := args.Get(0).(*service.LinkMetadata)
+	return meta, args.Error(1)
+}
+
+func (m *MockMetadataService) Run(ctx context.Context, workers int) {
+	m.Called(workers)
+}
+
+func TestGetMetadata(t *testing.T) {
+	mockService := new(MockMetadataService)
+	handler := NewMetadataHandler(mockService, newTestLogger())
+	fetchedAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	mockService.On("GetMetadata", "", "", "ok").Return(&service.LinkMetadata{
+		Title: "Example", Description: "An example", ImageURL: "https://example.com/og.png", FetchedAt: fetchedAt,
+	}, nil)
+	mockService.On("GetMetadata", "", "", "failed").Return(&service.LinkMetadata{Error: "address is not public: 10.0.0.1", FetchedAt: fetchedAt}, nil)
+	mockService.On("GetMetadata", "", "", "pending").Return(&service.LinkMetadata{}, nil)
+	mockService.On("GetMetadata", "", "", "missing").Return(nil, service.ErrNotFound)
+	mockService.On("GetMetadata", "", "", "broken").Return(nil, errors.New("database is locked"))
+
+	get := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/links/"+code+"/metadata", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("code", code)
+		w := httptest.NewRecorder()
+		handler.GetMetadata(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		return w
+	}
+
+	w := get("ok")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response MetadataResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "ok", response.Status)
+	assert.Equal(t, "Example", response.Title)
+	assert.Equal(t, "https://example.com/og.png", response.ImageURL)
+	require.NotNil(t, response.FetchedAt)
+	assert.True(t, fetchedAt.Equal(*response.FetchedAt))
+
+	// The reason for a failure is not exposed
+	w = get("failed")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"code": "failed", "status": "failed", "fetched_at": "2024-05-01T08:00:00Z"}`, w.Body.String())
+
+	w = get("pending")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"code": "pending", "status": "pending"}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("missing").Code)
+	assert.Equal(t, http.StatusInternalServerError, get("broken").Code)
+
+	// Links are looked up in the caller's workspace
+	mockService.On("GetMetadata", "sales", "", "ok").Return(nil, service.ErrNotFound)
+	req := httptest.NewRequest("GET", "/api/v1/links/ok/metadata", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("code", "ok")
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, principalKey{}, &service.Principal{Workspace: "sales", Member: "ann@example.com", Role: service.RoleMember})
+	w = httptest.NewRecorder()
+	handler.GetMetadata(w, req.WithContext(ctx))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPreviewShowsMetadata(t *testing.T) {
+	mockService := new(MockURLService)
+	mockMetadata := new(MockMetadataService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{Metadata: mockMetadata})
+
+	redirect := &service.Redirect{URL: "https://example.com/article", Status: http.StatusFound, Workspace: "sales"}
+	mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "story", ClientID: testClientID}).Return(redirect, nil)
+	mockMetadata.On("GetMetadata", "sales", "", "story").Return(&service.LinkMetadata{
+		Title: "A <great> story", Description: "Read all about it", ImageURL: "https://example.com/card.png", FetchedAt: time.Now(),
+	}, nil)
+
+	w := httptest.NewRecorder()
+	handler.RedirectURL(w, newRedirectRequest("GET", "/story+", "story+", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "A &lt;great&gt; story")
+	assert.Contains(t, body, "Read all about it")
+	assert.Contains(t, body, `<img src="https://example.com/card.png" alt="" referrerpolicy="no-referrer">`)
+	assert.Contains(t, body, "https://example.com/article")
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+// Pages rendered by the redirect handler. Unlike the static error pages in
+// web/, these carry per-request data, so they are compiled in.
+var pageTemplates = template.Must(template.New("pages").Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>{{.}} - URL Shortener</title>
+    <link rel="stylesheet" href="/styles.css">
+    <style>
+        .page { text-align: center; padding: 2rem; max-width: 600px; margin: 2rem auto; }
+        .page h1 { font-size: 2rem; color: #2c3e50; margin: 1rem 0; }
+        .page p { font-size: 1.1rem; color: #7f8c8d; line-height: 1.6; }
+        .page .error { color: #e74c3c; }
+        .page input[type=password] { padding: 12px; border: 1px solid #ccc; border-radius: 8px; font-size: 1rem; width: 60%; }
+        .page .destination { font-family: monospace; font-size: 1rem; color: #2c3e50; word-break: break-all; }
+        .page .warnings { text-align: left; color: #c0392b; line-height: 1.6; }
+        .page .safe { color: #27ae60; }
+        .page .page-card { border: 1px solid #e1e8ed; border-radius: 8px; padding: 1rem; margin: 1rem 0; }
+        .page .page-card img { max-width: 100%; max-height: 240px; border-radius: 4px; }
+        .page .page-card .page-title { color: #2c3e50; font-weight: 600; margin: 0.5rem 0 0; }
+        .page .page-card .page-description { font-size: 1rem; margin: 0.25rem 0 0; }
+        .page .links a { margin: 0 0.5rem; }
+        .page button, .page .button { display: inline-block; text-decoration: none; background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; padding: 12px 24px; border: 0; border-radius: 8px; font-weight: 500; cursor: pointer; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="page">
+{{end}}
+
+{{define "foot"}}        </div>
+    </div>
+</body>
+</html>
+{{end}}
+
+{{define "password"}}{{template "head" "Password Required"}}            <div class="icon">🔒</div>
+            <h1>This link is password protected</h1>
+            <p>Enter the password you were given to continue.</p>
+            {{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+            <form method="post" action="{{.Action}}">
+                <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+                <input type="password" name="password" aria-label="Password" autocomplete="current-password" required autofocus>
+                <button type="submit">Continue</button>
+            </form>
+{{template "foot"}}{{end}}
+
+{{define "preview"}}{{template "head" "Link Preview"}}            <div class="icon">🔎</div>
+            <h1>{{if .Interstitial}}Check where this link goes{{else}}Link preview{{end}}</h1>
+            {{if .Destination}}{{if or .Title .ImageURL}}<div class="page-card">
+                {{if .ImageURL}}<img src="{{.ImageURL}}" alt="" referrerpolicy="no-referrer">{{end}}
+                {{if .Title}}<p class="page-title">{{.Title}}</p>{{end}}
+                {{if .Description}}<p class="page-description">{{.Description}}</p>{{end}}
+            </div>
+            {{end}}<p>This link goes to</p>
+            <p class="destination">{{.Destination}}</p>
+            {{if .Warnings}}<ul class="warnings">{{range .Warnings}}
+                <li>{{.}}</li>{{end}}
+            </ul>{{else}}<p class="safe">Nothing unusual was found about this destination.</p>{{end}}
+            {{else}}<p>This link is password protected. Its destination is shown once you enter the password.</p>{{end}}
+            {{if .CreatedAt}}<p>Created on {{.CreatedAt}}</p>{{end}}
+            <p><a class="button" href="{{.ContinueURL}}" rel="nofollow">Continue</a></p>
+{{template "foot"}}{{end}}
+
+{{define "social-card"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="robots" content="noindex">
+    <title>{{.Title}}</title>
+    <meta property="og:type" content="website">
+    <meta property="og:title" content="{{.Title}}">
+    <meta name="twitter:title" content="{{.Title}}">
+    {{if .ShortURL}}<meta property="og:url" content="{{.ShortURL}}">
+    {{end}}{{if .Description}}<meta name="description" content="{{.Description}}">
+    <meta property="og:description" content="{{.Description}}">
+    <meta name="twitter:description" content="{{.Description}}">
+    {{end}}{{if .ImageURL}}<meta property="og:image" content="{{.ImageURL}}">
+    <meta name="twitter:image" content="{{.ImageURL}}">
+    <meta name="twitter:card" content="summary_large_image">
+    {{else}}<meta name="twitter:card" content="summary">
+    {{end}}</head>
+<body>
+    <h1>{{.Title}}</h1>
+    {{if .Description}}<p>{{.Description}}</p>
+    {{end}}{{if .Destination}}<p><a href="{{.Destination}}" rel="nofollow">Continue</a></p>
+    {{end}}</body>
+</html>
+{{end}}
+
+{{define "open-app"}}{{template "head" "Opening App"}}            <div class="icon">📱</div>
+            <h1>Opening the app…</h1>
+            <p>If nothing happens, you don't have the app installed.</p>
+            <p class="links"><a class="button" href="{{.DeepLink}}">Open the app</a><a href="{{.FallbackURL}}" rel="nofollow">Continue to the website</a></p>
+            <script>
+                (function () {
+                    // Leave for the website unless the app takes over and
+                    // the page is hidden first
+                    var timer = setTimeout(function () { window.location.replace({{.FallbackURL}}); }, 1500);
+                    document.addEventListener("visibilitychange", function () {
+                        if (document.hidden) { clearTimeout(timer); }
+                    });
+                    window.location.href = {{.DeepLink}};
+                })();
+            </script>
+{{template "foot"}}{{end}}
+
+{{define "coming-soon"}}{{template "head" "Coming Soon"}}            <div class="icon">⏳</div>
+            <h1>Coming soon</h1>
+            <p>This link isn't active yet. Please check back later.</p>
+{{template "foot"}}{{end}}
+`))
+
+// passwordPageData fills the "password" page
+type passwordPageData struct {
+	Action    string
+	CSRFToken string
+	Error     string
+}
+
+// renderPage writes the named page with status. Pages are rendered to a
+// buffer first so that a template error still yields a clean 500.
+func renderPage(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		serveErrorPage(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
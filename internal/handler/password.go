@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/security"
+	"github.com/urlshortener/internal/service"
+)
+
+const (
+	// accessCookie prefixes the cookies that remember the visitor unlocked
+	// a link. Each link has its own, named by accessCookieName and sent to
+	// every path, so that its preview at /{code}+ is unlocked too.
+	accessCookie = "link_access"
+	// csrfCookie holds the double-submit CSRF token for the password form
+	csrfCookie = "link_csrf"
+	// maxPasswordFormBytes bounds the password form body
+	maxPasswordFormBytes = 4 << 10
+)
+
+// hasAccess reports whether the visitor holds a valid access cookie for
+// code on domain
+func (h *URLHandler) hasAccess(r *http.Request, domain, code string) bool {
+	cookie, err := r.Cookie(accessCookieName(code))
+	return err == nil && h.access.signer.Verify(cookie.Value, linkKey(domain, code), time.Now())
+}
+
+// accessCookieName names the access cookie of code. Codes only use
+// letters, digits, '-' and '_', which are all allowed in cookie names.
+func accessCookieName(code string) string {
+	return accessCookie + "_" + code
+}
+
+// linkKey names code on domain for signed cookies and rate limits, so that
+// the same code on two domains is two links. Links on the default domain
+// are named by their code alone.
+func linkKey(domain, code string) string {
+	if domain == "" {
+		return code
+	}
+	return domain + "/" + code
+}
+
+// servePasswordGate handles a visit to a protected link without access: a
+// form submission is checked, anything else gets the password prompt
+func (h *URLHandler) servePasswordGate(w http.ResponseWriter, r *http.Request, domain, code string) {
+	w.Header().Set("Cache-Control", "private, no-store")
+	if r.Method != http.MethodPost {
+		h.renderPasswordPage(w, r, code, http.StatusOK, "")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasswordFormBytes)
+	if err := r.ParseForm(); err != nil {
+		h.renderPasswordPage(w, r, code, http.StatusBadRequest, "The form could not be read. Please try again.")
+		return
+	}
+
+	clientIP := r.RemoteAddr
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || !h.access.csrf.ValidateToken(r.PostForm.Get("csrf_token"), cookie.Value) {
+		security.LogSecurityEvent(h.logger, "link_password_csrf_failed", clientIP, "code: "+code)
+		h.renderPasswordPage(w, r, code, http.StatusForbidden, "Your session expired. Please enter the password again.")
+		return
+	}
+	if !h.access.limiter.Allow(linkKey(domain, code)) {
+		security.LogSecurityEvent(h.logger, "link_password_rate_limited", clientIP, "code: "+code)
+		w.Header().Set("Retry-After", "60")
+		h.renderPasswordPage(w, r, code, http.StatusTooManyRequests, "Too many attempts. Please wait a minute and try again.")
+		return
+	}
+
+	err = h.service.UnlockLink(r.Context(), domain, code, r.PostForm.Get("password"))
+	if errors.Is(err, service.ErrPasswordMismatch) {
+		security.LogSecurityEvent(h.logger, "link_password_rejected", clientIP, "code: "+code)
+		h.renderPasswordPage(w, r, code, http.StatusForbidden, "That password is incorrect.")
+		return
+	}
+	if err != nil {
+		h.metrics.RecordInternalError()
+		h.logger.WithFields(logrus.Fields{
+			"code":      code,
+			"error":     err.Error(),
+			"remote_ip": r.RemoteAddr,
+		}).Error("Failed to check link password")
+		serveErrorPage(w, r, problemFromError(err).Status)
+		return
+	}
+
+	expires := time.Now().Add(h.access.ttl)
+	access := h.linkCookie(code, accessCookieName(code), h.access.signer.Sign(linkKey(domain, code), expires), expires)
+	access.Path = "/"
+	http.SetCookie(w, access)
+	h.logger.WithFields(logrus.Fields{
+		"code":      code,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Protected link unlocked")
+
+	// Send the visitor back to the same URL, now with access, so that it is
+	// redirected like any other visit
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
+
+// renderPasswordPage shows the password prompt with a fresh CSRF token
+func (h *URLHandler) renderPasswordPage(w http.ResponseWriter, r *http.Request, code string, status int, message string) {
+	token, err := h.access.csrf.GenerateToken()
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to generate CSRF token")
+		serveErrorPage(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, h.linkCookie(code, csrfCookie, token, time.Time{}))
+	renderPage(w, r, status, "password", passwordPageData{
+		Action:    r.URL.RequestURI(),
+		CSRFToken: token,
+		Error:     message,
+	})
+}
+
+// linkCookie builds a cookie scoped to a link's path. A zero expiry makes
+// a session cookie.
+func (h *URLHandler) linkCookie(code, name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/" + code,
+		Expires:  expires,
+		Secure:   h.access.secureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
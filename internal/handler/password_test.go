@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+// newRedirectRequest builds a request for code as routed by chi
+func newRedirectRequest(method, target, code string, form url.Values, cookies ...*http.Cookie) *http.Request {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("code", code)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+// responseCookie returns the named cookie set by a response
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestPasswordProtectedRedirect(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{PasswordAttempts: 2})
+	protected := &service.Redirect{URL: "https://example.com/secret", Status: http.StatusMovedPermanently, Protected: true}
+	mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "secret", RawQuery: "a=1", ClientID: testClientID}).Return(protected, nil)
+	mockService.On("RecordClick", "", "secret", service.Click{}).Return(nil)
+
+	// promptFor fetches the prompt and returns its CSRF cookie
+	promptFor := func(t *testing.T) *http.Cookie {
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/secret?a=1", "secret", nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		assert.NotContains(t, w.Body.String(), "example.com")
+		csrf := responseCookie(w, csrfCookie)
+		require.NotNil(t, csrf)
+		assert.Equal(t, "/secret", csrf.Path)
+		assert.Contains(t, w.Body.String(), csrf.Value)
+		return csrf
+	}
+
+	t.Run("correct password unlocks the link", func(t *testing.T) {
+		csrf := promptFor(t)
+		mockService.On("UnlockLink", "", "secret", "hunter2").Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		form := url.Values{"password": {"hunter2"}, "csrf_token": {csrf.Value}}
+		handler.RedirectURL(w, newRedirectRequest("POST", "/secret?a=1", "secret", form, csrf))
+
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/secret?a=1", w.Header().Get("Location"))
+		access := responseCookie(w, accessCookieName("secret"))
+		require.NotNil(t, access)
+		assert.True(t, access.HttpOnly)
+		assert.Equal(t, "/", access.Path, "sent to the preview as well")
+
+		w = httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/secret?a=1", "secret", nil, access))
+
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+		assert.Equal(t, "https://example.com/secret", w.Header().Get("Location"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	})
+
+	t.Run("access cookie is bound to its code", func(t *testing.T) {
+		forged := &http.Cookie{Name: accessCookieName("secret"), Value: handler.access.signer.Sign("other", time.Now().Add(time.Hour))}
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/secret?a=1", "secret", nil, forged))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
+	})
+
+	t.Run("missing CSRF token is rejected", func(t *testing.T) {
+		csrf := promptFor(t)
+
+		w := httptest.NewRecorder()
+		form := url.Values{"password": {"hunter2"}, "csrf_token": {"forged"}}
+		handler.RedirectURL(w, newRedirectRequest("POST", "/secret?a=1", "secret", form, csrf))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Nil(t, responseCookie(w, accessCookieName("secret")))
+	})
+
+	t.Run("wrong passwords are rate limited", func(t *testing.T) {
+		mockService.On("UnlockLink", "", "secret", "guess").Return(service.ErrPasswordMismatch).Once()
+		csrf := promptFor(t)
+		form := url.Values{"password": {"guess"}, "csrf_token": {csrf.Value}}
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("POST", "/secret?a=1", "secret", form, csrf))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "incorrect")
+
+		// The first test used the other attempt of this minute
+		w = httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("POST", "/secret?a=1", "secret", form, csrf))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestUnlockedLinkPreview(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+	protected := &service.Redirect{URL: "https://example.com/secret", Status: http.StatusFound, Protected: true}
+	mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "secret", ClientID: testClientID}).Return(protected, nil)
+	mockService.On("UnlockLink", "", "secret", "hunter2").Return(nil).Once()
+
+	// The jar sends cookies back only to the paths they match, as a
+	// browser does
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	visit := func(method, target, code string, form url.Values) *httptest.ResponseRecorder {
+		u := &url.URL{Scheme: "http", Host: testHost, Path: target}
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest(method, target, code, form, jar.Cookies(u)...))
+		jar.SetCookies(u, w.Result().Cookies())
+		return w
+	}
+
+	w := visit("GET", "/secret", "secret", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	csrf := responseCookie(w, csrfCookie)
+	require.NotNil(t, csrf)
+	w = visit("POST", "/secret", "secret", url.Values{"password": {"hunter2"}, "csrf_token": {csrf.Value}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	w = visit("GET", "/secret+", "secret+", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://example.com/secret")
+	mockService.AssertExpectations(t)
+}
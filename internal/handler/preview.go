@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+const (
+	// previewSuffix after a code asks for the link's preview page, as in
+	// /abc123+
+	previewSuffix = "+"
+	// previewParam=1 in the query string also asks for the preview page
+	previewParam = "preview"
+	// continueCookie lets the visitor past a link's interstitial page once.
+	// It is scoped to the link's path like the access cookie.
+	continueCookie = "link_continue"
+	// continueTTL is how long the interstitial's Continue link stays valid
+	continueTTL = 10 * time.Minute
+)
+
+// previewPageData fills the "preview" page
+type previewPageData struct {
+	// Destination is empty while a protected link is still locked
+	Destination  string
+	CreatedAt    string
+	Warnings     []string
+	ContinueURL  string
+	Interstitial bool
+	// Title, Description and ImageURL come from the destination page, once
+	// it has been fetched
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// splitPreview strips the preview request, if any, from the code and the
+// query string. Other query parameters are kept in their original form.
+func splitPreview(code, rawQuery string) (string, string, bool) {
+	code, preview := strings.CutSuffix(code, previewSuffix)
+	if rawQuery == "" {
+		return code, rawQuery, preview
+	}
+	pairs := strings.Split(rawQuery, "&")
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		if name, _, _ := strings.Cut(pair, "="); name == previewParam {
+			preview = preview || pair == previewParam+"=1"
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	return code, strings.Join(kept, "&"), preview
+}
+
+// hasContinued reports whether the visitor followed the Continue link of
+// code's interstitial page on domain
+func (h *URLHandler) hasContinued(r *http.Request, domain, code string) bool {
+	cookie, err := r.Cookie(continueCookie)
+	return err == nil && h.access.signer.Verify(cookie.Value, continueCookie+":"+linkKey(domain, code), time.Now())
+}
+
+// servePreview shows where a link goes instead of redirecting. The
+// destination of a protected link stays hidden until it is unlocked.
+func (h *URLHandler) servePreview(w http.ResponseWriter, r *http.Request, req service.RedirectRequest, redirect *service.Redirect, interstitial bool) {
+	data := previewPageData{
+		Interstitial: interstitial,
+		ContinueURL:  "/" + req.Code + req.Path,
+	}
+	if req.RawQuery != "" {
+		data.ContinueURL += "?" + req.RawQuery
+	}
+	if !redirect.Protected || h.hasAccess(r, redirect.Domain, req.Code) {
+		data.Destination = redirect.URL
+		data.Warnings = service.CheckSafety(redirect.URL).Warnings
+		h.describeDestination(r, redirect, req.Code, &data)
+	}
+	if !redirect.CreatedAt.IsZero() {
+		data.CreatedAt = redirect.CreatedAt.UTC().Format("2 January 2006")
+	}
+
+	expires := time.Now().Add(continueTTL)
+	http.SetCookie(w, h.linkCookie(req.Code, continueCookie, h.access.signer.Sign(continueCookie+":"+linkKey(redirect.Domain, req.Code), expires), expires))
+	h.logger.WithFields(logrus.Fields{
+		"code":         req.Code,
+		"interstitial": interstitial,
+		"remote_ip":    r.RemoteAddr,
+	}).Info("Link preview shown")
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	renderPage(w, r, http.StatusOK, "preview", data)
+}
+
+// describeDestination adds the destination page's metadata to data, if it
+// has been fetched. The preview is still useful without it, so errors are
+// only logged.
+func (h *URLHandler) describeDestination(r *http.Request, redirect *service.Redirect, code string, data *previewPageData) {
+	if h.metadata == nil {
+		return
+	}
+	meta, err := h.metadata.GetMetadata(r.Context(), redirect.Workspace, redirect.Domain, code)
+	if err != nil {
+		if !errors.Is(err, service.ErrNotFound) {
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Warn("Failed to get link metadata for preview")
+		}
+		return
+	}
+	if meta.Error == "" {
+		data.Title = meta.Title
+		data.Description = meta.Description
+		data.ImageURL = meta.ImageURL
+	}
+}
+
+// clearContinue removes the continue cookie once it has been used, so that
+// the next visit shows the interstitial page again
+func (h *URLHandler) clearContinue(w http.ResponseWriter, code string) {
+	cookie := h.linkCookie(code, continueCookie, "", time.Time{})
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
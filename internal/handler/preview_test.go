@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+func TestSplitPreview(t *testing.T) {
+	for _, tc := range []struct {
+		code, rawQuery   string
+		wantCode, wantRQ string
+		preview          bool
+	}{
+		{"abc", "", "abc", "", false},
+		{"abc+", "", "abc", "", true},
+		{"abc", "preview=1", "abc", "", true},
+		{"abc", "a=1&preview=1&b=%20", "abc", "a=1&b=%20", true},
+		{"abc", "preview=0&a=1", "abc", "a=1", false},
+		{"abc", "previews=1", "abc", "previews=1", false},
+	} {
+		code, rawQuery, preview := splitPreview(tc.code, tc.rawQuery)
+		assert.Equal(t, tc.wantCode, code, tc.code+"?"+tc.rawQuery)
+		assert.Equal(t, tc.wantRQ, rawQuery, tc.code+"?"+tc.rawQuery)
+		assert.Equal(t, tc.preview, preview, tc.code+"?"+tc.rawQuery)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	t.Run("shows the destination without redirecting", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "http://203.0.113.7/login", Status: http.StatusFound, CreatedAt: created}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "abc", RawQuery: "q=1", ClientID: testClientID}).Return(redirect, nil).Twice()
+
+		for target, code := range map[string]string{"/abc+?q=1": "abc+", "/abc?q=1&preview=1": "abc"} {
+			w := httptest.NewRecorder()
+			handler.RedirectURL(w, newRedirectRequest("GET", target, code, nil))
+
+			assert.Equal(t, http.StatusOK, w.Code, target)
+			assert.Empty(t, w.Header().Get("Location"))
+			assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+			body := w.Body.String()
+			assert.Contains(t, body, "http://203.0.113.7/login")
+			assert.Contains(t, body, "1 May 2024")
+			assert.Contains(t, body, "secure (HTTPS)")
+			assert.Contains(t, body, "bare IP address")
+			assert.Contains(t, body, `href="/abc?q=1"`)
+		}
+		mockService.AssertNotCalled(t, "RecordClick", "abc", service.Click{})
+	})
+
+	t.Run("hides the destination of a locked link", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://example.com/secret", Status: http.StatusFound, Protected: true}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "secret", ClientID: testClientID}).Return(redirect, nil).Once()
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/secret+", "secret+", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "example.com")
+		assert.Contains(t, w.Body.String(), "password protected")
+	})
+}
+
+func TestInterstitialRedirect(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+	redirect := &service.Redirect{URL: "https://example.com/", Status: http.StatusFound, Interstitial: true}
+	mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "careful", ClientID: testClientID}).Return(redirect, nil)
+	mockService.On("RecordClick", "", "careful", service.Click{}).Return(nil).Once()
+
+	// The first visit shows the page and counts nothing
+	w := httptest.NewRecorder()
+	handler.RedirectURL(w, newRedirectRequest("GET", "/careful", "careful", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://example.com/")
+	cont := responseCookie(w, continueCookie)
+	require.NotNil(t, cont)
+	assert.Equal(t, "/careful", cont.Path)
+	mockService.AssertNotCalled(t, "RecordClick", "careful", service.Click{})
+
+	// Following Continue redirects and uses up the cookie
+	w = httptest.NewRecorder()
+	handler.RedirectURL(w, newRedirectRequest("GET", "/careful", "careful", nil, cont))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/", w.Header().Get("Location"))
+	cleared := responseCookie(w, continueCookie)
+	require.NotNil(t, cleared)
+	assert.Equal(t, -1, cleared.MaxAge)
+
+	// A continue cookie for another link does not help
+	forged := &http.Cookie{Name: continueCookie, Value: handler.access.signer.Sign(continueCookie+":other", time.Now().Add(time.Minute))}
+	w = httptest.NewRecorder()
+	handler.RedirectURL(w, newRedirectRequest("GET", "/careful", "careful", nil, forged))
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/urlshortener/internal/service"
+)
+
+// problemContentType is the media type defined by RFC 7807
+const problemContentType = "application/problem+json"
+
+// Problem type URIs. These are part of the public API and must not change.
+const (
+	ProblemTypeInvalidRequest = "/problems/invalid-request"
+	ProblemTypeInvalidURL     = "/problems/invalid-url"
+	ProblemTypeBlockedURL     = "/problems/blocked-url"
+	ProblemTypeNotFound       = "/problems/not-found"
+	ProblemTypeConflict       = "/problems/conflict"
+	ProblemTypeExpired        = "/problems/expired"
+	ProblemTypeNotActive      = "/problems/not-active"
+	ProblemTypeUnavailable    = "/problems/unavailable"
+	ProblemTypeBatchTooLarge  = "/problems/batch-too-large"
+	ProblemTypeKeyReused      = "/problems/idempotency-key-reused"
+	ProblemTypeKeyInUse       = "/problems/idempotency-key-in-use"
+	ProblemTypeUnauthorized   = "/problems/unauthorized"
+	ProblemTypeForbidden      = "/problems/forbidden"
+	ProblemTypeQuotaExceeded  = "/problems/quota-exceeded"
+	ProblemTypeInternal       = "/problems/internal-error"
+)
+
+// Problem represents an RFC 7807 problem details response
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// newProblem builds a problem for a request-level failure that has no
+// underlying service error, such as an unparseable body
+func newProblem(problemType string, status int, detail string) Problem {
+	return Problem{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// problemFromError maps a service error to its problem response. This is
+// the single place where error types are translated into status codes.
+func problemFromError(err error) Problem {
+	var urlErr *service.URLError
+	detail := ""
+	if errors.As(err, &urlErr) {
+		detail = urlErr.Reason
+	}
+	var inputErr *service.InputError
+	if errors.As(err, &inputErr) {
+		detail = inputErr.Error()
+	}
+	var quotaErr *service.QuotaError
+	if errors.As(err, &quotaErr) {
+		detail = quotaErr.Reason()
+	}
+
+	switch {
+	case errors.Is(err, service.ErrInvalidInput):
+		return newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, detail)
+	case errors.Is(err, service.ErrInvalidURL):
+		return newProblem(ProblemTypeInvalidURL, http.StatusBadRequest, orDefault(detail, "please provide a valid URL"))
+	case errors.Is(err, service.ErrBlocked):
+		return newProblem(ProblemTypeBlockedURL, http.StatusForbidden, orDefault(detail, "this URL cannot be shortened"))
+	case errors.Is(err, service.ErrNotActive):
+		return newProblem(ProblemTypeNotActive, http.StatusNotFound, "this link is not active yet")
+	case errors.Is(err, service.ErrNotFound):
+		return newProblem(ProblemTypeNotFound, http.StatusNotFound, "no URL exists for this code")
+	case errors.Is(err, service.ErrConflict):
+		return newProblem(ProblemTypeConflict, http.StatusConflict, "this code is already in use")
+	case errors.Is(err, service.ErrIdempotencyKeyReused):
+		return newProblem(ProblemTypeKeyReused, http.StatusUnprocessableEntity, "this idempotency key was already used for a different request")
+	case errors.Is(err, service.ErrIdempotencyKeyInUse):
+		return newProblem(ProblemTypeKeyInUse, http.StatusConflict, "a request with this idempotency key is still in progress")
+	case errors.Is(err, service.ErrQuotaExceeded):
+		// Active links only free up as links end, so retrying will not help
+		if quotaErr != nil && quotaErr.Window == service.QuotaWindowActive {
+			return newProblem(ProblemTypeQuotaExceeded, http.StatusForbidden, detail)
+		}
+		return newProblem(ProblemTypeQuotaExceeded, http.StatusTooManyRequests, orDefault(detail, "the link quota is used up"))
+	case errors.Is(err, service.ErrExpired):
+		return newProblem(ProblemTypeExpired, http.StatusGone, "this link is no longer available")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return newProblem(ProblemTypeUnavailable, http.StatusServiceUnavailable, "the request could not be completed in time")
+	default:
+		// Never leak internal error text to clients
+		return newProblem(ProblemTypeInternal, http.StatusInternalServerError, "an unexpected error occurred")
+	}
+}
+
+// respondWithProblem sends a problem+json response
+func respondWithProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Instance == "" && r != nil {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// respondWithError maps err to a problem and sends it
+func respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	setRetryAfter(w, err)
+	respondWithProblem(w, r, problemFromError(err))
+}
+
+// setRetryAfter tells clients refused by a quota when it resets
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var quotaErr *service.QuotaError
+	if errors.As(err, &quotaErr) && !quotaErr.ResetAt.IsZero() {
+		seconds := int(math.Ceil(time.Until(quotaErr.ResetAt).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
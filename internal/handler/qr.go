@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/qr"
+	"github.com/urlshortener/internal/service"
+)
+
+const (
+	// qrCacheSize bounds how many rendered images are kept in memory
+	qrCacheSize = 512
+	// qrMaxAge is how long clients may cache an image. A code's image only
+	// changes if BASE_URL does.
+	qrMaxAge = 24 * time.Hour
+)
+
+// QRHandler serves QR codes for short links
+type QRHandler struct {
+	service  service.URLService
+	renderer *qr.Renderer
+	logger   *logrus.Logger
+}
+
+// NewQRHandler creates a new QRHandler
+func NewQRHandler(service service.URLService, logger *logrus.Logger) *QRHandler {
+	return &QRHandler{
+		service:  service,
+		renderer: qr.NewRenderer(qrCacheSize),
+		logger:   logger,
+	}
+}
+
+// QRCode handles the GET /{code}/qr endpoint. The image encodes the short
+// URL, as PNG or SVG chosen by ?format=png|svg or the Accept header. Size,
+// margin, error correction level and colours can be set with the size,
+// margin, level, fg and bg query parameters. The code is looked up on the
+// domain of the request's Host, as for redirects.
+func (h *QRHandler) QRCode(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	format, opts, err := qrRequestOptions(r)
+	if err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	shortURL, err := h.service.ShortURL(r.Context(), r.Host, code)
+	if err != nil {
+		if problemFromError(err).Status >= http.StatusInternalServerError {
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Error("Failed to look up link for QR code")
+		}
+		respondWithError(w, r, err)
+		return
+	}
+
+	image, err := h.renderer.Render(shortURL, format, opts)
+	if err != nil {
+		h.logger.WithFields(logrus.Fields{
+			"code":  code,
+			"error": err.Error(),
+		}).Error("Failed to render QR code")
+		respondWithError(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(image)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(qrMaxAge.Seconds())))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Vary", "Accept")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(image))
+}
+
+// qrRequestOptions reads the image format and drawing options from the
+// request, starting from qr.DefaultOptions
+func qrRequestOptions(r *http.Request) (qr.Format, qr.Options, error) {
+	query := r.URL.Query()
+	opts := qr.DefaultOptions()
+
+	format := qr.FormatPNG
+	if name := query.Get("format"); name != "" {
+		var err error
+		if format, err = qr.ParseFormat(name); err != nil {
+			return "", opts, err
+		}
+	} else {
+		for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+			mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+			if accepted, ok := qr.FormatForMediaType(mediaType); ok {
+				format = accepted
+				break
+			}
+		}
+	}
+
+	for name, field := range map[string]*int{"size": &opts.Size, "margin": &opts.Margin} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return "", opts, fmt.Errorf("%s must be a whole number", name)
+			}
+			*field = n
+		}
+	}
+	if value := query.Get("level"); value != "" {
+		level, err := qr.ParseLevel(value)
+		if err != nil {
+			return "", opts, err
+		}
+		opts.Level = level
+	}
+	if value := query.Get("fg"); value != "" {
+		c, err := qr.ParseColor(value)
+		if err != nil {
+			return "", opts, fmt.Errorf("fg: %w", err)
+		}
+		opts.Foreground = c
+	}
+	if value := query.Get("bg"); value != "" {
+		c, err := qr.ParseColor(value)
+		if err != nil {
+			return "", opts, fmt.Errorf("bg: %w", err)
+		}
+		opts.Background = c
+	}
+	if err := opts.Validate(); err != nil {
+		return "", opts, err
+	}
+	return format, opts, nil
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/service"
+)
+
+// newQRRequest builds a QR code request for code as routed by chi
+func newQRRequest(target, code, accept string) *http.Request {
+	req := httptest.NewRequest("GET", target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("code", code)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestQRCode(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewQRHandler(mockService, newTestLogger())
+	mockService.On("ShortURL", testHost, "abc123").Return("http://localhost:8080/abc123", nil)
+	mockService.On("ShortURL", testHost, "missing").Return("", fmt.Errorf("%w for code: missing", service.ErrNotFound))
+
+	t.Run("PNG by default", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.QRCode(w, newQRRequest("/abc123/qr?size=300", "abc123", ""))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		assert.Equal(t, "public, max-age=86400", w.Header().Get("Cache-Control"))
+		img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, 300, img.Bounds().Dx())
+	})
+
+	t.Run("SVG by Accept header or format", func(t *testing.T) {
+		for _, tc := range []struct{ target, accept string }{
+			{"/abc123/qr", "image/svg+xml, image/*;q=0.8"},
+			{"/abc123/qr?format=svg&fg=%23336699&level=H", "image/png"},
+		} {
+			w := httptest.NewRecorder()
+			handler.QRCode(w, newQRRequest(tc.target, "abc123", tc.accept))
+
+			require.Equal(t, http.StatusOK, w.Code, tc.target)
+			assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+			assert.True(t, strings.HasPrefix(w.Body.String(), "<svg"))
+		}
+	})
+
+	t.Run("unchanged image is not sent again", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.QRCode(w, newQRRequest("/abc123/qr", "abc123", ""))
+		etag := w.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		req := newQRRequest("/abc123/qr", "abc123", "")
+		req.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		handler.QRCode(w, req)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.Bytes())
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		for _, query := range []string{"format=gif", "size=big", "size=10", "margin=99", "level=Z", "bg=blue"} {
+			w := httptest.NewRecorder()
+			handler.QRCode(w, newQRRequest("/abc123/qr?"+query, "abc123", ""))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+			assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.QRCode(w, newQRRequest("/missing/qr", "missing", ""))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// QuotaHandler handles the endpoints reporting link quotas and their
+// usage, and those setting quotas. The latter must be protected by
+// security.AdminAuth, since workspace admins may not raise their own.
+type QuotaHandler struct {
+	service service.QuotaService
+	logger  *logrus.Logger
+}
+
+// NewQuotaHandler creates a new QuotaHandler
+func NewQuotaHandler(service service.QuotaService, logger *logrus.Logger) *QuotaHandler {
+	return &QuotaHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// QuotaRequest represents the limits of a quota. Zero is no limit.
+type QuotaRequest struct {
+	LinksPerDay   int64 `json:"links_per_day"`
+	LinksPerMonth int64 `json:"links_per_month"`
+	ActiveLinks   int64 `json:"active_links"`
+}
+
+// QuotaUsageResponse represents a quota with its usage
+type QuotaUsageResponse struct {
+	// Member is empty for the quota of the whole workspace
+	Member string            `json:"member,omitempty"`
+	Limits QuotaRequest      `json:"limits"`
+	Usage  LinkUsageResponse `json:"usage"`
+	// Days and months are counted in UTC
+	DayResetsAt   time.Time `json:"day_resets_at"`
+	MonthResetsAt time.Time `json:"month_resets_at"`
+}
+
+// LinkUsageResponse represents the links counted against a quota
+type LinkUsageResponse struct {
+	LinksToday     int64 `json:"links_today"`
+	LinksThisMonth int64 `json:"links_this_month"`
+	ActiveLinks    int64 `json:"active_links"`
+}
+
+// CallerUsageResponse represents the quotas a caller creates links under
+type CallerUsageResponse struct {
+	Workspace QuotaUsageResponse `json:"workspace"`
+	// Member is only set for requests made with an API key
+	Member *QuotaUsageResponse `json:"member,omitempty"`
+}
+
+// QuotaUsageListResponse represents the quotas of a workspace, its own
+// first
+type QuotaUsageListResponse struct {
+	Quotas []QuotaUsageResponse `json:"quotas"`
+}
+
+// GetUsage handles GET /api/v1/usage, reporting the quotas of the caller's
+// workspace and, for API keys, of their member
+func (h *QuotaHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	workspace, member := workspaceOf(r), memberOf(r)
+	report, err := h.service.GetUsage(r.Context(), workspace, "")
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	response := CallerUsageResponse{Workspace: newQuotaUsageResponse(report)}
+	if member != "" {
+		report, err := h.service.GetUsage(r.Context(), workspace, member)
+		if err != nil {
+			h.respondWithError(w, r, err)
+			return
+		}
+		memberUsage := newQuotaUsageResponse(report)
+		response.Member = &memberUsage
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// ListUsage handles GET /api/v1/admin/usage, reporting the quota of the
+// workspace and those of its members
+func (h *QuotaHandler) ListUsage(w http.ResponseWriter, r *http.Request) {
+	reports, err := h.service.ListUsage(r.Context(), workspaceOf(r))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	response := QuotaUsageListResponse{Quotas: make([]QuotaUsageResponse, len(reports))}
+	for i, report := range reports {
+		response.Quotas[i] = newQuotaUsageResponse(report)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// SaveQuota handles PUT /api/v1/admin/quotas/workspace and
+// PUT /api/v1/admin/quotas/members/{email}, replacing the quota of the
+// workspace or member
+func (h *QuotaHandler) SaveQuota(w http.ResponseWriter, r *http.Request) {
+	var body QuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	quota := &service.Quota{
+		Workspace:     workspaceOf(r),
+		Member:        chi.URLParam(r, "email"),
+		LinksPerDay:   body.LinksPerDay,
+		LinksPerMonth: body.LinksPerMonth,
+		ActiveLinks:   body.ActiveLinks,
+	}
+	if err := h.service.SaveQuota(r.Context(), quota); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace":       quota.Workspace,
+		"member":          quota.Member,
+		"links_per_day":   quota.LinksPerDay,
+		"links_per_month": quota.LinksPerMonth,
+		"active_links":    quota.ActiveLinks,
+		"remote_ip":       r.RemoteAddr,
+	}).Info("Quota saved")
+	report, err := h.service.GetUsage(r.Context(), quota.Workspace, quota.Member)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newQuotaUsageResponse(report))
+}
+
+// DeleteQuota handles DELETE /api/v1/admin/quotas/workspace and
+// DELETE /api/v1/admin/quotas/members/{email}, lifting the quota
+func (h *QuotaHandler) DeleteQuota(w http.ResponseWriter, r *http.Request) {
+	workspace, member := workspaceOf(r), chi.URLParam(r, "email")
+	if err := h.service.DeleteQuota(r.Context(), workspace, member); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace": workspace,
+		"member":    member,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Quota removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithError logs unexpected failures and sends the problem for err
+func (h *QuotaHandler) respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := problemFromError(err)
+	if problem.Status >= http.StatusInternalServerError {
+		h.logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"path":  r.URL.Path,
+		}).Error("Quota request failed")
+	}
+	if problem.Status == http.StatusNotFound {
+		switch {
+		case chi.URLParam(r, "email") == "":
+			problem.Detail = "this workspace has no quota"
+		case r.Method == http.MethodPut:
+			problem.Detail = "no member of this workspace has this email"
+		default:
+			problem.Detail = "this member has no quota"
+		}
+	}
+	respondWithProblem(w, r, problem)
+}
+
+// newQuotaUsageResponse converts a quota report into its response body
+func newQuotaUsageResponse(report *service.QuotaReport) QuotaUsageResponse {
+	return QuotaUsageResponse{
+		Member: report.Member,
+		Limits: QuotaRequest{
+			LinksPerDay:   report.LinksPerDay,
+			LinksPerMonth: report.LinksPerMonth,
+			ActiveLinks:   report.ActiveLinks,
+		},
+		Usage: LinkUsageResponse{
+			LinksToday:     report.Usage.LinksToday,
+			LinksThisMonth: report.Usage.LinksThisMonth,
+			ActiveLinks:    report.Usage.ActiveLinks,
+		},
+		DayResetsAt:   report.DayResetsAt,
+		MonthResetsAt: report.MonthResetsAt,
+	}
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockQuotaService is a mock implementation of service.QuotaService
+type MockQuotaService struct {
+	mock.Mock
+}
+
+func (m *MockQuotaService) Reserve(ctx context.Context, workspace, member string, n int) (*service.QuotaReservation, error) {
+	args := m.Called(workspace, member, n)
+	reservation, _ := args.Get(0).(*service.QuotaReservation)
+	return reservation, args.Error(1)
+}
+
+func (m *MockQuotaService) SaveQuota(ctx context.Context, quota *service.Quota) error {
+	return m.Called(*quota).Error(0)
+}
+
+func (m *MockQuotaService) DeleteQuota(ctx context.Context, workspace, member string) error {
+	return m.Called(workspace, member).Error(0)
+}
+
+func (m *MockQuotaService) GetUsage(ctx context.Context, workspace, member string) (*service.QuotaReport, error) {
+	args := m.Called(workspace, member)
+	report, _ := args.Get(0).(*service.QuotaReport)
+	return report, args.Error(1)
+}
+
+func (m *MockQuotaService) ListUsage(ctx context.Context, workspace string) ([]*service.QuotaReport, error) {
+	args := m.Called(workspace)
+	reports, _ := args.Get(0).([]*service.QuotaReport)
+	return reports, args.Error(1)
+}
+
+func newQuotaRouter(h *QuotaHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/usage", h.GetUsage)
+	r.Get("/admin/usage", h.ListUsage)
+	r.Put("/quotas/workspace", h.SaveQuota)
+	r.Delete("/quotas/workspace", h.DeleteQuota)
+	r.Put("/quotas/members/{email}", h.SaveQuota)
+	r.Delete("/quotas/members/{email}", h.DeleteQuota)
+	return r
+}
+
+// withPrincipal returns req acting for principal, as Authenticate would
+func withPrincipal(req *http.Request, principal *service.Principal) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+}
+
+func TestQuotaHandler(t *testing.T) {
+	mockService := new(MockQuotaService)
+	router := newQuotaRouter(NewQuotaHandler(mockService, newTestLogger()))
+	workspaceReport := &service.QuotaReport{
+		Quota: service.Quota{Workspace: "marketing", LinksPerDay: 100},
+		Usage: service.QuotaUsage{LinksToday: 12, LinksThisMonth: 40, ActiveLinks: 35},
+	}
+	memberReport := &service.QuotaReport{
+		Quota: service.Quota{Workspace: "marketing", Member: "ann@example.com", LinksPerMonth: 50},
+		Usage: service.QuotaUsage{LinksToday: 3, LinksThisMonth: 9, ActiveLinks: 9},
+	}
+
+	t.Run("anonymous usage only reports the workspace", func(t *testing.T) {
+		mockService.On("GetUsage", "", "").Return(&service.QuotaReport{}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/usage", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"workspace":{"limits":{"links_per_day":0`)
+		assert.NotContains(t, w.Body.String(), `"member"`)
+	})
+
+	t.Run("api keys also see their member's usage", func(t *testing.T) {
+		mockService.On("GetUsage", "marketing", "").Return(workspaceReport, nil).Once()
+		mockService.On("GetUsage", "marketing", "ann@example.com").Return(memberReport, nil).Once()
+
+		w := httptest.NewRecorder()
+		req := withPrincipal(httptest.NewRequest("GET", "/usage", nil),
+			&service.Principal{Workspace: "marketing", Member: "ann@example.com", Role: service.RoleMember})
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"links_per_day":100`)
+		assert.Contains(t, w.Body.String(), `"member":{"member":"ann@example.com","limits":{"links_per_day":0,"links_per_month":50`)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("list usage", func(t *testing.T) {
+		mockService.On("ListUsage", "marketing").Return([]*service.QuotaReport{workspaceReport, memberReport}, nil).Once()
+
+		w := httptest.NewRecorder()
+		req := withPrincipal(httptest.NewRequest("GET", "/admin/usage", nil),
+			&service.Principal{Workspace: "marketing", Role: service.RoleAdmin})
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"links_today":12`)
+		assert.Contains(t, w.Body.String(), `"links_this_month":9`)
+	})
+
+	t.Run("save member quota", func(t *testing.T) {
+		mockService.On("SaveQuota", service.Quota{Workspace: "marketing", Member: "ann@example.com", LinksPerMonth: 50}).Return(nil).Once()
+		mockService.On("GetUsage", "marketing", "ann@example.com").Return(memberReport, nil).Once()
+
+		w := httptest.NewRecorder()
+		req := withPrincipal(httptest.NewRequest("PUT", "/quotas/members/ann@example.com", strings.NewReader(`{"links_per_month":50}`)),
+			&service.Principal{Workspace: "marketing", Role: service.RoleAdmin})
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"links_per_month":50`)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("negative limits are rejected", func(t *testing.T) {
+		mockService.On("SaveQuota", service.Quota{LinksPerDay: -1}).
+			Return(&service.InputError{Field: "links_per_day", Reason: "must not be negative"}).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/quotas/workspace", strings.NewReader(`{"links_per_day":-1}`)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "links_per_day")
+	})
+
+	t.Run("unknown member", func(t *testing.T) {
+		mockService.On("SaveQuota", service.Quota{Member: "bob@example.com", LinksPerDay: 5}).
+			Return(fmt.Errorf("%w for member: bob@example.com", service.ErrNotFound)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/quotas/members/bob@example.com", strings.NewReader(`{"links_per_day":5}`)))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "no member of this workspace has this email", decodeProblem(t, w).Detail)
+	})
+
+	t.Run("delete quota", func(t *testing.T) {
+		mockService.On("DeleteQuota", "", "").Return(nil).Once()
+		mockService.On("DeleteQuota", "", "ann@example.com").Return(fmt.Errorf("%w for quota: ann@example.com", service.ErrNotFound)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/quotas/workspace", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/quotas/members/ann@example.com", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "this member has no quota", decodeProblem(t, w).Detail)
+	})
+}
+
+func TestShortenURLOverQuota(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+	shorten := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/shorten", strings.NewReader(`{"url":"http://example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ShortenURL(w, req)
+		return w
+	}
+
+	t.Run("daily quota", func(t *testing.T) {
+		mockService.On("ShortenURL", "http://example.com").Return(nil, &service.QuotaError{
+			Window:  service.QuotaWindowDay,
+			Limit:   100,
+			ResetAt: time.Now().Add(90 * time.Second),
+		}).Once()
+
+		w := shorten()
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "90", w.Header().Get("Retry-After"))
+		problem := decodeProblem(t, w)
+		assert.Equal(t, ProblemTypeQuotaExceeded, problem.Type)
+		assert.Equal(t, "the workspace may create 100 links per day", problem.Detail)
+	})
+
+	t.Run("active links", func(t *testing.T) {
+		mockService.On("ShortenURL", "http://example.com").Return(nil, &service.QuotaError{
+			Member: "ann@example.com",
+			Window: service.QuotaWindowActive,
+			Limit:  10,
+		}).Once()
+
+		w := shorten()
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, w.Header().Get("Retry-After"))
+		problem := decodeProblem(t, w)
+		assert.Equal(t, ProblemTypeQuotaExceeded, problem.Type)
+		assert.Equal(t, "ann@example.com may have 10 active links", problem.Detail)
+	})
+}
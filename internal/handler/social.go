@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+	"github.com/urlshortener/internal/useragent"
+)
+
+// SocialCardBody holds a link's social card in request and response bodies
+type SocialCardBody struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// toService converts the body into a service card
+func (b *SocialCardBody) toService() service.SocialCard {
+	if b == nil {
+		return service.SocialCard{}
+	}
+	return service.SocialCard{Title: b.Title, Description: b.Description, ImageURL: b.ImageURL}
+}
+
+// newSocialCardBody returns the body for card, or nil if it is unset
+func newSocialCardBody(card service.SocialCard) *SocialCardBody {
+	if card.IsZero() {
+		return nil
+	}
+	return &SocialCardBody{Title: card.Title, Description: card.Description, ImageURL: card.ImageURL}
+}
+
+// socialCardPageData fills the "social-card" page
+type socialCardPageData struct {
+	Title       string
+	Description string
+	ImageURL    string
+	ShortURL    string
+	// Destination is empty for protected links
+	Destination string
+}
+
+// wantsSocialCard reports whether the request comes from a crawler that
+// should be shown the link's social card instead of being redirected
+func wantsSocialCard(r *http.Request, redirect *service.Redirect) bool {
+	if redirect.Social.IsZero() || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	return useragent.IsCrawler(r.UserAgent())
+}
+
+// serveSocialCard shows a crawler the link's Open Graph and Twitter Card
+// tags, so that previews describe the destination as the link's owner
+// chose without the crawler following the redirect. Crawler visits are not
+// counted as clicks.
+func (h *URLHandler) serveSocialCard(w http.ResponseWriter, r *http.Request, req service.RedirectRequest, redirect *service.Redirect) {
+	data := socialCardPageData{
+		Title:       redirect.Social.Title,
+		Description: redirect.Social.Description,
+		ImageURL:    redirect.Social.ImageURL,
+	}
+	if shortURL, err := h.service.ShortURL(r.Context(), req.Host, req.Code); err == nil {
+		data.ShortURL = shortURL
+	}
+	if !redirect.Protected {
+		data.Destination = redirect.URL
+	}
+	if data.Title == "" {
+		data.Title = data.ShortURL
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"code":       req.Code,
+		"user_agent": r.UserAgent(),
+	}).Info("Social card shown to crawler")
+
+	if redirect.Cacheable() {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	renderPage(w, r, http.StatusOK, "social-card", data)
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+const (
+	slackbotUA = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	browserUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+)
+
+func TestSocialCardBody(t *testing.T) {
+	var req ShortenURLRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"url": "https://example.com", "social": {"title": "Sale", "image_url": "https://example.com/card.png"}}`), &req))
+	assert.Equal(t, service.SocialCard{Title: "Sale", ImageURL: "https://example.com/card.png"}, req.toService().Social)
+
+	body, err := json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc", Social: service.SocialCard{Title: "Sale"}}))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"social":{"title":"Sale"}`)
+
+	body, err = json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc"}))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "social")
+}
+
+func TestSocialCardForCrawlers(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+	card := service.SocialCard{Title: `Spring "sale"`, Description: "Everything must go", ImageURL: "https://cdn.example.com/sale.png"}
+
+	visit := func(code, userAgent string) *httptest.ResponseRecorder {
+		req := newRedirectRequest("GET", "/"+code, code, nil)
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, req)
+		return w
+	}
+
+	t.Run("crawlers get the card without a click being counted", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://example.com/sale?utm_source=chat", Status: http.StatusFound, Social: card}
+		mockService.On("ResolveRedirect", withCode("sale")).Return(redirect, nil)
+		mockService.On("ShortURL", testHost, "sale").Return("http://localhost:8080/sale", nil)
+
+		w := visit("sale", slackbotUA)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "User-Agent", w.Header().Get("Vary"))
+		body := w.Body.String()
+		assert.Contains(t, body, `<meta property="og:title" content="Spring &#34;sale&#34;">`)
+		assert.Contains(t, body, `<meta property="og:description" content="Everything must go">`)
+		assert.Contains(t, body, `<meta property="og:image" content="https://cdn.example.com/sale.png">`)
+		assert.Contains(t, body, `<meta name="twitter:card" content="summary_large_image">`)
+		assert.Contains(t, body, `<meta property="og:url" content="http://localhost:8080/sale">`)
+		mockService.AssertNotCalled(t, "RecordClick", "sale", service.Click{})
+	})
+
+	t.Run("people are redirected", func(t *testing.T) {
+		mockService.On("RecordClick", "", "sale", service.Click{}).Return(nil).Once()
+
+		w := visit("sale", browserUA)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/sale?utm_source=chat", w.Header().Get("Location"))
+		assert.Equal(t, "User-Agent", w.Header().Get("Vary"))
+	})
+
+	t.Run("crawlers follow links without a card", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://example.com/plain", Status: http.StatusFound}
+		mockService.On("ResolveRedirect", withCode("plain")).Return(redirect, nil)
+		mockService.On("RecordClick", "", "plain", service.Click{}).Return(nil).Once()
+
+		w := visit("plain", slackbotUA)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+
+	t.Run("protected links keep their destination hidden", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://example.com/secret", Status: http.StatusFound, Protected: true,
+			Social: service.SocialCard{Description: "Members only"}}
+		mockService.On("ResolveRedirect", withCode("secret")).Return(redirect, nil)
+		mockService.On("ShortURL", testHost, "secret").Return("http://localhost:8080/secret", nil)
+
+		w := visit("secret", slackbotUA)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		body := w.Body.String()
+		assert.NotContains(t, body, "example.com/secret")
+		assert.Contains(t, body, `<title>http://localhost:8080/secret</title>`)
+		assert.Contains(t, body, `<meta name="twitter:card" content="summary">`)
+	})
+	mockService.AssertExpectations(t)
+}
+
+// withCode matches a redirect request for code from any user agent
+func withCode(code string) interface{} {
+	return mock.MatchedBy(func(req service.RedirectRequest) bool { return req.Code == code })
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// UTMTemplateHandler handles the UTM template endpoints
+type UTMTemplateHandler struct {
+	service service.UTMTemplateService
+	logger  *logrus.Logger
+}
+
+// NewUTMTemplateHandler creates a new UTMTemplateHandler
+func NewUTMTemplateHandler(service service.UTMTemplateService, logger *logrus.Logger) *UTMTemplateHandler {
+	return &UTMTemplateHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// UTMParamsBody holds UTM parameters in request and response bodies
+type UTMParamsBody struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// UTMTemplateResponse represents a stored template
+type UTMTemplateResponse struct {
+	Team string `json:"team"`
+	Name string `json:"name"`
+	UTMParamsBody
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UTMTemplateListResponse represents a team's templates
+type UTMTemplateListResponse struct {
+	Templates []UTMTemplateResponse `json:"templates"`
+}
+
+// ListTemplates handles GET /api/v1/admin/teams/{team}/utm-templates
+func (h *UTMTemplateHandler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.service.ListTemplates(r.Context(), workspaceOf(r), chi.URLParam(r, "team"))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	response := UTMTemplateListResponse{Templates: make([]UTMTemplateResponse, len(templates))}
+	for i, tmpl := range templates {
+		response.Templates[i] = newUTMTemplateResponse(tmpl)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// GetTemplate handles GET /api/v1/admin/teams/{team}/utm-templates/{name}
+func (h *UTMTemplateHandler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := h.service.GetTemplate(r.Context(), workspaceOf(r), chi.URLParam(r, "team"), chi.URLParam(r, "name"))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newUTMTemplateResponse(tmpl))
+}
+
+// SaveTemplate handles PUT /api/v1/admin/teams/{team}/utm-templates/{name},
+// creating the template or replacing all of its parameters
+func (h *UTMTemplateHandler) SaveTemplate(w http.ResponseWriter, r *http.Request) {
+	var body UTMParamsBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	tmpl := &service.UTMTemplate{
+		Workspace: workspaceOf(r),
+		Team:      chi.URLParam(r, "team"),
+		Name:      chi.URLParam(r, "name"),
+		Params:    body.toService(),
+	}
+	if err := h.service.SaveTemplate(r.Context(), tmpl); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"team":      tmpl.Team,
+		"name":      tmpl.Name,
+		"remote_ip": r.RemoteAddr,
+	}).Info("UTM template saved")
+	respondWithJSON(w, http.StatusOK, newUTMTemplateResponse(tmpl))
+}
+
+// DeleteTemplate handles DELETE /api/v1/admin/teams/{team}/utm-templates/{name}
+func (h *UTMTemplateHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	team, name := chi.URLParam(r, "team"), chi.URLParam(r, "name")
+	if err := h.service.DeleteTemplate(r.Context(), workspaceOf(r), team, name); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"team":      team,
+		"name":      name,
+		"remote_ip": r.RemoteAddr,
+	}).Info("UTM template deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithError logs unexpected failures and sends the problem for err
+func (h *UTMTemplateHandler) respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := problemFromError(err)
+	if problem.Status >= http.StatusInternalServerError {
+		h.logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"path":  r.URL.Path,
+		}).Error("UTM template request failed")
+	}
+	if problem.Status == http.StatusNotFound {
+		problem.Detail = "no UTM template exists with this name"
+	}
+	respondWithProblem(w, r, problem)
+}
+
+// toService converts the body into service parameters
+func (b UTMParamsBody) toService() service.UTMParams {
+	return service.UTMParams{
+		Source:   b.Source,
+		Medium:   b.Medium,
+		Campaign: b.Campaign,
+		Term:     b.Term,
+		Content:  b.Content,
+	}
+}
+
+// newUTMTemplateResponse converts a template into its response body
+func newUTMTemplateResponse(tmpl *service.UTMTemplate) UTMTemplateResponse {
+	return UTMTemplateResponse{
+		Team: tmpl.Team,
+		Name: tmpl.Name,
+		UTMParamsBody: UTMParamsBody{
+			Source:   tmpl.Params.Source,
+			Medium:   tmpl.Params.Medium,
+			Campaign: tmpl.Params.Campaign,
+			Term:     tmpl.Params.Term,
+			Content:  tmpl.Params.Content,
+		},
+		CreatedAt: tmpl.CreatedAt,
+		UpdatedAt: tmpl.UpdatedAt,
+	}
+}
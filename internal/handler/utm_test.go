@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockUTMTemplateService is a mock implementation of service.UTMTemplateService
+type MockUTMTemplateService struct {
+	mock.Mock
+}
+
+func (m *MockUTMTemplateService) SaveTemplate(ctx context.Context, tmpl *service.UTMTemplate) error {
+	return m.Called(tmpl).Error(0)
+}
+
+func (m *MockUTMTemplateService) GetTemplate(ctx context.Context, workspace, team, name string) (*service.UTMTemplate, error) {
+	args := m.Called(workspace, team, name)
+	tmpl, _ := args.Get(0).(*service.UTMTemplate)
+	return tmpl, args.Error(1)
+}
+
+func (m *MockUTMTemplateService) ListTemplates(ctx context.Context, workspace, team string) ([]*service.UTMTemplate, error) {
+	args := m.Called(workspace, team)
+	templates, _ := args.Get(0).([]*service.UTMTemplate)
+	return templates, args.Error(1)
+}
+
+func (m *MockUTMTemplateService) DeleteTemplate(ctx context.Context, workspace, team, name string) error {
+	return m.Called(workspace, team, name).Error(0)
+}
+
+func newUTMTemplateRouter(h *UTMTemplateHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/teams/{team}/utm-templates", h.ListTemplates)
+	r.Get("/teams/{team}/utm-templates/{name}", h.GetTemplate)
+	r.Put("/teams/{team}/utm-templates/{name}", h.SaveTemplate)
+	r.Delete("/teams/{team}/utm-templates/{name}", h.DeleteTemplate)
+	return r
+}
+
+func TestUTMTemplateHandler(t *testing.T) {
+	mockService := new(MockUTMTemplateService)
+	router := newUTMTemplateRouter(NewUTMTemplateHandler(mockService, newTestLogger()))
+
+	t.Run("save", func(t *testing.T) {
+		mockService.On("SaveTemplate", &service.UTMTemplate{
+			Team: "growth", Name: "ads", Params: service.UTMParams{Source: "google", Medium: "cpc"},
+		}).Return(nil).Once()
+
+		req := httptest.NewRequest("PUT", "/teams/growth/utm-templates/ads", strings.NewReader(`{"source":"google","medium":"cpc"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"source":"google"`)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		mockService.On("ListTemplates", "", "growth").Return([]*service.UTMTemplate{
+			{Team: "growth", Name: "ads", Params: service.UTMParams{Source: "google"}},
+		}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/teams/growth/utm-templates", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"name":"ads"`)
+	})
+
+	t.Run("missing template", func(t *testing.T) {
+		mockService.On("DeleteTemplate", "", "growth", "gone").Return(fmt.Errorf("%w for UTM template: growth/gone", service.ErrNotFound)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/teams/growth/utm-templates/gone", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "no UTM template exists")
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/teams/growth/utm-templates/ads", strings.NewReader("{")))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestShortenURLRequestUTM(t *testing.T) {
+	req := ShortenURLRequest{
+		URL: "https://example.com",
+		UTM: &UTMRequest{Template: "growth/ads", UTMParamsBody: UTMParamsBody{Campaign: "spring"}},
+	}
+
+	sreq := req.toService()
+
+	assert.Equal(t, "growth/ads", sreq.UTMTemplate)
+	assert.Equal(t, service.UTMParams{Campaign: "spring"}, sreq.UTM)
+}
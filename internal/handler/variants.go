@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+const (
+	// variantCookie remembers which variant of a split link a visitor was
+	// sent to, so that they see the same one again
+	variantCookie = "link_variant"
+	// variantCookieTTL is how long a visitor keeps their variant
+	variantCookieTTL = 30 * 24 * time.Hour
+)
+
+// VariantBody holds one of a split link's destinations in request and
+// response bodies. Clicks is only reported, never accepted.
+type VariantBody struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks *int64 `json:"clicks,omitempty"`
+}
+
+// variantsToService converts variant bodies into service variants
+func variantsToService(bodies []VariantBody) []service.Variant {
+	if len(bodies) == 0 {
+		return nil
+	}
+	variants := make([]service.Variant, len(bodies))
+	for i, body := range bodies {
+		variants[i] = service.Variant{Name: body.Name, URL: body.URL, Weight: body.Weight}
+	}
+	return variants
+}
+
+// newVariantBodies returns the bodies for variants, or nil if there are
+// none. Click counts are included if withClicks is set.
+func newVariantBodies(variants []service.Variant, withClicks bool) []VariantBody {
+	if len(variants) == 0 {
+		return nil
+	}
+	bodies := make([]VariantBody, len(variants))
+	for i, variant := range variants {
+		bodies[i] = VariantBody{Name: variant.Name, URL: variant.URL, Weight: variant.Weight}
+		if withClicks {
+			clicks := variant.Clicks
+			bodies[i].Clicks = &clicks
+		}
+	}
+	return bodies
+}
+
+// clientID identifies the visitor making r by address and browser, so
+// that visitors without the variant cookie still see the same variant
+func (h *URLHandler) clientID(r *http.Request) string {
+	addr := h.proxies.ClientIP(r)
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String() + " " + r.UserAgent()
+}
+
+// assignedVariant returns the variant the visitor was sent to before, if
+// any. The cookie is scoped to the link's path.
+func assignedVariant(r *http.Request) string {
+	cookie, err := r.Cookie(variantCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// rememberVariant keeps the visitor on the variant they were sent to
+func (h *URLHandler) rememberVariant(w http.ResponseWriter, req service.RedirectRequest, redirect *service.Redirect) {
+	if redirect.Variant == "" || redirect.Variant == req.Variant {
+		return
+	}
+	http.SetCookie(w, h.linkCookie(req.Code, variantCookie, redirect.Variant, time.Now().Add(variantCookieTTL)))
+}
+
+// VariantWeightsRequest represents the request body for changing weights
+type VariantWeightsRequest struct {
+	// Weights maps variant names to their new weights; variants not named
+	// keep theirs
+	Weights map[string]int `json:"weights"`
+}
+
+// VariantsResponse lists a split link's variants with their clicks
+type VariantsResponse struct {
+	Code     string        `json:"code"`
+	Variants []VariantBody `json:"variants"`
+}
+
+// SetVariantWeights handles PATCH /api/v1/admin/links/{code}/variants,
+// with ?domain= for links on a branded domain. New weights apply to the
+// next visit.
+func (h *AdminHandler) SetVariantWeights(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	var body VariantWeightsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	variants, err := h.service.SetVariantWeights(r.Context(), workspaceOf(r), r.URL.Query().Get("domain"), code, body.Weights)
+	if err != nil {
+		problem := problemFromError(err)
+		if problem.Status >= http.StatusInternalServerError {
+			h.logger.WithFields(logrus.Fields{
+				"code":  code,
+				"error": err.Error(),
+			}).Error("Failed to set variant weights")
+		}
+		respondWithProblem(w, r, problem)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"code":      code,
+		"weights":   body.Weights,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Variant weights changed")
+	respondWithJSON(w, http.StatusOK, VariantsResponse{Code: code, Variants: newVariantBodies(variants, true)})
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/service"
+)
+
+func TestVariantBody(t *testing.T) {
+	var req ShortenURLRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"url": "https://example.com", "variants": [
+		{"name": "control", "url": "https://example.com/a", "weight": 90},
+		{"url": "https://example.com/b", "weight": 10, "clicks": 500}]}`), &req))
+	assert.Equal(t, []service.Variant{
+		{Name: "control", URL: "https://example.com/a", Weight: 90},
+		{URL: "https://example.com/b", Weight: 10},
+	}, req.toService().Variants)
+
+	body, err := json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc",
+		Variants: []service.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}}}))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"variants":[{"name":"a","url":"https://example.com/a","weight":1}]`)
+
+	body, err = json.Marshal(newShortenURLResponse(&service.ShortenResult{Code: "abc"}))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "variants")
+}
+
+func TestSplitRedirect(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewURLHandler(mockService, metrics.NewMetrics(), newTestLogger(), RedirectConfig{})
+
+	t.Run("new visitors are assigned a variant", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://example.com/b", Status: http.StatusFound, Split: true, Variant: "b"}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "split", ClientID: testClientID}).Return(redirect, nil).Once()
+		mockService.On("RecordClick", "", "split", service.Click{Variant: "b"}).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/split", "split", nil))
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/b", w.Header().Get("Location"))
+		assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, variantCookie, cookies[0].Name)
+		assert.Equal(t, "b", cookies[0].Value)
+		assert.Equal(t, "/split", cookies[0].Path)
+		assert.True(t, cookies[0].HttpOnly)
+	})
+
+	t.Run("returning visitors keep their variant", func(t *testing.T) {
+		redirect := &service.Redirect{URL: "https://example.com/b", Status: http.StatusFound, Split: true, Variant: "b"}
+		mockService.On("ResolveRedirect", service.RedirectRequest{Host: testHost, Code: "split", Variant: "b", ClientID: testClientID}).Return(redirect, nil).Once()
+		mockService.On("RecordClick", "", "split", service.Click{Variant: "b"}).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		handler.RedirectURL(w, newRedirectRequest("GET", "/split", "split", nil, &http.Cookie{Name: variantCookie, Value: "b"}))
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Empty(t, w.Result().Cookies())
+	})
+	mockService.AssertExpectations(t)
+}
+
+func TestSetVariantWeights(t *testing.T) {
+	mockService := new(MockURLService)
+	handler := NewAdminHandler(mockService, newTestLogger())
+
+	patch := func(code, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/admin/links/"+code+"/variants", strings.NewReader(body))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("code", code)
+		w := httptest.NewRecorder()
+		handler.SetVariantWeights(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		return w
+	}
+
+	mockService.On("SetVariantWeights", "", "", "split", map[string]int{"a": 20, "b": 80}).Return([]service.Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 20, Clicks: 7},
+		{Name: "b", URL: "https://example.com/b", Weight: 80},
+	}, nil).Once()
+	w := patch("split", `{"weights": {"a": 20, "b": 80}}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"code": "split", "variants": [
+		{"name": "a", "url": "https://example.com/a", "weight": 20, "clicks": 7},
+		{"name": "b", "url": "https://example.com/b", "weight": 80, "clicks": 0}]}`, w.Body.String())
+
+	mockService.On("SetVariantWeights", "", "", "split", map[string]int{"c": 1}).
+		Return(nil, &service.InputError{Field: "weights.c", Reason: "is not a variant of this link"}).Once()
+	w = patch("split", `{"weights": {"c": 1}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = patch("split", `not json`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockService.AssertExpectations(t)
+}
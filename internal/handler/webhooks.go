@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// WebhookHandler handles the webhook endpoints. Routes using it must be
+// protected by RequireAdmin.
+type WebhookHandler struct {
+	service service.WebhookService
+	logger  *logrus.Logger
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler(service service.WebhookService, logger *logrus.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// WebhookRequest represents the request body for creating a webhook
+type WebhookRequest struct {
+	URL string `json:"url"`
+	// Secret signs the payloads; one is generated if empty
+	Secret string `json:"secret,omitempty"`
+	// Events lists the event types to send; empty means all of them
+	Events []string `json:"events,omitempty"`
+}
+
+// WebhookResponse represents a webhook
+type WebhookResponse struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookListResponse represents every webhook of a workspace
+type WebhookListResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+}
+
+// DeliveryResponse represents a delivery to a webhook
+type DeliveryResponse struct {
+	ID             int64           `json:"id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      time.Time       `json:"created_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	// NextAttemptAt is when a pending delivery is next tried
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// DeliveryListResponse represents the latest deliveries to a webhook
+type DeliveryListResponse struct {
+	Deliveries []DeliveryResponse `json:"deliveries"`
+}
+
+// ListWebhooks handles GET /api/v1/admin/webhooks
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.service.ListWebhooks(r.Context(), workspaceOf(r))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	response := WebhookListResponse{Webhooks: make([]WebhookResponse, len(webhooks))}
+	for i, webhook := range webhooks {
+		response.Webhooks[i] = newWebhookResponse(webhook, false)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// CreateWebhook handles POST /api/v1/admin/webhooks. The secret is only
+// ever returned in this response.
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var body WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	webhook := &service.Webhook{Workspace: workspaceOf(r), URL: body.URL, Secret: body.Secret, Events: body.Events}
+	if err := h.service.CreateWebhook(r.Context(), webhook); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"webhook_id": webhook.ID,
+		"url":        webhook.URL,
+		"events":     webhook.Events,
+		"remote_ip":  r.RemoteAddr,
+	}).Info("Webhook created")
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusCreated, newWebhookResponse(webhook, true))
+}
+
+// GetWebhook handles GET /api/v1/admin/webhooks/{id}
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	webhook, err := h.service.GetWebhook(r.Context(), workspaceOf(r), id)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newWebhookResponse(webhook, false))
+}
+
+// DeleteWebhook handles DELETE /api/v1/admin/webhooks/{id}. Deliveries not
+// yet made are dropped.
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.DeleteWebhook(r.Context(), workspaceOf(r), id); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"webhook_id": id,
+		"remote_ip":  r.RemoteAddr,
+	}).Info("Webhook deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries handles GET /api/v1/admin/webhooks/{id}/deliveries,
+// newest first
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid limit: must be a number"))
+			return
+		}
+	}
+
+	deliveries, err := h.service.ListDeliveries(r.Context(), workspaceOf(r), id, limit)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	response := DeliveryListResponse{Deliveries: make([]DeliveryResponse, len(deliveries))}
+	for i, delivery := range deliveries {
+		response.Deliveries[i] = DeliveryResponse{
+			ID:             delivery.ID,
+			Event:          delivery.Event,
+			Status:         string(delivery.Status),
+			Attempts:       delivery.Attempts,
+			ResponseStatus: delivery.ResponseStatus,
+			Error:          delivery.Error,
+			Payload:        delivery.Payload,
+			CreatedAt:      delivery.CreatedAt,
+			LastAttemptAt:  delivery.LastAttemptAt,
+		}
+		if delivery.Status == service.DeliveryPending {
+			next := delivery.NextAttemptAt
+			response.Deliveries[i].NextAttemptAt = &next
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// webhookID parses the id in the path, sending a problem if it is not a
+// number
+func webhookID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid id: must be a number"))
+		return 0, false
+	}
+	return id, true
+}
+
+// respondWithError logs unexpected failures and sends the problem for err,
+// which concerns a webhook
+func (h *WebhookHandler) respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := problemFromError(err)
+	if problem.Status >= http.StatusInternalServerError {
+		h.logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"path":  r.URL.Path,
+		}).Error("Webhook request failed")
+	}
+	if problem.Status == http.StatusNotFound {
+		problem.Detail = "no webhook exists with this id"
+	}
+	respondWithProblem(w, r, problem)
+}
+
+// newWebhookResponse converts a webhook into its response body, with its
+// secret if withSecret is set
+func newWebhookResponse(webhook *service.Webhook, withSecret bool) WebhookResponse {
+	response := WebhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		CreatedAt: webhook.CreatedAt,
+	}
+	if response.Events == nil {
+		response.Events = []string{}
+	}
+	if withSecret {
+		response.Secret = webhook.Secret
+	}
+	return response
+}
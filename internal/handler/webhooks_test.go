@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockWebhookService is a mock implementation of service.WebhookService
+type MockWebhookService struct {
+	mock.Mock
+}
+
+func (m *MockWebhookService) Wants(ctx context.Context, workspace, eventType string) bool {
+	return m.Called(workspace, eventType).Bool(0)
+}
+
+func (m *MockWebhookService) Publish(ctx context.Context, event service.LinkEvent) {
+	m.Called(event)
+}
+
+func (m *MockWebhookService) Enqueue(event service.LinkEvent) bool {
+	return m.Called(event).Bool(0)
+}
+
+func (m *MockWebhookService) ListWebhooks(ctx context.Context, workspace string) ([]*service.Webhook, error) {
+	args := m.Called(workspace)
+	webhooks, _ := args.Get(0).([]*service.Webhook)
+	return webhooks, args.Error(1)
+}
+
+func (m *MockWebhookService) GetWebhook(ctx context.Context, workspace string, id int64) (*service.Webhook, error) {
+	args := m.Called(workspace, id)
+	webhook, _ := args.Get(0).(*service.Webhook)
+	return webhook, args.Error(1)
+}
+
+func (m *MockWebhookService) CreateWebhook(ctx context.Context, webhook *service.Webhook) error {
+	args := m.Called(*webhook)
+	webhook.ID = 3
+	webhook.Secret = "whsec_generated"
+	return args.Error(0)
+}
+
+func (m *MockWebhookService) DeleteWebhook(ctx context.Context, workspace string, id int64) error {
+	return m.Called(workspace, id).Error(0)
+}
+
+func (m *MockWebhookService) ListDeliveries(ctx context.Context, workspace string, id int64, limit int) ([]*service.WebhookDelivery, error) {
+	args := m.Called(workspace, id, limit)
+	deliveries, _ := args.Get(0).([]*service.WebhookDelivery)
+	return deliveries, args.Error(1)
+}
+
+func (m *MockWebhookService) Run(ctx context.Context, workers int) {
+	m.Called(workers)
+}
+
+func newWebhookRouter(h *WebhookHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/webhooks", h.ListWebhooks)
+	r.Post("/webhooks", h.CreateWebhook)
+	r.Get("/webhooks/{id}", h.GetWebhook)
+	r.Delete("/webhooks/{id}", h.DeleteWebhook)
+	r.Get("/webhooks/{id}/deliveries", h.ListDeliveries)
+	return r
+}
+
+func TestWebhookHandler(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(NewWebhookHandler(mockService, newTestLogger()))
+
+	t.Run("create returns the secret once", func(t *testing.T) {
+		mockService.On("CreateWebhook", service.Webhook{URL: "https://crm.example.com/hooks", Events: []string{"link.created"}}).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{"url":"https://crm.example.com/hooks","events":["link.created"]}`)))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.Contains(t, w.Body.String(), `"id":3`)
+		assert.Contains(t, w.Body.String(), `"secret":"whsec_generated"`)
+	})
+
+	t.Run("invalid webhook", func(t *testing.T) {
+		mockService.On("CreateWebhook", service.Webhook{URL: "ftp://crm.example.com"}).
+			Return(&service.InputError{Field: "url", Reason: "must be an absolute http or https URL"}).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{"url":"ftp://crm.example.com"}`)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, decodeProblem(t, w).Detail, "url")
+	})
+
+	t.Run("list hides secrets", func(t *testing.T) {
+		mockService.On("ListWebhooks", "").Return([]*service.Webhook{{ID: 3, URL: "https://crm.example.com/hooks", Secret: "whsec_generated"}}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"events":[]`)
+		assert.NotContains(t, w.Body.String(), "whsec_generated")
+	})
+
+	t.Run("missing webhook", func(t *testing.T) {
+		mockService.On("GetWebhook", "", int64(9)).Return(nil, fmt.Errorf("%w for webhook: 9", service.ErrNotFound)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/9", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "no webhook exists with this id", decodeProblem(t, w).Detail)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/webhooks/crm", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		mockService.On("DeleteWebhook", "", int64(3)).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/webhooks/3", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("delivery log", func(t *testing.T) {
+		attempted := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		mockService.On("ListDeliveries", "", int64(3), 5).Return([]*service.WebhookDelivery{
+			{ID: 2, Event: "link.clicked", Status: service.DeliveryPending, Attempts: 1, ResponseStatus: 503, Error: "unexpected status 503",
+				Payload: []byte(`{"event":"link.clicked"}`), LastAttemptAt: &attempted, NextAttemptAt: attempted.Add(30 * time.Second)},
+			{ID: 1, Event: "link.created", Status: "delivered", Attempts: 1, ResponseStatus: 204, Payload: []byte(`{"event":"link.created"}`)},
+		}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/3/deliveries?limit=5", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.Contains(t, body, `"payload":{"event":"link.clicked"}`)
+		assert.Contains(t, body, `"next_attempt_at":"2024-05-01T12:00:30Z"`)
+		assert.Equal(t, 1, strings.Count(body, "next_attempt_at"), "only pending deliveries have a next attempt")
+		assert.Contains(t, body, `"status":"delivered"`)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/3/deliveries?limit=all", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/service"
+)
+
+// WorkspaceHandler handles the endpoints managing workspaces, their
+// members and API keys. Routes using it must be protected by
+// security.AdminAuth, since workspace admins may not manage workspaces.
+type WorkspaceHandler struct {
+	service service.WorkspaceService
+	logger  *logrus.Logger
+}
+
+// NewWorkspaceHandler creates a new WorkspaceHandler
+func NewWorkspaceHandler(service service.WorkspaceService, logger *logrus.Logger) *WorkspaceHandler {
+	return &WorkspaceHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// WorkspaceRequest represents the request body for creating a workspace
+type WorkspaceRequest struct {
+	Slug string `json:"slug"`
+	// Name is shown to people; it defaults to the slug
+	Name string `json:"name,omitempty"`
+}
+
+// WorkspaceResponse represents a workspace
+type WorkspaceResponse struct {
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Links     int64     `json:"links"`
+	Members   int64     `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WorkspaceListResponse represents every workspace
+type WorkspaceListResponse struct {
+	Workspaces []WorkspaceResponse `json:"workspaces"`
+}
+
+// MemberRequest represents the request body for adding a member or
+// changing their role
+type MemberRequest struct {
+	// Role is "admin" or "member"
+	Role string `json:"role"`
+}
+
+// MemberResponse represents a member of a workspace
+type MemberResponse struct {
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MemberListResponse represents the members of a workspace
+type MemberListResponse struct {
+	Members []MemberResponse `json:"members"`
+}
+
+// APIKeyRequest represents the request body for issuing an API key
+type APIKeyRequest struct {
+	// Member is the email of the member the key acts for
+	Member string `json:"member"`
+	Name   string `json:"name,omitempty"`
+}
+
+// APIKeyResponse represents an API key
+type APIKeyResponse struct {
+	ID     int64  `json:"id"`
+	Member string `json:"member"`
+	Name   string `json:"name,omitempty"`
+	Role   string `json:"role"`
+	// Prefix is the start of the key, to tell keys apart
+	Prefix string `json:"prefix"`
+	// Key is the full key. It is only returned when the key is issued.
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APIKeyListResponse represents the API keys of a workspace
+type APIKeyListResponse struct {
+	Keys []APIKeyResponse `json:"keys"`
+}
+
+// ListWorkspaces handles GET /api/v1/admin/workspaces
+func (h *WorkspaceHandler) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := h.service.ListWorkspaces(r.Context())
+	if err != nil {
+		h.respondWithError(w, r, err, "", "")
+		return
+	}
+
+	response := WorkspaceListResponse{Workspaces: make([]WorkspaceResponse, len(workspaces))}
+	for i, workspace := range workspaces {
+		response.Workspaces[i] = newWorkspaceResponse(workspace)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// CreateWorkspace handles POST /api/v1/admin/workspaces
+func (h *WorkspaceHandler) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var body WorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	workspace := &service.Workspace{Slug: body.Slug, Name: body.Name}
+	if err := h.service.CreateWorkspace(r.Context(), workspace); err != nil {
+		h.respondWithError(w, r, err, "", "a workspace with this slug already exists")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace": workspace.Slug,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Workspace created")
+	respondWithJSON(w, http.StatusCreated, newWorkspaceResponse(workspace))
+}
+
+// GetWorkspace handles GET /api/v1/admin/workspaces/{slug}
+func (h *WorkspaceHandler) GetWorkspace(w http.ResponseWriter, r *http.Request) {
+	workspace, err := h.service.GetWorkspace(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		h.respondWithError(w, r, err, "no workspace exists with this slug", "")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newWorkspaceResponse(workspace))
+}
+
+// DeleteWorkspace handles DELETE /api/v1/admin/workspaces/{slug}. Its
+// members and API keys go with it; workspaces with links or domains
+// cannot be deleted.
+func (h *WorkspaceHandler) DeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+	if err := h.service.DeleteWorkspace(r.Context(), slug); err != nil {
+		h.respondWithError(w, r, err, "no workspace exists with this slug", "this workspace still has links or domains")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace": slug,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Workspace deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListMembers handles GET /api/v1/admin/workspaces/{slug}/members
+func (h *WorkspaceHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := h.service.ListMembers(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		h.respondWithError(w, r, err, "no workspace exists with this slug", "")
+		return
+	}
+
+	response := MemberListResponse{Members: make([]MemberResponse, len(members))}
+	for i, member := range members {
+		response.Members[i] = newMemberResponse(member)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// SaveMember handles PUT /api/v1/admin/workspaces/{slug}/members/{email},
+// adding the member or changing their role
+func (h *WorkspaceHandler) SaveMember(w http.ResponseWriter, r *http.Request) {
+	var body MemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	member := &service.Member{Workspace: chi.URLParam(r, "slug"), Email: chi.URLParam(r, "email"), Role: body.Role}
+	if err := h.service.SaveMember(r.Context(), member); err != nil {
+		h.respondWithError(w, r, err, "no workspace exists with this slug", "")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace": member.Workspace,
+		"member":    member.Email,
+		"role":      member.Role,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Workspace member saved")
+	respondWithJSON(w, http.StatusOK, newMemberResponse(member))
+}
+
+// RemoveMember handles DELETE /api/v1/admin/workspaces/{slug}/members/{email},
+// revoking the member's API keys
+func (h *WorkspaceHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	slug, email := chi.URLParam(r, "slug"), chi.URLParam(r, "email")
+	if err := h.service.RemoveMember(r.Context(), slug, email); err != nil {
+		h.respondWithError(w, r, err, "no member of this workspace has this email", "")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace": slug,
+		"member":    email,
+		"remote_ip": r.RemoteAddr,
+	}).Info("Workspace member removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAPIKeys handles GET /api/v1/admin/workspaces/{slug}/keys
+func (h *WorkspaceHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.ListAPIKeys(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		h.respondWithError(w, r, err, "no workspace exists with this slug", "")
+		return
+	}
+
+	response := APIKeyListResponse{Keys: make([]APIKeyResponse, len(keys))}
+	for i, key := range keys {
+		response.Keys[i] = newAPIKeyResponse(key, "")
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// CreateAPIKey handles POST /api/v1/admin/workspaces/{slug}/keys. The
+// key is only ever returned in this response.
+func (h *WorkspaceHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid request body"))
+		return
+	}
+
+	key := &service.APIKey{Workspace: chi.URLParam(r, "slug"), Member: body.Member, Name: body.Name}
+	secret, err := h.service.CreateAPIKey(r.Context(), key)
+	if err != nil {
+		h.respondWithError(w, r, err, "no member of this workspace has this email", "")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace": key.Workspace,
+		"member":    key.Member,
+		"key_id":    key.ID,
+		"remote_ip": r.RemoteAddr,
+	}).Info("API key issued")
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusCreated, newAPIKeyResponse(key, secret))
+}
+
+// DeleteAPIKey handles DELETE /api/v1/admin/workspaces/{slug}/keys/{id}
+func (h *WorkspaceHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondWithProblem(w, r, newProblem(ProblemTypeInvalidRequest, http.StatusBadRequest, "invalid id: must be a number"))
+		return
+	}
+	if err := h.service.DeleteAPIKey(r.Context(), slug, id); err != nil {
+		h.respondWithError(w, r, err, "this workspace has no API key with this id", "")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"workspace": slug,
+		"key_id":    id,
+		"remote_ip": r.RemoteAddr,
+	}).Info("API key revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithError logs unexpected failures and sends the problem for err,
+// with notFound and conflict replacing the details of 404 and 409
+// responses when set
+func (h *WorkspaceHandler) respondWithError(w http.ResponseWriter, r *http.Request, err error, notFound, conflict string) {
+	problem := problemFromError(err)
+	if problem.Status >= http.StatusInternalServerError {
+		h.logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"path":  r.URL.Path,
+		}).Error("Workspace request failed")
+	}
+	switch {
+	case problem.Status == http.StatusNotFound && notFound != "":
+		problem.Detail = notFound
+	case problem.Status == http.StatusConflict && conflict != "":
+		problem.Detail = conflict
+	}
+	respondWithProblem(w, r, problem)
+}
+
+// newWorkspaceResponse converts a workspace into its response body
+func newWorkspaceResponse(workspace *service.Workspace) WorkspaceResponse {
+	return WorkspaceResponse{
+		Slug:      workspace.Slug,
+		Name:      workspace.Name,
+		Links:     workspace.Links,
+		Members:   workspace.Members,
+		CreatedAt: workspace.CreatedAt,
+	}
+}
+
+// newMemberResponse converts a member into its response body
+func newMemberResponse(member *service.Member) MemberResponse {
+	return MemberResponse{
+		Email:     member.Email,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt,
+	}
+}
+
+// newAPIKeyResponse converts an API key into its response body, including
+// secret if it was just issued
+func newAPIKeyResponse(key *service.APIKey, secret string) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Member:     key.Member,
+		Name:       key.Name,
+		Role:       key.Role,
+		Prefix:     key.Prefix,
+		Key:        secret,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
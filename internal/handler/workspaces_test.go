@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/urlshortener/internal/service"
+)
+
+// MockWorkspaceService is a mock implementation of service.WorkspaceService
+type MockWorkspaceService struct {
+	mock.Mock
+}
+
+func (m *MockWorkspaceService) ListWorkspaces(ctx context.Context) ([]*service.Workspace, error) {
+	args := m.Called()
+	workspaces, _ := args.Get(0).([]*service.Workspace)
+	return workspaces, args.Error(1)
+}
+
+func (m *MockWorkspaceService) GetWorkspace(ctx context.Context, slug string) (*service.Workspace, error) {
+	args := m.Called(slug)
+	workspace, _ := args.Get(0).(*service.Workspace)
+	return workspace, args.Error(1)
+}
+
+func (m *MockWorkspaceService) CreateWorkspace(ctx context.Context, workspace *service.Workspace) error {
+	return m.Called(*workspace).Error(0)
+}
+
+func (m *MockWorkspaceService) DeleteWorkspace(ctx context.Context, slug string) error {
+	return m.Called(slug).Error(0)
+}
+
+func (m *MockWorkspaceService) ListMembers(ctx context.Context, workspace string) ([]*service.Member, error) {
+	args := m.Called(workspace)
+	members, _ := args.Get(0).([]*service.Member)
+	return members, args.Error(1)
+}
+
+func (m *MockWorkspaceService) SaveMember(ctx context.Context, member *service.Member) error {
+	return m.Called(*member).Error(0)
+}
+
+func (m *MockWorkspaceService) RemoveMember(ctx context.Context, workspace, email string) error {
+	return m.Called(workspace, email).Error(0)
+}
+
+func (m *MockWorkspaceService) ListAPIKeys(ctx context.Context, workspace string) ([]*service.APIKey, error) {
+	args := m.Called(workspace)
+	keys, _ := args.Get(0).([]*service.APIKey)
+	return keys, args.Error(1)
+}
+
+func (m *MockWorkspaceService) CreateAPIKey(ctx context.Context, key *service.APIKey) (string, error) {
+	args := m.Called(*key)
+	key.ID = 7
+	key.Prefix = "usk_abcdefgh"
+	key.KeyHash = "hash"
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockWorkspaceService) DeleteAPIKey(ctx context.Context, workspace string, id int64) error {
+	return m.Called(workspace, id).Error(0)
+}
+
+func (m *MockWorkspaceService) Authenticate(ctx context.Context, secret string) (*service.Principal, error) {
+	args := m.Called(secret)
+	principal, _ := args.Get(0).(*service.Principal)
+	return principal, args.Error(1)
+}
+
+func newWorkspaceRouter(h *WorkspaceHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/workspaces", h.ListWorkspaces)
+	r.Post("/workspaces", h.CreateWorkspace)
+	r.Get("/workspaces/{slug}", h.GetWorkspace)
+	r.Delete("/workspaces/{slug}", h.DeleteWorkspace)
+	r.Get("/workspaces/{slug}/members", h.ListMembers)
+	r.Put("/workspaces/{slug}/members/{email}", h.SaveMember)
+	r.Delete("/workspaces/{slug}/members/{email}", h.RemoveMember)
+	r.Get("/workspaces/{slug}/keys", h.ListAPIKeys)
+	r.Post("/workspaces/{slug}/keys", h.CreateAPIKey)
+	r.Delete("/workspaces/{slug}/keys/{id}", h.DeleteAPIKey)
+	return r
+}
+
+func TestWorkspaceHandler(t *testing.T) {
+	mockService := new(MockWorkspaceService)
+	router := newWorkspaceRouter(NewWorkspaceHandler(mockService, newTestLogger()))
+
+	t.Run("create workspace", func(t *testing.T) {
+		mockService.On("CreateWorkspace", service.Workspace{Slug: "marketing", Name: "Marketing"}).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/workspaces", strings.NewReader(`{"slug":"marketing","name":"Marketing"}`)))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"slug":"marketing"`)
+	})
+
+	t.Run("slug taken", func(t *testing.T) {
+		mockService.On("CreateWorkspace", service.Workspace{Slug: "marketing"}).Return(fmt.Errorf("%w: workspace marketing", service.ErrConflict)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/workspaces", strings.NewReader(`{"slug":"marketing"}`)))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "a workspace with this slug already exists")
+	})
+
+	t.Run("workspaces with links are kept", func(t *testing.T) {
+		mockService.On("DeleteWorkspace", "marketing").Return(fmt.Errorf("%w: workspace marketing has 3 links", service.ErrConflict)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/workspaces/marketing", nil))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "this workspace still has links or domains")
+	})
+
+	t.Run("missing workspace", func(t *testing.T) {
+		mockService.On("GetWorkspace", "gone").Return(nil, fmt.Errorf("%w for workspace: gone", service.ErrNotFound)).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/workspaces/gone", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "no workspace exists with this slug")
+	})
+
+	t.Run("save member takes the email from the path", func(t *testing.T) {
+		mockService.On("SaveMember", service.Member{Workspace: "marketing", Email: "ann@example.com", Role: service.RoleMember}).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/workspaces/marketing/members/ann@example.com", strings.NewReader(`{"role":"member"}`)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"role":"member"`)
+	})
+
+	t.Run("new keys are shown once", func(t *testing.T) {
+		mockService.On("CreateAPIKey", service.APIKey{Workspace: "marketing", Member: "ann@example.com", Name: "ci"}).
+			Return("usk_abcdefgh", nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/workspaces/marketing/keys", strings.NewReader(`{"member":"ann@example.com","name":"ci"}`)))
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.Contains(t, w.Body.String(), `"key":"usk_abcdefgh"`)
+		assert.NotContains(t, w.Body.String(), "hash")
+	})
+
+	t.Run("listed keys have no secret", func(t *testing.T) {
+		mockService.On("ListAPIKeys", "marketing").
+			Return([]*service.APIKey{{ID: 7, Member: "ann@example.com", Prefix: "usk_abcdefgh", KeyHash: "hash"}}, nil).Once()
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/workspaces/marketing/keys", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"prefix":"usk_abcdefgh"`)
+		assert.NotContains(t, w.Body.String(), `"key"`)
+		assert.NotContains(t, w.Body.String(), "hash")
+	})
+
+	t.Run("key ids are numbers", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/workspaces/marketing/keys/abc", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockService.AssertNotCalled(t, "DeleteAPIKey", mock.Anything, mock.Anything)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	mockService := new(MockWorkspaceService)
+	memberKey := "usk_" + strings.Repeat("m", 40)
+	mockService.On("Authenticate", memberKey).
+		Return(&service.Principal{Workspace: "marketing", Member: "ann@example.com", Role: service.RoleMember}, nil)
+	mockService.On("Authenticate", mock.Anything).Return(nil, service.ErrInvalidAPIKey)
+	mockService.On("GetWorkspace", "marketing").Return(&service.Workspace{Slug: "marketing"}, nil)
+	mockService.On("GetWorkspace", "gone").Return(nil, fmt.Errorf("%w for workspace: gone", service.ErrNotFound))
+
+	var seen *service.Principal
+	authenticate := Authenticate(mockService, "secret-token", newTestLogger())
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = PrincipalFrom(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	open := authenticate(echo)
+	admin := authenticate(RequireAdmin(newTestLogger())(echo))
+
+	serve := func(h http.Handler, credential, workspace string) *httptest.ResponseRecorder {
+		seen = nil
+		req := httptest.NewRequest("POST", "/shorten", nil)
+		if credential != "" {
+			req.Header.Set("Authorization", "Bearer "+credential)
+		}
+		if workspace != "" {
+			req.Header.Set(WorkspaceHeader, workspace)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("anonymous requests use the default workspace", func(t *testing.T) {
+		w := serve(open, "", "")
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Nil(t, seen)
+	})
+
+	t.Run("API keys act in their workspace", func(t *testing.T) {
+		w := serve(open, memberKey, "")
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "marketing", seen.Workspace)
+		assert.Equal(t, "ann@example.com", seen.Member)
+	})
+
+	t.Run("unknown keys are rejected", func(t *testing.T) {
+		w := serve(open, "usk_"+strings.Repeat("x", 40), "")
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		assert.Contains(t, w.Body.String(), ProblemTypeUnauthorized)
+	})
+
+	t.Run("wrong admin tokens are rejected", func(t *testing.T) {
+		w := serve(open, "guess", "")
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("keys cannot switch workspace", func(t *testing.T) {
+		w := serve(open, memberKey, "sales")
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "this API key belongs to another workspace")
+		assert.Nil(t, seen)
+	})
+
+	t.Run("the admin token acts in the requested workspace", func(t *testing.T) {
+		w := serve(admin, "secret-token", "marketing")
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, &service.Principal{Workspace: "marketing", Role: service.RoleAdmin}, seen)
+	})
+
+	t.Run("the requested workspace must exist", func(t *testing.T) {
+		w := serve(admin, "secret-token", "gone")
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "no workspace exists with this slug")
+	})
+
+	t.Run("members cannot use the admin API", func(t *testing.T) {
+		w := serve(admin, memberKey, "")
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), ProblemTypeForbidden)
+		assert.Nil(t, seen)
+	})
+
+	t.Run("the admin API needs credentials", func(t *testing.T) {
+		w := serve(admin, "", "")
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Nil(t, seen)
+	})
+}
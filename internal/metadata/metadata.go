@@ -0,0 +1,274 @@
+// Package metadata fetches destination pages and extracts what is worth
+// showing about them: the title, description and Open Graph image. Fetches
+// are bounded in time and size and, unless configured otherwise, refuse to
+// connect to private, loopback and other non-public addresses.
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/urlshortener/internal/security"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Defaults for Options
+const (
+	DefaultTimeout   = 5 * time.Second
+	DefaultMaxBytes  = 1 << 20
+	DefaultUserAgent = "URLShortenerBot/1.0 (link preview)"
+)
+
+const (
+	// maxRedirects bounds how many redirects a fetch follows
+	maxRedirects = 5
+	// Extracted values are cut to these lengths, in runes
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	maxImageURLLength    = 2048
+)
+
+// Page holds the metadata of a fetched page. Empty fields were not found.
+type Page struct {
+	Title       string
+	Description string
+	// ImageURL is absolute, resolved against the page's final URL
+	ImageURL string
+}
+
+// Options controls a Fetcher
+type Options struct {
+	// Timeout bounds a whole fetch, redirects included; zero means
+	// DefaultTimeout
+	Timeout time.Duration
+	// MaxBytes bounds how much of a page is read; zero means
+	// DefaultMaxBytes
+	MaxBytes int64
+	// UserAgent is sent with every request; empty means DefaultUserAgent
+	UserAgent string
+	// AllowPrivate permits connections to non-public addresses. It exists
+	// for tests against local servers and must not be set in production.
+	AllowPrivate bool
+}
+
+// Fetcher fetches page metadata. It is safe for concurrent use.
+type Fetcher struct {
+	client *http.Client
+	opts   Options
+}
+
+// NewFetcher returns a fetcher using opts
+func NewFetcher(opts Options) *Fetcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = security.RefuseNonPublic
+	}
+	transport := &http.Transport{
+		// No proxy: the dialer must see the destination's own address
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("refusing to follow redirect to %s URL", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return &Fetcher{client: client, opts: opts}
+}
+
+// Fetch downloads the page at rawURL and extracts its metadata. Only HTML
+// pages are read, and only up to Options.MaxBytes.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Page, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", req.URL.Scheme)
+	}
+	req.Header.Set("User-Agent", f.opts.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("not an HTML page (%s)", orUnknown(mediaType))
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.opts.MaxBytes), contentType)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported character set: %w", err)
+	}
+	return Parse(body, resp.Request.URL), nil
+}
+
+// Parse extracts metadata from an HTML document. It reads no further than
+// the start of <body>, where all the metadata it looks for lives. base
+// resolves a relative image URL.
+func Parse(r io.Reader, base *url.URL) *Page {
+	var title, description, ogTitle, ogDescription, image string
+	var inTitle bool
+	tokenizer := html.NewTokenizer(r)
+
+loop:
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			switch string(name) {
+			case "body":
+				break loop
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				if !hasAttr {
+					continue
+				}
+				key, content := metaAttributes(tokenizer)
+				switch key {
+				case "description":
+					description = firstNonEmpty(description, content)
+				case "og:title":
+					ogTitle = firstNonEmpty(ogTitle, content)
+				case "og:description":
+					ogDescription = firstNonEmpty(ogDescription, content)
+				case "og:image", "og:image:url", "og:image:secure_url":
+					image = firstNonEmpty(image, content)
+				case "twitter:image":
+					if image == "" {
+						image = content
+					}
+				}
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+		}
+	}
+
+	return &Page{
+		Title:       truncate(clean(firstNonEmpty(clean(title), ogTitle)), maxTitleLength),
+		Description: truncate(clean(firstNonEmpty(clean(description), ogDescription)), maxDescriptionLength),
+		ImageURL:    resolveImage(base, image),
+	}
+}
+
+// metaAttributes returns the name or property of a <meta> tag, lowercased,
+// and its content
+func metaAttributes(tokenizer *html.Tokenizer) (string, string) {
+	var key, content string
+	for {
+		name, value, more := tokenizer.TagAttr()
+		switch string(name) {
+		case "name", "property":
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(string(value)))
+			}
+		case "content":
+			content = string(value)
+		}
+		if !more {
+			return key, content
+		}
+	}
+}
+
+// resolveImage makes an image URL absolute, dropping anything that is not
+// a plain http or https URL
+func resolveImage(base *url.URL, raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		ref = base.ResolveReference(ref)
+	}
+	if (ref.Scheme != "http" && ref.Scheme != "https") || ref.Host == "" {
+		return ""
+	}
+	resolved := ref.String()
+	if len(resolved) > maxImageURLLength {
+		return ""
+	}
+	return resolved
+}
+
+// clean collapses runs of whitespace, including newlines, to single spaces
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncate cuts s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func orUnknown(mediaType string) string {
+	if mediaType == "" {
+		return "unknown type"
+	}
+	return mediaType
+}
@@ -0,0 +1,134 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/security"
+)
+
+const page = `<!DOCTYPE html>
+<html><head>
+<meta charset="utf-8">
+<title>
+  Example   Domain
+</title>
+<meta name="Description" content="An example page.">
+<meta property="og:title" content="Ignored, the title wins">
+<meta property="og:image" content="/images/card.png">
+<meta name="twitter:image" content="https://cdn.example/ignored.png">
+</head>
+<body><title>Not this one</title></body></html>`
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/articles/1")
+
+	t.Run("standard tags", func(t *testing.T) {
+		p := Parse(strings.NewReader(page), base)
+		assert.Equal(t, &Page{
+			Title:       "Example Domain",
+			Description: "An example page.",
+			ImageURL:    "https://example.com/images/card.png",
+		}, p)
+	})
+
+	t.Run("Open Graph fallbacks", func(t *testing.T) {
+		p := Parse(strings.NewReader(`<head>
+			<meta property="og:title" content="OG title">
+			<meta property="og:description" content="OG description">
+			<meta name="twitter:image" content="https://cdn.example/card.png">`), base)
+		assert.Equal(t, &Page{
+			Title:       "OG title",
+			Description: "OG description",
+			ImageURL:    "https://cdn.example/card.png",
+		}, p)
+	})
+
+	t.Run("unsafe images are dropped", func(t *testing.T) {
+		p := Parse(strings.NewReader(`<meta property="og:image" content="javascript:alert(1)">`), base)
+		assert.Empty(t, p.ImageURL)
+	})
+
+	t.Run("long values are truncated", func(t *testing.T) {
+		p := Parse(strings.NewReader("<title>"+strings.Repeat("é", 400)+"</title>"), base)
+		assert.Equal(t, maxTitleLength, len([]rune(p.Title)))
+		assert.True(t, strings.HasSuffix(p.Title, "…"))
+	})
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, DefaultUserAgent, r.UserAgent())
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+	mux.HandleFunc("/latin1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write([]byte("<title>Caf\xe9</title>"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title": "no"}`))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<head><!--" + strings.Repeat("x", 4096) + "--><title>Too far</title>"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := NewFetcher(Options{AllowPrivate: true})
+	ctx := context.Background()
+
+	t.Run("follows redirects", func(t *testing.T) {
+		p, err := fetcher.Fetch(ctx, server.URL+"/moved")
+		require.NoError(t, err)
+		assert.Equal(t, "Example Domain", p.Title)
+		assert.Equal(t, server.URL+"/images/card.png", p.ImageURL)
+	})
+
+	t.Run("decodes the declared charset", func(t *testing.T) {
+		p, err := fetcher.Fetch(ctx, server.URL+"/latin1")
+		require.NoError(t, err)
+		assert.Equal(t, "Café", p.Title)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for path, want := range map[string]string{
+			"/loop":    "redirects",
+			"/json":    "not an HTML page",
+			"/missing": "unexpected status 404",
+		} {
+			_, err := fetcher.Fetch(ctx, server.URL+path)
+			require.Error(t, err, path)
+			assert.Contains(t, err.Error(), want, path)
+		}
+		_, err := fetcher.Fetch(ctx, "ftp://example.com/")
+		assert.Error(t, err)
+	})
+
+	t.Run("reads at most MaxBytes", func(t *testing.T) {
+		p, err := NewFetcher(Options{AllowPrivate: true, MaxBytes: 1024}).Fetch(ctx, server.URL+"/large")
+		require.NoError(t, err)
+		assert.Empty(t, p.Title)
+	})
+
+	t.Run("private addresses are blocked by default", func(t *testing.T) {
+		_, err := NewFetcher(Options{}).Fetch(ctx, server.URL+"/page")
+		assert.True(t, errors.Is(err, security.ErrBlockedAddress), "got %v", err)
+	})
+}
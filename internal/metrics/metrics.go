@@ -21,6 +21,14 @@ type Metrics struct {
 	// Database metrics
 	DBOperationsTotal    *prometheus.CounterVec
 	DBOperationDuration  *prometheus.HistogramVec
+
+	// Quota metrics
+	QuotaUsage       *prometheus.GaugeVec
+	QuotaUtilization *prometheus.GaugeVec
+
+	// Webhook metrics
+	WebhookDeliveriesTotal  *prometheus.CounterVec
+	WebhookDeliveryDuration prometheus.Histogram
 }
 
 var (
@@ -99,6 +107,38 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"operation"},
 		),
+
+		// Quota metrics
+		QuotaUsage: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "link_quota_usage",
+				Help: "Links counted against a quota limit",
+			},
+			[]string{"workspace", "member", "window"},
+		),
+		QuotaUtilization: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "link_quota_utilization_ratio",
+				Help: "Share of a quota limit in use",
+			},
+			[]string{"workspace", "member", "window"},
+		),
+
+		// Webhook metrics
+		WebhookDeliveriesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "webhook_deliveries_total",
+				Help: "Total number of webhook delivery attempts by outcome",
+			},
+			[]string{"outcome"},
+		),
+		WebhookDeliveryDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "webhook_delivery_duration_seconds",
+				Help:    "Duration of webhook delivery attempts in seconds",
+				Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+			},
+		),
 		}
 
 		// Register all metrics
@@ -112,6 +152,10 @@ func NewMetrics() *Metrics {
 			metricsInstance.InternalErrorsTotal,
 			metricsInstance.DBOperationsTotal,
 			metricsInstance.DBOperationDuration,
+			metricsInstance.QuotaUsage,
+			metricsInstance.QuotaUtilization,
+			metricsInstance.WebhookDeliveriesTotal,
+			metricsInstance.WebhookDeliveryDuration,
 		)
 	})
 
@@ -145,8 +189,29 @@ func (m *Metrics) RecordInternalError() {
 	m.InternalErrorsTotal.Inc()
 }
 
-// RecordDBOperation records metrics for a database operation
+// RecordDBOperation records metrics for a database operation. Status is one
+// of "success", "not_found", "conflict", "error", "timeout" or "cancelled".
 func (m *Metrics) RecordDBOperation(operation, status string, duration float64) {
 	m.DBOperationsTotal.WithLabelValues(operation, status).Inc()
 	m.DBOperationDuration.WithLabelValues(operation).Observe(duration)
-}
\ No newline at end of file
+}
+// RecordQuotaUsage sets how much of one window of a quota is in use.
+// Member is empty for the quota of a whole workspace.
+func (m *Metrics) RecordQuotaUsage(workspace, member, window string, used, limit int64) {
+	m.QuotaUsage.WithLabelValues(workspace, member, window).Set(float64(used))
+	m.QuotaUtilization.WithLabelValues(workspace, member, window).Set(float64(used) / float64(limit))
+}
+
+// ForgetQuota drops the series of a quota that was changed or removed
+func (m *Metrics) ForgetQuota(workspace, member string) {
+	labels := prometheus.Labels{"workspace": workspace, "member": member}
+	m.QuotaUsage.DeletePartialMatch(labels)
+	m.QuotaUtilization.DeletePartialMatch(labels)
+}
+
+// RecordWebhookDelivery records an attempt at a webhook delivery. Outcome
+// is one of "delivered", "retrying" or "failed".
+func (m *Metrics) RecordWebhookDelivery(outcome string, duration float64) {
+	m.WebhookDeliveriesTotal.WithLabelValues(outcome).Inc()
+	m.WebhookDeliveryDuration.Observe(duration)
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/urlshortener/internal/metrics"
 )
 
@@ -41,9 +42,13 @@ func MetricsMiddleware(m *metrics.Metrics) func(http.Handler) http.Handler {
 			// Process the request
 			next.ServeHTTP(wrapped, r)
 			
-			// Record metrics
+			// Record metrics, preferring the matched route pattern so that
+			// parameterised routes share a single label value
 			duration := time.Since(start).Seconds()
 			endpoint := getEndpointName(r.URL.Path)
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				endpoint = rctx.RoutePattern()
+			}
 			statusCode := strconv.Itoa(wrapped.statusCode)
 			
 			m.RecordHTTPRequest(
@@ -0,0 +1,290 @@
+// Package qr renders QR codes as PNG or SVG images and caches the results,
+// so that popular codes are only encoded once.
+package qr
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"sync"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Limits and defaults for Options
+const (
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultSize   = 256
+	MaxMargin     = 16
+	DefaultMargin = 4
+)
+
+// Format is an image format
+type Format string
+
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+)
+
+// ParseFormat parses a format name
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "png":
+		return FormatPNG, nil
+	case "svg":
+		return FormatSVG, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q (want png or svg)", name)
+	}
+}
+
+// FormatForMediaType maps an Accept media type to a format
+func FormatForMediaType(mediaType string) (Format, bool) {
+	switch mediaType {
+	case "image/png":
+		return FormatPNG, true
+	case "image/svg+xml":
+		return FormatSVG, true
+	default:
+		return "", false
+	}
+}
+
+// ContentType returns the media type for the format
+func (f Format) ContentType() string {
+	if f == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Level is an error correction level. Higher levels survive more damage
+// but need a denser code.
+type Level string
+
+const (
+	LevelLow      Level = "L"
+	LevelMedium   Level = "M"
+	LevelQuartile Level = "Q"
+	LevelHigh     Level = "H"
+)
+
+var recoveryLevels = map[Level]qrcode.RecoveryLevel{
+	LevelLow:      qrcode.Low,
+	LevelMedium:   qrcode.Medium,
+	LevelQuartile: qrcode.High,
+	LevelHigh:     qrcode.Highest,
+}
+
+// ParseLevel parses an error correction level, L, M, Q or H
+func ParseLevel(name string) (Level, error) {
+	level := Level(strings.ToUpper(name))
+	if _, ok := recoveryLevels[level]; !ok {
+		return "", fmt.Errorf("unsupported error correction level %q (want L, M, Q or H)", name)
+	}
+	return level, nil
+}
+
+// ParseColor parses an opaque colour written as "rrggbb" or "rgb", with or
+// without a leading "#"
+func ParseColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q (want a hex colour such as #1a2b3c)", value)
+	}
+	return color.RGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 0xff}, nil
+}
+
+// Options controls how a code is drawn
+type Options struct {
+	// Size is the width and height of the image in pixels
+	Size int
+	// Margin is the quiet zone around the code, in modules
+	Margin     int
+	Level      Level
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// DefaultOptions returns black-on-white options with medium error
+// correction
+func DefaultOptions() Options {
+	return Options{
+		Size:       DefaultSize,
+		Margin:     DefaultMargin,
+		Level:      LevelMedium,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// Validate checks that the options are within their limits
+func (o Options) Validate() error {
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("size must be between %d and %d pixels", MinSize, MaxSize)
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("margin must be between 0 and %d modules", MaxMargin)
+	}
+	if _, ok := recoveryLevels[o.Level]; !ok {
+		return fmt.Errorf("unsupported error correction level %q", o.Level)
+	}
+	return nil
+}
+
+// Render encodes content as a QR code image
+func Render(content string, format Format, opts Options) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	code, err := qrcode.New(content, recoveryLevels[opts.Level])
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	// The quiet zone is drawn here, so that its width can be chosen
+	code.DisableBorder = true
+	modules := code.Bitmap()
+
+	if format == FormatSVG {
+		return renderSVG(modules, opts), nil
+	}
+	return renderPNG(modules, opts)
+}
+
+// renderPNG draws the modules at a whole number of pixels each, centred in
+// an image of exactly opts.Size pixels. Codes too dense for that size get
+// one pixel per module and a larger image.
+func renderPNG(modules [][]bool, opts Options) ([]byte, error) {
+	span := len(modules) + 2*opts.Margin
+	scale := opts.Size / span
+	size := opts.Size
+	if scale < 1 {
+		scale, size = 1, span
+	}
+	offset := (size-span*scale)/2 + opts.Margin*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{opts.Background, opts.Foreground})
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				start := img.PixOffset(offset+x*scale, offset+y*scale+py)
+				for px := 0; px < scale; px++ {
+					img.Pix[start+px] = 1
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderSVG draws the modules as a single path, one unit per module, with
+// runs of dark modules in a row merged into one rectangle
+func renderSVG(modules [][]bool, opts Options) []byte {
+	span := len(modules) + 2*opts.Margin
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, span, span)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/><path fill="%s" d="`, hexColor(opts.Background), hexColor(opts.Foreground))
+	for y, row := range modules {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+opts.Margin, y+opts.Margin, run, run)
+			x += run
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Renderer renders QR codes, keeping the most recently used images in
+// memory. It is safe for concurrent use.
+type Renderer struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	content string
+	format  Format
+	opts    Options
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	image []byte
+}
+
+// NewRenderer returns a renderer caching up to capacity images
+func NewRenderer(capacity int) *Renderer {
+	return &Renderer{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+	}
+}
+
+// Render is like the package-level Render, but serves repeated requests
+// from the cache. The returned slice must not be modified.
+func (r *Renderer) Render(content string, format Format, opts Options) ([]byte, error) {
+	key := cacheKey{content: content, format: format, opts: opts}
+	r.mu.Lock()
+	if elem, ok := r.entries[key]; ok {
+		r.order.MoveToFront(elem)
+		r.mu.Unlock()
+		return elem.Value.(*cacheEntry).image, nil
+	}
+	r.mu.Unlock()
+
+	// Rendering happens outside the lock; two concurrent misses for the
+	// same key both render, and the second result replaces the first
+	img, err := Render(content, format, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.entries[key]; ok {
+		r.order.Remove(elem)
+	}
+	r.entries[key] = r.order.PushFront(&cacheEntry{key: key, image: img})
+	for r.order.Len() > r.capacity {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return img, nil
+}
@@ -0,0 +1,111 @@
+package qr
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	format, err := ParseFormat("SVG")
+	require.NoError(t, err)
+	assert.Equal(t, FormatSVG, format)
+	_, err = ParseFormat("gif")
+	assert.Error(t, err)
+
+	level, err := ParseLevel("q")
+	require.NoError(t, err)
+	assert.Equal(t, LevelQuartile, level)
+	_, err = ParseLevel("X")
+	assert.Error(t, err)
+
+	c, err := ParseColor("#1a2B3c")
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{R: 0x1a, G: 0x2b, B: 0x3c, A: 0xff}, c)
+	c, err = ParseColor("f00")
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{R: 0xff, A: 0xff}, c)
+	for _, bad := range []string{"", "#12345", "red", "#gggggg", "+12345"} {
+		_, err = ParseColor(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestRenderPNG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Foreground = color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff}
+
+	data, err := Render("http://localhost:8080/abc123", FormatPNG, opts)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	assert.Equal(t, 256, img.Bounds().Dx())
+	assert.Equal(t, 256, img.Bounds().Dy())
+	// The corner lies in the quiet zone, and the finder pattern starts
+	// just inside it
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.RGBAModel.Convert(img.At(0, 0)))
+	inside := false
+	for i := 0; i < 128; i++ {
+		if color.RGBAModel.Convert(img.At(i, i)) == opts.Foreground {
+			inside = true
+			break
+		}
+	}
+	assert.True(t, inside)
+}
+
+func TestRenderSVG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Size = 512
+	opts.Margin = 0
+	opts.Background = color.RGBA{R: 0xff, G: 0xee, B: 0xdd, A: 0xff}
+
+	data, err := Render("http://localhost:8080/abc123", FormatSVG, opts)
+	require.NoError(t, err)
+	svg := string(data)
+
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="512" height="512"`))
+	assert.Contains(t, svg, `fill="#ffeedd"`)
+	assert.Contains(t, svg, `fill="#000000"`)
+	// Without a margin the finder pattern's top row starts at the origin
+	assert.Contains(t, svg, `d="M0 0h7v1h-7z`)
+}
+
+func TestRenderRejectsInvalidOptions(t *testing.T) {
+	for _, mutate := range []func(*Options){
+		func(o *Options) { o.Size = MinSize - 1 },
+		func(o *Options) { o.Size = MaxSize + 1 },
+		func(o *Options) { o.Margin = -1 },
+		func(o *Options) { o.Margin = MaxMargin + 1 },
+		func(o *Options) { o.Level = "Z" },
+	} {
+		opts := DefaultOptions()
+		mutate(&opts)
+		_, err := Render("http://example.com", FormatPNG, opts)
+		assert.Error(t, err)
+	}
+}
+
+func TestRendererCache(t *testing.T) {
+	renderer := NewRenderer(2)
+	opts := DefaultOptions()
+
+	first, err := renderer.Render("http://a.example", FormatPNG, opts)
+	require.NoError(t, err)
+	again, err := renderer.Render("http://a.example", FormatPNG, opts)
+	require.NoError(t, err)
+	assert.Same(t, &first[0], &again[0])
+
+	_, err = renderer.Render("http://b.example", FormatPNG, opts)
+	require.NoError(t, err)
+	_, err = renderer.Render("http://c.example", FormatPNG, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 2, renderer.order.Len())
+	assert.NotContains(t, renderer.entries, cacheKey{content: "http://a.example", format: FormatPNG, opts: opts})
+}
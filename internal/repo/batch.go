@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// StoreURLs stores several links in a single transaction. Each link is
+// written under its own savepoint, so a failure such as a code conflict
+// rolls back only that link and is reported at the same index of the
+// returned slice. The error is non-nil only when the batch as a whole
+// could not be committed, in which case nothing was stored.
+func (r *SQLiteRepository) StoreURLs(ctx context.Context, links []*Link) ([]error, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	itemErrs := make([]error, len(links))
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		for i, link := range links {
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT batch_item`); err != nil {
+				return err
+			}
+			if err := insertLink(ctx, tx, link); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				itemErrs[i] = wrapStoreError(ctx, link, err)
+				if _, err := tx.ExecContext(ctx, `ROLLBACK TO batch_item`); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.ExecContext(ctx, `RELEASE batch_item`); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	r.recordResult(ctx, "store_urls", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to store URLs: %w", err)
+	}
+	return itemErrs, nil
+}
@@ -0,0 +1,502 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CatalogRepository stores the folders and tags links are organised with.
+// Links are moved between them through URLRepository.UpdateLink. Each
+// workspace has folders and tags of its own.
+type CatalogRepository interface {
+	ListFolders(ctx context.Context, workspace string) ([]*Folder, error)
+	CreateFolder(ctx context.Context, workspace, name string) (*Folder, error)
+	RenameFolder(ctx context.Context, workspace, name, newName string) (*Folder, error)
+	DeleteFolder(ctx context.Context, workspace, name string) error
+	ListTags(ctx context.Context, workspace string) ([]*TagStats, error)
+	GetTagStats(ctx context.Context, workspace, name string) (*TagStats, error)
+	DeleteTag(ctx context.Context, workspace, name string) error
+}
+
+// Folder is a named collection of links. A link is in at most one folder.
+type Folder struct {
+	Name      string
+	CreatedAt time.Time
+	// Links counts the links in the folder
+	Links int64
+}
+
+// TagStats summarises the links carrying a tag and their visits
+type TagStats struct {
+	Name          string
+	Links         int64
+	Clicks        int64
+	LastClickedAt *time.Time
+	// Countries counts clicks by visitor country; only filled in by
+	// GetTagStats
+	Countries map[string]int64
+}
+
+// LinkFilter selects the links returned by ListLinks
+type LinkFilter struct {
+	// Workspace is the workspace whose links are listed
+	Workspace string
+	// Tags lists tags every returned link must carry
+	Tags []string
+	// Folder, if set, is the folder the links must be in
+	Folder string
+	// BeforeID, if positive, skips links created at or after the one
+	// with this ID, so that pages can be walked newest first
+	BeforeID int64
+	// Limit bounds how many links are returned
+	Limit int
+}
+
+// LinkUpdate changes how a link is organised. Nil fields are left as they
+// are.
+type LinkUpdate struct {
+	// Tags replaces all of the link's tags
+	Tags *[]string
+	// Folder moves the link into the named folder, creating it if needed;
+	// empty takes it out of its folder
+	Folder *string
+}
+
+// ListLinks returns the links matching filter, newest first, with their
+// tags
+func (r *SQLiteRepository) ListLinks(ctx context.Context, filter LinkFilter) ([]*Link, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	where := []string{`workspace = ?`}
+	args := []any{filter.Workspace}
+	if filter.BeforeID > 0 {
+		where = append(where, `id < ?`)
+		args = append(args, filter.BeforeID)
+	}
+	if filter.Folder != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM url_folders uf JOIN folders f ON f.id = uf.folder_id
+			WHERE uf.url_id = urls.id AND f.name = ?)`)
+		args = append(args, filter.Folder)
+	}
+	for _, tag := range filter.Tags {
+		where = append(where, `EXISTS (SELECT 1 FROM url_tags ut JOIN tags t ON t.id = ut.tag_id
+			WHERE ut.url_id = urls.id AND t.name = ?)`)
+		args = append(args, tag)
+	}
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE ` + strings.Join(where, ` AND `) + ` ORDER BY id DESC LIMIT ?`
+	args = append(args, filter.Limit)
+
+	links, err := func() ([]*Link, error) {
+		rows, err := r.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		links := []*Link{}
+		for rows.Next() {
+			link, err := scanLink(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			links = append(links, link)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		// Tags are loaded once the rows are closed, since the pool may
+		// have a single connection
+		for _, link := range links {
+			if err := loadTags(ctx, r.db, link); err != nil {
+				return nil, err
+			}
+		}
+		return links, nil
+	}()
+	r.recordResult(ctx, "list_links", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	return links, nil
+}
+
+// UpdateLink applies update to the link of workspace with code and returns
+// the link, with its tags and variants, as stored afterwards
+func (r *SQLiteRepository) UpdateLink(ctx context.Context, workspace, domain, code string, update LinkUpdate) (*Link, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	var link *Link
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var id int64
+		if err := tx.QueryRowContext(ctx, `SELECT id FROM urls WHERE workspace = ? AND domain = ? AND code = ?`,
+			workspace, domain, code).Scan(&id); err != nil {
+			return err
+		}
+		if update.Tags != nil {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM url_tags WHERE url_id = ?`, id); err != nil {
+				return err
+			}
+			if err := attachTags(ctx, tx, workspace, id, *update.Tags); err != nil {
+				return err
+			}
+		}
+		if update.Folder != nil {
+			if err := attachFolder(ctx, tx, workspace, id, *update.Folder); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if link, err = scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM urls WHERE id = ?`, id)); err != nil {
+			return err
+		}
+		if err := loadTags(ctx, tx, link); err != nil {
+			return err
+		}
+		return loadVariants(ctx, tx, link)
+	})
+	r.recordResult(ctx, "update_link", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to update link: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for code: %s", ErrNotFound, code)
+		}
+		return nil, fmt.Errorf("failed to update link: %w", err)
+	}
+	return link, nil
+}
+
+// deletedLinkRetention is how long deleted links are remembered for quotas,
+// long enough to cover any calendar month they were created in
+const deletedLinkRetention = 32 * 24 * time.Hour
+
+// DeleteLink deletes the link of workspace with code, along with its tags,
+// clicks and variants, and returns it as it was. The link is remembered
+// for a while so that it still counts against its creator's quotas.
+func (r *SQLiteRepository) DeleteLink(ctx context.Context, workspace, domain, code string) (*Link, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	var link *Link
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if link, err = scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM urls WHERE workspace = ? AND domain = ? AND code = ?`,
+			workspace, domain, code)); err != nil {
+			return err
+		}
+		if err := loadTags(ctx, tx, link); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM urls WHERE id = ?`, link.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO deleted_links (workspace, created_by, created_at) VALUES (?, ?, ?)`,
+			link.Workspace, link.CreatedBy, link.CreatedAt.UTC()); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM deleted_links WHERE created_at < ?`, time.Now().Add(-deletedLinkRetention).UTC())
+		return err
+	})
+	r.recordResult(ctx, "delete_link", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to delete link: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for code: %s", ErrNotFound, code)
+		}
+		return nil, fmt.Errorf("failed to delete link: %w", err)
+	}
+	return link, nil
+}
+
+// ListFolders returns every folder of workspace, ordered by name, with its
+// link count
+func (r *SQLiteRepository) ListFolders(ctx context.Context, workspace string) ([]*Folder, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	folders, err := func() ([]*Folder, error) {
+		rows, err := r.db.QueryContext(ctx,
+			`SELECT name, created_at, (SELECT COUNT(*) FROM url_folders WHERE folder_id = folders.id)
+			FROM folders WHERE workspace = ? ORDER BY name`, workspace)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		folders := []*Folder{}
+		for rows.Next() {
+			var folder Folder
+			if err := rows.Scan(&folder.Name, &folder.CreatedAt, &folder.Links); err != nil {
+				return nil, err
+			}
+			folders = append(folders, &folder)
+		}
+		return folders, rows.Err()
+	}()
+	r.recordResult(ctx, "list_folders", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	return folders, nil
+}
+
+// CreateFolder creates an empty folder in workspace, returning ErrConflict
+// if the name is taken there
+func (r *SQLiteRepository) CreateFolder(ctx context.Context, workspace, name string) (*Folder, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	folder := &Folder{Name: name}
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO folders (workspace, name, created_at) VALUES (?, ?, ?) RETURNING created_at`,
+		workspace, name, time.Now().UTC()).Scan(&folder.CreatedAt)
+	r.recordResult(ctx, "create_folder", start, err)
+	if err != nil {
+		return nil, wrapFolderError(ctx, "create", name, err)
+	}
+	return folder, nil
+}
+
+// RenameFolder gives a folder of workspace a new name, keeping its links.
+// It returns ErrConflict if another folder there already has the new name.
+func (r *SQLiteRepository) RenameFolder(ctx context.Context, workspace, name, newName string) (*Folder, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	folder := &Folder{Name: newName}
+	err := r.db.QueryRowContext(ctx,
+		`UPDATE folders SET name = ? WHERE workspace = ? AND name = ?
+		RETURNING created_at, (SELECT COUNT(*) FROM url_folders WHERE folder_id = folders.id)`,
+		newName, workspace, name).Scan(&folder.CreatedAt, &folder.Links)
+	r.recordResult(ctx, "rename_folder", start, err)
+	if err != nil {
+		if ctx.Err() == nil && isUniqueViolation(err) {
+			return nil, fmt.Errorf("%w: folder %s", ErrConflict, newName)
+		}
+		return nil, wrapFolderError(ctx, "rename", name, err)
+	}
+	return folder, nil
+}
+
+// DeleteFolder removes a folder of workspace. Its links are kept, outside
+// any folder.
+func (r *SQLiteRepository) DeleteFolder(ctx context.Context, workspace, name string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx, `DELETE FROM folders WHERE workspace = ? AND name = ?`, workspace, name)
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	if err == nil && deleted == 0 {
+		err = sql.ErrNoRows
+	}
+	r.recordResult(ctx, "delete_folder", start, err)
+	if err != nil {
+		return wrapFolderError(ctx, "delete", name, err)
+	}
+	return nil
+}
+
+// ListTags returns every tag of workspace, ordered by name, with the
+// number of links carrying it and their combined clicks
+func (r *SQLiteRepository) ListTags(ctx context.Context, workspace string) ([]*TagStats, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	tags, err := func() ([]*TagStats, error) {
+		// Totals are added up here rather than in SQL, since the driver
+		// only parses timestamps read straight from a column
+		rows, err := r.db.QueryContext(ctx,
+			`SELECT t.name, u.id, u.clicks, u.last_clicked_at FROM tags t
+			LEFT JOIN url_tags ut ON ut.tag_id = t.id LEFT JOIN urls u ON u.id = ut.url_id
+			WHERE t.workspace = ? ORDER BY t.name`, workspace)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		tags := []*TagStats{}
+		for rows.Next() {
+			var name string
+			var urlID, clicks sql.NullInt64
+			var lastClicked sql.NullTime
+			if err := rows.Scan(&name, &urlID, &clicks, &lastClicked); err != nil {
+				return nil, err
+			}
+			if len(tags) == 0 || tags[len(tags)-1].Name != name {
+				tags = append(tags, &TagStats{Name: name})
+			}
+			if urlID.Valid {
+				addTagClicks(tags[len(tags)-1], clicks.Int64, lastClicked)
+			}
+		}
+		return tags, rows.Err()
+	}()
+	r.recordResult(ctx, "list_tags", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	return tags, nil
+}
+
+// GetTagStats returns the combined click statistics of the links carrying
+// a tag of workspace, including their clicks by country
+func (r *SQLiteRepository) GetTagStats(ctx context.Context, workspace, name string) (*TagStats, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	stats := &TagStats{Name: name, Countries: make(map[string]int64)}
+	err := func() error {
+		var tagID int64
+		if err := r.db.QueryRowContext(ctx, `SELECT id FROM tags WHERE workspace = ? AND name = ?`, workspace, name).Scan(&tagID); err != nil {
+			return err
+		}
+
+		rows, err := r.db.QueryContext(ctx,
+			`SELECT u.clicks, u.last_clicked_at FROM url_tags ut JOIN urls u ON u.id = ut.url_id
+			WHERE ut.tag_id = ?`, tagID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var clicks int64
+			var lastClicked sql.NullTime
+			if err := rows.Scan(&clicks, &lastClicked); err != nil {
+				rows.Close()
+				return err
+			}
+			addTagClicks(stats, clicks, lastClicked)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT cc.country, SUM(cc.clicks) FROM click_countries cc
+			JOIN url_tags ut ON ut.url_id = cc.url_id WHERE ut.tag_id = ? GROUP BY cc.country`, tagID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var country string
+			var clicks int64
+			if err := rows.Scan(&country, &clicks); err != nil {
+				return err
+			}
+			stats.Countries[country] = clicks
+		}
+		return rows.Err()
+	}()
+	r.recordResult(ctx, "get_tag_stats", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to get tag stats: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for tag: %s", ErrNotFound, name)
+		}
+		return nil, fmt.Errorf("failed to get tag stats: %w", err)
+	}
+	return stats, nil
+}
+
+// DeleteTag removes a tag of workspace from every link carrying it
+func (r *SQLiteRepository) DeleteTag(ctx context.Context, workspace, name string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx, `DELETE FROM tags WHERE workspace = ? AND name = ?`, workspace, name)
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	r.recordResult(ctx, "delete_tag", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("failed to delete tag: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w for tag: %s", ErrNotFound, name)
+	}
+	return nil
+}
+
+// addTagClicks adds one link's clicks to a tag's totals
+func addTagClicks(stats *TagStats, clicks int64, lastClicked sql.NullTime) {
+	stats.Links++
+	stats.Clicks += clicks
+	if lastClicked.Valid && (stats.LastClickedAt == nil || lastClicked.Time.After(*stats.LastClickedAt)) {
+		last := lastClicked.Time
+		stats.LastClickedAt = &last
+	}
+}
+
+// attachFolder puts a URL in the named folder of workspace, creating the
+// folder if needed, or takes it out of its folder if name is empty
+func attachFolder(ctx context.Context, tx *sql.Tx, workspace string, urlID int64, name string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM url_folders WHERE url_id = ?`, urlID); err != nil {
+		return err
+	}
+	if name == "" {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO folders (workspace, name, created_at) VALUES (?, ?, ?) ON CONFLICT(workspace, name) DO NOTHING`,
+		workspace, name, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO url_folders (url_id, folder_id) SELECT ?, id FROM folders WHERE workspace = ? AND name = ?`,
+		urlID, workspace, name)
+	return err
+}
+
+// wrapFolderError converts a failed folder operation into the error
+// returned to callers
+func wrapFolderError(ctx context.Context, op, name string, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("failed to %s folder: %w", op, ctx.Err())
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w for folder: %s", ErrNotFound, name)
+	case isUniqueViolation(err):
+		return fmt.Errorf("%w: folder %s", ErrConflict, name)
+	default:
+		return fmt.Errorf("failed to %s folder: %w", op, err)
+	}
+}
@@ -0,0 +1,225 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func linkCodes(links []*Link) []string {
+	codes := make([]string, len(links))
+	for i, link := range links {
+		codes[i] = link.Code
+	}
+	return codes
+}
+
+func TestListLinks(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://one.com", Code: "one", Tags: []string{"email", "spring"}, Folder: "launch"}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://two.com", Code: "two", Tags: []string{"email"}}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://three.com", Code: "three", Folder: "launch"}))
+
+	t.Run("newest first with tags and folder", func(t *testing.T) {
+		links, err := repo.ListLinks(ctx, LinkFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"three", "two", "one"}, linkCodes(links))
+		assert.Equal(t, []string{"email", "spring"}, links[2].Tags)
+		assert.Equal(t, "launch", links[2].Folder)
+		assert.Empty(t, links[1].Folder)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		links, err := repo.ListLinks(ctx, LinkFilter{Tags: []string{"email"}, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"two", "one"}, linkCodes(links))
+
+		links, err = repo.ListLinks(ctx, LinkFilter{Tags: []string{"email", "spring"}, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"one"}, linkCodes(links), "every tag must match")
+
+		links, err = repo.ListLinks(ctx, LinkFilter{Folder: "launch", Tags: []string{"email"}, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"one"}, linkCodes(links))
+
+		links, err = repo.ListLinks(ctx, LinkFilter{Folder: "missing", Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, links)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		first, err := repo.ListLinks(ctx, LinkFilter{Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"three", "two"}, linkCodes(first))
+
+		rest, err := repo.ListLinks(ctx, LinkFilter{BeforeID: first[1].ID, Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"one"}, linkCodes(rest))
+	})
+}
+
+func TestUpdateLink(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "abc", Tags: []string{"old"}, Folder: "drafts"}))
+
+	tags := []string{"new", "spring"}
+	link, err := repo.UpdateLink(ctx, "", "", "abc", LinkUpdate{Tags: &tags})
+	require.NoError(t, err)
+	assert.Equal(t, tags, link.Tags)
+	assert.Equal(t, "drafts", link.Folder, "nil fields are left alone")
+
+	folder := "launch"
+	link, err = repo.UpdateLink(ctx, "", "", "abc", LinkUpdate{Folder: &folder})
+	require.NoError(t, err)
+	assert.Equal(t, "launch", link.Folder)
+	assert.Equal(t, tags, link.Tags)
+
+	none := ""
+	link, err = repo.UpdateLink(ctx, "", "", "abc", LinkUpdate{Folder: &none})
+	require.NoError(t, err)
+	assert.Empty(t, link.Folder)
+
+	_, err = repo.UpdateLink(ctx, "", "", "missing", LinkUpdate{Folder: &folder})
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestDeleteLink(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "abc", Workspace: "marketing",
+		CreatedBy: "ann@example.com", Tags: []string{"spring"}, Folder: "launch"}))
+	_, err := repo.RecordClick(ctx, "", "abc", Click{Country: "DE"})
+	require.NoError(t, err)
+
+	_, err = repo.DeleteLink(ctx, "", "", "abc")
+	assert.True(t, errors.Is(err, ErrNotFound), "links of other workspaces are not found")
+
+	link, err := repo.DeleteLink(ctx, "marketing", "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com", link.OriginalURL)
+	assert.Equal(t, []string{"spring"}, link.Tags)
+	assert.Equal(t, int64(1), link.Clicks)
+
+	_, err = repo.GetLink(ctx, "", "abc")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = repo.DeleteLink(ctx, "marketing", "", "abc")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// The link still counts against the quotas of the month it was
+	// created in
+	now := time.Now().UTC()
+	periods := UsagePeriods{Day: now.Add(-time.Hour), Month: now.AddDate(0, 0, -1), Now: now}
+	usage, err := repo.GetQuotaUsage(ctx, "marketing", "ann@example.com", periods)
+	require.NoError(t, err)
+	assert.Equal(t, &QuotaUsage{LinksToday: 1, LinksThisMonth: 1}, usage)
+
+	// The code is free again
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://other.com", Code: "abc"}))
+}
+
+func TestFolders(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	created, err := repo.CreateFolder(ctx, "", "drafts")
+	require.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+	_, err = repo.CreateFolder(ctx, "", "drafts")
+	assert.True(t, errors.Is(err, ErrConflict))
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://one.com", Code: "one", Folder: "launch"}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://two.com", Code: "two", Folder: "launch"}))
+
+	folders, err := repo.ListFolders(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, folders, 2)
+	assert.Equal(t, "drafts", folders[0].Name)
+	assert.Equal(t, int64(0), folders[0].Links)
+	assert.Equal(t, "launch", folders[1].Name)
+	assert.Equal(t, int64(2), folders[1].Links)
+
+	t.Run("rename keeps links", func(t *testing.T) {
+		renamed, err := repo.RenameFolder(ctx, "", "launch", "spring-launch")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), renamed.Links)
+		link, err := repo.GetLink(ctx, "", "one")
+		require.NoError(t, err)
+		assert.Equal(t, "spring-launch", link.Folder)
+
+		_, err = repo.RenameFolder(ctx, "", "spring-launch", "drafts")
+		assert.True(t, errors.Is(err, ErrConflict))
+		_, err = repo.RenameFolder(ctx, "", "missing", "other")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("delete keeps links outside any folder", func(t *testing.T) {
+		require.NoError(t, repo.DeleteFolder(ctx, "", "spring-launch"))
+		link, err := repo.GetLink(ctx, "", "one")
+		require.NoError(t, err)
+		assert.Empty(t, link.Folder)
+
+		assert.True(t, errors.Is(repo.DeleteFolder(ctx, "", "spring-launch"), ErrNotFound))
+	})
+}
+
+func TestTagStats(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://one.com", Code: "one", Tags: []string{"email", "spring"}}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://two.com", Code: "two", Tags: []string{"email"}}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://three.com", Code: "three", Tags: []string{"unused"}}))
+	_, err := repo.RecordClick(ctx, "", "one", Click{Country: "DE"})
+	require.NoError(t, err)
+	_, err = repo.RecordClick(ctx, "", "two", Click{Country: "DE"})
+	require.NoError(t, err)
+	_, err = repo.RecordClick(ctx, "", "two", Click{Country: "FR"})
+	require.NoError(t, err)
+	_, err = repo.RecordClick(ctx, "", "two", Click{})
+	require.NoError(t, err)
+
+	tags, err := repo.ListTags(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, tags, 3)
+	assert.Equal(t, "email", tags[0].Name)
+	assert.Equal(t, int64(2), tags[0].Links)
+	assert.Equal(t, int64(4), tags[0].Clicks)
+	assert.NotNil(t, tags[0].LastClickedAt)
+	assert.Equal(t, "spring", tags[1].Name)
+	assert.Equal(t, int64(1), tags[1].Clicks)
+	assert.Equal(t, "unused", tags[2].Name)
+	assert.Equal(t, int64(0), tags[2].Clicks)
+	assert.Nil(t, tags[2].LastClickedAt)
+
+	stats, err := repo.GetTagStats(ctx, "", "email")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Links)
+	assert.Equal(t, int64(4), stats.Clicks)
+	assert.Equal(t, map[string]int64{"DE": 2, "FR": 1}, stats.Countries)
+
+	_, err = repo.GetTagStats(ctx, "", "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	t.Run("delete removes the tag from its links", func(t *testing.T) {
+		require.NoError(t, repo.DeleteTag(ctx, "", "email"))
+		link, err := repo.GetLink(ctx, "", "one")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"spring"}, link.Tags)
+
+		assert.True(t, errors.Is(repo.DeleteTag(ctx, "", "email"), ErrNotFound))
+	})
+}
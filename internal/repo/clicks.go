@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Click describes a visit being counted
+type Click struct {
+	// Country is the visitor's ISO 3166-1 alpha-2 country code; empty if
+	// unknown
+	Country string
+	// Variant names the variant of a split link the visitor was sent to;
+	// empty if none
+	Variant string
+}
+
+// ClickStats summarises the visits to a link
+type ClickStats struct {
+	Clicks        int64
+	LastClickedAt *time.Time
+	// Countries counts clicks by visitor country. Clicks from unknown
+	// countries are only in the total.
+	Countries map[string]int64
+	// Variants are the link's variants with their clicks; empty unless
+	// the link is split
+	Variants []Variant
+}
+
+// GetClickStats returns the click statistics of the link of workspace with
+// code
+func (r *SQLiteRepository) GetClickStats(ctx context.Context, workspace, domain, code string) (*ClickStats, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	stats := &ClickStats{Countries: make(map[string]int64)}
+	err := func() error {
+		var id int64
+		var lastClicked sql.NullTime
+		if err := r.db.QueryRowContext(ctx,
+			`SELECT id, clicks, last_clicked_at FROM urls WHERE workspace = ? AND domain = ? AND code = ?`, workspace, domain, code).
+			Scan(&id, &stats.Clicks, &lastClicked); err != nil {
+			return err
+		}
+		if lastClicked.Valid {
+			stats.LastClickedAt = &lastClicked.Time
+		}
+
+		rows, err := r.db.QueryContext(ctx, `SELECT country, clicks FROM click_countries WHERE url_id = ?`, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var country string
+			var clicks int64
+			if err := rows.Scan(&country, &clicks); err != nil {
+				return err
+			}
+			stats.Countries[country] = clicks
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		link := &Link{ID: id}
+		if err := loadVariants(ctx, r.db, link); err != nil {
+			return err
+		}
+		stats.Variants = link.Variants
+		return nil
+	}()
+	r.recordResult(ctx, "get_click_stats", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to get click stats: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for code: %s", ErrNotFound, code)
+		}
+		return nil, fmt.Errorf("failed to get click stats: %w", err)
+	}
+	return stats, nil
+}
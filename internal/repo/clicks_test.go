@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metrics"
+)
+
+func TestRecordClick(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "open"}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com/secret", Code: "once", MaxClicks: 1}))
+
+	t.Run("unlimited links count every click", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := repo.RecordClick(ctx, "", "open", Click{})
+			require.NoError(t, err)
+		}
+		link, err := repo.GetLink(ctx, "", "open")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), link.Clicks)
+		assert.NotNil(t, link.LastClickedAt)
+		assert.Zero(t, link.MaxClicks)
+	})
+
+	t.Run("one-time link", func(t *testing.T) {
+		_, err := repo.RecordClick(ctx, "", "once", Click{})
+		require.NoError(t, err)
+		_, err = repo.RecordClick(ctx, "", "once", Click{})
+		assert.True(t, errors.Is(err, ErrLimitReached))
+
+		link, err := repo.GetLink(ctx, "", "once")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), link.Clicks)
+		assert.Equal(t, 1, link.MaxClicks)
+	})
+
+	t.Run("missing link", func(t *testing.T) {
+		_, err := repo.RecordClick(ctx, "", "missing", Click{})
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestClickStats(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "world"}))
+	stats, err := repo.GetClickStats(ctx, "", "", "world")
+	require.NoError(t, err)
+	assert.Zero(t, stats.Clicks)
+	assert.Nil(t, stats.LastClickedAt)
+	assert.Empty(t, stats.Countries)
+
+	for _, country := range []string{"DE", "DE", "US", ""} {
+		_, err = repo.RecordClick(ctx, "", "world", Click{Country: country})
+		require.NoError(t, err)
+	}
+	stats, err = repo.GetClickStats(ctx, "", "", "world")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), stats.Clicks)
+	assert.NotNil(t, stats.LastClickedAt)
+	assert.Equal(t, map[string]int64{"DE": 2, "US": 1}, stats.Countries)
+
+	_, err = repo.GetClickStats(ctx, "", "", "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestRecordClickConcurrent(t *testing.T) {
+	// A file database, so that clicks race across real connections
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "clicks.db"))
+	require.NoError(t, err)
+	applyMigrations(t, db)
+	repo := &SQLiteRepository{db: db, metrics: metrics.NewMetrics()}
+	defer repo.Close()
+	ctx := context.Background()
+
+	const maxClicks, visitors = 5, 40
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "limited", MaxClicks: maxClicks}))
+
+	var wg sync.WaitGroup
+	var counted, refused atomic.Int32
+	for i := 0; i < visitors; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.RecordClick(ctx, "", "limited", Click{})
+			switch {
+			case err == nil:
+				counted.Add(1)
+			case errors.Is(err, ErrLimitReached):
+				refused.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(maxClicks), counted.Load())
+	assert.Equal(t, int32(visitors-maxClicks), refused.Load())
+	link, err := repo.GetLink(ctx, "", "limited")
+	require.NoError(t, err)
+	assert.Equal(t, int64(maxClicks), link.Clicks)
+}
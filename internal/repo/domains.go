@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DomainRepository stores the branded domains links can be created on.
+// The default domain is not stored. Each domain belongs to a workspace,
+// but hosts are unique across all of them, so GetDomain, which serves
+// visitors, is not scoped.
+type DomainRepository interface {
+	ListDomains(ctx context.Context, workspace string) ([]*Domain, error)
+	GetDomain(ctx context.Context, host string) (*Domain, error)
+	CreateDomain(ctx context.Context, domain *Domain) error
+	UpdateDomain(ctx context.Context, domain *Domain) error
+	DeleteDomain(ctx context.Context, workspace, host string) error
+}
+
+// Domain is a branded short domain and its settings
+type Domain struct {
+	Host string
+	// Workspace is the slug of the workspace owning the domain and the
+	// only one that can create links on it
+	Workspace string
+	// NotFoundURL is where visitors asking for an unknown code are sent;
+	// empty shows the standard 404 page
+	NotFoundURL string
+	// RedirectStatus is given to links created on the domain without a
+	// status of their own; zero means the server default
+	RedirectStatus int
+	CreatedAt      time.Time
+	// Links counts the links on the domain
+	Links int64
+}
+
+const domainColumns = `host, workspace, not_found_url, redirect_status, created_at,
+	(SELECT COUNT(*) FROM urls WHERE urls.domain = domains.host)`
+
+// ListDomains returns every domain of workspace, ordered by host, with its
+// link count
+func (r *SQLiteRepository) ListDomains(ctx context.Context, workspace string) ([]*Domain, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	domains, err := func() ([]*Domain, error) {
+		rows, err := r.db.QueryContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE workspace = ? ORDER BY host`, workspace)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		domains := []*Domain{}
+		for rows.Next() {
+			domain, err := scanDomain(rows)
+			if err != nil {
+				return nil, err
+			}
+			domains = append(domains, domain)
+		}
+		return domains, rows.Err()
+	}()
+	r.recordResult(ctx, "list_domains", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	return domains, nil
+}
+
+// GetDomain retrieves a domain by host
+func (r *SQLiteRepository) GetDomain(ctx context.Context, host string) (*Domain, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	domain, err := scanDomain(r.db.QueryRowContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE host = ?`, host))
+	r.recordResult(ctx, "get_domain", start, err)
+	if err != nil {
+		return nil, wrapDomainError(ctx, "get", host, err)
+	}
+	return domain, nil
+}
+
+// CreateDomain stores a new domain, returning ErrConflict if its host is
+// already registered by any workspace. domain.CreatedAt is set to the stored value.
+func (r *SQLiteRepository) CreateDomain(ctx context.Context, domain *Domain) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO domains (host, workspace, not_found_url, redirect_status, created_at) VALUES (?, ?, ?, ?, ?)
+		RETURNING created_at`,
+		domain.Host, domain.Workspace, nullIfEmpty(domain.NotFoundURL), nullIfZero(domain.RedirectStatus), time.Now().UTC()).
+		Scan(&domain.CreatedAt)
+	r.recordResult(ctx, "create_domain", start, err)
+	if err != nil {
+		return wrapDomainError(ctx, "create", domain.Host, err)
+	}
+	return nil
+}
+
+// UpdateDomain replaces the settings of an existing domain of
+// domain.Workspace. Links already
+// created keep their redirect status. domain.CreatedAt and domain.Links are
+// set to the stored values.
+func (r *SQLiteRepository) UpdateDomain(ctx context.Context, domain *Domain) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.QueryRowContext(ctx,
+		`UPDATE domains SET not_found_url = ?, redirect_status = ? WHERE host = ? AND workspace = ?
+		RETURNING created_at, (SELECT COUNT(*) FROM urls WHERE urls.domain = domains.host)`,
+		nullIfEmpty(domain.NotFoundURL), nullIfZero(domain.RedirectStatus), domain.Host, domain.Workspace).
+		Scan(&domain.CreatedAt, &domain.Links)
+	r.recordResult(ctx, "update_domain", start, err)
+	if err != nil {
+		return wrapDomainError(ctx, "update", domain.Host, err)
+	}
+	return nil
+}
+
+// DeleteDomain removes a domain of workspace. A domain with links cannot be
+// deleted; ErrConflict is returned instead.
+func (r *SQLiteRepository) DeleteDomain(ctx context.Context, workspace, host string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var links int64
+		if err := tx.QueryRowContext(ctx,
+			`SELECT (SELECT COUNT(*) FROM urls WHERE urls.domain = domains.host) FROM domains WHERE host = ? AND workspace = ?`,
+			host, workspace).Scan(&links); err != nil {
+			return err
+		}
+		if links > 0 {
+			return fmt.Errorf("%w: domain %s has %d links", ErrConflict, host, links)
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM domains WHERE host = ?`, host)
+		return err
+	})
+	r.recordResult(ctx, "delete_domain", start, err)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, ErrConflict) {
+			return err
+		}
+		return wrapDomainError(ctx, "delete", host, err)
+	}
+	return nil
+}
+
+// scanDomain reads a row selected with domainColumns
+func scanDomain(row rowScanner) (*Domain, error) {
+	var domain Domain
+	var notFoundURL sql.NullString
+	var redirectStatus sql.NullInt64
+	if err := row.Scan(&domain.Host, &domain.Workspace, &notFoundURL, &redirectStatus, &domain.CreatedAt, &domain.Links); err != nil {
+		return nil, err
+	}
+	domain.NotFoundURL = notFoundURL.String
+	domain.RedirectStatus = int(redirectStatus.Int64)
+	return &domain, nil
+}
+
+// wrapDomainError turns err from a domain operation into ErrNotFound or
+// ErrConflict where it applies
+func wrapDomainError(ctx context.Context, op, host string, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("failed to %s domain: %w", op, ctx.Err())
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w for domain: %s", ErrNotFound, host)
+	case isUniqueViolation(err):
+		return fmt.Errorf("%w: domain %s", ErrConflict, host)
+	default:
+		return fmt.Errorf("failed to %s domain: %w", op, err)
+	}
+}
@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomains(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	domain := &Domain{Host: "go.example.com", NotFoundURL: "https://example.com/404", RedirectStatus: 301}
+	require.NoError(t, repo.CreateDomain(ctx, domain))
+	assert.False(t, domain.CreatedAt.IsZero())
+	assert.True(t, errors.Is(repo.CreateDomain(ctx, &Domain{Host: "go.example.com"}), ErrConflict))
+	require.NoError(t, repo.CreateDomain(ctx, &Domain{Host: "links.example.org"}))
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://one.com", Code: "one", Domain: "go.example.com"}))
+
+	domains, err := repo.ListDomains(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, domains, 2)
+	assert.Equal(t, "go.example.com", domains[0].Host)
+	assert.Equal(t, "https://example.com/404", domains[0].NotFoundURL)
+	assert.Equal(t, 301, domains[0].RedirectStatus)
+	assert.Equal(t, int64(1), domains[0].Links)
+	assert.Equal(t, int64(0), domains[1].Links)
+
+	t.Run("update replaces settings", func(t *testing.T) {
+		require.NoError(t, repo.UpdateDomain(ctx, &Domain{Host: "go.example.com"}))
+		got, err := repo.GetDomain(ctx, "go.example.com")
+		require.NoError(t, err)
+		assert.Empty(t, got.NotFoundURL)
+		assert.Zero(t, got.RedirectStatus)
+
+		assert.True(t, errors.Is(repo.UpdateDomain(ctx, &Domain{Host: "missing.example.com"}), ErrNotFound))
+	})
+
+	t.Run("domains with links are kept", func(t *testing.T) {
+		assert.True(t, errors.Is(repo.DeleteDomain(ctx, "", "go.example.com"), ErrConflict))
+		require.NoError(t, repo.DeleteDomain(ctx, "", "links.example.org"))
+		assert.True(t, errors.Is(repo.DeleteDomain(ctx, "", "links.example.org"), ErrNotFound))
+		_, err := repo.GetDomain(ctx, "links.example.org")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestCodesArePerDomain(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://default.com", Code: "abc"}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://branded.com", Code: "abc", Domain: "go.example.com"}))
+	assert.True(t, errors.Is(repo.StoreURL(ctx, &Link{OriginalURL: "http://other.com", Code: "abc", Domain: "go.example.com"}), ErrConflict))
+
+	link, err := repo.GetLink(ctx, "", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "http://default.com", link.OriginalURL)
+	assert.Empty(t, link.Domain)
+
+	link, err = repo.GetLink(ctx, "go.example.com", "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "http://branded.com", link.OriginalURL)
+	assert.Equal(t, "go.example.com", link.Domain)
+
+	_, err = repo.GetLink(ctx, "links.example.org", "abc")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	t.Run("clicks count on the right domain", func(t *testing.T) {
+		_, err = repo.RecordClick(ctx, "go.example.com", "abc", Click{})
+		require.NoError(t, err)
+		stats, err := repo.GetClickStats(ctx, "", "go.example.com", "abc")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Clicks)
+		stats, err = repo.GetClickStats(ctx, "", "", "abc")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), stats.Clicks)
+	})
+
+	t.Run("dedupe stays on the domain", func(t *testing.T) {
+		found, err := repo.FindOrStoreURL(ctx, &Link{OriginalURL: "http://default.com", Code: "d1", URLHash: "h1"})
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		branded := &Link{OriginalURL: "http://default.com", Code: "d2", URLHash: "h1", Domain: "go.example.com"}
+		found, err = repo.FindOrStoreURL(ctx, branded)
+		require.NoError(t, err)
+		assert.False(t, found, "a link on another domain is not reused")
+
+		again := &Link{OriginalURL: "http://default.com", Code: "d3", URLHash: "h1", Domain: "go.example.com"}
+		found, err = repo.FindOrStoreURL(ctx, again)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "d2", again.Code)
+	})
+}
@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IdempotencyRepository stores responses keyed by client-supplied
+// idempotency keys. Each workspace has keys of its own. A reservation is
+// identified by the time it was made at; every change after it checks
+// that the key still holds that reservation and has no response.
+type IdempotencyRepository interface {
+	ReserveIdempotencyKey(ctx context.Context, workspace, key, fingerprint string, reservedAt, expiresBefore time.Time) (*IdempotencyRecord, error)
+	ReclaimIdempotencyKey(ctx context.Context, workspace, key, fingerprint string, abandonedAt, reservedAt time.Time) (bool, error)
+	CompleteIdempotencyKey(ctx context.Context, workspace, key string, reservedAt time.Time, response StoredResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, workspace, key string, reservedAt time.Time) error
+}
+
+// StoredResponse is a response saved for replay
+type StoredResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyRecord is a previously reserved key. Response is nil while
+// the request that reserved the key is still being processed.
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Response    *StoredResponse
+	CreatedAt   time.Time
+}
+
+// ReserveIdempotencyKey claims key for a new request at reservedAt. It
+// returns nil if the key was free, or the existing record if the key is
+// already taken. Records created before expiresBefore are discarded first,
+// freeing their keys for reuse.
+func (r *SQLiteRepository) ReserveIdempotencyKey(ctx context.Context, workspace, key, fingerprint string, reservedAt, expiresBefore time.Time) (*IdempotencyRecord, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	var existing *IdempotencyRecord
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM idempotency_keys WHERE created_at < ?`, expiresBefore.UTC()); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO idempotency_keys (workspace, idempotency_key, fingerprint, created_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(workspace, idempotency_key) DO NOTHING`,
+			workspace, key, fingerprint, reservedAt.UTC())
+		if err != nil {
+			return err
+		}
+		if inserted, err := result.RowsAffected(); err != nil || inserted == 1 {
+			return err
+		}
+
+		existing, err = scanIdempotencyRecord(tx.QueryRowContext(ctx,
+			`SELECT idempotency_key, fingerprint, status_code, content_type, body, created_at
+			FROM idempotency_keys WHERE workspace = ? AND idempotency_key = ?`, workspace, key))
+		return err
+	})
+	r.recordResult(ctx, "reserve_idempotency_key", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	return existing, nil
+}
+
+// ReclaimIdempotencyKey takes over a key whose request was abandoned,
+// making a new reservation at reservedAt for fingerprint. It reports false
+// if the key no longer holds the pending reservation made at abandonedAt,
+// because it completed or someone else took it over first.
+func (r *SQLiteRepository) ReclaimIdempotencyKey(ctx context.Context, workspace, key, fingerprint string, abandonedAt, reservedAt time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET fingerprint = ?, created_at = ?
+		WHERE workspace = ? AND idempotency_key = ? AND created_at = ? AND status_code IS NULL`,
+		fingerprint, reservedAt.UTC(), workspace, key, abandonedAt.UTC())
+	var reclaimed int64
+	if err == nil {
+		reclaimed, err = result.RowsAffected()
+	}
+	r.recordResult(ctx, "reclaim_idempotency_key", start, err)
+	if err != nil {
+		return false, fmt.Errorf("failed to reclaim idempotency key: %w", err)
+	}
+	return reclaimed == 1, nil
+}
+
+// CompleteIdempotencyKey saves the response for the reservation of key
+// made at reservedAt. Nothing is saved if the key was taken over since.
+func (r *SQLiteRepository) CompleteIdempotencyKey(ctx context.Context, workspace, key string, reservedAt time.Time, response StoredResponse) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET status_code = ?, content_type = ?, body = ?
+		WHERE workspace = ? AND idempotency_key = ? AND created_at = ? AND status_code IS NULL`,
+		response.StatusCode, response.ContentType, response.Body, workspace, key, reservedAt.UTC())
+	r.recordResult(ctx, "complete_idempotency_key", start, err)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the reservation of key made at reservedAt
+// so that the request can be retried, for example after a server error.
+// A key taken over since is left to its new owner.
+func (r *SQLiteRepository) ReleaseIdempotencyKey(ctx context.Context, workspace, key string, reservedAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE workspace = ? AND idempotency_key = ? AND created_at = ? AND status_code IS NULL`,
+		workspace, key, reservedAt.UTC())
+	r.recordResult(ctx, "release_idempotency_key", start, err)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// scanIdempotencyRecord reads a row selected by ReserveIdempotencyKey
+func scanIdempotencyRecord(row *sql.Row) (*IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	if err := row.Scan(&record.Key, &record.Fingerprint, &statusCode, &contentType, &body, &record.CreatedAt); err != nil {
+		return nil, err
+	}
+	if statusCode.Valid {
+		record.Response = &StoredResponse{
+			StatusCode:  int(statusCode.Int64),
+			ContentType: contentType.String,
+			Body:        body,
+		}
+	}
+	return &record, nil
+}
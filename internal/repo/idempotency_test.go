@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+	expiresBefore := time.Now().Add(-time.Hour)
+
+	t.Run("reserve, complete and replay", func(t *testing.T) {
+		reservedAt := time.Now()
+		record, err := repo.ReserveIdempotencyKey(ctx, "", "key-1", "fp-1", reservedAt, expiresBefore)
+		require.NoError(t, err)
+		assert.Nil(t, record)
+
+		record, err = repo.ReserveIdempotencyKey(ctx, "", "key-1", "fp-1", time.Now(), expiresBefore)
+		require.NoError(t, err)
+		require.NotNil(t, record)
+		assert.Equal(t, "fp-1", record.Fingerprint)
+		assert.Nil(t, record.Response, "response is pending")
+
+		response := StoredResponse{StatusCode: 200, ContentType: "application/json", Body: []byte(`{"code":"abc"}`)}
+		require.NoError(t, repo.CompleteIdempotencyKey(ctx, "", "key-1", reservedAt, response))
+
+		record, err = repo.ReserveIdempotencyKey(ctx, "", "key-1", "fp-2", time.Now(), expiresBefore)
+		require.NoError(t, err)
+		require.NotNil(t, record)
+		assert.Equal(t, "fp-1", record.Fingerprint)
+		assert.Equal(t, &response, record.Response)
+	})
+
+	t.Run("release frees the key", func(t *testing.T) {
+		reservedAt := time.Now()
+		_, err := repo.ReserveIdempotencyKey(ctx, "", "key-2", "fp", reservedAt, expiresBefore)
+		require.NoError(t, err)
+		require.NoError(t, repo.ReleaseIdempotencyKey(ctx, "", "key-2", reservedAt))
+
+		record, err := repo.ReserveIdempotencyKey(ctx, "", "key-2", "fp", time.Now(), expiresBefore)
+		require.NoError(t, err)
+		assert.Nil(t, record)
+	})
+
+	t.Run("expired keys are reusable", func(t *testing.T) {
+		_, err := repo.ReserveIdempotencyKey(ctx, "", "key-3", "old", time.Now(), expiresBefore)
+		require.NoError(t, err)
+
+		record, err := repo.ReserveIdempotencyKey(ctx, "", "key-3", "new", time.Now(), time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Nil(t, record)
+	})
+
+	t.Run("only one reclaim of an abandoned key wins", func(t *testing.T) {
+		abandonedAt := time.Now().Add(-10 * time.Minute)
+		_, err := repo.ReserveIdempotencyKey(ctx, "", "key-4", "fp", abandonedAt, expiresBefore)
+		require.NoError(t, err)
+		record, err := repo.ReserveIdempotencyKey(ctx, "", "key-4", "fp", time.Now(), expiresBefore)
+		require.NoError(t, err)
+		require.NotNil(t, record)
+
+		first, second := time.Now(), time.Now().Add(time.Millisecond)
+		reclaimed, err := repo.ReclaimIdempotencyKey(ctx, "", "key-4", "fp", record.CreatedAt, first)
+		require.NoError(t, err)
+		assert.True(t, reclaimed)
+		reclaimed, err = repo.ReclaimIdempotencyKey(ctx, "", "key-4", "fp", record.CreatedAt, second)
+		require.NoError(t, err)
+		assert.False(t, reclaimed, "the abandoned reservation is already gone")
+	})
+
+	t.Run("a stale owner cannot touch the new reservation", func(t *testing.T) {
+		abandonedAt := time.Now().Add(-10 * time.Minute)
+		_, err := repo.ReserveIdempotencyKey(ctx, "", "key-5", "fp", abandonedAt, expiresBefore)
+		require.NoError(t, err)
+		record, err := repo.ReserveIdempotencyKey(ctx, "", "key-5", "fp", time.Now(), expiresBefore)
+		require.NoError(t, err)
+		reservedAt := time.Now()
+		reclaimed, err := repo.ReclaimIdempotencyKey(ctx, "", "key-5", "fp", record.CreatedAt, reservedAt)
+		require.NoError(t, err)
+		require.True(t, reclaimed)
+
+		stale := StoredResponse{StatusCode: 201, Body: []byte("stale")}
+		require.NoError(t, repo.CompleteIdempotencyKey(ctx, "", "key-5", abandonedAt, stale))
+		require.NoError(t, repo.ReleaseIdempotencyKey(ctx, "", "key-5", abandonedAt))
+
+		record, err = repo.ReserveIdempotencyKey(ctx, "", "key-5", "fp", time.Now(), expiresBefore)
+		require.NoError(t, err)
+		require.NotNil(t, record, "the new reservation survives")
+		assert.Nil(t, record.Response)
+		assert.True(t, record.CreatedAt.Equal(reservedAt))
+
+		fresh := StoredResponse{StatusCode: 201, Body: []byte("fresh")}
+		require.NoError(t, repo.CompleteIdempotencyKey(ctx, "", "key-5", reservedAt, fresh))
+		require.NoError(t, repo.ReleaseIdempotencyKey(ctx, "", "key-5", reservedAt))
+		record, err = repo.ReserveIdempotencyKey(ctx, "", "key-5", "fp", time.Now(), expiresBefore)
+		require.NoError(t, err)
+		require.NotNil(t, record)
+		assert.Equal(t, &fresh, record.Response, "a completed key is not released")
+	})
+}
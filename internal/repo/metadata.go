@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MetadataRepository stores what was found on each link's destination page
+type MetadataRepository interface {
+	SaveMetadata(ctx context.Context, domain, code string, meta *LinkMetadata) error
+	GetMetadata(ctx context.Context, workspace, domain, code string) (*LinkMetadata, error)
+}
+
+// LinkMetadata describes a link's destination page. A failed fetch is
+// stored too, with Error set, so that it is not retried indefinitely.
+type LinkMetadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+	Error       string
+	FetchedAt   time.Time
+}
+
+// SaveMetadata creates or replaces the metadata of the link with code
+func (r *SQLiteRepository) SaveMetadata(ctx context.Context, domain, code string, meta *LinkMetadata) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	fetchedAt := meta.FetchedAt
+	if fetchedAt.IsZero() {
+		fetchedAt = time.Now()
+	}
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO link_metadata (url_id, title, description, image_url, error, fetched_at)
+		SELECT id, ?, ?, ?, ?, ? FROM urls WHERE domain = ? AND code = ?
+		ON CONFLICT(url_id) DO UPDATE SET
+			title = excluded.title, description = excluded.description, image_url = excluded.image_url,
+			error = excluded.error, fetched_at = excluded.fetched_at`,
+		nullIfEmpty(meta.Title), nullIfEmpty(meta.Description), nullIfEmpty(meta.ImageURL),
+		nullIfEmpty(meta.Error), fetchedAt.UTC(), domain, code)
+	var saved int64
+	if err == nil {
+		saved, err = result.RowsAffected()
+	}
+	r.recordResult(ctx, "save_metadata", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if saved == 0 {
+		return fmt.Errorf("%w for code: %s", ErrNotFound, code)
+	}
+	meta.FetchedAt = fetchedAt.UTC()
+	return nil
+}
+
+// GetMetadata retrieves the metadata of workspace's link with code.
+// ErrNotFound means the link does not exist in workspace or its
+// destination has not been fetched yet.
+func (r *SQLiteRepository) GetMetadata(ctx context.Context, workspace, domain, code string) (*LinkMetadata, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	var meta LinkMetadata
+	var title, description, imageURL, fetchErr sql.NullString
+	err := r.db.QueryRowContext(ctx,
+		`SELECT m.title, m.description, m.image_url, m.error, m.fetched_at
+		FROM link_metadata m JOIN urls u ON u.id = m.url_id WHERE u.workspace = ? AND u.domain = ? AND u.code = ?`,
+		workspace, domain, code).
+		Scan(&title, &description, &imageURL, &fetchErr, &meta.FetchedAt)
+	r.recordResult(ctx, "get_metadata", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to get metadata: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for metadata: %s", ErrNotFound, code)
+		}
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	meta.Title = title.String
+	meta.Description = description.String
+	meta.ImageURL = imageURL.String
+	meta.Error = fetchErr.String
+	return &meta, nil
+}
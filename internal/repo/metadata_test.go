@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkMetadata(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "meta01"}))
+
+	t.Run("missing until saved", func(t *testing.T) {
+		_, err := repo.GetMetadata(ctx, "", "", "meta01")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("save and replace", func(t *testing.T) {
+		fetchedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		require.NoError(t, repo.SaveMetadata(ctx, "", "meta01", &LinkMetadata{Error: "unexpected status 503", FetchedAt: fetchedAt}))
+
+		meta, err := repo.GetMetadata(ctx, "", "", "meta01")
+		require.NoError(t, err)
+		assert.Equal(t, "unexpected status 503", meta.Error)
+		assert.True(t, fetchedAt.Equal(meta.FetchedAt))
+
+		require.NoError(t, repo.SaveMetadata(ctx, "", "meta01", &LinkMetadata{
+			Title:       "Example Domain",
+			Description: "An example",
+			ImageURL:    "http://example.com/og.png",
+		}))
+		meta, err = repo.GetMetadata(ctx, "", "", "meta01")
+		require.NoError(t, err)
+		assert.Equal(t, "Example Domain", meta.Title)
+		assert.Equal(t, "An example", meta.Description)
+		assert.Equal(t, "http://example.com/og.png", meta.ImageURL)
+		assert.Empty(t, meta.Error)
+		assert.True(t, meta.FetchedAt.After(fetchedAt))
+	})
+
+	t.Run("other workspaces see nothing", func(t *testing.T) {
+		_, err := repo.GetMetadata(ctx, "sales", "", "meta01")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		err := repo.SaveMetadata(ctx, "", "nosuch", &LinkMetadata{Title: "x"})
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("overwriting import discards metadata", func(t *testing.T) {
+		link := &Link{OriginalURL: "http://example.org", Code: "meta01"}
+		_, err := repo.ImportLinks(ctx, []*Link{link}, ImportOptions{Policy: ConflictOverwrite})
+		require.NoError(t, err)
+
+		_, err = repo.GetMetadata(ctx, "", "", "meta01")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
@@ -0,0 +1,210 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// QuotaRepository stores the quotas capping how many links a workspace, or
+// one of its members, may create, and counts the links they have
+type QuotaRepository interface {
+	ListQuotas(ctx context.Context, workspace string) ([]*Quota, error)
+	GetQuota(ctx context.Context, workspace, member string) (*Quota, error)
+	SaveQuota(ctx context.Context, quota *Quota) error
+	DeleteQuota(ctx context.Context, workspace, member string) error
+	GetQuotaUsage(ctx context.Context, workspace, member string, periods UsagePeriods) (*QuotaUsage, error)
+}
+
+// Quota caps the links of a workspace, or of one of its members when
+// Member is set. A zero limit is no limit.
+type Quota struct {
+	Workspace string
+	// Member is the email of the member the quota applies to; empty for
+	// the quota of the whole workspace
+	Member string
+	// LinksPerDay and LinksPerMonth cap the links created per calendar day
+	// and month
+	LinksPerDay   int64
+	LinksPerMonth int64
+	// ActiveLinks caps the links that still redirect, that is those whose
+	// active window has not ended and whose clicks have not run out
+	ActiveLinks int64
+	UpdatedAt   time.Time
+}
+
+// UsagePeriods are the instants usage is counted from. Day and Month are
+// the starts of the current day and month; Now decides which links are
+// still active.
+type UsagePeriods struct {
+	Day   time.Time
+	Month time.Time
+	Now   time.Time
+}
+
+// QuotaUsage counts the links a quota applies to
+type QuotaUsage struct {
+	LinksToday     int64
+	LinksThisMonth int64
+	ActiveLinks    int64
+}
+
+const quotaColumns = `workspace, member, links_per_day, links_per_month, active_links, updated_at`
+
+// ListQuotas returns the quotas of a workspace, the workspace's own first
+// and then its members' by email
+func (r *SQLiteRepository) ListQuotas(ctx context.Context, workspace string) ([]*Quota, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	quotas, err := func() ([]*Quota, error) {
+		rows, err := r.db.QueryContext(ctx, `SELECT `+quotaColumns+` FROM quotas WHERE workspace = ? ORDER BY member`, workspace)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		quotas := []*Quota{}
+		for rows.Next() {
+			quota, err := scanQuota(rows)
+			if err != nil {
+				return nil, err
+			}
+			quotas = append(quotas, quota)
+		}
+		return quotas, rows.Err()
+	}()
+	r.recordResult(ctx, "list_quotas", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list quotas: %w", err)
+	}
+	return quotas, nil
+}
+
+// GetQuota retrieves the quota of a workspace, or of one of its members if
+// member is set
+func (r *SQLiteRepository) GetQuota(ctx context.Context, workspace, member string) (*Quota, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	quota, err := scanQuota(r.db.QueryRowContext(ctx,
+		`SELECT `+quotaColumns+` FROM quotas WHERE workspace = ? AND member = ?`, workspace, member))
+	r.recordResult(ctx, "get_quota", start, err)
+	if err != nil {
+		return nil, wrapQuotaError(ctx, "get quota", workspace, member, err)
+	}
+	return quota, nil
+}
+
+// SaveQuota creates or replaces a quota. The workspace, and the member if
+// set, must exist; ErrNotFound is returned otherwise. quota.UpdatedAt is
+// set to the stored value.
+func (r *SQLiteRepository) SaveQuota(ctx context.Context, quota *Quota) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO quotas (`+quotaColumns+`)
+		SELECT ?, ?, ?, ?, ?, ?
+		WHERE (? = '' AND (? = '' OR EXISTS (SELECT 1 FROM workspaces WHERE slug = ?)))
+			OR EXISTS (SELECT 1 FROM workspace_members WHERE workspace = ? AND email = ?)
+		ON CONFLICT(workspace, member) DO UPDATE SET
+			links_per_day = excluded.links_per_day, links_per_month = excluded.links_per_month,
+			active_links = excluded.active_links, updated_at = excluded.updated_at
+		RETURNING updated_at`,
+		quota.Workspace, quota.Member, quota.LinksPerDay, quota.LinksPerMonth, quota.ActiveLinks, time.Now().UTC(),
+		quota.Member, quota.Workspace, quota.Workspace, quota.Workspace, quota.Member).Scan(&quota.UpdatedAt)
+	r.recordResult(ctx, "save_quota", start, err)
+	if err != nil {
+		return wrapQuotaError(ctx, "save quota", quota.Workspace, quota.Member, err)
+	}
+	return nil
+}
+
+// DeleteQuota removes the quota of a workspace, or of one of its members
+// if member is set
+func (r *SQLiteRepository) DeleteQuota(ctx context.Context, workspace, member string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx, `DELETE FROM quotas WHERE workspace = ? AND member = ?`, workspace, member)
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	if err == nil && deleted == 0 {
+		err = sql.ErrNoRows
+	}
+	r.recordResult(ctx, "delete_quota", start, err)
+	if err != nil {
+		return wrapQuotaError(ctx, "delete quota", workspace, member, err)
+	}
+	return nil
+}
+
+// GetQuotaUsage counts the links of a workspace, or those created by one
+// of its members if member is set
+func (r *SQLiteRepository) GetQuotaUsage(ctx context.Context, workspace, member string, periods UsagePeriods) (*QuotaUsage, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	scope, scopeArgs := `workspace = ?`, []any{workspace}
+	if member != "" {
+		scope, scopeArgs = scope+` AND created_by = ?`, append(scopeArgs, member)
+	}
+	args := append([]any{periods.Day.UTC(), periods.Month.UTC(), periods.Now.UTC()}, scopeArgs...)
+	args = append(append(args, scopeArgs...), periods.Month.UTC())
+	// Deleted links still count towards the day and month they were
+	// created in, but are no longer active
+	var usage QuotaUsage
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(created_at >= ?), 0), COALESCE(SUM(created_at >= ?), 0), COALESCE(SUM(active), 0) FROM (
+			SELECT created_at, (active_until IS NULL OR active_until > ?) AND (max_clicks IS NULL OR clicks < max_clicks) AS active
+			FROM urls WHERE `+scope+`
+			UNION ALL
+			SELECT created_at, 0 FROM deleted_links WHERE `+scope+` AND created_at >= ?
+		)`, args...).Scan(&usage.LinksToday, &usage.LinksThisMonth, &usage.ActiveLinks)
+	r.recordResult(ctx, "get_quota_usage", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to count links for quota: %w", err)
+	}
+	return &usage, nil
+}
+
+// scanQuota reads a row selected with quotaColumns
+func scanQuota(row rowScanner) (*Quota, error) {
+	var quota Quota
+	if err := row.Scan(&quota.Workspace, &quota.Member, &quota.LinksPerDay, &quota.LinksPerMonth,
+		&quota.ActiveLinks, &quota.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// wrapQuotaError turns a failed quota operation into an error naming the
+// quota, with sql.ErrNoRows becoming ErrNotFound
+func wrapQuotaError(ctx context.Context, op, workspace, member string, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("failed to %s: %w", op, ctx.Err())
+	case errors.Is(err, sql.ErrNoRows) && member != "":
+		return fmt.Errorf("%w for quota of member: %s", ErrNotFound, member)
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w for quota of workspace: %s", ErrNotFound, workspace)
+	default:
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+}
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateWorkspace(ctx, &Workspace{Slug: "marketing", Name: "Marketing"}))
+	require.NoError(t, repo.SaveMember(ctx, &Member{Workspace: "marketing", Email: "ann@example.com", Role: "member"}))
+
+	t.Run("quotas need their workspace and member", func(t *testing.T) {
+		quota := &Quota{Workspace: "marketing", LinksPerDay: 10}
+		require.NoError(t, repo.SaveQuota(ctx, quota))
+		assert.False(t, quota.UpdatedAt.IsZero())
+		require.NoError(t, repo.SaveQuota(ctx, &Quota{Workspace: "marketing", LinksPerDay: 20, ActiveLinks: 100}))
+		require.NoError(t, repo.SaveQuota(ctx, &Quota{Workspace: "marketing", Member: "ann@example.com", LinksPerMonth: 5}))
+		require.NoError(t, repo.SaveQuota(ctx, &Quota{LinksPerDay: 1000}))
+
+		assert.True(t, errors.Is(repo.SaveQuota(ctx, &Quota{Workspace: "missing", LinksPerDay: 1}), ErrNotFound))
+		assert.True(t, errors.Is(repo.SaveQuota(ctx, &Quota{Workspace: "marketing", Member: "bob@example.com", LinksPerDay: 1}), ErrNotFound))
+		assert.True(t, errors.Is(repo.SaveQuota(ctx, &Quota{Member: "ann@example.com", LinksPerDay: 1}), ErrNotFound))
+
+		quotas, err := repo.ListQuotas(ctx, "marketing")
+		require.NoError(t, err)
+		require.Len(t, quotas, 2)
+		assert.Equal(t, "", quotas[0].Member)
+		assert.Equal(t, int64(20), quotas[0].LinksPerDay)
+		assert.Equal(t, int64(100), quotas[0].ActiveLinks)
+		assert.Equal(t, "ann@example.com", quotas[1].Member)
+
+		got, err := repo.GetQuota(ctx, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1000), got.LinksPerDay)
+		_, err = repo.GetQuota(ctx, "marketing", "bob@example.com")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("usage counts the links of the workspace or member", func(t *testing.T) {
+		now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+		periods := UsagePeriods{
+			Day:   time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC),
+			Month: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			Now:   now,
+		}
+		ended := now.Add(-time.Hour)
+		for _, link := range []*Link{
+			{Workspace: "marketing", CreatedBy: "ann@example.com", Code: "today", CreatedAt: now.Add(-time.Hour)},
+			{Workspace: "marketing", Code: "month", CreatedAt: now.AddDate(0, 0, -3)},
+			{Workspace: "marketing", CreatedBy: "ann@example.com", Code: "ended", CreatedAt: now.AddDate(0, -1, 0), ActiveUntil: &ended},
+			{Workspace: "marketing", Code: "used", CreatedAt: now.AddDate(0, -1, 0), MaxClicks: 1, Clicks: 1},
+			{Workspace: "", Code: "other", CreatedAt: now},
+		} {
+			link.OriginalURL = "https://example.com/" + link.Code
+			require.NoError(t, repo.StoreURL(ctx, link))
+		}
+
+		usage, err := repo.GetQuotaUsage(ctx, "marketing", "", periods)
+		require.NoError(t, err)
+		assert.Equal(t, &QuotaUsage{LinksToday: 1, LinksThisMonth: 2, ActiveLinks: 2}, usage)
+
+		usage, err = repo.GetQuotaUsage(ctx, "marketing", "ann@example.com", periods)
+		require.NoError(t, err)
+		assert.Equal(t, &QuotaUsage{LinksToday: 1, LinksThisMonth: 1, ActiveLinks: 1}, usage)
+
+		link, err := repo.GetLink(ctx, "", "today")
+		require.NoError(t, err)
+		assert.Equal(t, "ann@example.com", link.CreatedBy)
+	})
+
+	t.Run("quotas go with their member", func(t *testing.T) {
+		require.NoError(t, repo.RemoveMember(ctx, "marketing", "ann@example.com"))
+		_, err := repo.GetQuota(ctx, "marketing", "ann@example.com")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("delete quota", func(t *testing.T) {
+		require.NoError(t, repo.DeleteQuota(ctx, "", ""))
+		assert.True(t, errors.Is(repo.DeleteQuota(ctx, "", ""), ErrNotFound))
+	})
+}
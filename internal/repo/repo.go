@@ -1,44 +1,187 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/urlshortener/internal/metrics"
 )
 
-// URLRepository defines the interface for URL storage operations
+var (
+	// ErrNotFound is returned when no URL exists for a code
+	ErrNotFound = errors.New("URL not found")
+	// ErrConflict is returned when a code is already in use
+	ErrConflict = errors.New("code already exists")
+	// ErrLimitReached is returned when a link has used up its clicks
+	ErrLimitReached = errors.New("click limit reached")
+)
+
+// URLRepository defines the interface for URL storage operations. Codes
+// are unique per domain; the empty domain is the default one. Links belong
+// to a workspace, the empty one being the default. Lookups by domain and
+// code serve visitors and are not scoped, but every other query only sees
+// the links of the given workspace.
 type URLRepository interface {
-	StoreURL(originalURL, code string) error
-	GetOriginalURL(code string) (string, error)
+	StoreURL(ctx context.Context, link *Link) error
+	StoreURLs(ctx context.Context, links []*Link) ([]error, error)
+	FindOrStoreURL(ctx context.Context, link *Link) (bool, error)
+	GetOriginalURL(ctx context.Context, domain, code string) (string, error)
+	GetLink(ctx context.Context, domain, code string) (*Link, error)
+	RecordClick(ctx context.Context, domain, code string, click Click) (string, error)
+	GetClickStats(ctx context.Context, workspace, domain, code string) (*ClickStats, error)
+	SetVariantWeights(ctx context.Context, workspace, domain, code string, weights map[string]int) ([]Variant, error)
+	ListLinks(ctx context.Context, filter LinkFilter) ([]*Link, error)
+	UpdateLink(ctx context.Context, workspace, domain, code string, update LinkUpdate) (*Link, error)
+	DeleteLink(ctx context.Context, workspace, domain, code string) (*Link, error)
+	ExportLinks(ctx context.Context, workspace string, fn func(*Link) error) error
+	ImportLinks(ctx context.Context, links []*Link, opts ImportOptions) ([]ImportResult, error)
 	Close() error
 }
 
+// Link is a shortened URL as stored in the urls table
+type Link struct {
+	ID            int64
+	Code          string
+	OriginalURL   string
+	Tags          []string
+	CreatedAt     time.Time
+	Clicks        int64
+	LastClickedAt *time.Time
+	// URLHash identifies the canonical form of OriginalURL; empty if unknown
+	URLHash string
+	// Folder names the folder the link is in; empty if none
+	Folder string
+	// Domain is the host of the branded domain the link is on; empty
+	// means the default domain
+	Domain string
+	// Workspace is the slug of the workspace owning the link; empty means
+	// the default workspace
+	Workspace string
+	// CreatedBy is the email of the member whose API key created the link;
+	// empty for links created otherwise
+	CreatedBy string
+	// RedirectStatus is the HTTP status used to redirect; zero means the
+	// server default
+	RedirectStatus int
+	// PassQuery forwards the visitor's query string to the destination
+	PassQuery bool
+	// PassPath appends any path after the code to the destination path
+	PassPath bool
+	// PasswordHash is the bcrypt hash of the password visitors must enter;
+	// empty means the link is open
+	PasswordHash string
+	// MaxClicks is how many visits the link allows; zero means unlimited
+	MaxClicks int
+	// ActiveFrom and ActiveUntil bound when the link redirects to
+	// OriginalURL; nil leaves that side open
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	// FallbackURL is where visitors outside the active window are sent;
+	// empty means they get an error page
+	FallbackURL string
+	// Interstitial shows visitors a preview of the destination before
+	// every redirect
+	Interstitial bool
+	// Social is what crawlers building link previews are shown instead of
+	// the redirect; the zero value lets them follow it
+	Social SocialCard
+	// DeviceRules send visitors elsewhere depending on their operating
+	// system and device type. The first matching rule wins; visitors
+	// matching none go to OriginalURL.
+	DeviceRules []DeviceRule
+	// GeoRules send visitors elsewhere depending on where they are. They
+	// are checked after DeviceRules, for visitors matching none of those.
+	GeoRules []GeoRule
+	// Variants split the visitors matching no rule between several
+	// destinations by weight; empty sends them all to OriginalURL
+	Variants []Variant
+}
+
+// DeviceRule redirects visitors on the listed operating systems and device
+// types. An empty list matches any. URL may be a deep link into an app, in
+// which case FallbackURL is where visitors without the app go.
+type DeviceRule struct {
+	OS          []string `json:"os,omitempty"`
+	Device      []string `json:"device,omitempty"`
+	URL         string   `json:"url"`
+	FallbackURL string   `json:"fallback_url,omitempty"`
+}
+
+// GeoRule redirects visitors in the listed countries or continents to URL.
+// Countries are ISO 3166-1 alpha-2 codes and continents two-letter codes
+// such as "EU"; a visitor matching either list matches the rule.
+type GeoRule struct {
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	URL        string   `json:"url"`
+}
+
+// SocialCard holds the Open Graph and Twitter Card details of a link
+type SocialCard struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// IsZero reports whether no detail of the card is set
+func (c SocialCard) IsZero() bool {
+	return c == SocialCard{}
+}
+
+// Timeouts bounds how long individual database operations may run.
+// A zero duration leaves the operation bound only by the caller's context.
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
 // SQLiteRepository implements URLRepository using SQLite
 type SQLiteRepository struct {
-	db      *sql.DB
-	metrics *metrics.Metrics
+	db       *sql.DB
+	metrics  *metrics.Metrics
+	timeouts Timeouts
 }
 
 // NewSQLiteRepository creates a new SQLite repository
-func NewSQLiteRepository(dbPath string, metrics *metrics.Metrics) (*SQLiteRepository, error) {
+func NewSQLiteRepository(dbPath string, metrics *metrics.Metrics, timeouts Timeouts) (*SQLiteRepository, error) {
 	db, err := OpenDatabase(dbPath)
 	if err != nil {
 		return nil, err
 	}
 
 	return &SQLiteRepository{
-		db:      db,
-		metrics: metrics,
+		db:       db,
+		metrics:  metrics,
+		timeouts: timeouts,
 	}, nil
 }
 
 // OpenDatabase opens a connection to the SQLite database
 func OpenDatabase(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Foreign keys are off by default in SQLite and must be enabled per
+	// connection for ON DELETE CASCADE to apply. Writers from different
+	// connections wait for each other's locks, for up to busy_timeout
+	// milliseconds, instead of failing at once with "database is locked".
+	dsn := dbPath
+	for _, param := range []string{"_foreign_keys=on", "_busy_timeout=5000"} {
+		name, _, _ := strings.Cut(param, "=")
+		if strings.Contains(dsn, name) {
+			continue
+		}
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + param
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -56,35 +199,66 @@ func OpenDatabase(dbPath string) (*sql.DB, error) {
 	return db, nil
 }
 
-// StoreURL stores a URL with its generated code
-func (r *SQLiteRepository) StoreURL(originalURL, code string) error {
+// StoreURL stores a link and its tags. On success link.ID and
+// link.CreatedAt are populated.
+func (r *SQLiteRepository) StoreURL(ctx context.Context, link *Link) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
 	start := time.Now()
-	query := `INSERT INTO urls (original_url, code, created_at) VALUES (?, ?, ?)`
-	_, err := r.db.Exec(query, originalURL, code, time.Now().UTC())
-	
-	// Record metrics
-	duration := time.Since(start).Seconds()
-	if err != nil {
-		r.metrics.RecordDBOperation("store_url", "error", duration)
-		return fmt.Errorf("failed to store URL: %w", err)
-	}
-	r.metrics.RecordDBOperation("store_url", "success", duration)
-	return nil
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		return insertLink(ctx, tx, link)
+	})
+	r.recordResult(ctx, "store_url", start, err)
+	return wrapStoreError(ctx, link, err)
+}
+
+// FindOrStoreURL returns the oldest link in the same workspace and on the
+// same domain with the same URLHash and redirect behaviour as link, storing
+// link only if there is none. It reports whether an existing link was found, in which case link
+// is overwritten with it.
+func (r *SQLiteRepository) FindOrStoreURL(ctx context.Context, link *Link) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	found := false
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		existing, err := findByURLHash(ctx, tx, link)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			*link = *existing
+			found = true
+			return nil
+		}
+		return insertLink(ctx, tx, link)
+	})
+	r.recordResult(ctx, "find_or_store_url", start, err)
+	return found, wrapStoreError(ctx, link, err)
 }
 
 // GetOriginalURL retrieves the original URL for a given code
-func (r *SQLiteRepository) GetOriginalURL(code string) (string, error) {
+func (r *SQLiteRepository) GetOriginalURL(ctx context.Context, domain, code string) (string, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
 	start := time.Now()
-	query := `SELECT original_url FROM urls WHERE code = ?`
+	query := `SELECT original_url FROM urls WHERE domain = ? AND code = ?`
 	var originalURL string
-	err := r.db.QueryRow(query, code).Scan(&originalURL)
+	err := r.db.QueryRowContext(ctx, query, domain, code).Scan(&originalURL)
 	
 	// Record metrics
 	duration := time.Since(start).Seconds()
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			r.metrics.RecordDBOperation("get_url", contextStatus(ctxErr), duration)
+			return "", fmt.Errorf("failed to get URL: %w", ctxErr)
+		}
 		if errors.Is(err, sql.ErrNoRows) {
 			r.metrics.RecordDBOperation("get_url", "not_found", duration)
-			return "", fmt.Errorf("URL not found for code: %s", code)
+			return "", fmt.Errorf("%w for code: %s", ErrNotFound, code)
 		}
 		r.metrics.RecordDBOperation("get_url", "error", duration)
 		return "", fmt.Errorf("failed to get URL: %w", err)
@@ -93,7 +267,377 @@ func (r *SQLiteRepository) GetOriginalURL(code string) (string, error) {
 	return originalURL, nil
 }
 
+// GetLink retrieves the link, with its tags and variants, for a given code
+func (r *SQLiteRepository) GetLink(ctx context.Context, domain, code string) (*Link, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	link, err := scanLink(r.db.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM urls WHERE domain = ? AND code = ?`, domain, code))
+	if err == nil {
+		err = loadTags(ctx, r.db, link)
+	}
+	if err == nil {
+		err = loadVariants(ctx, r.db, link)
+	}
+	r.recordResult(ctx, "get_link", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to get link: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for code: %s", ErrNotFound, code)
+		}
+		return nil, fmt.Errorf("failed to get link: %w", err)
+	}
+	return link, nil
+}
+
+// RecordClick counts a visit to the link with the given code. A link with
+// a click limit is only counted while clicks < max_clicks, in a single
+// statement, so concurrent visits can never take it past its limit;
+// ErrLimitReached is returned once it is used up. The visitor's country,
+// if known, and the variant they were sent to are counted in the same
+// transaction. The workspace of the link is returned.
+func (r *SQLiteRepository) RecordClick(ctx context.Context, domain, code string, click Click) (string, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	var workspace string
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var id int64
+		err := tx.QueryRowContext(ctx,
+			`UPDATE urls SET clicks = clicks + 1, last_clicked_at = ?
+			WHERE domain = ? AND code = ? AND (max_clicks IS NULL OR clicks < max_clicks) RETURNING id, workspace`,
+			time.Now().UTC(), domain, code).Scan(&id, &workspace)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Either the link is exhausted or it does not exist
+			var exists int
+			err = tx.QueryRowContext(ctx, `SELECT 1 FROM urls WHERE domain = ? AND code = ?`, domain, code).Scan(&exists)
+			if err == nil {
+				return fmt.Errorf("%w for code: %s", ErrLimitReached, code)
+			} else if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w for code: %s", ErrNotFound, code)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if click.Country != "" {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO click_countries (url_id, country, clicks) VALUES (?, ?, 1)
+				ON CONFLICT(url_id, country) DO UPDATE SET clicks = clicks + 1`,
+				id, click.Country); err != nil {
+				return err
+			}
+		}
+		if click.Variant != "" {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE link_variants SET clicks = clicks + 1 WHERE url_id = ? AND name = ?`,
+				id, click.Variant); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	r.recordResult(ctx, "record_click", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("failed to record click: %w", ctx.Err())
+		}
+		if errors.Is(err, ErrLimitReached) || errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to record click: %w", err)
+	}
+	return workspace, nil
+}
+
 // Close closes the database connection
 func (r *SQLiteRepository) Close() error {
 	return r.db.Close()
-}
\ No newline at end of file
+}
+
+// inTx runs fn inside a transaction, committing if it returns nil
+func (r *SQLiteRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertLink inserts a link row and attaches its tags, folder and variants.
+// CreatedAt defaults to now; a non-zero value is kept so that imports
+// preserve history.
+func insertLink(ctx context.Context, tx *sql.Tx, link *Link) error {
+	createdAt := link.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO urls (workspace, created_by, domain, original_url, code, url_hash, created_at, clicks, last_clicked_at, redirect_status,
+			pass_query, pass_path, password_hash, max_clicks, active_from, active_until, fallback_url,
+			interstitial, social_title, social_description, social_image_url, device_rules, geo_rules)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		link.Workspace, link.CreatedBy, link.Domain, link.OriginalURL, link.Code, nullIfEmpty(link.URLHash), createdAt, link.Clicks, link.LastClickedAt,
+		nullIfZero(link.RedirectStatus), link.PassQuery, link.PassPath, nullIfEmpty(link.PasswordHash),
+		nullIfZero(link.MaxClicks), utcOrNil(link.ActiveFrom), utcOrNil(link.ActiveUntil), nullIfEmpty(link.FallbackURL),
+		link.Interstitial, nullIfEmpty(link.Social.Title), nullIfEmpty(link.Social.Description),
+		nullIfEmpty(link.Social.ImageURL), rulesOrNil(link.DeviceRules), rulesOrNil(link.GeoRules))
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := attachTags(ctx, tx, link.Workspace, id, link.Tags); err != nil {
+		return err
+	}
+	if err := attachFolder(ctx, tx, link.Workspace, id, link.Folder); err != nil {
+		return err
+	}
+	if err := attachVariants(ctx, tx, id, link.Variants); err != nil {
+		return err
+	}
+
+	link.ID = id
+	link.CreatedAt = createdAt
+	return nil
+}
+
+// findByURLHash returns the oldest plain link in the workspace and on the
+// domain of like with the same hash, or nil. The link must also redirect
+// like like does: with the same status, passing the query and path on the
+// same way and with or without an interstitial.
+// Password-protected, click-limited and scheduled links are never shared
+// with other callers.
+func findByURLHash(ctx context.Context, tx *sql.Tx, like *Link) (*Link, error) {
+	if like.URLHash == "" {
+		return nil, nil
+	}
+	link, err := scanLink(tx.QueryRowContext(ctx,
+		`SELECT `+linkColumns+` FROM urls WHERE workspace = ? AND domain = ? AND url_hash = ? AND password_hash IS NULL AND max_clicks IS NULL
+			AND active_from IS NULL AND active_until IS NULL
+			AND social_title IS NULL AND social_description IS NULL AND social_image_url IS NULL
+			AND device_rules IS NULL AND geo_rules IS NULL
+			AND NOT EXISTS (SELECT 1 FROM link_variants v WHERE v.url_id = urls.id)
+			AND COALESCE(redirect_status, 0) = ? AND pass_query = ? AND pass_path = ? AND interstitial = ?
+		ORDER BY id LIMIT 1`, like.Workspace, like.Domain, like.URLHash,
+		like.RedirectStatus, like.PassQuery, like.PassPath, like.Interstitial))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := loadTags(ctx, tx, link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// linkColumns are the urls columns read by scanLink, in order, followed by
+// the name of the link's folder
+const linkColumns = `id, workspace, created_by, domain, code, original_url, url_hash, created_at, clicks, last_clicked_at, redirect_status,
+	pass_query, pass_path, password_hash, max_clicks, active_from, active_until, fallback_url, interstitial,
+	social_title, social_description, social_image_url, device_rules, geo_rules,
+	(SELECT f.name FROM url_folders uf JOIN folders f ON f.id = uf.folder_id WHERE uf.url_id = urls.id)`
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// scanLink reads a row selected with linkColumns
+func scanLink(row rowScanner) (*Link, error) {
+	var link Link
+	var urlHash, passwordHash, fallbackURL, socialTitle, socialDescription, socialImageURL, deviceRules, geoRules, folder sql.NullString
+	var lastClicked, activeFrom, activeUntil sql.NullTime
+	var redirectStatus, maxClicks sql.NullInt64
+	if err := row.Scan(&link.ID, &link.Workspace, &link.CreatedBy, &link.Domain, &link.Code, &link.OriginalURL, &urlHash, &link.CreatedAt,
+		&link.Clicks, &lastClicked, &redirectStatus, &link.PassQuery, &link.PassPath, &passwordHash, &maxClicks,
+		&activeFrom, &activeUntil, &fallbackURL, &link.Interstitial,
+		&socialTitle, &socialDescription, &socialImageURL, &deviceRules, &geoRules, &folder); err != nil {
+		return nil, err
+	}
+	if err := scanRules(deviceRules, link.Code, &link.DeviceRules); err != nil {
+		return nil, err
+	}
+	if err := scanRules(geoRules, link.Code, &link.GeoRules); err != nil {
+		return nil, err
+	}
+	link.URLHash = urlHash.String
+	link.Folder = folder.String
+	if lastClicked.Valid {
+		link.LastClickedAt = &lastClicked.Time
+	}
+	link.RedirectStatus = int(redirectStatus.Int64)
+	link.PasswordHash = passwordHash.String
+	link.MaxClicks = int(maxClicks.Int64)
+	if activeFrom.Valid {
+		link.ActiveFrom = &activeFrom.Time
+	}
+	if activeUntil.Valid {
+		link.ActiveUntil = &activeUntil.Time
+	}
+	link.FallbackURL = fallbackURL.String
+	link.Social = SocialCard{
+		Title:       socialTitle.String,
+		Description: socialDescription.String,
+		ImageURL:    socialImageURL.String,
+	}
+	return &link, nil
+}
+
+// loadTags fills in link.Tags, sorted by name
+func loadTags(ctx context.Context, q queryer, link *Link) error {
+	rows, err := q.QueryContext(ctx,
+		`SELECT t.name FROM url_tags ut JOIN tags t ON t.id = ut.tag_id WHERE ut.url_id = ? ORDER BY t.name`, link.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return err
+		}
+		link.Tags = append(link.Tags, tag)
+	}
+	return rows.Err()
+}
+
+// attachTags links the named tags of workspace to a URL, creating missing
+// tags
+func attachTags(ctx context.Context, tx *sql.Tx, workspace string, urlID int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO tags (workspace, name) VALUES (?, ?) ON CONFLICT(workspace, name) DO NOTHING`,
+			workspace, tag); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO url_tags (url_id, tag_id) SELECT ?, id FROM tags WHERE workspace = ? AND name = ?`,
+			urlID, workspace, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrapStoreError converts a failed insert into the error returned to callers
+func wrapStoreError(ctx context.Context, link *Link, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("failed to store URL: %w", ctx.Err())
+	case isUniqueViolation(err):
+		return fmt.Errorf("%w: %s", ErrConflict, link.Code)
+	default:
+		return fmt.Errorf("failed to store URL: %w", err)
+	}
+}
+
+// recordResult records metrics for an operation that finished with err,
+// distinguishing context expiry, missing rows and conflicts from failures
+func (r *SQLiteRepository) recordResult(ctx context.Context, operation string, start time.Time, err error) {
+	status := "success"
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		status = contextStatus(ctx.Err())
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, ErrNotFound):
+		status = "not_found"
+	case isUniqueViolation(err), errors.Is(err, ErrConflict):
+		status = "conflict"
+	case errors.Is(err, ErrLimitReached):
+		status = "limit_reached"
+	default:
+		status = "error"
+	}
+	r.metrics.RecordDBOperation(operation, status, time.Since(start).Seconds())
+}
+
+// withTimeout derives a context bounded by d, or a plain cancellable
+// context when no timeout is configured
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// contextStatus returns the metrics status for an operation whose context
+// ended. Timeouts and cancellations are reported separately from genuine
+// errors so that client disconnects do not trip database error alerts.
+func contextStatus(ctxErr error) string {
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "cancelled"
+}
+
+// nullIfEmpty stores an empty string as NULL
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullIfZero stores zero as NULL
+func nullIfZero(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+// utcOrNil stores an optional timestamp in UTC, like created_at
+func utcOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// rulesOrNil stores device or geo rules as a JSON array, or NULL if there
+// are none
+func rulesOrNil[T DeviceRule | GeoRule](rules []T) interface{} {
+	if len(rules) == 0 {
+		return nil
+	}
+	// Marshalling plain strings cannot fail
+	data, _ := json.Marshal(rules)
+	return string(data)
+}
+
+// scanRules decodes device or geo rules stored by rulesOrNil, or variants
+// exported as JSON
+func scanRules[T DeviceRule | GeoRule | Variant](column sql.NullString, code string, rules *[]T) error {
+	if !column.Valid {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(column.String), rules); err != nil {
+		return fmt.Errorf("invalid rules for code %s: %w", code, err)
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is a SQLite UNIQUE constraint failure
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
@@ -1,8 +1,14 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -11,20 +17,15 @@ import (
 )
 
 func setupTestRepo(t *testing.T) *SQLiteRepository {
-	db, err := sql.Open("sqlite3", ":memory:")
-	assert.NoError(t, err)
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
+	require.NoError(t, err)
 
-	// Create the urls table
-	createTableSQL := `CREATE TABLE IF NOT EXISTS urls (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		original_url TEXT NOT NULL,
-		code TEXT NOT NULL UNIQUE,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		clicks INTEGER NOT NULL DEFAULT 0,
-		last_clicked_at TIMESTAMP
-	);`
-	_, err = db.Exec(createTableSQL)
-	assert.NoError(t, err)
+	// Every connection to :memory: is a separate database, so pin the pool
+	// to one connection that lives as long as the test
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	applyMigrations(t, db)
 
 	repo := &SQLiteRepository{
 		db:      db,
@@ -33,52 +34,347 @@ func setupTestRepo(t *testing.T) *SQLiteRepository {
 	return repo
 }
 
+// applyMigrations applies the real migrations so tests run against the
+// production schema
+func applyMigrations(t *testing.T, db *sql.DB) {
+	files, err := filepath.Glob("../../migrations/*.up.sql")
+	require.NoError(t, err)
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		require.NoError(t, err)
+		_, err = db.Exec(string(migration))
+		require.NoError(t, err, file)
+	}
+}
+
 func TestStoreURL(t *testing.T) {
 	repo := setupTestRepo(t)
 	defer repo.Close()
+	ctx := context.Background()
 
 	t.Run("successful store", func(t *testing.T) {
-		err := repo.StoreURL("http://example.com", "abc123")
+		err := repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "abc123"})
 		assert.NoError(t, err)
 
 		// Verify the URL was stored by retrieving it
-		url, err := repo.GetOriginalURL("abc123")
+		url, err := repo.GetOriginalURL(ctx, "", "abc123")
 		assert.NoError(t, err)
 		assert.Equal(t, "http://example.com", url)
 	})
 
 	t.Run("duplicate code error", func(t *testing.T) {
 		// First store should succeed
-		err := repo.StoreURL("http://example1.com", "duplicate")
+		err := repo.StoreURL(ctx, &Link{OriginalURL: "http://example1.com", Code: "duplicate"})
 		assert.NoError(t, err)
 
 		// Second store with same code should fail
-		err = repo.StoreURL("http://example2.com", "duplicate")
+		err = repo.StoreURL(ctx, &Link{OriginalURL: "http://example2.com", Code: "duplicate"})
 		assert.Error(t, err)
+		assert.True(t, errors.Is(err, ErrConflict))
 	})
 }
 
 func TestGetOriginalURL(t *testing.T) {
 	repo := setupTestRepo(t)
 	defer repo.Close()
+	ctx := context.Background()
 
 	t.Run("successful retrieval", func(t *testing.T) {
 		// Store test data first
-		err := repo.StoreURL("http://example.com", "test123")
+		err := repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "test123"})
 		require.NoError(t, err)
 
-		url, err := repo.GetOriginalURL("test123")
+		url, err := repo.GetOriginalURL(ctx, "", "test123")
 		assert.NoError(t, err)
 		assert.Equal(t, "http://example.com", url)
 	})
 
 	t.Run("URL not found", func(t *testing.T) {
-		url, err := repo.GetOriginalURL("notfound")
+		url, err := repo.GetOriginalURL(ctx, "", "notfound")
 		assert.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotFound))
 		assert.Empty(t, url)
 	})
 }
 
+func TestContextCancellation(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+
+	t.Run("cancelled context aborts query", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := repo.GetOriginalURL(ctx, "", "abc123")
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.False(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("expired deadline aborts write", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		err := repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "late"})
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("status labels", func(t *testing.T) {
+		assert.Equal(t, "timeout", contextStatus(context.DeadlineExceeded))
+		assert.Equal(t, "cancelled", contextStatus(context.Canceled))
+	})
+}
+
+func TestStoreURLWithTags(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "http://example.com", Code: "tagged", Tags: []string{"email", "spring"}}
+	require.NoError(t, repo.StoreURL(ctx, link))
+	assert.NotZero(t, link.ID)
+	assert.False(t, link.CreatedAt.IsZero())
+
+	// A second link reuses the existing tag rows
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.org", Code: "tagged2", Tags: []string{"email"}}))
+
+	var tagCount, linkTagCount int
+	require.NoError(t, repo.db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&tagCount))
+	require.NoError(t, repo.db.QueryRow(`SELECT COUNT(*) FROM url_tags`).Scan(&linkTagCount))
+	assert.Equal(t, 2, tagCount)
+	assert.Equal(t, 3, linkTagCount)
+}
+
+func TestFindOrStoreURL(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://legacy.com", Code: "legacy"}))
+	first := &Link{OriginalURL: "http://example.com", Code: "first", URLHash: "h1", Tags: []string{"email"}}
+	require.NoError(t, repo.StoreURL(ctx, first))
+
+	t.Run("returns oldest link with the same hash", func(t *testing.T) {
+		link := &Link{OriginalURL: "http://EXAMPLE.com", Code: "second", URLHash: "h1"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "first", link.Code)
+		assert.Equal(t, "http://example.com", link.OriginalURL)
+		assert.Equal(t, []string{"email"}, link.Tags)
+		assert.Equal(t, first.ID, link.ID)
+
+		_, err = repo.GetOriginalURL(ctx, "", "second")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("stores link with a new hash", func(t *testing.T) {
+		link := &Link{OriginalURL: "http://other.com", Code: "other", URLHash: "h2"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.NotZero(t, link.ID)
+	})
+
+	t.Run("links without a hash never match", func(t *testing.T) {
+		link := &Link{OriginalURL: "http://legacy.com", Code: "legacy2"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("password-protected links never match", func(t *testing.T) {
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://secret.com", Code: "secret", URLHash: "h4", PasswordHash: "$2a$10$x"}))
+
+		link := &Link{OriginalURL: "http://secret.com", Code: "open", URLHash: "h4"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.Equal(t, "open", link.Code)
+
+		stored, err := repo.GetLink(ctx, "", "secret")
+		require.NoError(t, err)
+		assert.Equal(t, "$2a$10$x", stored.PasswordHash)
+	})
+
+	t.Run("scheduled links never match", func(t *testing.T) {
+		until := time.Now().Add(time.Hour)
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://sale.com", Code: "sale", URLHash: "h5", ActiveUntil: &until}))
+
+		link := &Link{OriginalURL: "http://sale.com", Code: "always", URLHash: "h5"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.Equal(t, "always", link.Code)
+	})
+
+	t.Run("links with a social card never match", func(t *testing.T) {
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://card.com", Code: "card", URLHash: "h6",
+			Social: SocialCard{ImageURL: "http://card.com/og.png"}}))
+
+		link := &Link{OriginalURL: "http://card.com", Code: "nocard", URLHash: "h6"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("links with device rules never match", func(t *testing.T) {
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://app.com", Code: "app", URLHash: "h7",
+			DeviceRules: []DeviceRule{{OS: []string{"ios"}, URL: "http://apps.apple.com/app"}}}))
+
+		link := &Link{OriginalURL: "http://app.com", Code: "noapp", URLHash: "h7"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("links with geo rules never match", func(t *testing.T) {
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://shop.com", Code: "shop", URLHash: "h8",
+			GeoRules: []GeoRule{{Countries: []string{"DE"}, URL: "http://shop.de"}}}))
+
+		link := &Link{OriginalURL: "http://shop.com", Code: "noshop", URLHash: "h8"}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("links redirecting differently never match", func(t *testing.T) {
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://plain.com", Code: "plain", URLHash: "h9"}))
+
+		for _, link := range []*Link{
+			{OriginalURL: "http://plain.com", Code: "moved", URLHash: "h9", RedirectStatus: 301},
+			{OriginalURL: "http://plain.com", Code: "query", URLHash: "h9", PassQuery: true},
+			{OriginalURL: "http://plain.com", Code: "path", URLHash: "h9", PassPath: true},
+			{OriginalURL: "http://plain.com", Code: "preview", URLHash: "h9", Interstitial: true},
+		} {
+			code := link.Code
+			found, err := repo.FindOrStoreURL(ctx, link)
+			require.NoError(t, err)
+			assert.False(t, found, code)
+			assert.Equal(t, code, link.Code)
+		}
+
+		// Each of those is found again by a link redirecting the same way
+		link := &Link{OriginalURL: "http://plain.com", Code: "moved2", URLHash: "h9", RedirectStatus: 301}
+		found, err := repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "moved", link.Code)
+		assert.Equal(t, 301, link.RedirectStatus)
+
+		link = &Link{OriginalURL: "http://plain.com", Code: "plain2", URLHash: "h9"}
+		found, err = repo.FindOrStoreURL(ctx, link)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "plain", link.Code)
+	})
+
+	t.Run("code conflict", func(t *testing.T) {
+		_, err := repo.FindOrStoreURL(ctx, &Link{OriginalURL: "http://new.com", Code: "first", URLHash: "h3"})
+		assert.True(t, errors.Is(err, ErrConflict))
+	})
+}
+
+func TestGetLink(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{
+		OriginalURL:    "http://example.com",
+		Code:           "perm",
+		URLHash:        "h1",
+		Tags:           []string{"spring", "email"},
+		RedirectStatus: 308,
+		PassQuery:      true,
+	}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.org", Code: "plain"}))
+	launch := time.Date(2030, 1, 1, 9, 0, 0, 0, time.FixedZone("CET", 3600))
+	require.NoError(t, repo.StoreURL(ctx, &Link{
+		OriginalURL: "http://example.net",
+		Code:        "launch",
+		ActiveFrom:  &launch,
+		FallbackURL: "http://example.net/soon",
+		Social:      SocialCard{Title: "Launch", Description: "Coming in 2030"},
+		DeviceRules: []DeviceRule{
+			{OS: []string{"ios"}, URL: "myapp://launch", FallbackURL: "http://apps.apple.com/app"},
+			{Device: []string{"mobile", "tablet"}, URL: "http://m.example.net"},
+		},
+		GeoRules: []GeoRule{{Countries: []string{"FR", "BE"}, Continents: []string{"AF"}, URL: "http://example.fr"}},
+	}))
+
+	link, err := repo.GetLink(ctx, "", "perm")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com", link.OriginalURL)
+	assert.Equal(t, "h1", link.URLHash)
+	assert.Equal(t, 308, link.RedirectStatus)
+	assert.True(t, link.PassQuery)
+	assert.False(t, link.PassPath)
+	assert.Equal(t, []string{"email", "spring"}, link.Tags)
+
+	link, err = repo.GetLink(ctx, "", "plain")
+	require.NoError(t, err)
+	assert.Zero(t, link.RedirectStatus)
+	assert.Empty(t, link.URLHash)
+	assert.Nil(t, link.Tags)
+	assert.Nil(t, link.ActiveFrom)
+	assert.Nil(t, link.ActiveUntil)
+	assert.Empty(t, link.FallbackURL)
+	assert.True(t, link.Social.IsZero())
+	assert.Nil(t, link.DeviceRules)
+	assert.Nil(t, link.GeoRules)
+
+	link, err = repo.GetLink(ctx, "", "launch")
+	require.NoError(t, err)
+	require.NotNil(t, link.ActiveFrom)
+	assert.True(t, link.ActiveFrom.Equal(launch))
+	assert.Nil(t, link.ActiveUntil)
+	assert.Equal(t, "http://example.net/soon", link.FallbackURL)
+	assert.Equal(t, SocialCard{Title: "Launch", Description: "Coming in 2030"}, link.Social)
+	assert.Equal(t, []DeviceRule{
+		{OS: []string{"ios"}, URL: "myapp://launch", FallbackURL: "http://apps.apple.com/app"},
+		{Device: []string{"mobile", "tablet"}, URL: "http://m.example.net"},
+	}, link.DeviceRules)
+	assert.Equal(t, []GeoRule{{Countries: []string{"FR", "BE"}, Continents: []string{"AF"}, URL: "http://example.fr"}}, link.GeoRules)
+
+	_, err = repo.GetLink(ctx, "", "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestStoreURLs(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://taken.com", Code: "taken"}))
+
+	links := []*Link{
+		{OriginalURL: "http://one.com", Code: "one", Tags: []string{"batch"}},
+		{OriginalURL: "http://two.com", Code: "taken", Tags: []string{"batch"}},
+		{OriginalURL: "http://three.com", Code: "three"},
+	}
+	itemErrs, err := repo.StoreURLs(ctx, links)
+	require.NoError(t, err)
+	require.Len(t, itemErrs, 3)
+
+	assert.NoError(t, itemErrs[0])
+	assert.True(t, errors.Is(itemErrs[1], ErrConflict))
+	assert.NoError(t, itemErrs[2])
+
+	url, err := repo.GetOriginalURL(ctx, "", "three")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://three.com", url)
+
+	// The conflicting item was rolled back without touching the original
+	url, err = repo.GetOriginalURL(ctx, "", "taken")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://taken.com", url)
+
+	var linkTagCount int
+	require.NoError(t, repo.db.QueryRow(`SELECT COUNT(*) FROM url_tags`).Scan(&linkTagCount))
+	assert.Equal(t, 1, linkTagCount)
+}
+
 // Note: IncrementClickCount is not part of the current URLRepository interface
 
 // Note: CodeExists is not part of the current URLRepository interface
@@ -89,26 +385,27 @@ func TestGetOriginalURL(t *testing.T) {
 func TestSQLiteRepositoryIntegration(t *testing.T) {
 	repo := setupTestRepo(t)
 	defer repo.Close()
+	ctx := context.Background()
 
 	// Test the full workflow
 	code := "integration123"
 	originalURL := "http://integration-test.com"
 
 	// 1. Store URL
-	err := repo.StoreURL(originalURL, code)
+	err := repo.StoreURL(ctx, &Link{OriginalURL: originalURL, Code: code})
 	assert.NoError(t, err)
 
 	// 2. Retrieve URL
-	retrievedURL, err := repo.GetOriginalURL(code)
+	retrievedURL, err := repo.GetOriginalURL(ctx, "", code)
 	assert.NoError(t, err)
 	assert.Equal(t, originalURL, retrievedURL)
 
 	// 3. Try to store duplicate code (should fail)
-	err = repo.StoreURL("http://another-url.com", code)
+	err = repo.StoreURL(ctx, &Link{OriginalURL: "http://another-url.com", Code: code})
 	assert.Error(t, err)
 
 	// 4. Verify original URL is still there
-	retrievedURL, err = repo.GetOriginalURL(code)
+	retrievedURL, err = repo.GetOriginalURL(ctx, "", code)
 	assert.NoError(t, err)
 	assert.Equal(t, originalURL, retrievedURL)
 }
\ No newline at end of file
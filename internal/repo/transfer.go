@@ -0,0 +1,268 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what an import does with a code that already exists
+// on the link's domain
+type ConflictPolicy string
+
+const (
+	// ConflictSkip keeps the existing link and ignores the imported one
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing link with the imported one
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename stores the imported link under a newly generated code
+	ConflictRename ConflictPolicy = "rename"
+)
+
+// ImportOutcome describes what happened to a single imported link
+type ImportOutcome string
+
+// Import outcomes reported per link
+const (
+	ImportCreated     ImportOutcome = "created"
+	ImportSkipped     ImportOutcome = "skipped"
+	ImportOverwritten ImportOutcome = "overwritten"
+	ImportRenamed     ImportOutcome = "renamed"
+	ImportFailed      ImportOutcome = "failed"
+)
+
+// maxRenameAttempts bounds how many fresh codes are tried for one link
+const maxRenameAttempts = 5
+
+// ImportOptions controls ImportLinks
+type ImportOptions struct {
+	Policy ConflictPolicy
+	// DryRun performs every write inside a transaction that is rolled back,
+	// so the returned results are exactly what a real import would do
+	DryRun bool
+	// NewCode generates replacement codes for ConflictRename
+	NewCode func() (string, error)
+}
+
+// ImportResult is the outcome of importing one link. For renamed links
+// Code holds the new code and OriginalCode the code from the import.
+type ImportResult struct {
+	Code         string
+	OriginalCode string
+	Outcome      ImportOutcome
+	Err          error
+}
+
+// ExportLinks streams every link of workspace, with its domain, tags,
+// folder, variants and click counts, to fn in insertion order. Iteration
+// stops at the first error returned by fn.
+func (r *SQLiteRepository) ExportLinks(ctx context.Context, workspace string, fn func(*Link) error) error {
+	start := time.Now()
+	err := r.exportLinks(ctx, workspace, fn)
+	r.recordResult(ctx, "export_links", start, err)
+	return err
+}
+
+func (r *SQLiteRepository) exportLinks(ctx context.Context, workspace string, fn func(*Link) error) error {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.id, u.domain, u.code, u.original_url, u.created_at, u.clicks, u.last_clicked_at,
+			COALESCE(u.redirect_status, 0), u.pass_query, u.pass_path,
+			COALESCE(u.password_hash, ''), COALESCE(u.max_clicks, 0),
+			u.active_from, u.active_until, COALESCE(u.fallback_url, ''), u.interstitial,
+			COALESCE(u.social_title, ''), COALESCE(u.social_description, ''), COALESCE(u.social_image_url, ''),
+			u.device_rules, u.geo_rules,
+			(SELECT NULLIF(json_group_array(json_object('name', v.name, 'url', v.url, 'weight', v.weight, 'clicks', v.clicks)), '[]')
+				FROM (SELECT * FROM link_variants WHERE url_id = u.id ORDER BY position) v),
+			COALESCE((SELECT group_concat(t.name, ',') FROM url_tags ut
+				JOIN tags t ON t.id = ut.tag_id WHERE ut.url_id = u.id), ''),
+			COALESCE((SELECT f.name FROM url_folders uf JOIN folders f ON f.id = uf.folder_id WHERE uf.url_id = u.id), '')
+		FROM urls u WHERE u.workspace = ? ORDER BY u.id`, workspace)
+	if err != nil {
+		return fmt.Errorf("failed to export links: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		link := Link{Workspace: workspace}
+		var lastClicked, activeFrom, activeUntil sql.NullTime
+		var deviceRules, geoRules, variants sql.NullString
+		var tags string
+		if err := rows.Scan(&link.ID, &link.Domain, &link.Code, &link.OriginalURL, &link.CreatedAt,
+			&link.Clicks, &lastClicked, &link.RedirectStatus, &link.PassQuery, &link.PassPath, &link.PasswordHash, &link.MaxClicks,
+			&activeFrom, &activeUntil, &link.FallbackURL, &link.Interstitial,
+			&link.Social.Title, &link.Social.Description, &link.Social.ImageURL, &deviceRules, &geoRules, &variants, &tags, &link.Folder); err != nil {
+			return fmt.Errorf("failed to export links: %w", err)
+		}
+		if err := scanRules(deviceRules, link.Code, &link.DeviceRules); err != nil {
+			return fmt.Errorf("failed to export links: %w", err)
+		}
+		if err := scanRules(geoRules, link.Code, &link.GeoRules); err != nil {
+			return fmt.Errorf("failed to export links: %w", err)
+		}
+		if err := scanRules(variants, link.Code, &link.Variants); err != nil {
+			return fmt.Errorf("failed to export links: %w", err)
+		}
+		if lastClicked.Valid {
+			link.LastClickedAt = &lastClicked.Time
+		}
+		if activeFrom.Valid {
+			link.ActiveFrom = &activeFrom.Time
+		}
+		if activeUntil.Valid {
+			link.ActiveUntil = &activeUntil.Time
+		}
+		if tags != "" {
+			link.Tags = strings.Split(tags, ",")
+		}
+		if err := fn(&link); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export links: %w", err)
+	}
+	return nil
+}
+
+// ImportLinks stores links in a single transaction, resolving existing
+// codes according to opts.Policy. A code taken by another workspace's link
+// is never overwritten. As with StoreURLs, each link is written
+// under its own savepoint and failures are reported per link.
+func (r *SQLiteRepository) ImportLinks(ctx context.Context, links []*Link, opts ImportOptions) ([]ImportResult, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	results := make([]ImportResult, len(links))
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		for i, link := range links {
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT import_item`); err != nil {
+				return err
+			}
+			results[i] = importLink(ctx, tx, link, opts)
+			if results[i].Err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if _, err := tx.ExecContext(ctx, `ROLLBACK TO import_item`); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.ExecContext(ctx, `RELEASE import_item`); err != nil {
+				return err
+			}
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+	r.recordResult(ctx, "import_links", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to import links: %w", err)
+	}
+	return results, nil
+}
+
+// errDryRun aborts the import transaction after all writes succeeded
+var errDryRun = errors.New("dry run")
+
+// importLink writes one link inside the current savepoint
+func importLink(ctx context.Context, tx *sql.Tx, link *Link, opts ImportOptions) ImportResult {
+	result := ImportResult{Code: link.Code, OriginalCode: link.Code, Outcome: ImportCreated}
+
+	err := insertLink(ctx, tx, link)
+	if err == nil || !isUniqueViolation(err) {
+		return withImportError(ctx, result, link, err)
+	}
+
+	switch opts.Policy {
+	case ConflictOverwrite:
+		result.Outcome = ImportOverwritten
+		return withImportError(ctx, result, link, overwriteLink(ctx, tx, link))
+	case ConflictRename:
+		for attempt := 0; attempt < maxRenameAttempts; attempt++ {
+			code, genErr := opts.NewCode()
+			if genErr != nil {
+				return withImportError(ctx, result, link, genErr)
+			}
+			link.Code = code
+			err = insertLink(ctx, tx, link)
+			if err == nil || !isUniqueViolation(err) {
+				break
+			}
+		}
+		result.Code = link.Code
+		result.Outcome = ImportRenamed
+		return withImportError(ctx, result, link, err)
+	default:
+		result.Outcome = ImportSkipped
+		return result
+	}
+}
+
+// withImportError marks result as failed if err is non-nil
+func withImportError(ctx context.Context, result ImportResult, link *Link, err error) ImportResult {
+	if err != nil {
+		result.Outcome = ImportFailed
+		result.Err = wrapStoreError(ctx, link, err)
+	}
+	return result
+}
+
+// overwriteLink replaces the stored link having link.Code on link.Domain
+// with link. It fails with ErrConflict if that link belongs to another
+// workspace.
+func overwriteLink(ctx context.Context, tx *sql.Tx, link *Link) error {
+	createdAt := link.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	if err := tx.QueryRowContext(ctx,
+		`UPDATE urls SET original_url = ?, url_hash = ?, created_at = ?, clicks = ?, last_clicked_at = ?,
+			redirect_status = ?, pass_query = ?, pass_path = ?, password_hash = ?, max_clicks = ?,
+			active_from = ?, active_until = ?, fallback_url = ?, interstitial = ?,
+			social_title = ?, social_description = ?, social_image_url = ?, device_rules = ?, geo_rules = ?
+		WHERE workspace = ? AND domain = ? AND code = ? RETURNING id`,
+		link.OriginalURL, nullIfEmpty(link.URLHash), createdAt, link.Clicks, link.LastClickedAt,
+		nullIfZero(link.RedirectStatus), link.PassQuery, link.PassPath, nullIfEmpty(link.PasswordHash),
+		nullIfZero(link.MaxClicks), utcOrNil(link.ActiveFrom), utcOrNil(link.ActiveUntil), nullIfEmpty(link.FallbackURL),
+		link.Interstitial, nullIfEmpty(link.Social.Title), nullIfEmpty(link.Social.Description),
+		nullIfEmpty(link.Social.ImageURL), rulesOrNil(link.DeviceRules), rulesOrNil(link.GeoRules), link.Workspace, link.Domain, link.Code).Scan(&link.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrConflict, link.Code)
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM url_tags WHERE url_id = ?`, link.ID); err != nil {
+		return err
+	}
+	// Metadata describes the old destination, and the breakdown of clicks
+	// by country would not add up to the imported count
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_metadata WHERE url_id = ?`, link.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM click_countries WHERE url_id = ?`, link.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_variants WHERE url_id = ?`, link.ID); err != nil {
+		return err
+	}
+	link.CreatedAt = createdAt
+	if err := attachFolder(ctx, tx, link.Workspace, link.ID, link.Folder); err != nil {
+		return err
+	}
+	if err := attachVariants(ctx, tx, link.ID, link.Variants); err != nil {
+		return err
+	}
+	return attachTags(ctx, tx, link.Workspace, link.ID, link.Tags)
+}
@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportAll(t *testing.T, repo *SQLiteRepository) map[string]*Link {
+	t.Helper()
+	links := make(map[string]*Link)
+	require.NoError(t, repo.ExportLinks(context.Background(), "", func(link *Link) error {
+		links[link.Code] = link
+		return nil
+	}))
+	return links
+}
+
+func TestExportLinks(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	clicked := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	require.NoError(t, repo.StoreURL(ctx, &Link{
+		OriginalURL:   "http://example.com",
+		Code:          "abc",
+		Tags:          []string{"a", "b"},
+		Folder:        "launch",
+		Domain:        "go.example.com",
+		CreatedAt:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Clicks:        7,
+		LastClickedAt: &clicked,
+	}))
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.org", Code: "def"}))
+
+	links := exportAll(t, repo)
+	require.Len(t, links, 2)
+	assert.Equal(t, "http://example.com", links["abc"].OriginalURL)
+	assert.ElementsMatch(t, []string{"a", "b"}, links["abc"].Tags)
+	assert.Equal(t, int64(7), links["abc"].Clicks)
+	assert.True(t, clicked.Equal(*links["abc"].LastClickedAt))
+	assert.True(t, links["abc"].CreatedAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "launch", links["abc"].Folder)
+	assert.Nil(t, links["def"].LastClickedAt)
+	assert.Empty(t, links["def"].Tags)
+	assert.Empty(t, links["def"].Folder)
+	assert.Equal(t, "go.example.com", links["abc"].Domain)
+	assert.Empty(t, links["def"].Domain)
+
+	t.Run("callback error stops iteration", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		err := repo.ExportLinks(ctx, "", func(*Link) error {
+			calls++
+			return stop
+		})
+		assert.True(t, errors.Is(err, stop))
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestImportLinks(t *testing.T) {
+	ctx := context.Background()
+	counter := 0
+	newCode := func() (string, error) {
+		counter++
+		return fmt.Sprintf("new%d", counter), nil
+	}
+
+	setup := func(t *testing.T) *SQLiteRepository {
+		repo := setupTestRepo(t)
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://old.com", Code: "taken", Tags: []string{"old"}, Clicks: 3}))
+		return repo
+	}
+	incoming := func() []*Link {
+		return []*Link{
+			{OriginalURL: "http://fresh.com", Code: "fresh", Clicks: 10},
+			{OriginalURL: "http://replacement.com", Code: "taken", Tags: []string{"new"}, Folder: "new", Clicks: 99},
+		}
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		repo := setup(t)
+		defer repo.Close()
+
+		results, err := repo.ImportLinks(ctx, incoming(), ImportOptions{Policy: ConflictSkip, NewCode: newCode})
+		require.NoError(t, err)
+		assert.Equal(t, ImportCreated, results[0].Outcome)
+		assert.Equal(t, ImportSkipped, results[1].Outcome)
+
+		links := exportAll(t, repo)
+		assert.Equal(t, int64(10), links["fresh"].Clicks)
+		assert.Equal(t, "http://old.com", links["taken"].OriginalURL)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		repo := setup(t)
+		defer repo.Close()
+
+		results, err := repo.ImportLinks(ctx, incoming(), ImportOptions{Policy: ConflictOverwrite, NewCode: newCode})
+		require.NoError(t, err)
+		assert.Equal(t, ImportOverwritten, results[1].Outcome)
+
+		links := exportAll(t, repo)
+		assert.Len(t, links, 2)
+		assert.Equal(t, "http://replacement.com", links["taken"].OriginalURL)
+		assert.Equal(t, int64(99), links["taken"].Clicks)
+		assert.Equal(t, []string{"new"}, links["taken"].Tags)
+		assert.Equal(t, "new", links["taken"].Folder)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		repo := setup(t)
+		defer repo.Close()
+
+		results, err := repo.ImportLinks(ctx, incoming(), ImportOptions{Policy: ConflictRename, NewCode: newCode})
+		require.NoError(t, err)
+		assert.Equal(t, ImportRenamed, results[1].Outcome)
+		assert.Equal(t, "taken", results[1].OriginalCode)
+		assert.NotEqual(t, "taken", results[1].Code)
+
+		links := exportAll(t, repo)
+		assert.Len(t, links, 3)
+		assert.Equal(t, "http://old.com", links["taken"].OriginalURL)
+		assert.Equal(t, "http://replacement.com", links[results[1].Code].OriginalURL)
+	})
+
+	t.Run("dry run stores nothing", func(t *testing.T) {
+		repo := setup(t)
+		defer repo.Close()
+
+		results, err := repo.ImportLinks(ctx, incoming(), ImportOptions{Policy: ConflictOverwrite, DryRun: true, NewCode: newCode})
+		require.NoError(t, err)
+		assert.Equal(t, ImportCreated, results[0].Outcome)
+		assert.Equal(t, ImportOverwritten, results[1].Outcome)
+
+		links := exportAll(t, repo)
+		assert.Len(t, links, 1)
+		assert.Equal(t, "http://old.com", links["taken"].OriginalURL)
+	})
+
+	t.Run("duplicates within one import", func(t *testing.T) {
+		repo := setup(t)
+		defer repo.Close()
+
+		results, err := repo.ImportLinks(ctx, []*Link{
+			{OriginalURL: "http://one.com", Code: "dup"},
+			{OriginalURL: "http://two.com", Code: "dup"},
+		}, ImportOptions{Policy: ConflictSkip, NewCode: newCode})
+		require.NoError(t, err)
+		assert.Equal(t, ImportCreated, results[0].Outcome)
+		assert.Equal(t, ImportSkipped, results[1].Outcome)
+	})
+}
@@ -0,0 +1,171 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// UTMTemplateRepository stores reusable sets of UTM parameters. Templates
+// are scoped by workspace and team, so different teams may use the same
+// names.
+type UTMTemplateRepository interface {
+	SaveUTMTemplate(ctx context.Context, tmpl *UTMTemplate) error
+	GetUTMTemplate(ctx context.Context, workspace, team, name string) (*UTMTemplate, error)
+	ListUTMTemplates(ctx context.Context, workspace, team string) ([]*UTMTemplate, error)
+	DeleteUTMTemplate(ctx context.Context, workspace, team, name string) error
+}
+
+// UTMParams are the standard campaign tracking parameters. Empty fields
+// are unset.
+type UTMParams struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Term     string
+	Content  string
+}
+
+// UTMTemplate is a named set of UTM parameters owned by a team of a
+// workspace
+type UTMTemplate struct {
+	Workspace string
+	Team      string
+	Name      string
+	Params    UTMParams
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const utmTemplateColumns = `workspace, team, name, utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+	created_at, updated_at`
+
+// SaveUTMTemplate creates or replaces a template, keeping its original
+// creation time when it already exists. tmpl's timestamps are updated to
+// the stored values.
+func (r *SQLiteRepository) SaveUTMTemplate(ctx context.Context, tmpl *UTMTemplate) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	now := time.Now().UTC()
+	p := tmpl.Params
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO utm_templates (`+utmTemplateColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(workspace, team, name) DO UPDATE SET
+			utm_source = excluded.utm_source, utm_medium = excluded.utm_medium,
+			utm_campaign = excluded.utm_campaign, utm_term = excluded.utm_term,
+			utm_content = excluded.utm_content, updated_at = excluded.updated_at
+		RETURNING created_at, updated_at`,
+		tmpl.Workspace, tmpl.Team, tmpl.Name, nullIfEmpty(p.Source), nullIfEmpty(p.Medium), nullIfEmpty(p.Campaign),
+		nullIfEmpty(p.Term), nullIfEmpty(p.Content), now, now).Scan(&tmpl.CreatedAt, &tmpl.UpdatedAt)
+	r.recordResult(ctx, "save_utm_template", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("failed to save UTM template: %w", err)
+	}
+	return nil
+}
+
+// GetUTMTemplate retrieves a team's template by name
+func (r *SQLiteRepository) GetUTMTemplate(ctx context.Context, workspace, team, name string) (*UTMTemplate, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	tmpl, err := scanUTMTemplate(r.db.QueryRowContext(ctx,
+		`SELECT `+utmTemplateColumns+` FROM utm_templates WHERE workspace = ? AND team = ? AND name = ?`, workspace, team, name))
+	r.recordResult(ctx, "get_utm_template", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to get UTM template: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for UTM template: %s/%s", ErrNotFound, team, name)
+		}
+		return nil, fmt.Errorf("failed to get UTM template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// ListUTMTemplates returns a team's templates ordered by name
+func (r *SQLiteRepository) ListUTMTemplates(ctx context.Context, workspace, team string) ([]*UTMTemplate, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	templates, err := func() ([]*UTMTemplate, error) {
+		rows, err := r.db.QueryContext(ctx,
+			`SELECT `+utmTemplateColumns+` FROM utm_templates WHERE workspace = ? AND team = ? ORDER BY name`, workspace, team)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		templates := []*UTMTemplate{}
+		for rows.Next() {
+			tmpl, err := scanUTMTemplate(rows)
+			if err != nil {
+				return nil, err
+			}
+			templates = append(templates, tmpl)
+		}
+		return templates, rows.Err()
+	}()
+	r.recordResult(ctx, "list_utm_templates", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list UTM templates: %w", err)
+	}
+	return templates, nil
+}
+
+// DeleteUTMTemplate removes a team's template. Links already created from
+// it are unaffected, since their parameters are part of the stored URL.
+func (r *SQLiteRepository) DeleteUTMTemplate(ctx context.Context, workspace, team, name string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx, `DELETE FROM utm_templates WHERE workspace = ? AND team = ? AND name = ?`, workspace, team, name)
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	r.recordResult(ctx, "delete_utm_template", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("failed to delete UTM template: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w for UTM template: %s/%s", ErrNotFound, team, name)
+	}
+	return nil
+}
+
+// scanUTMTemplate reads a row selected with utmTemplateColumns
+func scanUTMTemplate(row rowScanner) (*UTMTemplate, error) {
+	var tmpl UTMTemplate
+	var source, medium, campaign, term, content sql.NullString
+	if err := row.Scan(&tmpl.Workspace, &tmpl.Team, &tmpl.Name, &source, &medium, &campaign, &term, &content,
+		&tmpl.CreatedAt, &tmpl.UpdatedAt); err != nil {
+		return nil, err
+	}
+	tmpl.Params = UTMParams{
+		Source:   source.String,
+		Medium:   medium.String,
+		Campaign: campaign.String,
+		Term:     term.String,
+		Content:  content.String,
+	}
+	return &tmpl, nil
+}
@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUTMTemplates(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	newsletter := &UTMTemplate{Team: "growth", Name: "newsletter", Params: UTMParams{Source: "newsletter", Medium: "email"}}
+	require.NoError(t, repo.SaveUTMTemplate(ctx, newsletter))
+	require.NoError(t, repo.SaveUTMTemplate(ctx, &UTMTemplate{Team: "growth", Name: "ads", Params: UTMParams{Source: "google", Medium: "cpc"}}))
+	require.NoError(t, repo.SaveUTMTemplate(ctx, &UTMTemplate{Team: "sales", Name: "newsletter", Params: UTMParams{Source: "crm"}}))
+
+	t.Run("get is scoped by team", func(t *testing.T) {
+		tmpl, err := repo.GetUTMTemplate(ctx, "", "growth", "newsletter")
+		require.NoError(t, err)
+		assert.Equal(t, UTMParams{Source: "newsletter", Medium: "email"}, tmpl.Params)
+
+		tmpl, err = repo.GetUTMTemplate(ctx, "", "sales", "newsletter")
+		require.NoError(t, err)
+		assert.Equal(t, "crm", tmpl.Params.Source)
+
+		_, err = repo.GetUTMTemplate(ctx, "", "support", "newsletter")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("save replaces and keeps creation time", func(t *testing.T) {
+		createdAt := newsletter.CreatedAt
+		updated := &UTMTemplate{Team: "growth", Name: "newsletter", Params: UTMParams{Source: "newsletter", Campaign: "spring"}}
+		require.NoError(t, repo.SaveUTMTemplate(ctx, updated))
+		assert.True(t, createdAt.Equal(updated.CreatedAt))
+
+		tmpl, err := repo.GetUTMTemplate(ctx, "", "growth", "newsletter")
+		require.NoError(t, err)
+		assert.Equal(t, UTMParams{Source: "newsletter", Campaign: "spring"}, tmpl.Params)
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		templates, err := repo.ListUTMTemplates(ctx, "", "growth")
+		require.NoError(t, err)
+		require.Len(t, templates, 2)
+		assert.Equal(t, "ads", templates[0].Name)
+
+		require.NoError(t, repo.DeleteUTMTemplate(ctx, "", "growth", "ads"))
+		err = repo.DeleteUTMTemplate(ctx, "", "growth", "ads")
+		assert.True(t, errors.Is(err, ErrNotFound))
+
+		templates, err = repo.ListUTMTemplates(ctx, "", "growth")
+		require.NoError(t, err)
+		assert.Len(t, templates, 1)
+	})
+}
@@ -0,0 +1,94 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Variant is one of the destinations a split link sends its visitors to,
+// in proportion to its weight
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	// Clicks counts the visits sent to this variant
+	Clicks int64 `json:"clicks,omitempty"`
+}
+
+// SetVariantWeights changes the weights of the named variants of the link
+// of workspace with code, leaving the others as they are, and returns all
+// its variants
+func (r *SQLiteRepository) SetVariantWeights(ctx context.Context, workspace, domain, code string, weights map[string]int) ([]Variant, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	link := &Link{Workspace: workspace, Domain: domain, Code: code}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, `SELECT id FROM urls WHERE workspace = ? AND domain = ? AND code = ?`,
+			workspace, domain, code).Scan(&link.ID); err != nil {
+			return err
+		}
+		for name, weight := range weights {
+			result, err := tx.ExecContext(ctx,
+				`UPDATE link_variants SET weight = ? WHERE url_id = ? AND name = ?`, weight, link.ID, name)
+			if err != nil {
+				return err
+			}
+			if n, err := result.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				return fmt.Errorf("%w: no variant %q for code: %s", ErrNotFound, name, code)
+			}
+		}
+		return loadVariants(ctx, tx, link)
+	})
+	r.recordResult(ctx, "set_variant_weights", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to set variant weights: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w for code: %s", ErrNotFound, code)
+		}
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to set variant weights: %w", err)
+	}
+	return link.Variants, nil
+}
+
+// loadVariants fills in link.Variants, in the order they were given
+func loadVariants(ctx context.Context, q queryer, link *Link) error {
+	rows, err := q.QueryContext(ctx,
+		`SELECT name, url, weight, clicks FROM link_variants WHERE url_id = ? ORDER BY position`, link.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	link.Variants = nil
+	for rows.Next() {
+		var variant Variant
+		if err := rows.Scan(&variant.Name, &variant.URL, &variant.Weight, &variant.Clicks); err != nil {
+			return err
+		}
+		link.Variants = append(link.Variants, variant)
+	}
+	return rows.Err()
+}
+
+// attachVariants stores the variants of a URL
+func attachVariants(ctx context.Context, tx *sql.Tx, urlID int64, variants []Variant) error {
+	for i, variant := range variants {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO link_variants (url_id, position, name, url, weight, clicks) VALUES (?, ?, ?, ?, ?, ?)`,
+			urlID, i, variant.Name, variant.URL, variant.Weight, variant.Clicks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
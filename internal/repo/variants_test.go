@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariants(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	variants := []Variant{
+		{Name: "b", URL: "http://example.com/b", Weight: 30},
+		{Name: "a", URL: "http://example.com/a", Weight: 70},
+	}
+	require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "split", URLHash: "h1", Variants: variants}))
+
+	link, err := repo.GetLink(ctx, "", "split")
+	require.NoError(t, err)
+	assert.Equal(t, variants, link.Variants, "variants keep their order")
+
+	t.Run("clicks are counted per variant", func(t *testing.T) {
+		_, err = repo.RecordClick(ctx, "", "split", Click{Variant: "a"})
+		require.NoError(t, err)
+		_, err = repo.RecordClick(ctx, "", "split", Click{Variant: "a"})
+		require.NoError(t, err)
+		_, err = repo.RecordClick(ctx, "", "split", Click{Variant: "b"})
+		require.NoError(t, err)
+		// A variant removed since the visitor was assigned is not an error
+		_, err = repo.RecordClick(ctx, "", "split", Click{Variant: "gone"})
+		require.NoError(t, err)
+
+		stats, err := repo.GetClickStats(ctx, "", "", "split")
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), stats.Clicks)
+		assert.Equal(t, []Variant{
+			{Name: "b", URL: "http://example.com/b", Weight: 30, Clicks: 1},
+			{Name: "a", URL: "http://example.com/a", Weight: 70, Clicks: 2},
+		}, stats.Variants)
+	})
+
+	t.Run("weights", func(t *testing.T) {
+		updated, err := repo.SetVariantWeights(ctx, "", "", "split", map[string]int{"b": 50})
+		require.NoError(t, err)
+		assert.Equal(t, []Variant{
+			{Name: "b", URL: "http://example.com/b", Weight: 50, Clicks: 1},
+			{Name: "a", URL: "http://example.com/a", Weight: 70, Clicks: 2},
+		}, updated)
+
+		// Unknown variants change nothing
+		_, err = repo.SetVariantWeights(ctx, "", "", "split", map[string]int{"a": 0, "c": 10})
+		assert.True(t, errors.Is(err, ErrNotFound))
+		link, err := repo.GetLink(ctx, "", "split")
+		require.NoError(t, err)
+		assert.Equal(t, 70, link.Variants[1].Weight)
+
+		_, err = repo.SetVariantWeights(ctx, "", "", "missing", map[string]int{"a": 1})
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("split links are never deduplicated", func(t *testing.T) {
+		found, err := repo.FindOrStoreURL(ctx, &Link{OriginalURL: "http://example.com", Code: "plain", URLHash: "h1"})
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("export and overwrite", func(t *testing.T) {
+		links := exportAll(t, repo)
+		assert.Equal(t, []Variant{
+			{Name: "b", URL: "http://example.com/b", Weight: 50, Clicks: 1},
+			{Name: "a", URL: "http://example.com/a", Weight: 70, Clicks: 2},
+		}, links["split"].Variants)
+		assert.Nil(t, links["plain"].Variants)
+
+		replacement := &Link{OriginalURL: "http://example.org", Code: "split",
+			Variants: []Variant{{Name: "x", URL: "http://example.org/x", Weight: 1}}}
+		results, err := repo.ImportLinks(ctx, []*Link{replacement}, ImportOptions{Policy: ConflictOverwrite})
+		require.NoError(t, err)
+		require.NoError(t, results[0].Err)
+		link, err := repo.GetLink(ctx, "", "split")
+		require.NoError(t, err)
+		assert.Equal(t, []Variant{{Name: "x", URL: "http://example.org/x", Weight: 1}}, link.Variants)
+	})
+}
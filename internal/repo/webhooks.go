@@ -0,0 +1,405 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WebhookRepository stores webhooks and the queue of deliveries to them.
+// Deliveries double as the delivery log: they are kept once delivered or
+// given up on, until pruned.
+type WebhookRepository interface {
+	ListWebhooks(ctx context.Context, workspace string) ([]*Webhook, error)
+	ListAllWebhooks(ctx context.Context) ([]*Webhook, error)
+	GetWebhook(ctx context.Context, workspace string, id int64) (*Webhook, error)
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	DeleteWebhook(ctx context.Context, workspace string, id int64) error
+	EnqueueDeliveries(ctx context.Context, workspace, event string, payload []byte) (int64, error)
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error)
+	CompleteDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	ListDeliveries(ctx context.Context, workspace string, webhookID int64, limit int) ([]*WebhookDelivery, error)
+	PruneDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Webhook is a URL the events of a workspace's links are sent to
+type Webhook struct {
+	ID        int64
+	Workspace string
+	URL       string
+	// Secret is the key payloads are signed with
+	Secret string
+	// Events lists the event types sent; empty means all of them
+	Events    []string
+	CreatedAt time.Time
+}
+
+// DeliveryStatus is how far a delivery has got
+type DeliveryStatus string
+
+const (
+	// DeliveryPending deliveries are waiting for their next attempt
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliveryDelivered deliveries were accepted by the webhook
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed deliveries ran out of attempts
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an event queued for, or sent to, one webhook
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID int64
+	Event     string
+	Payload   []byte
+	Status    DeliveryStatus
+	// Attempts counts the attempts made, including one in progress
+	Attempts      int
+	NextAttemptAt time.Time
+	LastAttemptAt *time.Time
+	// ResponseStatus is the HTTP status of the last response; zero if
+	// there was none
+	ResponseStatus int
+	// Error says why the last attempt failed; empty if it did not
+	Error     string
+	CreatedAt time.Time
+	// URL and Secret are the webhook's, only read by ClaimDeliveries
+	URL    string
+	Secret string
+}
+
+const webhookColumns = `id, workspace, url, secret, events, created_at`
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at, last_attempt_at,
+	response_status, error, created_at`
+
+// ListWebhooks returns the webhooks of a workspace, oldest first
+func (r *SQLiteRepository) ListWebhooks(ctx context.Context, workspace string) ([]*Webhook, error) {
+	return r.listWebhooks(ctx, "list_webhooks", `WHERE workspace = ? ORDER BY id`, workspace)
+}
+
+// ListAllWebhooks returns the webhooks of every workspace, oldest first
+func (r *SQLiteRepository) ListAllWebhooks(ctx context.Context) ([]*Webhook, error) {
+	return r.listWebhooks(ctx, "list_all_webhooks", `ORDER BY id`)
+}
+
+// listWebhooks returns the webhooks selected by where
+func (r *SQLiteRepository) listWebhooks(ctx context.Context, op, where string, args ...any) ([]*Webhook, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	webhooks, err := func() ([]*Webhook, error) {
+		rows, err := r.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks `+where, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		webhooks := []*Webhook{}
+		for rows.Next() {
+			webhook, err := scanWebhook(rows)
+			if err != nil {
+				return nil, err
+			}
+			webhooks = append(webhooks, webhook)
+		}
+		return webhooks, rows.Err()
+	}()
+	r.recordResult(ctx, op, start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// GetWebhook retrieves a webhook of a workspace
+func (r *SQLiteRepository) GetWebhook(ctx context.Context, workspace string, id int64) (*Webhook, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	webhook, err := scanWebhook(r.db.QueryRowContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE workspace = ? AND id = ?`, workspace, id))
+	r.recordResult(ctx, "get_webhook", start, err)
+	if err != nil {
+		return nil, wrapWebhookError(ctx, "get webhook", id, err)
+	}
+	return webhook, nil
+}
+
+// CreateWebhook stores a webhook. webhook.ID and webhook.CreatedAt are set
+// to the stored values.
+func (r *SQLiteRepository) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO webhooks (workspace, url, secret, events, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id, created_at`,
+		webhook.Workspace, webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","), time.Now().UTC()).
+		Scan(&webhook.ID, &webhook.CreatedAt)
+	r.recordResult(ctx, "create_webhook", start, err)
+	if err != nil {
+		return wrapWebhookError(ctx, "create webhook", webhook.ID, err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook of a workspace along with its deliveries
+func (r *SQLiteRepository) DeleteWebhook(ctx context.Context, workspace string, id int64) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhooks WHERE workspace = ? AND id = ?`, workspace, id)
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	if err == nil && deleted == 0 {
+		err = sql.ErrNoRows
+	}
+	r.recordResult(ctx, "delete_webhook", start, err)
+	if err != nil {
+		return wrapWebhookError(ctx, "delete webhook", id, err)
+	}
+	return nil
+}
+
+// EnqueueDeliveries queues payload for every webhook of workspace wanting
+// event, due at once, and returns how many were queued
+func (r *SQLiteRepository) EnqueueDeliveries(ctx context.Context, workspace, event string, payload []byte) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	now := time.Now().UTC()
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at)
+		SELECT id, ?, ?, ?, ?, ? FROM webhooks
+		WHERE workspace = ? AND (events = '' OR instr(',' || events || ',', ',' || ? || ',') > 0)`,
+		event, payload, DeliveryPending, now, now, workspace, event)
+	var queued int64
+	if err == nil {
+		queued, err = result.RowsAffected()
+	}
+	r.recordResult(ctx, "enqueue_deliveries", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return 0, fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+	return queued, nil
+}
+
+// ClaimDeliveries takes up to limit pending deliveries that are due at
+// now, oldest first, and counts an attempt at each. They are not due again
+// until lease has passed, so that other workers leave them alone and one
+// whose attempt never completes is retried. The webhook's URL and secret
+// are read with each.
+func (r *SQLiteRepository) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	var deliveries []*WebhookDelivery
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		// Updating first takes the write lock before anything is read
+		rows, err := tx.QueryContext(ctx,
+			`UPDATE webhook_deliveries SET attempts = attempts + 1, last_attempt_at = ?, next_attempt_at = ?
+			WHERE id IN (SELECT id FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?)
+			RETURNING id`,
+			now.UTC(), now.Add(lease).UTC(), DeliveryPending, now.UTC(), limit)
+		if err != nil {
+			return err
+		}
+		var ids []any
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+
+		rows, err = tx.QueryContext(ctx,
+			`SELECT `+deliveryColumns+`,
+				(SELECT url FROM webhooks WHERE id = webhook_id), (SELECT secret FROM webhooks WHERE id = webhook_id)
+			FROM webhook_deliveries WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`) ORDER BY id`, ids...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var delivery *WebhookDelivery
+			if delivery, err = scanDelivery(rows, true); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		return rows.Err()
+	})
+	r.recordResult(ctx, "claim_deliveries", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// CompleteDelivery records the outcome of an attempt at a claimed
+// delivery: its Status, NextAttemptAt, ResponseStatus and Error
+func (r *SQLiteRepository) CompleteDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, next_attempt_at = ?, response_status = ?, error = ? WHERE id = ?`,
+		delivery.Status, delivery.NextAttemptAt.UTC(), nullIfZero(delivery.ResponseStatus), nullIfEmpty(delivery.Error), delivery.ID)
+	var updated int64
+	if err == nil {
+		updated, err = result.RowsAffected()
+	}
+	if err == nil && updated == 0 {
+		// The webhook was deleted meanwhile
+		err = sql.ErrNoRows
+	}
+	r.recordResult(ctx, "complete_delivery", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("failed to complete webhook delivery: %w", ctx.Err())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w for webhook delivery: %d", ErrNotFound, delivery.ID)
+		}
+		return fmt.Errorf("failed to complete webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns up to limit deliveries to a webhook of workspace,
+// newest first. ErrNotFound is returned if there is no such webhook.
+func (r *SQLiteRepository) ListDeliveries(ctx context.Context, workspace string, webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	deliveries, err := func() ([]*WebhookDelivery, error) {
+		var exists int
+		if err := r.db.QueryRowContext(ctx, `SELECT 1 FROM webhooks WHERE workspace = ? AND id = ?`, workspace, webhookID).
+			Scan(&exists); err != nil {
+			return nil, err
+		}
+
+		rows, err := r.db.QueryContext(ctx,
+			`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, webhookID, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		deliveries := []*WebhookDelivery{}
+		for rows.Next() {
+			delivery, err := scanDelivery(rows, false)
+			if err != nil {
+				return nil, err
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		return deliveries, rows.Err()
+	}()
+	r.recordResult(ctx, "list_deliveries", start, err)
+	if err != nil {
+		return nil, wrapWebhookError(ctx, "list webhook deliveries", webhookID, err)
+	}
+	return deliveries, nil
+}
+
+// PruneDeliveries deletes the deliveries created before before that are no
+// longer pending, and returns how many were deleted
+func (r *SQLiteRepository) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE created_at < ? AND status != ?`, before.UTC(), DeliveryPending)
+	var pruned int64
+	if err == nil {
+		pruned, err = result.RowsAffected()
+	}
+	r.recordResult(ctx, "prune_deliveries", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return 0, fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+	return pruned, nil
+}
+
+// scanWebhook reads a row selected with webhookColumns
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var webhook Webhook
+	var events string
+	if err := row.Scan(&webhook.ID, &webhook.Workspace, &webhook.URL, &webhook.Secret, &events, &webhook.CreatedAt); err != nil {
+		return nil, err
+	}
+	webhook.Events = []string{}
+	if events != "" {
+		webhook.Events = strings.Split(events, ",")
+	}
+	return &webhook, nil
+}
+
+// scanDelivery reads a row selected with deliveryColumns, followed by the
+// webhook's URL and secret if withWebhook is set
+func scanDelivery(row rowScanner, withWebhook bool) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var lastAttempt sql.NullTime
+	var responseStatus sql.NullInt64
+	var deliveryErr sql.NullString
+	dest := []any{&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+		&delivery.NextAttemptAt, &lastAttempt, &responseStatus, &deliveryErr, &delivery.CreatedAt}
+	if withWebhook {
+		dest = append(dest, &delivery.URL, &delivery.Secret)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if lastAttempt.Valid {
+		delivery.LastAttemptAt = &lastAttempt.Time
+	}
+	delivery.ResponseStatus = int(responseStatus.Int64)
+	delivery.Error = deliveryErr.String
+	return &delivery, nil
+}
+
+// wrapWebhookError turns a failed webhook operation into an error naming
+// the webhook, with sql.ErrNoRows becoming ErrNotFound
+func wrapWebhookError(ctx context.Context, op string, id int64, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("failed to %s: %w", op, ctx.Err())
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w for webhook: %d", ErrNotFound, id)
+	default:
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+}
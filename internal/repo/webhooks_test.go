@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	all := &Webhook{Workspace: "marketing", URL: "https://crm.example.com/hooks", Secret: "s1"}
+	clicks := &Webhook{Workspace: "marketing", URL: "https://stats.example.com/hooks", Secret: "s2", Events: []string{"link.clicked"}}
+	other := &Webhook{Workspace: "sales", URL: "https://sales.example.com/hooks", Secret: "s3"}
+	for _, webhook := range []*Webhook{all, clicks, other} {
+		require.NoError(t, repo.CreateWebhook(ctx, webhook))
+		assert.NotZero(t, webhook.ID)
+		assert.False(t, webhook.CreatedAt.IsZero())
+	}
+
+	t.Run("webhooks are per workspace", func(t *testing.T) {
+		webhooks, err := repo.ListWebhooks(ctx, "marketing")
+		require.NoError(t, err)
+		require.Len(t, webhooks, 2)
+		assert.Equal(t, all.ID, webhooks[0].ID)
+		assert.Empty(t, webhooks[0].Events)
+		assert.Equal(t, []string{"link.clicked"}, webhooks[1].Events)
+
+		webhooks, err = repo.ListAllWebhooks(ctx)
+		require.NoError(t, err)
+		assert.Len(t, webhooks, 3)
+
+		got, err := repo.GetWebhook(ctx, "sales", other.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "s3", got.Secret)
+		_, err = repo.GetWebhook(ctx, "sales", all.ID)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("events are queued for the webhooks wanting them", func(t *testing.T) {
+		queued, err := repo.EnqueueDeliveries(ctx, "marketing", "link.created", []byte(`{"event":"link.created"}`))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), queued)
+
+		queued, err = repo.EnqueueDeliveries(ctx, "marketing", "link.clicked", []byte(`{"event":"link.clicked"}`))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), queued)
+
+		queued, err = repo.EnqueueDeliveries(ctx, "", "link.created", []byte(`{}`))
+		require.NoError(t, err)
+		assert.Zero(t, queued)
+	})
+
+	t.Run("claimed deliveries wait out their lease", func(t *testing.T) {
+		now := time.Now()
+		deliveries, err := repo.ClaimDeliveries(ctx, now, time.Minute, 2)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 2)
+		assert.Equal(t, "link.created", deliveries[0].Event)
+		assert.Equal(t, `{"event":"link.created"}`, string(deliveries[0].Payload))
+		assert.Equal(t, all.URL, deliveries[0].URL)
+		assert.Equal(t, "s1", deliveries[0].Secret)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		require.NotNil(t, deliveries[0].LastAttemptAt)
+
+		rest, err := repo.ClaimDeliveries(ctx, now, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		assert.Equal(t, "link.clicked", rest[0].Event)
+
+		none, err := repo.ClaimDeliveries(ctx, now, time.Minute, 10)
+		require.NoError(t, err)
+		assert.Empty(t, none)
+
+		// An attempt that never completed is retried once its lease is up
+		again, err := repo.ClaimDeliveries(ctx, now.Add(2*time.Minute), time.Minute, 10)
+		require.NoError(t, err)
+		assert.Len(t, again, 3)
+		assert.Equal(t, 2, again[0].Attempts)
+
+		delivered := again[0]
+		delivered.Status = DeliveryDelivered
+		delivered.ResponseStatus = 204
+		require.NoError(t, repo.CompleteDelivery(ctx, delivered))
+
+		retried := again[1]
+		retried.Status = DeliveryPending
+		retried.NextAttemptAt = now.Add(time.Hour)
+		retried.ResponseStatus = 500
+		retried.Error = "unexpected status 500"
+		require.NoError(t, repo.CompleteDelivery(ctx, retried))
+
+		due, err := repo.ClaimDeliveries(ctx, now.Add(5*time.Minute), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		assert.Equal(t, again[2].ID, due[0].ID)
+	})
+
+	t.Run("delivery log", func(t *testing.T) {
+		deliveries, err := repo.ListDeliveries(ctx, "marketing", all.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 2)
+		assert.Equal(t, "link.clicked", deliveries[0].Event)
+		assert.Equal(t, DeliveryPending, deliveries[0].Status)
+		assert.Equal(t, 500, deliveries[0].ResponseStatus)
+		assert.Equal(t, "unexpected status 500", deliveries[0].Error)
+		assert.Equal(t, DeliveryDelivered, deliveries[1].Status)
+		assert.Equal(t, 204, deliveries[1].ResponseStatus)
+		assert.Empty(t, deliveries[1].Error)
+		assert.Empty(t, deliveries[1].URL, "secrets are only read to deliver")
+
+		_, err = repo.ListDeliveries(ctx, "sales", all.ID, 10)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("prune keeps pending deliveries", func(t *testing.T) {
+		pruned, err := repo.PruneDeliveries(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), pruned)
+
+		deliveries, err := repo.ListDeliveries(ctx, "marketing", all.ID, 10)
+		require.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, repo.DeleteWebhook(ctx, "marketing", all.ID))
+		assert.True(t, errors.Is(repo.DeleteWebhook(ctx, "marketing", all.ID), ErrNotFound))
+		assert.True(t, errors.Is(repo.DeleteWebhook(ctx, "marketing", other.ID), ErrNotFound))
+
+		_, err := repo.ListDeliveries(ctx, "marketing", all.ID, 10)
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.True(t, errors.Is(repo.CompleteDelivery(ctx, &WebhookDelivery{ID: 1, Status: DeliveryDelivered}), ErrNotFound))
+	})
+}
@@ -0,0 +1,383 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WorkspaceRepository stores workspaces, their members and the API keys
+// members use. The default workspace, with an empty slug, is not stored
+// and has no members.
+type WorkspaceRepository interface {
+	ListWorkspaces(ctx context.Context) ([]*Workspace, error)
+	GetWorkspace(ctx context.Context, slug string) (*Workspace, error)
+	CreateWorkspace(ctx context.Context, workspace *Workspace) error
+	DeleteWorkspace(ctx context.Context, slug string) error
+	ListMembers(ctx context.Context, workspace string) ([]*Member, error)
+	SaveMember(ctx context.Context, member *Member) error
+	RemoveMember(ctx context.Context, workspace, email string) error
+	ListAPIKeys(ctx context.Context, workspace string) ([]*APIKey, error)
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	DeleteAPIKey(ctx context.Context, workspace string, id int64) error
+	UseAPIKey(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// Workspace is an isolated set of links, domains, folders, tags and UTM
+// templates, with the members who manage them
+type Workspace struct {
+	Slug      string
+	Name      string
+	CreatedAt time.Time
+	// Links and Members count what the workspace holds
+	Links   int64
+	Members int64
+}
+
+// Member is a person belonging to a workspace
+type Member struct {
+	Workspace string
+	Email     string
+	// Role is what the member may do in the workspace, such as "admin"
+	Role      string
+	CreatedAt time.Time
+}
+
+// APIKey lets a member call the API on behalf of their workspace. Only a
+// hash of the key is stored; Prefix, its first characters, helps people
+// tell keys apart.
+type APIKey struct {
+	ID        int64
+	Workspace string
+	Member    string
+	Name      string
+	Prefix    string
+	KeyHash   string
+	// Role is the member's current role, which the key acts with
+	Role       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+const workspaceColumns = `slug, name, created_at,
+	(SELECT COUNT(*) FROM urls WHERE urls.workspace = workspaces.slug),
+	(SELECT COUNT(*) FROM workspace_members m WHERE m.workspace = workspaces.slug)`
+
+const apiKeyColumns = `id, workspace, member, name, prefix, created_at, last_used_at,
+	(SELECT role FROM workspace_members m WHERE m.workspace = api_keys.workspace AND m.email = api_keys.member)`
+
+// ListWorkspaces returns every workspace, ordered by slug, with its link
+// and member counts
+func (r *SQLiteRepository) ListWorkspaces(ctx context.Context) ([]*Workspace, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	workspaces, err := func() ([]*Workspace, error) {
+		rows, err := r.db.QueryContext(ctx, `SELECT `+workspaceColumns+` FROM workspaces ORDER BY slug`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		workspaces := []*Workspace{}
+		for rows.Next() {
+			var workspace Workspace
+			if err := rows.Scan(&workspace.Slug, &workspace.Name, &workspace.CreatedAt, &workspace.Links, &workspace.Members); err != nil {
+				return nil, err
+			}
+			workspaces = append(workspaces, &workspace)
+		}
+		return workspaces, rows.Err()
+	}()
+	r.recordResult(ctx, "list_workspaces", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// GetWorkspace retrieves a workspace by slug
+func (r *SQLiteRepository) GetWorkspace(ctx context.Context, slug string) (*Workspace, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	var workspace Workspace
+	err := r.db.QueryRowContext(ctx, `SELECT `+workspaceColumns+` FROM workspaces WHERE slug = ?`, slug).
+		Scan(&workspace.Slug, &workspace.Name, &workspace.CreatedAt, &workspace.Links, &workspace.Members)
+	r.recordResult(ctx, "get_workspace", start, err)
+	if err != nil {
+		return nil, wrapWorkspaceError(ctx, "get workspace", "workspace", slug, err)
+	}
+	return &workspace, nil
+}
+
+// CreateWorkspace stores a new workspace, returning ErrConflict if its
+// slug is taken. workspace.CreatedAt is set to the stored value.
+func (r *SQLiteRepository) CreateWorkspace(ctx context.Context, workspace *Workspace) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO workspaces (slug, name, created_at) VALUES (?, ?, ?) RETURNING created_at`,
+		workspace.Slug, workspace.Name, time.Now().UTC()).Scan(&workspace.CreatedAt)
+	r.recordResult(ctx, "create_workspace", start, err)
+	if err != nil {
+		return wrapWorkspaceError(ctx, "create workspace", "workspace", workspace.Slug, err)
+	}
+	return nil
+}
+
+// DeleteWorkspace removes a workspace with its members, API keys, folders,
+// tags, UTM templates, idempotency keys, quotas and webhooks. A workspace
+// that still has links or domains cannot be deleted; ErrConflict is
+// returned instead.
+func (r *SQLiteRepository) DeleteWorkspace(ctx context.Context, slug string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var links, domains int64
+		if err := tx.QueryRowContext(ctx,
+			`SELECT (SELECT COUNT(*) FROM urls WHERE workspace = slug), (SELECT COUNT(*) FROM domains WHERE workspace = slug)
+			FROM workspaces WHERE slug = ?`, slug).Scan(&links, &domains); err != nil {
+			return err
+		}
+		if links > 0 || domains > 0 {
+			return fmt.Errorf("%w: workspace %s has %d links and %d domains", ErrConflict, slug, links, domains)
+		}
+		for _, table := range []string{"folders", "tags", "utm_templates", "idempotency_keys", "quotas", "webhooks", "deleted_links"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE workspace = ?`, slug); err != nil {
+				return err
+			}
+		}
+		// Members and their keys go with the workspace
+		_, err := tx.ExecContext(ctx, `DELETE FROM workspaces WHERE slug = ?`, slug)
+		return err
+	})
+	r.recordResult(ctx, "delete_workspace", start, err)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, ErrConflict) {
+			return err
+		}
+		return wrapWorkspaceError(ctx, "delete workspace", "workspace", slug, err)
+	}
+	return nil
+}
+
+// ListMembers returns the members of a workspace ordered by email
+func (r *SQLiteRepository) ListMembers(ctx context.Context, workspace string) ([]*Member, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	members, err := func() ([]*Member, error) {
+		rows, err := r.db.QueryContext(ctx,
+			`SELECT workspace, email, role, created_at FROM workspace_members WHERE workspace = ? ORDER BY email`, workspace)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		members := []*Member{}
+		for rows.Next() {
+			var member Member
+			if err := rows.Scan(&member.Workspace, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+				return nil, err
+			}
+			members = append(members, &member)
+		}
+		return members, rows.Err()
+	}()
+	r.recordResult(ctx, "list_members", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	return members, nil
+}
+
+// SaveMember adds a member to a workspace, or changes the role of an
+// existing one. member.CreatedAt is set to the stored value. ErrNotFound
+// is returned if the workspace does not exist.
+func (r *SQLiteRepository) SaveMember(ctx context.Context, member *Member) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO workspace_members (workspace, email, role, created_at)
+		SELECT slug, ?, ?, ? FROM workspaces WHERE slug = ?
+		ON CONFLICT(workspace, email) DO UPDATE SET role = excluded.role
+		RETURNING created_at`,
+		member.Email, member.Role, time.Now().UTC(), member.Workspace).Scan(&member.CreatedAt)
+	r.recordResult(ctx, "save_member", start, err)
+	if err != nil {
+		return wrapWorkspaceError(ctx, "save member", "workspace", member.Workspace, err)
+	}
+	return nil
+}
+
+// RemoveMember removes a member from a workspace, revoking their API keys
+// and dropping their quota
+func (r *SQLiteRepository) RemoveMember(ctx context.Context, workspace, email string) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM workspace_members WHERE workspace = ? AND email = ?`, workspace, email)
+		if err != nil {
+			return err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return sql.ErrNoRows
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM quotas WHERE workspace = ? AND member = ?`, workspace, email)
+		return err
+	})
+	r.recordResult(ctx, "remove_member", start, err)
+	if err != nil {
+		return wrapWorkspaceError(ctx, "remove member", "member", email, err)
+	}
+	return nil
+}
+
+// ListAPIKeys returns the API keys of a workspace, oldest first. Their
+// hashes are not read.
+func (r *SQLiteRepository) ListAPIKeys(ctx context.Context, workspace string) ([]*APIKey, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	keys, err := func() ([]*APIKey, error) {
+		rows, err := r.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE workspace = ? ORDER BY id`, workspace)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		keys := []*APIKey{}
+		for rows.Next() {
+			key, err := scanAPIKey(rows)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, rows.Err()
+	}()
+	r.recordResult(ctx, "list_api_keys", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// CreateAPIKey stores a key for a member of key.Workspace, returning
+// ErrNotFound if there is no such member. key.ID, key.Role and
+// key.CreatedAt are set to the stored values.
+func (r *SQLiteRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO api_keys (workspace, member, name, prefix, key_hash, created_at)
+		SELECT workspace, email, ?, ?, ?, ? FROM workspace_members WHERE workspace = ? AND email = ?
+		RETURNING id, created_at`,
+		key.Name, key.Prefix, key.KeyHash, time.Now().UTC(), key.Workspace, key.Member).Scan(&key.ID, &key.CreatedAt)
+	if err == nil {
+		err = r.db.QueryRowContext(ctx,
+			`SELECT role FROM workspace_members WHERE workspace = ? AND email = ?`, key.Workspace, key.Member).Scan(&key.Role)
+	}
+	r.recordResult(ctx, "create_api_key", start, err)
+	if err != nil {
+		return wrapWorkspaceError(ctx, "create API key", "member", key.Member, err)
+	}
+	return nil
+}
+
+// DeleteAPIKey revokes an API key of a workspace
+func (r *SQLiteRepository) DeleteAPIKey(ctx context.Context, workspace string, id int64) error {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.db.ExecContext(ctx, `DELETE FROM api_keys WHERE workspace = ? AND id = ?`, workspace, id)
+	var deleted int64
+	if err == nil {
+		deleted, err = result.RowsAffected()
+	}
+	if err == nil && deleted == 0 {
+		err = sql.ErrNoRows
+	}
+	r.recordResult(ctx, "delete_api_key", start, err)
+	if err != nil {
+		return wrapWorkspaceError(ctx, "delete API key", "API key", fmt.Sprint(id), err)
+	}
+	return nil
+}
+
+// UseAPIKey returns the key with the given hash, whatever its workspace,
+// and records that it was used. ErrNotFound is returned for unknown keys.
+func (r *SQLiteRepository) UseAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	ctx, cancel := withTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	start := time.Now()
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx,
+		`UPDATE api_keys SET last_used_at = ? WHERE key_hash = ? RETURNING `+apiKeyColumns,
+		time.Now().UTC(), hash))
+	r.recordResult(ctx, "use_api_key", start, err)
+	if err != nil {
+		return nil, wrapWorkspaceError(ctx, "use API key", "API key", "", err)
+	}
+	return key, nil
+}
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var lastUsed sql.NullTime
+	if err := row.Scan(&key.ID, &key.Workspace, &key.Member, &key.Name, &key.Prefix, &key.CreatedAt, &lastUsed, &key.Role); err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	return &key, nil
+}
+
+// wrapWorkspaceError converts a failed workspace, member or API key
+// operation into the error returned to callers. kind and name identify
+// what was not found or already exists.
+func wrapWorkspaceError(ctx context.Context, op, kind, name string, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("failed to %s: %w", op, ctx.Err())
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w for %s: %s", ErrNotFound, kind, name)
+	case isUniqueViolation(err):
+		return fmt.Errorf("%w: %s %s", ErrConflict, kind, name)
+	default:
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+}
@@ -0,0 +1,210 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaces(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	workspace := &Workspace{Slug: "marketing", Name: "Marketing"}
+	require.NoError(t, repo.CreateWorkspace(ctx, workspace))
+	assert.False(t, workspace.CreatedAt.IsZero())
+	assert.True(t, errors.Is(repo.CreateWorkspace(ctx, &Workspace{Slug: "marketing", Name: "Other"}), ErrConflict))
+
+	require.NoError(t, repo.SaveMember(ctx, &Member{Workspace: "marketing", Email: "ann@example.com", Role: "member"}))
+	require.NoError(t, repo.SaveMember(ctx, &Member{Workspace: "marketing", Email: "ann@example.com", Role: "admin"}))
+	assert.True(t, errors.Is(repo.SaveMember(ctx, &Member{Workspace: "missing", Email: "ann@example.com", Role: "admin"}), ErrNotFound))
+
+	members, err := repo.ListMembers(ctx, "marketing")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "admin", members[0].Role)
+
+	t.Run("api keys act with the member's role", func(t *testing.T) {
+		key := &APIKey{Workspace: "marketing", Member: "ann@example.com", Name: "ci", Prefix: "usk_abcd", KeyHash: "hash-1"}
+		require.NoError(t, repo.CreateAPIKey(ctx, key))
+		assert.Equal(t, "admin", key.Role)
+		assert.True(t, errors.Is(repo.CreateAPIKey(ctx, &APIKey{Workspace: "marketing", Member: "bob@example.com", KeyHash: "hash-2"}), ErrNotFound))
+
+		used, err := repo.UseAPIKey(ctx, "hash-1")
+		require.NoError(t, err)
+		assert.Equal(t, "marketing", used.Workspace)
+		assert.Equal(t, "ann@example.com", used.Member)
+		assert.Equal(t, "admin", used.Role)
+		require.NotNil(t, used.LastUsedAt)
+
+		_, err = repo.UseAPIKey(ctx, "unknown")
+		assert.True(t, errors.Is(err, ErrNotFound))
+
+		keys, err := repo.ListAPIKeys(ctx, "marketing")
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, "usk_abcd", keys[0].Prefix)
+		assert.Empty(t, keys[0].KeyHash)
+
+		assert.True(t, errors.Is(repo.DeleteAPIKey(ctx, "", key.ID), ErrNotFound))
+		require.NoError(t, repo.DeleteAPIKey(ctx, "marketing", key.ID))
+	})
+
+	t.Run("removing a member revokes their keys", func(t *testing.T) {
+		require.NoError(t, repo.CreateAPIKey(ctx, &APIKey{Workspace: "marketing", Member: "ann@example.com", KeyHash: "hash-3"}))
+		require.NoError(t, repo.RemoveMember(ctx, "marketing", "ann@example.com"))
+		assert.True(t, errors.Is(repo.RemoveMember(ctx, "marketing", "ann@example.com"), ErrNotFound))
+
+		_, err := repo.UseAPIKey(ctx, "hash-3")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("workspaces with links are kept", func(t *testing.T) {
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://one.com", Code: "one", Workspace: "marketing"}))
+		assert.True(t, errors.Is(repo.DeleteWorkspace(ctx, "marketing"), ErrConflict))
+
+		got, err := repo.GetWorkspace(ctx, "marketing")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), got.Links)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, repo.CreateWorkspace(ctx, &Workspace{Slug: "sales", Name: "Sales"}))
+		_, err := repo.CreateFolder(ctx, "sales", "leads")
+		require.NoError(t, err)
+		require.NoError(t, repo.SaveQuota(ctx, &Quota{Workspace: "sales", LinksPerDay: 10}))
+		require.NoError(t, repo.CreateWebhook(ctx, &Webhook{Workspace: "sales", URL: "https://crm.example.com", Secret: "secret"}))
+		require.NoError(t, repo.DeleteWorkspace(ctx, "sales"))
+		assert.True(t, errors.Is(repo.DeleteWorkspace(ctx, "sales"), ErrNotFound))
+
+		folders, err := repo.ListFolders(ctx, "sales")
+		require.NoError(t, err)
+		assert.Empty(t, folders)
+		quotas, err := repo.ListQuotas(ctx, "sales")
+		require.NoError(t, err)
+		assert.Empty(t, quotas)
+		webhooks, err := repo.ListWebhooks(ctx, "sales")
+		require.NoError(t, err)
+		assert.Empty(t, webhooks)
+
+		workspaces, err := repo.ListWorkspaces(ctx)
+		require.NoError(t, err)
+		require.Len(t, workspaces, 1)
+		assert.Equal(t, "marketing", workspaces[0].Slug)
+	})
+}
+
+func TestWorkspaceIsolation(t *testing.T) {
+	repo := setupTestRepo(t)
+	defer repo.Close()
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateDomain(ctx, &Domain{Host: "mk.example.com", Workspace: "marketing"}))
+	require.NoError(t, repo.CreateDomain(ctx, &Domain{Host: "sl.example.com", Workspace: "sales"}))
+	marketing := &Link{OriginalURL: "http://example.com", Code: "promo", Domain: "mk.example.com", Workspace: "marketing", Folder: "launch", Tags: []string{"q3"}}
+	sales := &Link{OriginalURL: "http://example.com", Code: "promo", Domain: "sl.example.com", Workspace: "sales", Folder: "launch", Tags: []string{"q3"}}
+	require.NoError(t, repo.StoreURL(ctx, marketing))
+	require.NoError(t, repo.StoreURL(ctx, sales))
+	workspace, err := repo.RecordClick(ctx, "mk.example.com", "promo", Click{})
+	require.NoError(t, err)
+	assert.Equal(t, "marketing", workspace, "clicks report the link's workspace")
+
+	t.Run("listing only sees the workspace's links", func(t *testing.T) {
+		links, err := repo.ListLinks(ctx, LinkFilter{Workspace: "sales", Tags: []string{"q3"}, Folder: "launch", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, links, 1)
+		assert.Equal(t, sales.ID, links[0].ID)
+
+		links, err = repo.ListLinks(ctx, LinkFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, links)
+	})
+
+	t.Run("folders and tags are per workspace", func(t *testing.T) {
+		folders, err := repo.ListFolders(ctx, "marketing")
+		require.NoError(t, err)
+		require.Len(t, folders, 1)
+		assert.Equal(t, int64(1), folders[0].Links)
+
+		stats, err := repo.GetTagStats(ctx, "marketing", "q3")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Links)
+		assert.Equal(t, int64(1), stats.Clicks)
+
+		require.NoError(t, repo.DeleteTag(ctx, "sales", "q3"))
+		stats, err = repo.GetTagStats(ctx, "marketing", "q3")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Links)
+	})
+
+	t.Run("links of other workspaces are not found", func(t *testing.T) {
+		_, err := repo.GetClickStats(ctx, "sales", "mk.example.com", "promo")
+		assert.True(t, errors.Is(err, ErrNotFound))
+		_, err = repo.UpdateLink(ctx, "sales", "mk.example.com", "promo", LinkUpdate{})
+		assert.True(t, errors.Is(err, ErrNotFound))
+		_, err = repo.SetVariantWeights(ctx, "sales", "mk.example.com", "promo", map[string]int{})
+		assert.True(t, errors.Is(err, ErrNotFound))
+
+		stats, err := repo.GetClickStats(ctx, "marketing", "mk.example.com", "promo")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Clicks)
+	})
+
+	t.Run("domains of other workspaces are not found", func(t *testing.T) {
+		domains, err := repo.ListDomains(ctx, "sales")
+		require.NoError(t, err)
+		require.Len(t, domains, 1)
+		assert.Equal(t, "sl.example.com", domains[0].Host)
+
+		assert.True(t, errors.Is(repo.UpdateDomain(ctx, &Domain{Host: "mk.example.com", Workspace: "sales"}), ErrNotFound))
+		assert.True(t, errors.Is(repo.DeleteDomain(ctx, "sales", "mk.example.com"), ErrNotFound))
+	})
+
+	t.Run("export only streams the workspace's links", func(t *testing.T) {
+		var codes []string
+		require.NoError(t, repo.ExportLinks(ctx, "marketing", func(link *Link) error {
+			codes = append(codes, link.Domain+"/"+link.Code)
+			assert.Equal(t, "marketing", link.Workspace)
+			return nil
+		}))
+		assert.Equal(t, []string{"mk.example.com/promo"}, codes)
+	})
+
+	t.Run("deduplication is per workspace", func(t *testing.T) {
+		require.NoError(t, repo.StoreURL(ctx, &Link{OriginalURL: "http://dedupe.com", URLHash: "h", Code: "d1", Workspace: "marketing"}))
+		found, err := repo.FindOrStoreURL(ctx, &Link{OriginalURL: "http://dedupe.com", URLHash: "h", Code: "d2", Workspace: "sales"})
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("imports never overwrite another workspace's link", func(t *testing.T) {
+		results, err := repo.ImportLinks(ctx, []*Link{{OriginalURL: "http://evil.com", Code: "promo", Domain: "mk.example.com", Workspace: "sales"}},
+			ImportOptions{Policy: ConflictOverwrite})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, ImportFailed, results[0].Outcome)
+		assert.True(t, errors.Is(results[0].Err, ErrConflict))
+
+		link, err := repo.GetLink(ctx, "mk.example.com", "promo")
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com", link.OriginalURL)
+	})
+
+	t.Run("utm templates and idempotency keys are per workspace", func(t *testing.T) {
+		require.NoError(t, repo.SaveUTMTemplate(ctx, &UTMTemplate{Workspace: "marketing", Team: "growth", Name: "newsletter", Params: UTMParams{Source: "newsletter"}}))
+		_, err := repo.GetUTMTemplate(ctx, "sales", "growth", "newsletter")
+		assert.True(t, errors.Is(err, ErrNotFound))
+
+		record, err := repo.ReserveIdempotencyKey(ctx, "marketing", "key", "a", time.Now(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Nil(t, record)
+		record, err = repo.ReserveIdempotencyKey(ctx, "sales", "key", "b", time.Now(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Nil(t, record)
+	})
+}
@@ -1,20 +1,37 @@
 package security
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/urlcanon"
 	"golang.org/x/time/rate"
 )
 
+// Errors returned by URLValidator.Check for hosts the policy refuses
+var (
+	// ErrDomainBlocked is returned for a host in BlockedDomains
+	ErrDomainBlocked = errors.New("domain is blocked")
+	// ErrDomainNotAllowed is returned for a host missing from a non-empty
+	// AllowedDomains
+	ErrDomainNotAllowed = errors.New("domain is not in allowed list")
+)
+
 // SecurityConfig holds security-related configuration
 type SecurityConfig struct {
 	RateLimitRPS     int
@@ -66,32 +83,48 @@ func (rl *RateLimiter) Allow(clientIP string) bool {
 	return limiter.Allow()
 }
 
-// URLValidator validates URLs for security
+// URLValidator validates URLs for security. Parsing and the checks shared
+// with the rest of the application are delegated to the canonicalizer the
+// application shortens URLs with, so that the validator accepts exactly the
+// URLs that can be shortened.
 type URLValidator struct {
-	config *SecurityConfig
+	config        *SecurityConfig
+	canonicalizer *urlcanon.Canonicalizer
 }
 
-// NewURLValidator creates a new URL validator
-func NewURLValidator(config *SecurityConfig) *URLValidator {
-	return &URLValidator{config: config}
+// NewURLValidator creates a new URL validator using canonicalizer
+func NewURLValidator(config *SecurityConfig, canonicalizer *urlcanon.Canonicalizer) *URLValidator {
+	return &URLValidator{
+		config:        config,
+		canonicalizer: canonicalizer,
+	}
 }
 
 // ValidateURL validates a URL for security concerns
 func (v *URLValidator) ValidateURL(rawURL string) error {
-	// Check URL length
-	if len(rawURL) > v.config.MaxURLLength {
-		return fmt.Errorf("URL exceeds maximum length of %d characters", v.config.MaxURLLength)
+	// Check length, scheme and host
+	canonical, err := v.canonicalizer.Canonicalize(rawURL)
+	if err != nil {
+		return err
+	}
+	if err := v.Check(canonical); err != nil {
+		return err
 	}
 
-	// Parse URL
-	parsedURL, err := url.Parse(rawURL)
-	if err != nil {
-		return fmt.Errorf("invalid URL format: %w", err)
+	// Check for suspicious patterns
+	if err := v.checkSuspiciousPatterns(rawURL); err != nil {
+		return err
 	}
 
-	// Check scheme
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("unsupported URL scheme: %s", parsedURL.Scheme)
+	return nil
+}
+
+// Check applies the HTTPS and domain policy to a URL that is already
+// canonical
+func (v *URLValidator) Check(canonicalURL string) error {
+	parsedURL, err := url.Parse(canonicalURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL format: %w", err)
 	}
 
 	// Require HTTPS in production
@@ -99,11 +132,12 @@ func (v *URLValidator) ValidateURL(rawURL string) error {
 		return fmt.Errorf("HTTPS required")
 	}
 
-	// Check for blocked domains
-	hostname := strings.ToLower(parsedURL.Hostname())
+	// Check for blocked domains. The canonical hostname is already
+	// lowercase, with internationalised names in punycode.
+	hostname := parsedURL.Hostname()
 	for _, blocked := range v.config.BlockedDomains {
 		if hostname == strings.ToLower(blocked) {
-			return fmt.Errorf("domain %s is blocked", hostname)
+			return fmt.Errorf("%w: %s", ErrDomainBlocked, hostname)
 		}
 	}
 
@@ -117,15 +151,10 @@ func (v *URLValidator) ValidateURL(rawURL string) error {
 			}
 		}
 		if !allowed {
-			return fmt.Errorf("domain %s is not in allowed list", hostname)
+			return fmt.Errorf("%w: %s", ErrDomainNotAllowed, hostname)
 		}
 	}
 
-	// Check for suspicious patterns
-	if err := v.checkSuspiciousPatterns(rawURL); err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -179,6 +208,101 @@ func (c *CSRFProtection) ValidateToken(provided, expected string) bool {
 	return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
 }
 
+// KeyedLimiter limits how often an event may happen per key, such as
+// password guesses per link. It is safe for concurrent use.
+type KeyedLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	every    time.Duration
+	burst    int
+}
+
+// maxLimiterKeys bounds the keys a KeyedLimiter tracks before it forgets
+// the ones that are back to a full allowance
+const maxLimiterKeys = 10000
+
+// NewKeyedLimiter allows burst events per key at once, refilling one every
+// every
+func NewKeyedLimiter(every time.Duration, burst int) *KeyedLimiter {
+	return &KeyedLimiter{
+		limiters: make(map[string]*rate.Limiter),
+		every:    every,
+		burst:    burst,
+	}
+}
+
+// Allow reports whether an event for key may happen now
+func (l *KeyedLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, exists := l.limiters[key]
+	if !exists {
+		if len(l.limiters) >= maxLimiterKeys {
+			l.prune()
+		}
+		limiter = rate.NewLimiter(rate.Every(l.every), l.burst)
+		l.limiters[key] = limiter
+	}
+	return limiter.Allow()
+}
+
+// prune forgets keys whose allowance has fully refilled, since a new
+// limiter for them would behave identically
+func (l *KeyedLimiter) prune() {
+	for key, limiter := range l.limiters {
+		if limiter.Tokens() >= float64(l.burst) {
+			delete(l.limiters, key)
+		}
+	}
+}
+
+// TokenSigner issues and verifies expiring HMAC-SHA256 signed tokens that
+// bind a value, such as a link code, without storing anything server-side
+type TokenSigner struct {
+	key []byte
+}
+
+// NewTokenSigner creates a signer using key. An empty key is replaced by a
+// random one, so tokens then stop verifying when the process restarts.
+func NewTokenSigner(key []byte) (*TokenSigner, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+	}
+	return &TokenSigner{key: key}, nil
+}
+
+// Sign returns a token for value that verifies until expires
+func (s *TokenSigner) Sign(value string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + s.mac(value, expiry)
+}
+
+// Verify reports whether token was issued by Sign for value and has not
+// yet expired
+func (s *TokenSigner) Verify(token, value string, now time.Time) bool {
+	expiry, mac, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(s.mac(value, expiry)))
+}
+
+func (s *TokenSigner) mac(value, expiry string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(expiry))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
 // SecurityHeaders adds security headers to HTTP responses
 func SecurityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -234,6 +358,93 @@ func getClientIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
+// TrustedProxies resolves the address of the client behind any reverse
+// proxies in front of the server. Forwarding headers are only believed
+// when they were added by a trusted proxy; anyone else could forge them.
+// The nil value trusts no proxy.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+}
+
+// ParseTrustedProxies parses addresses and CIDR ranges such as "10.0.0.0/8"
+// of trusted proxies
+func ParseTrustedProxies(entries []string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			addr = addr.Unmap()
+			proxies.prefixes = append(proxies.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// trusts reports whether addr is a trusted proxy
+func (p *TrustedProxies) trusts(addr netip.Addr) bool {
+	if p == nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that made r. Starting from
+// the connection's peer, X-Forwarded-For is walked from the right for as
+// long as the hops are trusted proxies; the first untrusted hop is the
+// client. X-Real-IP is used when a trusted peer sent no X-Forwarded-For.
+// The zero Addr is returned if the peer address cannot be parsed.
+func (p *TrustedProxies) ClientIP(r *http.Request) netip.Addr {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	client := peer.Addr().Unmap()
+	if !p.trusts(client) {
+		return client
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap()
+		}
+		return client
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Garbage is not an address, so the last good hop is the best
+			// we know
+			return client
+		}
+		client = addr.Unmap()
+		if !p.trusts(client) {
+			return client
+		}
+	}
+	return client
+}
+
 // SanitizeInput sanitizes user input to prevent injection attacks
 func SanitizeInput(input string) string {
 	// Remove potentially dangerous characters
@@ -251,4 +462,40 @@ func LogSecurityEvent(logger *logrus.Logger, event, clientIP, details string) {
 		"details":    details,
 		"timestamp":  time.Now().Format(time.RFC3339),
 	}).Warn("Security event detected")
-}
\ No newline at end of file
+}
+
+// AdminAuth restricts a route to callers presenting the admin token as a
+// bearer credential. With an empty token the routes are disabled entirely.
+func AdminAuth(token string, logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				writeProblem(w, r, http.StatusNotFound, "/problems/not-found", "admin API is disabled")
+				return
+			}
+
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				LogSecurityEvent(logger, "admin_auth_failed", getClientIP(r),
+					fmt.Sprintf("Path: %s, Method: %s", r.URL.Path, r.Method))
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeProblem(w, r, http.StatusUnauthorized, "/problems/unauthorized", "a valid admin token is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeProblem sends a minimal RFC 7807 response from middleware
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, problemType, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     problemType,
+		"title":    http.StatusText(status),
+		"status":   status,
+		"detail":   detail,
+		"instance": r.URL.Path,
+	})
+}
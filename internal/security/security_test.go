@@ -0,0 +1,100 @@
+package security
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/urlcanon"
+)
+
+func TestTokenSigner(t *testing.T) {
+	signer, err := NewTokenSigner([]byte("secret"))
+	require.NoError(t, err)
+	now := time.Now()
+	token := signer.Sign("abc", now.Add(time.Hour))
+
+	assert.True(t, signer.Verify(token, "abc", now))
+	assert.False(t, signer.Verify(token, "abd", now), "bound to its value")
+	assert.False(t, signer.Verify(token, "abc", now.Add(2*time.Hour)), "expired")
+	assert.False(t, signer.Verify("9999999999."+token[len("9999999999."):], "abc", now), "expiry is signed")
+
+	other, err := NewTokenSigner(nil)
+	require.NoError(t, err)
+	assert.False(t, other.Verify(token, "abc", now), "bound to its key")
+}
+
+func TestURLValidator(t *testing.T) {
+	canonicalizer := urlcanon.New(urlcanon.Options{MaxLength: 64, StripTrackingParams: true})
+	validator := NewURLValidator(&SecurityConfig{
+		AllowedDomains: []string{"Example.com", "xn--bcher-kva.example"},
+		BlockedDomains: []string{"localhost"},
+		RequireHTTPS:   true,
+	}, canonicalizer)
+
+	assert.NoError(t, validator.ValidateURL("https://EXAMPLE.com/a?utm_source=mail"))
+	assert.NoError(t, validator.ValidateURL("https://bücher.example/"), "hosts are compared in punycode")
+	assert.ErrorContains(t, validator.ValidateURL("http://example.com/"), "HTTPS required")
+	assert.ErrorIs(t, validator.ValidateURL("https://localhost/"), ErrDomainBlocked)
+	assert.ErrorIs(t, validator.ValidateURL("https://example.org/"), ErrDomainNotAllowed)
+	assert.Error(t, validator.ValidateURL("https://example.com/"+strings.Repeat("a", 64)), "the canonicalizer's limits apply")
+
+	assert.NoError(t, validator.Check("https://example.com/a"))
+	assert.ErrorIs(t, validator.Check("https://localhost/"), ErrDomainBlocked)
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	limiter := NewKeyedLimiter(time.Hour, 2)
+
+	assert.True(t, limiter.Allow("a"))
+	assert.True(t, limiter.Allow("a"))
+	assert.False(t, limiter.Allow("a"))
+	assert.True(t, limiter.Allow("b"), "keys are independent")
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.1 ", ""})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		proxies    *TrustedProxies
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		expected   string
+	}{
+		{"direct", proxies, "203.0.113.9:4000", nil, "", "203.0.113.9"},
+		{"untrusted peer cannot forge", proxies, "203.0.113.9:4000", []string{"198.51.100.7"}, "198.51.100.7", "203.0.113.9"},
+		{"through a proxy", proxies, "10.1.2.3:4000", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"through a chain", proxies, "10.1.2.3:4000", []string{"198.51.100.7, 192.0.2.1", "10.9.9.9"}, "", "198.51.100.7"},
+		{"spoofed entries are left of the client", proxies, "10.1.2.3:4000", []string{"1.2.3.4, 198.51.100.7"}, "", "198.51.100.7"},
+		{"all hops trusted", proxies, "10.1.2.3:4000", []string{"10.4.4.4"}, "", "10.4.4.4"},
+		{"garbage hop", proxies, "10.1.2.3:4000", []string{"unknown, 10.4.4.4"}, "", "10.4.4.4"},
+		{"real ip from a proxy", proxies, "10.1.2.3:4000", nil, "198.51.100.7", "198.51.100.7"},
+		{"mapped ipv4", proxies, "[::ffff:10.1.2.3]:4000", []string{"2001:db8::7"}, "", "2001:db8::7"},
+		{"nil trusts nobody", nil, "10.1.2.3:4000", []string{"198.51.100.7"}, "", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, netip.MustParseAddr(tt.expected), tt.proxies.ClientIP(r))
+		})
+	}
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
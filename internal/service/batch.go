@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// batchChunkSize is the number of links written per transaction. Chunking
+// keeps a large batch from holding SQLite's write lock for too long.
+const batchChunkSize = 500
+
+// BatchResult is the outcome of a single item in a batch. Exactly one of
+// Result and Err is set.
+type BatchResult struct {
+	Result *ShortenResult
+	Err    error
+}
+
+// ShortenBatch shortens many URLs at once. Invalid items and conflicting
+// aliases are reported per item and do not affect the rest of the batch.
+// Items beyond what the quotas of their workspace and member allow fail
+// with a *QuotaError, the last items first.
+// Items are stored in chunks of batchChunkSize, each in its own transaction;
+// if a chunk cannot be written, it and all later items are marked failed.
+func (s *URLServiceImpl) ShortenBatch(ctx context.Context, reqs []ShortenRequest) ([]BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(reqs))
+	links := make([]*repo.Link, len(reqs))
+	pending := make([]int, 0, len(reqs))
+
+	for i, req := range reqs {
+		link, err := s.prepareLink(ctx, req)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		links[i] = link
+		pending = append(pending, i)
+	}
+
+	groups := groupByCreator(reqs, pending)
+	for g, group := range groups {
+		if err := s.storeGroup(ctx, reqs, links, group, results); err != nil {
+			for _, later := range groups[g+1:] {
+				for _, i := range later {
+					results[i].Err = err
+				}
+			}
+			break
+		}
+	}
+
+	for _, i := range pending {
+		if results[i].Result != nil {
+			s.queueMetadata(links[i])
+			s.publish(ctx, EventLinkCreated, links[i], nil)
+		}
+	}
+	return results, nil
+}
+
+// storeGroup stores the pending items of one workspace member under their
+// quotas. If the links cannot be stored, the items left are marked failed
+// and the error is returned.
+func (s *URLServiceImpl) storeGroup(ctx context.Context, reqs []ShortenRequest, links []*repo.Link, group []int, results []BatchResult) error {
+	first := reqs[group[0]]
+	reservation, err := s.reserveQuota(ctx, first.Workspace, first.Member, len(group))
+	if err != nil {
+		for _, i := range group {
+			results[i].Err = err
+		}
+		return err
+	}
+	stored := 0
+	defer func() { reservation.Done(stored) }()
+	for _, i := range group[reservation.Allowed:] {
+		results[i].Err = reservation.Err
+	}
+	stored, err = s.storeLinks(ctx, reqs, links, group[:reservation.Allowed], results)
+	return err
+}
+
+// storeLinks stores the pending items in chunks, regenerating codes that
+// collide, and returns how many were stored
+func (s *URLServiceImpl) storeLinks(ctx context.Context, reqs []ShortenRequest, links []*repo.Link, pending []int, results []BatchResult) (int, error) {
+	stored := 0
+	for attempt := 1; len(pending) > 0; attempt++ {
+		var retry []int
+		for start := 0; start < len(pending); start += batchChunkSize {
+			chunk := pending[start:min(start+batchChunkSize, len(pending))]
+			chunkLinks := make([]*repo.Link, len(chunk))
+			for j, i := range chunk {
+				chunkLinks[j] = links[i]
+			}
+
+			itemErrs, err := s.repo.StoreURLs(ctx, chunkLinks)
+			if err != nil {
+				err = fmt.Errorf("failed to store URL: %w", err)
+				for _, unstored := range [][]int{pending[start:], retry} {
+					for _, i := range unstored {
+						results[i].Err = err
+					}
+				}
+				return stored, err
+			}
+
+			for j, i := range chunk {
+				itemErr := itemErrs[j]
+				switch {
+				case itemErr == nil:
+					stored++
+					results[i].Result = s.result(links[i])
+				case reqs[i].Alias == "" && errors.Is(itemErr, ErrConflict) && attempt < maxCodeAttempts:
+					// Generated code collided; try again with a fresh one
+					code, err := generateUniqueCode(codeLength)
+					if err != nil {
+						results[i].Err = fmt.Errorf("failed to generate code: %w", err)
+						continue
+					}
+					links[i].Code = code
+					retry = append(retry, i)
+				default:
+					results[i].Err = itemErr
+				}
+			}
+		}
+		pending = retry
+	}
+	return stored, nil
+}
+
+// groupByCreator splits the pending items by workspace and member,
+// keeping their order
+func groupByCreator(reqs []ShortenRequest, pending []int) [][]int {
+	type creator struct{ workspace, member string }
+	index := map[creator]int{}
+	var groups [][]int
+	for _, i := range pending {
+		key := creator{reqs[i].Workspace, reqs[i].Member}
+		g, ok := index[key]
+		if !ok {
+			g = len(groups)
+			index[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
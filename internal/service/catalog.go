@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+const (
+	// defaultListLimit is how many links a listing returns when the
+	// caller does not say
+	defaultListLimit = 50
+	// maxListLimit bounds how many links a listing may return
+	maxListLimit = 200
+)
+
+// folderNamePattern matches the names of folders
+var folderNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Folder is a named collection of links
+type Folder = repo.Folder
+
+// TagStats summarises the links carrying a tag and their visits
+type TagStats = repo.TagStats
+
+// LinkUpdate changes how a link is organised; nil fields are left as they
+// are
+type LinkUpdate = repo.LinkUpdate
+
+// LinkFilter selects the links returned by ListLinks
+type LinkFilter struct {
+	// Workspace is the workspace whose links are listed
+	Workspace string
+	// Tags lists tags every returned link must carry
+	Tags []string
+	// Folder, if set, is the folder the links must be in
+	Folder string
+	// Cursor continues a listing from the page that returned it
+	Cursor string
+	// Limit bounds how many links are returned; zero uses the default
+	Limit int
+}
+
+// LinkSummary describes a link in a listing
+type LinkSummary struct {
+	Code          string
+	ShortURL      string
+	OriginalURL   string
+	Tags          []string
+	Folder        string
+	Domain        string
+	Clicks        int64
+	CreatedAt     time.Time
+	LastClickedAt *time.Time
+}
+
+// LinkPage is one page of a link listing, newest links first
+type LinkPage struct {
+	Links []LinkSummary
+	// NextCursor fetches the following page; empty on the last one
+	NextCursor string
+}
+
+// CatalogService manages the folders and tags links are organised with
+type CatalogService interface {
+	ListFolders(ctx context.Context, workspace string) ([]*Folder, error)
+	CreateFolder(ctx context.Context, workspace, name string) (*Folder, error)
+	RenameFolder(ctx context.Context, workspace, name, newName string) (*Folder, error)
+	DeleteFolder(ctx context.Context, workspace, name string) error
+	ListTags(ctx context.Context, workspace string) ([]*TagStats, error)
+	GetTagStats(ctx context.Context, workspace, name string) (*TagStats, error)
+	DeleteTag(ctx context.Context, workspace, name string) error
+}
+
+// CatalogServiceImpl implements CatalogService
+type CatalogServiceImpl struct {
+	repo repo.CatalogRepository
+}
+
+// NewCatalogService creates a new CatalogService
+func NewCatalogService(repo repo.CatalogRepository) CatalogService {
+	return &CatalogServiceImpl{repo: repo}
+}
+
+// ListFolders returns every folder of workspace, ordered by name, with its
+// link count
+func (s *CatalogServiceImpl) ListFolders(ctx context.Context, workspace string) ([]*Folder, error) {
+	return s.repo.ListFolders(ctx, workspace)
+}
+
+// CreateFolder creates an empty folder. Folders are also created as links
+// are put in them.
+func (s *CatalogServiceImpl) CreateFolder(ctx context.Context, workspace, name string) (*Folder, error) {
+	if err := validateFolderName("name", name); err != nil {
+		return nil, err
+	}
+	return s.repo.CreateFolder(ctx, workspace, name)
+}
+
+// RenameFolder gives a folder a new name, keeping its links
+func (s *CatalogServiceImpl) RenameFolder(ctx context.Context, workspace, name, newName string) (*Folder, error) {
+	if err := validateFolderName("folder", name); err != nil {
+		return nil, err
+	}
+	if err := validateFolderName("name", newName); err != nil {
+		return nil, err
+	}
+	return s.repo.RenameFolder(ctx, workspace, name, newName)
+}
+
+// DeleteFolder removes a folder, leaving its links outside any folder
+func (s *CatalogServiceImpl) DeleteFolder(ctx context.Context, workspace, name string) error {
+	if err := validateFolderName("folder", name); err != nil {
+		return err
+	}
+	return s.repo.DeleteFolder(ctx, workspace, name)
+}
+
+// ListTags returns every tag of workspace, ordered by name, with the
+// number of links carrying it and their combined clicks
+func (s *CatalogServiceImpl) ListTags(ctx context.Context, workspace string) ([]*TagStats, error) {
+	return s.repo.ListTags(ctx, workspace)
+}
+
+// GetTagStats returns the combined click statistics of the links carrying
+// a tag
+func (s *CatalogServiceImpl) GetTagStats(ctx context.Context, workspace, name string) (*TagStats, error) {
+	if err := validateTagName(name); err != nil {
+		return nil, err
+	}
+	return s.repo.GetTagStats(ctx, workspace, name)
+}
+
+// DeleteTag removes a tag from every link carrying it
+func (s *CatalogServiceImpl) DeleteTag(ctx context.Context, workspace, name string) error {
+	if err := validateTagName(name); err != nil {
+		return err
+	}
+	return s.repo.DeleteTag(ctx, workspace, name)
+}
+
+// ListLinks returns a page of the links matching filter, newest first
+func (s *URLServiceImpl) ListLinks(ctx context.Context, filter LinkFilter) (*LinkPage, error) {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 1 || limit > maxListLimit {
+		return nil, &InputError{Field: "limit", Reason: fmt.Sprintf("must be between 1 and %d", maxListLimit)}
+	}
+	tags, err := normalizeTags(filter.Tags)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := normalizeFolder(filter.Folder)
+	if err != nil {
+		return nil, err
+	}
+	var beforeID int64
+	if filter.Cursor != "" {
+		if beforeID, err = strconv.ParseInt(filter.Cursor, 10, 64); err != nil || beforeID <= 0 {
+			return nil, &InputError{Field: "cursor", Reason: "is not a cursor returned by a listing"}
+		}
+	}
+
+	// One extra link tells whether there is another page
+	links, err := s.repo.ListLinks(ctx, repo.LinkFilter{Workspace: filter.Workspace, Tags: tags, Folder: folder, BeforeID: beforeID, Limit: limit + 1})
+	if err != nil {
+		return nil, err
+	}
+	page := &LinkPage{Links: make([]LinkSummary, 0, min(len(links), limit))}
+	if len(links) > limit {
+		links = links[:limit]
+		page.NextCursor = strconv.FormatInt(links[limit-1].ID, 10)
+	}
+	for _, link := range links {
+		page.Links = append(page.Links, LinkSummary{
+			Code:          link.Code,
+			ShortURL:      s.shortURL(link.Domain, link.Code),
+			OriginalURL:   link.OriginalURL,
+			Tags:          link.Tags,
+			Folder:        link.Folder,
+			Domain:        link.Domain,
+			Clicks:        link.Clicks,
+			CreatedAt:     link.CreatedAt,
+			LastClickedAt: link.LastClickedAt,
+		})
+	}
+	return page, nil
+}
+
+// UpdateLink replaces the tags of a link of workspace, moves it to another
+// folder, or both
+func (s *URLServiceImpl) UpdateLink(ctx context.Context, workspace, domain, code string, update LinkUpdate) (*ShortenResult, error) {
+	if update.Tags == nil && update.Folder == nil {
+		return nil, &InputError{Field: "body", Reason: "must set tags or folder"}
+	}
+	if update.Tags != nil {
+		tags, err := normalizeTags(*update.Tags)
+		if err != nil {
+			return nil, err
+		}
+		update.Tags = &tags
+	}
+	if update.Folder != nil {
+		folder, err := normalizeFolder(*update.Folder)
+		if err != nil {
+			return nil, err
+		}
+		update.Folder = &folder
+	}
+
+	link, err := s.repo.UpdateLink(ctx, workspace, normalizeHost(domain), code, update)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(ctx, EventLinkUpdated, link, nil)
+	return s.result(link), nil
+}
+
+// DeleteLink removes a link of workspace along with its clicks, and
+// returns it as it was
+func (s *URLServiceImpl) DeleteLink(ctx context.Context, workspace, domain, code string) (*ShortenResult, error) {
+	link, err := s.repo.DeleteLink(ctx, workspace, normalizeHost(domain), code)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(ctx, EventLinkDeleted, link, nil)
+	return s.result(link), nil
+}
+
+// normalizeFolder lowercases and validates a folder name, allowing empty
+// for no folder
+func normalizeFolder(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", nil
+	}
+	if err := validateFolderName("folder", name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// validateFolderName checks a folder name given in field
+func validateFolderName(field, name string) error {
+	if !folderNamePattern.MatchString(name) {
+		return &InputError{Field: field, Reason: "must be 1-64 lowercase letters, digits, '-' or '_'"}
+	}
+	return nil
+}
+
+// validateTagName checks a single tag name
+func validateTagName(name string) error {
+	if !tagPattern.MatchString(name) {
+		return &InputError{Field: "tag", Reason: "must be 1-32 lowercase letters, digits, '-' or '_'"}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+// MockCatalogRepository is a mock implementation of repo.CatalogRepository
+type MockCatalogRepository struct {
+	mock.Mock
+}
+
+func (m *MockCatalogRepository) ListFolders(ctx context.Context, workspace string) ([]*repo.Folder, error) {
+	args := m.Called(workspace)
+	folders, _ := args.Get(0).([]*repo.Folder)
+	return folders, args.Error(1)
+}
+
+func (m *MockCatalogRepository) CreateFolder(ctx context.Context, workspace, name string) (*repo.Folder, error) {
+	args := m.Called(workspace, name)
+	folder, _ := args.Get(0).(*repo.Folder)
+	return folder, args.Error(1)
+}
+
+func (m *MockCatalogRepository) RenameFolder(ctx context.Context, workspace, name, newName string) (*repo.Folder, error) {
+	args := m.Called(workspace, name, newName)
+	folder, _ := args.Get(0).(*repo.Folder)
+	return folder, args.Error(1)
+}
+
+func (m *MockCatalogRepository) DeleteFolder(ctx context.Context, workspace, name string) error {
+	return m.Called(workspace, name).Error(0)
+}
+
+func (m *MockCatalogRepository) ListTags(ctx context.Context, workspace string) ([]*repo.TagStats, error) {
+	args := m.Called(workspace)
+	tags, _ := args.Get(0).([]*repo.TagStats)
+	return tags, args.Error(1)
+}
+
+func (m *MockCatalogRepository) GetTagStats(ctx context.Context, workspace, name string) (*repo.TagStats, error) {
+	args := m.Called(workspace, name)
+	stats, _ := args.Get(0).(*repo.TagStats)
+	return stats, args.Error(1)
+}
+
+func (m *MockCatalogRepository) DeleteTag(ctx context.Context, workspace, name string) error {
+	return m.Called(workspace, name).Error(0)
+}
+
+func TestShortenURLWithFolder(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	mockRepo.On("StoreURL", "https://example.com/", mock.Anything).Return(nil).Once()
+	result, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Folder: " Launch "})
+	require.NoError(t, err)
+	assert.Equal(t, "launch", result.Folder)
+
+	_, err = service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Folder: "spring launch"})
+	var inputErr *InputError
+	require.True(t, errors.As(err, &inputErr))
+	assert.Equal(t, "folder", inputErr.Field)
+}
+
+func TestListLinks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("pages", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080"})
+		links := []*repo.Link{
+			{ID: 9, Code: "nine", OriginalURL: "https://example.com/9", Tags: []string{"email"}, Folder: "launch", Clicks: 3},
+			{ID: 7, Code: "seven", OriginalURL: "https://example.com/7"},
+			{ID: 4, Code: "four", OriginalURL: "https://example.com/4"},
+		}
+		mockRepo.On("ListLinks", repo.LinkFilter{Tags: []string{"email"}, Folder: "launch", Limit: 3}).Return(links, nil).Once()
+
+		page, err := service.ListLinks(ctx, LinkFilter{Tags: []string{"Email"}, Folder: "Launch", Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page.Links, 2)
+		assert.Equal(t, "http://localhost:8080/nine", page.Links[0].ShortURL)
+		assert.Equal(t, "launch", page.Links[0].Folder)
+		assert.Equal(t, int64(3), page.Links[0].Clicks)
+		assert.Equal(t, "7", page.NextCursor)
+
+		mockRepo.On("ListLinks", repo.LinkFilter{Tags: []string{}, BeforeID: 7, Limit: 3}).Return(links[2:], nil).Once()
+		page, err = service.ListLinks(ctx, LinkFilter{Cursor: "7", Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page.Links, 1)
+		assert.Empty(t, page.NextCursor, "the last page has no cursor")
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("default limit", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		service := NewURLService(mockRepo, Config{})
+		mockRepo.On("ListLinks", repo.LinkFilter{Tags: []string{}, Limit: defaultListLimit + 1}).Return([]*repo.Link{}, nil).Once()
+
+		page, err := service.ListLinks(ctx, LinkFilter{})
+		require.NoError(t, err)
+		assert.Empty(t, page.Links)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid filters", func(t *testing.T) {
+		service := NewURLService(new(MockURLRepository), Config{})
+		for field, filter := range map[string]LinkFilter{
+			"limit":  {Limit: maxListLimit + 1},
+			"cursor": {Cursor: "abc"},
+			"tags":   {Tags: []string{"no spaces"}},
+			"folder": {Folder: "a/b"},
+		} {
+			_, err := service.ListLinks(ctx, filter)
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), field)
+			assert.Equal(t, field, inputErr.Field)
+		}
+	})
+}
+
+func TestUpdateLink(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("normalises tags and folder", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080"})
+		tags := []string{"Email", "email", "Spring"}
+		folder := " Launch "
+		mockRepo.On("UpdateLink", "", "", "abc", mock.MatchedBy(func(update repo.LinkUpdate) bool {
+			return assert.ObjectsAreEqual([]string{"email", "spring"}, *update.Tags) && *update.Folder == "launch"
+		})).Return(&repo.Link{Code: "abc", OriginalURL: "https://example.com/", Tags: []string{"email", "spring"}, Folder: "launch"}, nil).Once()
+
+		result, err := service.UpdateLink(ctx, "", "", "abc", LinkUpdate{Tags: &tags, Folder: &folder})
+		require.NoError(t, err)
+		assert.Equal(t, "http://localhost:8080/abc", result.ShortURL)
+		assert.Equal(t, "launch", result.Folder)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("an update must change something", func(t *testing.T) {
+		service := NewURLService(new(MockURLRepository), Config{})
+		_, err := service.UpdateLink(ctx, "", "", "abc", LinkUpdate{})
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+
+	t.Run("missing links", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		service := NewURLService(mockRepo, Config{})
+		folder := ""
+		mockRepo.On("UpdateLink", "", "", "missing", mock.Anything).Return(nil, ErrNotFound).Once()
+
+		_, err := service.UpdateLink(ctx, "", "", "missing", LinkUpdate{Folder: &folder})
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestCatalogService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("folders", func(t *testing.T) {
+		mockRepo := new(MockCatalogRepository)
+		service := NewCatalogService(mockRepo)
+		mockRepo.On("CreateFolder", "", "launch").Return(&repo.Folder{Name: "launch"}, nil).Once()
+		mockRepo.On("RenameFolder", "", "launch", "spring").Return(&repo.Folder{Name: "spring"}, nil).Once()
+		mockRepo.On("DeleteFolder", "", "spring").Return(nil).Once()
+
+		_, err := service.CreateFolder(ctx, "", "launch")
+		require.NoError(t, err)
+		_, err = service.RenameFolder(ctx, "", "launch", "spring")
+		require.NoError(t, err)
+		require.NoError(t, service.DeleteFolder(ctx, "", "spring"))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid names are rejected", func(t *testing.T) {
+		service := NewCatalogService(new(MockCatalogRepository))
+
+		_, err := service.CreateFolder(ctx, "", "Launch")
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+		_, err = service.RenameFolder(ctx, "", "launch", "")
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+		_, err = service.GetTagStats(ctx, "", "no spaces")
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+		assert.True(t, errors.Is(service.DeleteTag(ctx, "", ""), ErrInvalidInput))
+	})
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// maxClicksLimit bounds max_clicks; larger limits are better left unset
+const maxClicksLimit = 1_000_000
+
+// RecordClick counts a visit to the link with the given code, just before
+// its visitor is redirected, along with where the visitor is. For a link
+// with max_clicks this is the authoritative check: it returns ErrExpired
+// once the clicks are used up, even if ResolveRedirect saw one left.
+func (s *URLServiceImpl) RecordClick(ctx context.Context, domain, code string, click Click) error {
+	domain = normalizeHost(domain)
+	workspace, err := s.repo.RecordClick(ctx, domain, code, click)
+	if errors.Is(err, repo.ErrLimitReached) {
+		return fmt.Errorf("%w: click limit reached for code: %s", ErrExpired, code)
+	}
+	if err != nil {
+		return err
+	}
+	s.publishClick(ctx, workspace, domain, code, click)
+	return nil
+}
+
+// publishClick tells the event publisher about a counted visit if a
+// webhook of the link's workspace wants clicks. The link is looked up by
+// the publisher's background worker, so that the redirect does not wait.
+func (s *URLServiceImpl) publishClick(ctx context.Context, workspace, domain, code string, click Click) {
+	if s.config.Events == nil || !s.config.Events.Wants(ctx, workspace, EventLinkClicked) {
+		return
+	}
+	s.config.Events.Enqueue(LinkEvent{
+		Type:       EventLinkClicked,
+		Workspace:  workspace,
+		Click:      &click,
+		OccurredAt: s.now(),
+		LoadLink: func(ctx context.Context) (*ShortenResult, error) {
+			link, err := s.repo.GetLink(ctx, domain, code)
+			if err != nil {
+				return nil, err
+			}
+			return s.result(link), nil
+		},
+	})
+}
+
+// exhausted reports whether a link has used up its clicks
+func exhausted(link *repo.Link) bool {
+	return link.MaxClicks > 0 && link.Clicks >= int64(link.MaxClicks)
+}
+
+// validateMaxClicks checks a requested click limit, allowing zero for
+// unlimited
+func validateMaxClicks(maxClicks int) error {
+	if maxClicks < 0 || maxClicks > maxClicksLimit {
+		return &InputError{Field: "max_clicks", Reason: fmt.Sprintf("must be between 1 and %d, or 0 for unlimited", maxClicksLimit)}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+func TestClickLimitedLinks(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{})
+
+	t.Run("one-time link is created without dedupe", func(t *testing.T) {
+		mockRepo.On("StoreURL", "https://example.com/", mock.Anything).Return(nil).Once()
+
+		result, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com", MaxClicks: 1, Dedupe: true})
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.MaxClicks)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid limits are rejected", func(t *testing.T) {
+		for _, maxClicks := range []int{-1, maxClicksLimit + 1} {
+			_, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com", MaxClicks: maxClicks})
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr))
+			assert.Equal(t, "max_clicks", inputErr.Field)
+		}
+	})
+
+	t.Run("redirect reports the limit until it is used up", func(t *testing.T) {
+		mockRepo.On("GetLink", "", "left").Return(&repo.Link{Code: "left", OriginalURL: "https://example.com/", MaxClicks: 2, Clicks: 1}, nil).Once()
+		mockRepo.On("GetLink", "", "done").Return(&repo.Link{Code: "done", OriginalURL: "https://example.com/", MaxClicks: 2, Clicks: 2}, nil).Once()
+
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "left"})
+		require.NoError(t, err)
+		assert.True(t, redirect.Limited)
+
+		_, err = service.ResolveRedirect(ctx, RedirectRequest{Code: "done"})
+		assert.True(t, errors.Is(err, ErrExpired))
+	})
+
+	t.Run("exhausted clicks are reported as expired", func(t *testing.T) {
+		mockRepo.On("RecordClick", "", "left", Click{}).Return("", fmt.Errorf("%w for code: left", repo.ErrLimitReached)).Once()
+		mockRepo.On("RecordClick", "", "open", Click{}).Return("", nil).Once()
+
+		assert.True(t, errors.Is(service.RecordClick(ctx, "", "left", Click{}), ErrExpired))
+		assert.NoError(t, service.RecordClick(ctx, "", "open", Click{}))
+	})
+}
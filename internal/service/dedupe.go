@@ -0,0 +1,12 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// urlHash returns the digest stored in repo.Link.URLHash for a canonical URL
+func urlHash(canonicalURL string) string {
+	sum := sha256.Sum256([]byte(canonicalURL))
+	return hex.EncodeToString(sum[:])
+}
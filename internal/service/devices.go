@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/useragent"
+)
+
+const (
+	// maxDeviceRules bounds how many device rules a link may have
+	maxDeviceRules = 20
+	// maxDeepLinkLength bounds a deep link, in bytes
+	maxDeepLinkLength = 2048
+)
+
+// deepLinkSchemePattern matches a URI scheme as defined by RFC 3986
+var deepLinkSchemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+// blockedDeepLinkSchemes run code or read local content in the browser
+// rather than opening an app
+var blockedDeepLinkSchemes = map[string]bool{
+	"javascript": true,
+	"vbscript":   true,
+	"data":       true,
+	"file":       true,
+	"blob":       true,
+	"about":      true,
+}
+
+// DeviceRule sends visitors on some operating systems or device types to
+// their own destination
+type DeviceRule = repo.DeviceRule
+
+// isWebURL reports whether a rule destination is a web page rather than a
+// deep link into an app
+func isWebURL(rawURL string) bool {
+	scheme, _, found := strings.Cut(rawURL, ":")
+	scheme = strings.ToLower(scheme)
+	return found && (scheme == "http" || scheme == "https")
+}
+
+// normalizeDeviceRules checks a link's device rules and returns them with
+// condition names normalised and web destinations canonicalised. Every
+// rule needs at least one condition, since a rule matching everyone would
+// make the link's own destination unreachable.
+func (s *URLServiceImpl) normalizeDeviceRules(rules []DeviceRule) ([]DeviceRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxDeviceRules {
+		return nil, &InputError{Field: "device_rules", Reason: fmt.Sprintf("must have at most %d rules", maxDeviceRules)}
+	}
+
+	normalized := make([]DeviceRule, len(rules))
+	for i, rule := range rules {
+		field := fmt.Sprintf("device_rules[%d]", i)
+		if len(rule.OS) == 0 && len(rule.Device) == 0 {
+			return nil, &InputError{Field: field, Reason: "must match an os or device"}
+		}
+		out := DeviceRule{}
+		for _, name := range rule.OS {
+			os, err := useragent.ParseOS(name)
+			if err != nil {
+				return nil, &InputError{Field: field + ".os", Reason: err.Error()}
+			}
+			out.OS = append(out.OS, string(os))
+		}
+		for _, name := range rule.Device {
+			device, err := useragent.ParseDevice(name)
+			if err != nil {
+				return nil, &InputError{Field: field + ".device", Reason: err.Error()}
+			}
+			out.Device = append(out.Device, string(device))
+		}
+
+		target := strings.TrimSpace(rule.URL)
+		fallback := strings.TrimSpace(rule.FallbackURL)
+		if isWebURL(target) {
+			if fallback != "" {
+				return nil, &InputError{Field: field + ".fallback_url", Reason: "is only allowed with a deep link"}
+			}
+			canonical, err := s.canonicalizeRuleURL(field+".url", target)
+			if err != nil {
+				return nil, err
+			}
+			out.URL = canonical
+		} else {
+			deepLink, err := normalizeDeepLink(field+".url", target)
+			if err != nil {
+				return nil, err
+			}
+			out.URL = deepLink
+			if fallback != "" {
+				if !isWebURL(fallback) {
+					return nil, &InputError{Field: field + ".fallback_url", Reason: "must be an http or https URL"}
+				}
+				if out.FallbackURL, err = s.canonicalizeRuleURL(field+".fallback_url", fallback); err != nil {
+					return nil, err
+				}
+			}
+		}
+		normalized[i] = out
+	}
+	return normalized, nil
+}
+
+// canonicalizeRuleURL canonicalises a web destination in a device rule,
+// reporting problems against field
+func (s *URLServiceImpl) canonicalizeRuleURL(field, rawURL string) (string, error) {
+	canonical, err := s.canonicalizeURL(rawURL)
+	if err != nil {
+		reason := "malformed URL"
+		var urlErr *URLError
+		if errors.As(err, &urlErr) {
+			reason = urlErr.Reason
+		}
+		if errors.Is(err, ErrBlocked) {
+			return "", &URLError{URL: rawURL, Reason: field + ": " + reason, Err: ErrBlocked}
+		}
+		return "", &InputError{Field: field, Reason: reason}
+	}
+	return canonical, nil
+}
+
+// normalizeDeepLink checks a custom-scheme URL that opens an app. Schemes
+// that browsers handle themselves, such as javascript:, are refused.
+func normalizeDeepLink(field, rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", &InputError{Field: field, Reason: "is required"}
+	}
+	if len(rawURL) > maxDeepLinkLength {
+		return "", &InputError{Field: field, Reason: "is too long"}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return "", &InputError{Field: field, Reason: "must be an http, https or app URL"}
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !deepLinkSchemePattern.MatchString(scheme) || blockedDeepLinkSchemes[scheme] {
+		return "", &InputError{Field: field, Reason: fmt.Sprintf("scheme %q is not allowed", scheme)}
+	}
+	// The rest is kept as given; apps parse their links in their own ways
+	return scheme + rawURL[len(u.Scheme):], nil
+}
+
+// matchDeviceRule returns the first of rules matching the visitor's user
+// agent, or nil if none does
+func matchDeviceRule(rules []DeviceRule, userAgent string) *DeviceRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	client := useragent.Parse(userAgent)
+	for i := range rules {
+		if matchesAny(rules[i].OS, string(client.OS)) && matchesAny(rules[i].Device, string(client.Device)) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether value is among names; no names match any value
+func matchesAny(names []string, value string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if name == value {
+			return true
+		}
+	}
+	return false
+}
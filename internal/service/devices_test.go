@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36"
+	windowsUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+)
+
+func TestNormalizeDeviceRules(t *testing.T) {
+	s := NewURLService(new(MockURLRepository), Config{}).(*URLServiceImpl)
+
+	rules, err := s.normalizeDeviceRules([]DeviceRule{
+		{OS: []string{" iOS "}, URL: "MyApp://item/42", FallbackURL: "https://apps.apple.com/app/id1"},
+		{Device: []string{"Mobile", "tablet"}, URL: "https://m.example.com/"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []DeviceRule{
+		{OS: []string{"ios"}, URL: "myapp://item/42", FallbackURL: "https://apps.apple.com/app/id1"},
+		{Device: []string{"mobile", "tablet"}, URL: "https://m.example.com/"},
+	}, rules)
+
+	rules, err = s.normalizeDeviceRules(nil)
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+
+	tests := []struct {
+		name  string
+		rule  DeviceRule
+		field string
+	}{
+		{"no conditions", DeviceRule{URL: "https://example.com"}, "device_rules[0]"},
+		{"unknown os", DeviceRule{OS: []string{"beos"}, URL: "https://example.com"}, "device_rules[0].os"},
+		{"unknown device", DeviceRule{Device: []string{"watch"}, URL: "https://example.com"}, "device_rules[0].device"},
+		{"missing url", DeviceRule{OS: []string{"ios"}}, "device_rules[0].url"},
+		{"javascript", DeviceRule{OS: []string{"ios"}, URL: "javascript:alert(1)"}, "device_rules[0].url"},
+		{"data", DeviceRule{OS: []string{"ios"}, URL: "DATA:text/html,hi"}, "device_rules[0].url"},
+		{"relative", DeviceRule{OS: []string{"ios"}, URL: "/app"}, "device_rules[0].url"},
+		{"bad web url", DeviceRule{OS: []string{"ios"}, URL: "https://"}, "device_rules[0].url"},
+		{"fallback without deep link", DeviceRule{OS: []string{"ios"}, URL: "https://example.com", FallbackURL: "https://example.org"}, "device_rules[0].fallback_url"},
+		{"deep link fallback", DeviceRule{OS: []string{"ios"}, URL: "myapp://x", FallbackURL: "otherapp://y"}, "device_rules[0].fallback_url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.normalizeDeviceRules([]DeviceRule{tt.rule})
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), "%v", err)
+			assert.Equal(t, tt.field, inputErr.Field)
+		})
+	}
+
+	_, err = s.normalizeDeviceRules(make([]DeviceRule, maxDeviceRules+1))
+	assert.True(t, errors.Is(err, ErrInvalidInput))
+}
+
+func TestDeviceRules(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	// Links with device rules are never deduplicated, so StoreURL is used
+	mockRepo.On("StoreURL", "https://example.com/app", mock.Anything).Return(nil).Once()
+	result, err := service.ShortenURL(ctx, ShortenRequest{
+		URL:         "https://example.com/app",
+		Dedupe:      true,
+		DeviceRules: []DeviceRule{{OS: []string{"android"}, URL: "https://play.google.com/store/apps/details?id=com.example"}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, result.DeviceRules, 1)
+	mockRepo.AssertNotCalled(t, "FindOrStoreURL", mock.Anything, mock.Anything)
+
+	link := &repo.Link{
+		Code:        "app",
+		OriginalURL: "https://example.com/app",
+		PassQuery:   true,
+		DeviceRules: []DeviceRule{
+			{OS: []string{"ios"}, URL: "myapp://open", FallbackURL: "https://apps.apple.com/app/id1"},
+			{OS: []string{"android"}, URL: "https://play.google.com/store/apps/details?id=com.example"},
+			{Device: []string{"tablet"}, URL: "otherapp://open"},
+		},
+	}
+	mockRepo.On("GetLink", "", "app").Return(link, nil)
+
+	tests := []struct {
+		name      string
+		userAgent string
+		url       string
+		deepLink  string
+	}{
+		{"ios opens the app", iPhoneUA, "https://apps.apple.com/app/id1?ref=x", "myapp://open"},
+		{"android goes to the store", androidUA, "https://play.google.com/store/apps/details?id=com.example&ref=x", ""},
+		{"others go to the link", windowsUA, "https://example.com/app?ref=x", ""},
+		{"no user agent goes to the link", "", "https://example.com/app?ref=x", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "app", RawQuery: "ref=x", UserAgent: tt.userAgent})
+			require.NoError(t, err)
+			assert.Equal(t, tt.url, redirect.URL)
+			assert.Equal(t, tt.deepLink, redirect.DeepLink)
+			assert.True(t, redirect.DeviceAware)
+			assert.False(t, redirect.Cacheable())
+		})
+	}
+
+	t.Run("deep links without a fallback fall back to the link", func(t *testing.T) {
+		tabletUA := "Mozilla/5.0 (Tablet; rv:26.0) Gecko/26.0 Firefox/26.0"
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "app", UserAgent: tabletUA})
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/app", redirect.URL)
+		assert.Equal(t, "otherapp://open", redirect.DeepLink)
+	})
+}
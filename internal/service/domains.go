@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// hostPattern matches the hosts of branded domains: lowercase DNS names
+// with at least two labels
+var hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Domain is a branded short domain and its settings
+type Domain = repo.Domain
+
+// MissingLinkError is returned by ResolveRedirect for an unknown code on a
+// domain with its own 404 page. It wraps ErrNotFound.
+type MissingLinkError struct {
+	Code string
+	// NotFoundURL is where the visitor should be sent
+	NotFoundURL string
+}
+
+func (e *MissingLinkError) Error() string {
+	return fmt.Sprintf("%s for code: %s", ErrNotFound, e.Code)
+}
+
+func (e *MissingLinkError) Unwrap() error {
+	return ErrNotFound
+}
+
+// DomainService manages the branded domains links can be created on, in
+// addition to the default domain of BaseURL. Each domain belongs to a
+// workspace, and only that workspace's links can be created on it.
+type DomainService interface {
+	ListDomains(ctx context.Context, workspace string) ([]*Domain, error)
+	GetDomain(ctx context.Context, workspace, host string) (*Domain, error)
+	CreateDomain(ctx context.Context, domain *Domain) error
+	UpdateDomain(ctx context.Context, domain *Domain) error
+	DeleteDomain(ctx context.Context, workspace, host string) error
+}
+
+// DomainServiceImpl implements DomainService
+type DomainServiceImpl struct {
+	repo repo.DomainRepository
+	// defaultHost is the host of BaseURL, which cannot be registered
+	defaultHost string
+}
+
+// NewDomainService creates a new DomainService. baseURL is the default
+// domain's base URL.
+func NewDomainService(repo repo.DomainRepository, baseURL string) DomainService {
+	return &DomainServiceImpl{repo: repo, defaultHost: hostOf(baseURL)}
+}
+
+// ListDomains returns every branded domain of workspace, ordered by host,
+// with its link count
+func (s *DomainServiceImpl) ListDomains(ctx context.Context, workspace string) ([]*Domain, error) {
+	return s.repo.ListDomains(ctx, workspace)
+}
+
+// GetDomain retrieves a branded domain of workspace by host. Domains of
+// other workspaces are not found.
+func (s *DomainServiceImpl) GetDomain(ctx context.Context, workspace, host string) (*Domain, error) {
+	host = normalizeHost(host)
+	if err := validateHost(host); err != nil {
+		return nil, err
+	}
+	domain, err := s.repo.GetDomain(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if domain.Workspace != workspace {
+		return nil, fmt.Errorf("%w for domain: %s", ErrNotFound, host)
+	}
+	return domain, nil
+}
+
+// CreateDomain validates and registers a branded domain for
+// domain.Workspace. The host must already point at this server for its
+// links to work.
+func (s *DomainServiceImpl) CreateDomain(ctx context.Context, domain *Domain) error {
+	if err := s.prepareDomain(domain); err != nil {
+		return err
+	}
+	if domain.Host == s.defaultHost {
+		return &InputError{Field: "host", Reason: "is the default domain"}
+	}
+	return s.repo.CreateDomain(ctx, domain)
+}
+
+// UpdateDomain replaces the settings of a branded domain of
+// domain.Workspace. Links already created on it keep their redirect
+// status.
+func (s *DomainServiceImpl) UpdateDomain(ctx context.Context, domain *Domain) error {
+	if err := s.prepareDomain(domain); err != nil {
+		return err
+	}
+	return s.repo.UpdateDomain(ctx, domain)
+}
+
+// DeleteDomain removes a branded domain of workspace. Domains with links
+// cannot be deleted.
+func (s *DomainServiceImpl) DeleteDomain(ctx context.Context, workspace, host string) error {
+	host = normalizeHost(host)
+	if err := validateHost(host); err != nil {
+		return err
+	}
+	return s.repo.DeleteDomain(ctx, workspace, host)
+}
+
+// prepareDomain normalises and validates a domain's host and settings
+func (s *DomainServiceImpl) prepareDomain(domain *Domain) error {
+	domain.Host = normalizeHost(domain.Host)
+	if err := validateHost(domain.Host); err != nil {
+		return err
+	}
+	if err := validateRedirectStatus(domain.RedirectStatus); err != nil {
+		return err
+	}
+	domain.NotFoundURL = strings.TrimSpace(domain.NotFoundURL)
+	if domain.NotFoundURL != "" {
+		u, err := url.Parse(domain.NotFoundURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &InputError{Field: "not_found_url", Reason: "must be an absolute http or https URL"}
+		}
+	}
+	return nil
+}
+
+// linkDomain returns the domain a new link of workspace is created on: nil
+// for the default domain, which is named by "" or the host of BaseURL and
+// shared by every workspace. Other hosts must be registered to workspace.
+func (s *URLServiceImpl) linkDomain(ctx context.Context, workspace, host string) (*Domain, error) {
+	host = normalizeHost(host)
+	if host == "" || host == s.defaultHost {
+		return nil, nil
+	}
+	if s.config.Domains == nil || validateHost(host) != nil {
+		return nil, &InputError{Field: "domain", Reason: "is not a registered domain"}
+	}
+	domain, err := s.config.Domains.GetDomain(ctx, host)
+	if errors.Is(err, ErrNotFound) || (err == nil && domain.Workspace != workspace) {
+		return nil, &InputError{Field: "domain", Reason: "is not a registered domain"}
+	}
+	return domain, err
+}
+
+// visitedDomain returns the domain a visit to host is for: the registered
+// domain with that host, or nil for the default domain. Hosts that are not
+// registered, such as the server's own name, serve the default domain.
+func (s *URLServiceImpl) visitedDomain(ctx context.Context, host string) (*Domain, error) {
+	host = normalizeHost(host)
+	if host == "" || host == s.defaultHost || s.config.Domains == nil || validateHost(host) != nil {
+		return nil, nil
+	}
+	domain, err := s.config.Domains.GetDomain(ctx, host)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return domain, err
+}
+
+// shortURL builds the short URL for code on domain. Branded domains use
+// the scheme of BaseURL.
+func (s *URLServiceImpl) shortURL(domain, code string) string {
+	base := strings.TrimSuffix(s.config.BaseURL, "/")
+	if domain != "" {
+		scheme := "https"
+		if u, err := url.Parse(s.config.BaseURL); err == nil && u.Scheme != "" {
+			scheme = u.Scheme
+		}
+		base = scheme + "://" + domain
+	}
+	return fmt.Sprintf("%s/%s", base, code)
+}
+
+// normalizeHost lowercases a host and strips any port and trailing dot
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// hostOf returns the normalised host of rawURL, or "" if it has none
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return normalizeHost(u.Host)
+}
+
+// validateHost checks the host of a branded domain
+func validateHost(host string) error {
+	if len(host) > 253 || !hostPattern.MatchString(host) {
+		return &InputError{Field: "host", Reason: "must be a domain name such as go.example.com"}
+	}
+	return nil
+}
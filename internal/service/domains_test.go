@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+// MockDomainRepository is a mock implementation of repo.DomainRepository
+type MockDomainRepository struct {
+	mock.Mock
+}
+
+func (m *MockDomainRepository) ListDomains(ctx context.Context, workspace string) ([]*repo.Domain, error) {
+	args := m.Called(workspace)
+	domains, _ := args.Get(0).([]*repo.Domain)
+	return domains, args.Error(1)
+}
+
+func (m *MockDomainRepository) GetDomain(ctx context.Context, host string) (*repo.Domain, error) {
+	args := m.Called(host)
+	domain, _ := args.Get(0).(*repo.Domain)
+	return domain, args.Error(1)
+}
+
+func (m *MockDomainRepository) CreateDomain(ctx context.Context, domain *repo.Domain) error {
+	return m.Called(*domain).Error(0)
+}
+
+func (m *MockDomainRepository) UpdateDomain(ctx context.Context, domain *repo.Domain) error {
+	return m.Called(*domain).Error(0)
+}
+
+func (m *MockDomainRepository) DeleteDomain(ctx context.Context, workspace, host string) error {
+	return m.Called(workspace, host).Error(0)
+}
+
+func TestShortenURLOnDomain(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	domains := new(MockDomainRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Domains: domains})
+	ctx := context.Background()
+
+	domains.On("GetDomain", "go.example.com").Return(&repo.Domain{Host: "go.example.com", RedirectStatus: http.StatusMovedPermanently}, nil)
+	domains.On("GetDomain", "missing.example.com").Return(nil, fmt.Errorf("%w for domain: missing.example.com", repo.ErrNotFound))
+	mockRepo.On("StoreURL", "https://example.com/", "launch").Return(nil)
+
+	t.Run("short URL uses the domain and its defaults", func(t *testing.T) {
+		result, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Alias: "launch", Domain: " Go.Example.com "})
+		require.NoError(t, err)
+		assert.Equal(t, "go.example.com", result.Domain)
+		assert.Equal(t, "http://go.example.com/launch", result.ShortURL)
+		assert.Equal(t, http.StatusMovedPermanently, result.RedirectStatus)
+
+		result, err = service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Alias: "launch", Domain: "go.example.com", RedirectStatus: http.StatusFound})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusFound, result.RedirectStatus, "the link's own status wins")
+	})
+
+	t.Run("the default domain by name", func(t *testing.T) {
+		result, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Alias: "launch", Domain: "localhost:8080"})
+		require.NoError(t, err)
+		assert.Empty(t, result.Domain)
+		assert.Equal(t, "http://localhost:8080/launch", result.ShortURL)
+	})
+
+	t.Run("unregistered domains are rejected", func(t *testing.T) {
+		_, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Domain: "missing.example.com"})
+		var inputErr *InputError
+		require.True(t, errors.As(err, &inputErr))
+		assert.Equal(t, "domain", inputErr.Field)
+
+		_, err = NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080"}).
+			ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Domain: "go.example.com"})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "without a domain repository only the default domain exists")
+	})
+}
+
+func TestResolveRedirectByHost(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	domains := new(MockDomainRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://sho.rt", Domains: domains})
+	ctx := context.Background()
+
+	domains.On("GetDomain", "go.example.com").Return(&repo.Domain{Host: "go.example.com", NotFoundURL: "https://example.com/404"}, nil)
+	domains.On("GetDomain", "www.sho.rt").Return(nil, repo.ErrNotFound)
+
+	t.Run("codes are looked up on the visited domain", func(t *testing.T) {
+		mockRepo.On("GetLink", "go.example.com", "abc").Return(&repo.Link{Code: "abc", Domain: "go.example.com", OriginalURL: "https://example.com/branded"}, nil).Once()
+
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Host: "GO.example.com:443", Code: "abc"})
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/branded", redirect.URL)
+		assert.Equal(t, "go.example.com", redirect.Domain)
+	})
+
+	t.Run("other hosts serve the default domain", func(t *testing.T) {
+		mockRepo.On("GetLink", "", "abc").Return(&repo.Link{Code: "abc", OriginalURL: "https://example.com/default"}, nil).Twice()
+
+		for _, host := range []string{"sho.rt", "www.sho.rt"} {
+			redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Host: host, Code: "abc"})
+			require.NoError(t, err)
+			assert.Equal(t, "https://example.com/default", redirect.URL)
+			assert.Empty(t, redirect.Domain)
+		}
+		domains.AssertNumberOfCalls(t, "GetDomain", 2)
+	})
+
+	t.Run("unknown codes go to the domain's 404 page", func(t *testing.T) {
+		mockRepo.On("GetLink", "go.example.com", "nope").Return(nil, fmt.Errorf("%w for code: nope", ErrNotFound)).Once()
+
+		_, err := service.ResolveRedirect(ctx, RedirectRequest{Host: "go.example.com", Code: "nope"})
+		var missing *MissingLinkError
+		require.True(t, errors.As(err, &missing))
+		assert.Equal(t, "https://example.com/404", missing.NotFoundURL)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestDomainService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("hosts and settings are normalised", func(t *testing.T) {
+		mockRepo := new(MockDomainRepository)
+		service := NewDomainService(mockRepo, "https://sho.rt")
+		mockRepo.On("CreateDomain", repo.Domain{Host: "go.example.com", NotFoundURL: "https://example.com/404", RedirectStatus: 301}).Return(nil).Once()
+
+		require.NoError(t, service.CreateDomain(ctx, &Domain{Host: " Go.Example.COM. ", NotFoundURL: " https://example.com/404 ", RedirectStatus: 301}))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid domains are rejected", func(t *testing.T) {
+		service := NewDomainService(new(MockDomainRepository), "https://sho.rt")
+		for field, domain := range map[string]*Domain{
+			"host":            {Host: "not a host"},
+			"not_found_url":   {Host: "go.example.com", NotFoundURL: "/404"},
+			"redirect_status": {Host: "go.example.com", RedirectStatus: 200},
+		} {
+			err := service.CreateDomain(ctx, domain)
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), field)
+			assert.Equal(t, field, inputErr.Field)
+		}
+
+		err := service.CreateDomain(ctx, &Domain{Host: "sho.rt"})
+		assert.True(t, errors.Is(err, ErrInvalidInput), "the default domain cannot be registered")
+		assert.True(t, errors.Is(service.DeleteDomain(ctx, "", ""), ErrInvalidInput))
+	})
+}
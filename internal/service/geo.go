@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/urlshortener/internal/geoip"
+	"github.com/urlshortener/internal/repo"
+)
+
+// maxGeoRules bounds how many geo rules a link may have
+const maxGeoRules = 50
+
+// GeoRule sends visitors in some countries or continents to their own
+// destination
+type GeoRule = repo.GeoRule
+
+// Location is where a visitor is. Empty fields are unknown.
+type Location = geoip.Location
+
+// Click describes a visit being counted
+type Click = repo.Click
+
+// ClickStats summarises the visits to a link
+type ClickStats = repo.ClickStats
+
+// normalizeGeoRules checks a link's geo rules and returns them with codes
+// in upper case and destinations canonicalised
+func (s *URLServiceImpl) normalizeGeoRules(rules []GeoRule) ([]GeoRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxGeoRules {
+		return nil, &InputError{Field: "geo_rules", Reason: fmt.Sprintf("must have at most %d rules", maxGeoRules)}
+	}
+
+	normalized := make([]GeoRule, len(rules))
+	for i, rule := range rules {
+		field := fmt.Sprintf("geo_rules[%d]", i)
+		if len(rule.Countries) == 0 && len(rule.Continents) == 0 {
+			return nil, &InputError{Field: field, Reason: "must match a country or continent"}
+		}
+		out := GeoRule{}
+		for _, name := range rule.Countries {
+			country, err := geoip.ParseCountry(name)
+			if err != nil {
+				return nil, &InputError{Field: field + ".countries", Reason: err.Error()}
+			}
+			out.Countries = append(out.Countries, country)
+		}
+		for _, name := range rule.Continents {
+			continent, err := geoip.ParseContinent(name)
+			if err != nil {
+				return nil, &InputError{Field: field + ".continents", Reason: err.Error()}
+			}
+			out.Continents = append(out.Continents, continent)
+		}
+		target := strings.TrimSpace(rule.URL)
+		if target == "" {
+			return nil, &InputError{Field: field + ".url", Reason: "is required"}
+		}
+		canonical, err := s.canonicalizeRuleURL(field+".url", target)
+		if err != nil {
+			return nil, err
+		}
+		out.URL = canonical
+		normalized[i] = out
+	}
+	return normalized, nil
+}
+
+// matchGeoRule returns the first of rules matching the visitor's location,
+// or nil if none does. Visitors from unknown places match no rule.
+func matchGeoRule(rules []GeoRule, location Location) *GeoRule {
+	for i := range rules {
+		if (location.Country != "" && contains(rules[i].Countries, location.Country)) ||
+			(location.Continent != "" && contains(rules[i].Continents, location.Continent)) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClickStats returns the click statistics of the link of workspace
+// with code
+func (s *URLServiceImpl) GetClickStats(ctx context.Context, workspace, domain, code string) (*ClickStats, error) {
+	return s.repo.GetClickStats(ctx, workspace, normalizeHost(domain), code)
+}
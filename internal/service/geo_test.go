@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+func TestNormalizeGeoRules(t *testing.T) {
+	s := NewURLService(new(MockURLRepository), Config{}).(*URLServiceImpl)
+
+	rules, err := s.normalizeGeoRules([]GeoRule{
+		{Countries: []string{" de", "at"}, URL: "HTTPS://Shop.Example.com/de"},
+		{Continents: []string{"eu"}, URL: "https://shop.example.com/eu"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []GeoRule{
+		{Countries: []string{"DE", "AT"}, URL: "https://shop.example.com/de"},
+		{Continents: []string{"EU"}, URL: "https://shop.example.com/eu"},
+	}, rules)
+
+	rules, err = s.normalizeGeoRules(nil)
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+
+	tests := []struct {
+		name  string
+		rule  GeoRule
+		field string
+	}{
+		{"no conditions", GeoRule{URL: "https://example.com"}, "geo_rules[0]"},
+		{"bad country", GeoRule{Countries: []string{"DEU"}, URL: "https://example.com"}, "geo_rules[0].countries"},
+		{"unknown continent", GeoRule{Continents: []string{"XX"}, URL: "https://example.com"}, "geo_rules[0].continents"},
+		{"missing url", GeoRule{Countries: []string{"DE"}}, "geo_rules[0].url"},
+		{"deep link", GeoRule{Countries: []string{"DE"}, URL: "myapp://open"}, "geo_rules[0].url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.normalizeGeoRules([]GeoRule{tt.rule})
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), "%v", err)
+			assert.Equal(t, tt.field, inputErr.Field)
+		})
+	}
+
+	_, err = s.normalizeGeoRules(make([]GeoRule, maxGeoRules+1))
+	assert.True(t, errors.Is(err, ErrInvalidInput))
+}
+
+func TestGeoRules(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	// Links with geo rules are never deduplicated, so StoreURL is used
+	mockRepo.On("StoreURL", "https://shop.example.com/", mock.Anything).Return(nil).Once()
+	result, err := service.ShortenURL(ctx, ShortenRequest{
+		URL:      "https://shop.example.com/",
+		Dedupe:   true,
+		GeoRules: []GeoRule{{Countries: []string{"de"}, URL: "https://shop.example.de/"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []GeoRule{{Countries: []string{"DE"}, URL: "https://shop.example.de/"}}, result.GeoRules)
+	mockRepo.AssertNotCalled(t, "FindOrStoreURL", mock.Anything, mock.Anything)
+
+	link := &repo.Link{
+		Code:        "shop",
+		OriginalURL: "https://shop.example.com/",
+		DeviceRules: []DeviceRule{{OS: []string{"android"}, URL: "https://play.google.com/store/apps/details?id=com.example"}},
+		GeoRules: []GeoRule{
+			{Countries: []string{"DE", "AT"}, URL: "https://shop.example.de/"},
+			{Countries: []string{"GB"}, Continents: []string{"EU"}, URL: "https://shop.example.eu/"},
+		},
+	}
+	mockRepo.On("GetLink", "", "shop").Return(link, nil)
+
+	tests := []struct {
+		name      string
+		location  Location
+		userAgent string
+		url       string
+	}{
+		{"country", Location{Country: "AT", Continent: "EU"}, "", "https://shop.example.de/"},
+		{"continent", Location{Country: "FR", Continent: "EU"}, "", "https://shop.example.eu/"},
+		{"country without continent", Location{Country: "GB"}, "", "https://shop.example.eu/"},
+		{"no match", Location{Country: "US", Continent: "NA"}, "", "https://shop.example.com/"},
+		{"unknown location", Location{}, "", "https://shop.example.com/"},
+		{"device rules come first", Location{Country: "DE", Continent: "EU"}, androidUA, "https://play.google.com/store/apps/details?id=com.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "shop", Location: tt.location, UserAgent: tt.userAgent})
+			require.NoError(t, err)
+			assert.Equal(t, tt.url, redirect.URL)
+			assert.True(t, redirect.GeoAware)
+			assert.False(t, redirect.Cacheable())
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+const (
+	// maxIdempotencyKeyLength bounds the Idempotency-Key header
+	maxIdempotencyKeyLength = 255
+	// abandonedKeyAfter is how long a reserved key may wait for its response
+	// before it is assumed that the request died and the key is reclaimed
+	abandonedKeyAfter = time.Minute
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a
+	// different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+	// ErrIdempotencyKeyInUse is returned when the request that first used a
+	// key has not finished yet
+	ErrIdempotencyKeyInUse = errors.New("a request with this idempotency key is still in progress")
+)
+
+// StoredResponse is a response saved for replay
+type StoredResponse = repo.StoredResponse
+
+// IdempotencyClaim is a key reserved by Begin for one request. The time
+// it was reserved at tells this reservation apart from later ones of the
+// same key, so that a request whose key was taken over cannot complete or
+// release its new owner's.
+type IdempotencyClaim struct {
+	Workspace  string
+	Key        string
+	ReservedAt time.Time
+}
+
+// IdempotencyService makes retried requests safe by replaying the response
+// to the first request that used an Idempotency-Key. Keys belong to a
+// workspace, so one workspace never sees another's responses.
+type IdempotencyService interface {
+	// Begin claims key for a request identified by fingerprint. It returns
+	// the claim if the request should be processed, or the stored response
+	// to replay if the key was already used for the same request.
+	Begin(ctx context.Context, workspace, key, fingerprint string) (*IdempotencyClaim, *StoredResponse, error)
+	// Complete stores the response for a key claimed by Begin
+	Complete(ctx context.Context, claim *IdempotencyClaim, response StoredResponse) error
+	// Release frees a key claimed by Begin without storing a response
+	Release(ctx context.Context, claim *IdempotencyClaim) error
+}
+
+// IdempotencyServiceImpl implements IdempotencyService
+type IdempotencyServiceImpl struct {
+	repo repo.IdempotencyRepository
+	ttl  time.Duration
+	// now is the clock reservations are made with
+	now func() time.Time
+}
+
+// NewIdempotencyService creates a new IdempotencyService that remembers
+// responses for ttl
+func NewIdempotencyService(repo repo.IdempotencyRepository, ttl time.Duration) IdempotencyService {
+	return &IdempotencyServiceImpl{
+		repo: repo,
+		ttl:  ttl,
+		now:  time.Now,
+	}
+}
+
+// Begin claims key for a request identified by fingerprint. A key whose
+// request never finished is taken over once it is abandoned, by replacing
+// that exact reservation, so that of several retries finding it only one
+// goes ahead.
+func (s *IdempotencyServiceImpl) Begin(ctx context.Context, workspace, key, fingerprint string) (*IdempotencyClaim, *StoredResponse, error) {
+	if err := validateIdempotencyKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	claim := &IdempotencyClaim{Workspace: workspace, Key: key, ReservedAt: s.now().UTC()}
+	record, err := s.repo.ReserveIdempotencyKey(ctx, workspace, key, fingerprint, claim.ReservedAt, claim.ReservedAt.Add(-s.ttl))
+	if err != nil {
+		return nil, nil, err
+	}
+	if record == nil {
+		return claim, nil, nil
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, nil, ErrIdempotencyKeyReused
+	}
+	if record.Response != nil {
+		return nil, record.Response, nil
+	}
+
+	if claim.ReservedAt.Sub(record.CreatedAt) < abandonedKeyAfter {
+		return nil, nil, ErrIdempotencyKeyInUse
+	}
+	// The original request never completed; take the key over
+	taken, err := s.repo.ReclaimIdempotencyKey(ctx, workspace, key, fingerprint, record.CreatedAt, claim.ReservedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !taken {
+		// Another retry reclaimed it first
+		return nil, nil, ErrIdempotencyKeyInUse
+	}
+	return claim, nil, nil
+}
+
+// Complete stores the response for a key claimed by Begin. Nothing is
+// stored if the claim was taken over in the meantime.
+func (s *IdempotencyServiceImpl) Complete(ctx context.Context, claim *IdempotencyClaim, response StoredResponse) error {
+	return s.repo.CompleteIdempotencyKey(ctx, claim.Workspace, claim.Key, claim.ReservedAt, response)
+}
+
+// Release frees a key claimed by Begin without storing a response, unless
+// the claim was taken over in the meantime
+func (s *IdempotencyServiceImpl) Release(ctx context.Context, claim *IdempotencyClaim) error {
+	return s.repo.ReleaseIdempotencyKey(ctx, claim.Workspace, claim.Key, claim.ReservedAt)
+}
+
+// validateIdempotencyKey checks that key is 1-255 visible ASCII characters
+func validateIdempotencyKey(key string) error {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return &InputError{Field: "Idempotency-Key", Reason: "must be 1-255 characters"}
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return &InputError{Field: "Idempotency-Key", Reason: "must contain only visible ASCII characters"}
+		}
+	}
+	return nil
+}
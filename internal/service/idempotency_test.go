@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+// MockIdempotencyRepository is a mock implementation of repo.IdempotencyRepository
+type MockIdempotencyRepository struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyRepository) ReserveIdempotencyKey(ctx context.Context, workspace, key, fingerprint string, reservedAt, expiresBefore time.Time) (*repo.IdempotencyRecord, error) {
+	args := m.Called(workspace, key, fingerprint, reservedAt)
+	record, _ := args.Get(0).(*repo.IdempotencyRecord)
+	return record, args.Error(1)
+}
+
+func (m *MockIdempotencyRepository) ReclaimIdempotencyKey(ctx context.Context, workspace, key, fingerprint string, abandonedAt, reservedAt time.Time) (bool, error) {
+	args := m.Called(workspace, key, fingerprint, abandonedAt, reservedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockIdempotencyRepository) CompleteIdempotencyKey(ctx context.Context, workspace, key string, reservedAt time.Time, response repo.StoredResponse) error {
+	return m.Called(workspace, key, reservedAt, response).Error(0)
+}
+
+func (m *MockIdempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, workspace, key string, reservedAt time.Time) error {
+	return m.Called(workspace, key, reservedAt).Error(0)
+}
+
+func TestIdempotencyBegin(t *testing.T) {
+	ctx := context.Background()
+	stored := &repo.StoredResponse{StatusCode: 200, Body: []byte("{}")}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	newService := func(mockRepo *MockIdempotencyRepository) *IdempotencyServiceImpl {
+		svc := NewIdempotencyService(mockRepo, time.Hour).(*IdempotencyServiceImpl)
+		svc.now = func() time.Time { return now }
+		return svc
+	}
+
+	t.Run("new key", func(t *testing.T) {
+		mockRepo := new(MockIdempotencyRepository)
+		svc := newService(mockRepo)
+		mockRepo.On("ReserveIdempotencyKey", "", "k", "fp", now).Return(nil, nil).Once()
+
+		claim, response, err := svc.Begin(ctx, "", "k", "fp")
+		assert.NoError(t, err)
+		assert.Nil(t, response)
+		assert.Equal(t, &IdempotencyClaim{Key: "k", ReservedAt: now}, claim)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		mockRepo := new(MockIdempotencyRepository)
+		svc := newService(mockRepo)
+		mockRepo.On("ReserveIdempotencyKey", "", "k", "fp", now).Return(&repo.IdempotencyRecord{
+			Key: "k", Fingerprint: "fp", Response: stored, CreatedAt: now,
+		}, nil).Once()
+
+		claim, response, err := svc.Begin(ctx, "", "k", "fp")
+		assert.NoError(t, err)
+		assert.Nil(t, claim)
+		assert.Equal(t, stored, response)
+	})
+
+	t.Run("different request", func(t *testing.T) {
+		mockRepo := new(MockIdempotencyRepository)
+		svc := newService(mockRepo)
+		mockRepo.On("ReserveIdempotencyKey", "", "k", "other", now).Return(&repo.IdempotencyRecord{
+			Key: "k", Fingerprint: "fp", Response: stored, CreatedAt: now,
+		}, nil).Once()
+
+		_, _, err := svc.Begin(ctx, "", "k", "other")
+		assert.True(t, errors.Is(err, ErrIdempotencyKeyReused))
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		mockRepo := new(MockIdempotencyRepository)
+		svc := newService(mockRepo)
+		mockRepo.On("ReserveIdempotencyKey", "", "k", "fp", now).Return(&repo.IdempotencyRecord{
+			Key: "k", Fingerprint: "fp", CreatedAt: now,
+		}, nil).Once()
+
+		_, _, err := svc.Begin(ctx, "", "k", "fp")
+		assert.True(t, errors.Is(err, ErrIdempotencyKeyInUse))
+	})
+
+	t.Run("abandoned key is reclaimed", func(t *testing.T) {
+		mockRepo := new(MockIdempotencyRepository)
+		svc := newService(mockRepo)
+		abandonedAt := now.Add(-2 * abandonedKeyAfter)
+		mockRepo.On("ReserveIdempotencyKey", "", "k", "fp", now).Return(&repo.IdempotencyRecord{
+			Key: "k", Fingerprint: "fp", CreatedAt: abandonedAt,
+		}, nil).Once()
+		mockRepo.On("ReclaimIdempotencyKey", "", "k", "fp", abandonedAt, now).Return(true, nil).Once()
+
+		claim, response, err := svc.Begin(ctx, "", "k", "fp")
+		require.NoError(t, err)
+		assert.Nil(t, response)
+		assert.Equal(t, &IdempotencyClaim{Key: "k", ReservedAt: now}, claim)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("abandoned key reclaimed by another retry", func(t *testing.T) {
+		mockRepo := new(MockIdempotencyRepository)
+		svc := newService(mockRepo)
+		abandonedAt := now.Add(-2 * abandonedKeyAfter)
+		mockRepo.On("ReserveIdempotencyKey", "", "k", "fp", now).Return(&repo.IdempotencyRecord{
+			Key: "k", Fingerprint: "fp", CreatedAt: abandonedAt,
+		}, nil).Once()
+		mockRepo.On("ReclaimIdempotencyKey", "", "k", "fp", abandonedAt, now).Return(false, nil).Once()
+
+		claim, _, err := svc.Begin(ctx, "", "k", "fp")
+		assert.True(t, errors.Is(err, ErrIdempotencyKeyInUse))
+		assert.Nil(t, claim)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("complete and release touch only the claim", func(t *testing.T) {
+		mockRepo := new(MockIdempotencyRepository)
+		svc := newService(mockRepo)
+		claim := &IdempotencyClaim{Workspace: "acme", Key: "k", ReservedAt: now}
+		mockRepo.On("CompleteIdempotencyKey", "acme", "k", now, *stored).Return(nil).Once()
+		mockRepo.On("ReleaseIdempotencyKey", "acme", "k", now).Return(nil).Once()
+
+		require.NoError(t, svc.Complete(ctx, claim, *stored))
+		require.NoError(t, svc.Release(ctx, claim))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		svc := newService(new(MockIdempotencyRepository))
+		for _, key := range []string{"has space", strings.Repeat("k", 256), "naïve"} {
+			_, _, err := svc.Begin(ctx, "", key, "fp")
+			assert.True(t, errors.Is(err, ErrInvalidInput), key)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/metadata"
+	"github.com/urlshortener/internal/repo"
+)
+
+// LinkMetadata describes a link's destination page
+type LinkMetadata = repo.LinkMetadata
+
+// MetadataQueue accepts links whose destination should be fetched
+type MetadataQueue interface {
+	// Enqueue schedules a fetch of url for the link with code on domain.
+	// It never blocks, and reports false if the link was dropped because
+	// the queue is full.
+	Enqueue(domain, code, url string) bool
+}
+
+// MetadataFetcher downloads a page and extracts its metadata
+type MetadataFetcher interface {
+	Fetch(ctx context.Context, url string) (*metadata.Page, error)
+}
+
+// MetadataStore is the storage used by MetadataService
+type MetadataStore interface {
+	repo.MetadataRepository
+	GetLink(ctx context.Context, domain, code string) (*repo.Link, error)
+}
+
+// MetadataService fetches destination pages in the background and serves
+// what was found
+type MetadataService interface {
+	MetadataQueue
+	// GetMetadata returns the metadata of a link of workspace. FetchedAt is
+	// zero while the fetch is still pending.
+	GetMetadata(ctx context.Context, workspace, domain, code string) (*LinkMetadata, error)
+	// Run processes the queue with workers goroutines until ctx is done
+	Run(ctx context.Context, workers int)
+}
+
+// metadataJob is a queued fetch
+type metadataJob struct {
+	domain string
+	code   string
+	url    string
+}
+
+// MetadataServiceImpl implements MetadataService
+type MetadataServiceImpl struct {
+	repo    MetadataStore
+	fetcher MetadataFetcher
+	jobs    chan metadataJob
+	logger  *logrus.Logger
+}
+
+// NewMetadataService creates a new MetadataService holding up to queueSize
+// pending fetches. Nothing is fetched until Run is called.
+func NewMetadataService(repo MetadataStore, fetcher MetadataFetcher, queueSize int, logger *logrus.Logger) MetadataService {
+	return &MetadataServiceImpl{
+		repo:    repo,
+		fetcher: fetcher,
+		jobs:    make(chan metadataJob, max(queueSize, 1)),
+		logger:  logger,
+	}
+}
+
+// Enqueue schedules a fetch of url for the link with code on domain
+func (s *MetadataServiceImpl) Enqueue(domain, code, url string) bool {
+	select {
+	case s.jobs <- metadataJob{domain: domain, code: code, url: url}:
+		return true
+	default:
+		s.logger.WithField("code", code).Warn("Metadata queue is full; not fetching destination")
+		return false
+	}
+}
+
+// GetMetadata returns the metadata of a link of workspace. Links of other
+// workspaces report ErrNotFound. Password-protected links are never
+// fetched, and report ErrNotFound so as not to reveal anything about their
+// destination.
+func (s *MetadataServiceImpl) GetMetadata(ctx context.Context, workspace, domain, code string) (*LinkMetadata, error) {
+	domain = normalizeHost(domain)
+	meta, err := s.repo.GetMetadata(ctx, workspace, domain, code)
+	if err == nil {
+		return meta, nil
+	}
+	if !errors.Is(err, repo.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	link, err := s.repo.GetLink(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if link.Workspace != workspace || link.PasswordHash != "" {
+		return nil, fmt.Errorf("%w for metadata: %s", ErrNotFound, code)
+	}
+	return &LinkMetadata{}, nil
+}
+
+// Run processes the queue with workers goroutines until ctx is done
+func (s *MetadataServiceImpl) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.jobs:
+					s.fetch(ctx, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// fetch fetches and stores the metadata of one link. Failures are stored
+// as well, so that the link reports why it has no metadata.
+func (s *MetadataServiceImpl) fetch(ctx context.Context, job metadataJob) {
+	start := time.Now()
+	meta := &LinkMetadata{}
+	page, err := s.fetcher.Fetch(ctx, job.url)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Shutting down; the fetch did not really fail
+			return
+		}
+		meta.Error = err.Error()
+	} else {
+		meta.Title = page.Title
+		meta.Description = page.Description
+		meta.ImageURL = page.ImageURL
+	}
+
+	fields := logrus.Fields{"code": job.code, "duration_ms": time.Since(start).Milliseconds()}
+	if err := s.repo.SaveMetadata(ctx, job.domain, job.code, meta); err != nil {
+		s.logger.WithFields(fields).WithError(err).Error("Failed to save destination metadata")
+		return
+	}
+	if meta.Error != "" {
+		s.logger.WithFields(fields).WithField("reason", meta.Error).Info("Destination metadata fetch failed")
+		return
+	}
+	s.logger.WithFields(fields).Info("Destination metadata fetched")
+}
+
+// queueMetadata schedules a fetch of a newly stored link's destination.
+// Password-protected links are skipped, since their metadata would reveal
+// the destination to anyone with the code.
+func (s *URLServiceImpl) queueMetadata(link *repo.Link) {
+	if s.config.Metadata == nil || link.PasswordHash != "" {
+		return
+	}
+	s.config.Metadata.Enqueue(link.Domain, link.Code, link.OriginalURL)
+}
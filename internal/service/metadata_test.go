@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/metadata"
+	"github.com/urlshortener/internal/repo"
+)
+
+// MockMetadataStore is a mock implementation of MetadataStore
+type MockMetadataStore struct {
+	MockURLRepository
+	saved chan *LinkMetadata
+}
+
+func (m *MockMetadataStore) SaveMetadata(ctx context.Context, domain, code string, meta *LinkMetadata) error {
+	err := m.Called(domain, code).Error(0)
+	m.saved <- meta
+	return err
+}
+
+func (m *MockMetadataStore) GetMetadata(ctx context.Context, workspace, domain, code string) (*LinkMetadata, error) {
+	args := m.Called(workspace, domain, code)
+	meta, _ := args.Get(0).(*LinkMetadata)
+	return meta, args.Error(1)
+}
+
+// recordingQueue remembers what was enqueued
+type recordingQueue map[string]string
+
+func (q recordingQueue) Enqueue(domain, code, url string) bool {
+	q[domain+"/"+code] = url
+	return true
+}
+
+func TestShortenURLQueuesMetadata(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	queue := recordingQueue{}
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Metadata: queue})
+	ctx := context.Background()
+
+	mockRepo.On("StoreURL", mock.Anything, mock.Anything).Return(nil)
+	result, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/a"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a", queue["/"+result.Code])
+
+	protected, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/b", Password: "s3cret-pass"})
+	require.NoError(t, err)
+	assert.NotContains(t, queue, "/"+protected.Code)
+
+	mockRepo.On("FindOrStoreURL", mock.Anything, mock.Anything).
+		Return(&repo.Link{Code: "exist1", OriginalURL: "https://example.com/c"}, nil)
+	existing, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/c", Dedupe: true})
+	require.NoError(t, err)
+	assert.True(t, existing.Deduplicated)
+	assert.NotContains(t, queue, "exist1")
+
+	mockRepo.On("StoreURLs", mock.Anything).Return([]error{nil}, nil)
+	results, err := service.ShortenBatch(ctx, []ShortenRequest{{URL: "https://example.com/d"}})
+	require.NoError(t, err)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, "https://example.com/d", queue["/"+results[0].Result.Code])
+}
+
+func TestMetadataService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/article" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>An article</title><meta property="og:image" content="/card.png">`))
+	}))
+	defer server.Close()
+
+	store := &MockMetadataStore{saved: make(chan *LinkMetadata, 2)}
+	logger, _ := test.NewNullLogger()
+	fetcher := metadata.NewFetcher(metadata.Options{AllowPrivate: true, Timeout: 2 * time.Second})
+	service := NewMetadataService(store, fetcher, 10, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Run(ctx, 2)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	receive := func() *LinkMetadata {
+		select {
+		case meta := <-store.saved:
+			return meta
+		case <-time.After(5 * time.Second):
+			t.Fatal("metadata was not saved")
+			return nil
+		}
+	}
+
+	t.Run("fetched page is stored", func(t *testing.T) {
+		store.On("SaveMetadata", "", "page01").Return(nil).Once()
+		require.True(t, service.Enqueue("", "page01", server.URL+"/article"))
+		meta := receive()
+		assert.Equal(t, "An article", meta.Title)
+		assert.Equal(t, server.URL+"/card.png", meta.ImageURL)
+		assert.Empty(t, meta.Error)
+	})
+
+	t.Run("failures are stored", func(t *testing.T) {
+		store.On("SaveMetadata", "", "gone01").Return(nil).Once()
+		require.True(t, service.Enqueue("", "gone01", server.URL+"/missing"))
+		meta := receive()
+		assert.Empty(t, meta.Title)
+		assert.Contains(t, meta.Error, "404")
+	})
+
+	t.Run("get", func(t *testing.T) {
+		stored := &LinkMetadata{Title: "An article", FetchedAt: time.Now()}
+		store.On("GetMetadata", "", "", "page01").Return(stored, nil)
+		meta, err := service.GetMetadata(ctx, "", "", "page01")
+		require.NoError(t, err)
+		assert.Same(t, stored, meta)
+
+		// Known links without metadata are pending
+		store.On("GetMetadata", "", "", mock.Anything).Return(nil, repo.ErrNotFound)
+		store.On("GetLink", "", "wait01").Return(&repo.Link{Code: "wait01"}, nil)
+		meta, err = service.GetMetadata(ctx, "", "", "wait01")
+		require.NoError(t, err)
+		assert.True(t, meta.FetchedAt.IsZero())
+
+		store.On("GetLink", "", "lock01").Return(&repo.Link{Code: "lock01", PasswordHash: "hash"}, nil)
+		_, err = service.GetMetadata(ctx, "", "", "lock01")
+		assert.True(t, errors.Is(err, ErrNotFound))
+
+		// Links of other workspaces are not found
+		store.On("GetMetadata", "sales", "", "page01").Return(nil, repo.ErrNotFound)
+		store.On("GetLink", "", "page01").Return(&repo.Link{Code: "page01"}, nil)
+		_, err = service.GetMetadata(ctx, "sales", "", "page01")
+		assert.True(t, errors.Is(err, ErrNotFound))
+
+		store.On("GetLink", "", "nosuch").Return(nil, repo.ErrNotFound)
+		_, err = service.GetMetadata(ctx, "", "", "nosuch")
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestMetadataQueueFull(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	service := NewMetadataService(&MockMetadataStore{}, metadata.NewFetcher(metadata.Options{}), 1, logger)
+
+	assert.True(t, service.Enqueue("", "a", "https://example.com"))
+	assert.False(t, service.Enqueue("", "b", "https://example.com"))
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// passthrough builds the destination for a visit to link, appending the
+// visitor's path and query string to destination when the link forwards
+// them
+func passthrough(link *repo.Link, destination string, req RedirectRequest) (string, error) {
+	forwardPath := link.PassPath && req.Path != ""
+	forwardQuery := link.PassQuery && req.RawQuery != ""
+	if !forwardPath && !forwardQuery {
+		return destination, nil
+	}
+
+	dest, err := url.Parse(destination)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse destination for code %s: %w", link.Code, err)
+	}
+	if forwardPath {
+		if err := appendPath(dest, req.Path); err != nil {
+			return "", fmt.Errorf("%w for code: %s", ErrNotFound, link.Code)
+		}
+	}
+	if forwardQuery {
+		dest.RawQuery = mergeQuery(dest.RawQuery, req.RawQuery)
+	}
+	return dest.String(), nil
+}
+
+// appendPath joins the escaped extra path onto dest's path. Dot segments
+// are refused so a visitor cannot climb out of the destination's path.
+func appendPath(dest *url.URL, extra string) error {
+	for _, segment := range strings.Split(extra, "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return err
+		}
+		if unescaped == "." || unescaped == ".." || strings.ContainsAny(unescaped, "/\\") {
+			return fmt.Errorf("unsafe path segment %q", segment)
+		}
+	}
+
+	escaped := strings.TrimSuffix(dest.EscapedPath(), "/") + "/" + strings.TrimPrefix(extra, "/")
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil {
+		return err
+	}
+	dest.Path, dest.RawPath = unescaped, escaped
+	return nil
+}
+
+// mergeQuery appends the visitor's query parameters to the destination's.
+// Parameters already in the destination win: a visitor's value for the
+// same name is dropped rather than added alongside or replacing it, so
+// links that pin e.g. utm_campaign keep it. Both query strings are kept in
+// their original encoding and order.
+func mergeQuery(destQuery, visitorQuery string) string {
+	pinned := make(map[string]bool)
+	for _, pair := range strings.Split(destQuery, "&") {
+		if pair != "" {
+			pinned[queryName(pair)] = true
+		}
+	}
+
+	merged := destQuery
+	for _, pair := range strings.Split(visitorQuery, "&") {
+		if pair == "" || pinned[queryName(pair)] {
+			continue
+		}
+		if merged != "" {
+			merged += "&"
+		}
+		merged += pair
+	}
+	return merged
+}
+
+// queryName returns the decoded name of a raw name=value pair
+func queryName(pair string) string {
+	name, _, _ := strings.Cut(pair, "=")
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
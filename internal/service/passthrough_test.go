@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+func TestMergeQuery(t *testing.T) {
+	tests := []struct {
+		name, dest, visitor, want string
+	}{
+		{"no destination query", "", "utm_source=x", "utm_source=x"},
+		{"new parameters are appended", "a=1", "b=2&c=3", "a=1&b=2&c=3"},
+		{"destination parameters win", "utm_source=site&a=1", "utm_source=x&b=2", "utm_source=site&a=1&b=2"},
+		{"names compare decoded", "utm%5Fsource=site", "utm_source=x", "utm%5Fsource=site"},
+		{"repeated visitor parameters are kept", "", "id=1&id=2", "id=1&id=2"},
+		{"encoding is preserved", "q=a%20b", "r=c+d&&", "q=a%20b&r=c+d"},
+		{"flag without value is pinned", "debug", "debug=1", "debug"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, mergeQuery(tc.dest, tc.visitor))
+		})
+	}
+}
+
+func TestResolveRedirectPassthrough(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{})
+
+	link := &repo.Link{
+		Code:        "docs",
+		OriginalURL: "https://example.com/guide/?lang=en#top",
+		PassQuery:   true,
+		PassPath:    true,
+	}
+	plain := &repo.Link{Code: "plain", OriginalURL: "https://example.com/"}
+	mockRepo.On("GetLink", "", "docs").Return(link, nil)
+	mockRepo.On("GetLink", "", "plain").Return(plain, nil)
+
+	tests := []struct {
+		name    string
+		req     RedirectRequest
+		want    string
+		wantErr error
+	}{
+		{"nothing to forward", RedirectRequest{Code: "docs"}, "https://example.com/guide/?lang=en#top", nil},
+		{"query merged before fragment", RedirectRequest{Code: "docs", RawQuery: "lang=fr&utm_source=x"}, "https://example.com/guide/?lang=en&utm_source=x#top", nil},
+		{"path appended", RedirectRequest{Code: "docs", Path: "/install/linux"}, "https://example.com/guide/install/linux?lang=en#top", nil},
+		{"escaped path kept", RedirectRequest{Code: "docs", Path: "/a%20b/c%3Fd"}, "https://example.com/guide/a%20b/c%3Fd?lang=en#top", nil},
+		{"dot segments refused", RedirectRequest{Code: "docs", Path: "/../admin"}, "", ErrNotFound},
+		{"encoded dot segments refused", RedirectRequest{Code: "docs", Path: "/%2e%2e/admin"}, "", ErrNotFound},
+		{"encoded slash refused", RedirectRequest{Code: "docs", Path: "/a%2F..%2Fb"}, "", ErrNotFound},
+		{"query ignored without flag", RedirectRequest{Code: "plain", RawQuery: "utm_source=x"}, "https://example.com/", nil},
+		{"path not found without flag", RedirectRequest{Code: "plain", Path: "/extra"}, "", ErrNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			redirect, err := service.ResolveRedirect(ctx, tc.req)
+			if tc.wantErr != nil {
+				assert.True(t, errors.Is(err, tc.wantErr), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, redirect.URL)
+		})
+	}
+}
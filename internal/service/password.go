@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// minPasswordLength is the shortest password a link may have
+	minPasswordLength = 4
+	// maxPasswordLength is the longest password bcrypt can hash
+	maxPasswordLength = 72
+)
+
+// ErrPasswordMismatch is returned when a visitor enters the wrong password
+// for a protected link
+var ErrPasswordMismatch = errors.New("incorrect password")
+
+// UnlockLink checks a visitor's password for the link with the given code.
+// Open links accept any password.
+func (s *URLServiceImpl) UnlockLink(ctx context.Context, domain, code, password string) error {
+	link, err := s.repo.GetLink(ctx, normalizeHost(domain), code)
+	if err != nil {
+		return err
+	}
+	if link.PasswordHash == "" {
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return fmt.Errorf("failed to check password for code %s: %w", code, err)
+	}
+	return nil
+}
+
+// hashPassword validates a new link password and returns its bcrypt hash
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", &InputError{Field: "password", Reason: fmt.Sprintf("must be %d-%d bytes", minPasswordLength, maxPasswordLength)}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// validatePasswordHash checks that an imported hash is a bcrypt hash
+func validatePasswordHash(hash string) error {
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return &InputError{Field: "password_hash", Reason: "must be a bcrypt hash"}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestShortenURLWithPassword(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("protected links are never deduplicated", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		service := NewURLService(mockRepo, Config{})
+		mockRepo.On("StoreURL", "https://example.com/", mock.Anything).Return(nil).Once()
+
+		result, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com", Password: "hunter2", Dedupe: true})
+
+		require.NoError(t, err)
+		assert.True(t, result.PasswordProtected)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("password length is checked", func(t *testing.T) {
+		service := NewURLService(new(MockURLRepository), Config{})
+		for _, password := range []string{"abc", strings.Repeat("x", 73)} {
+			_, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com", Password: password})
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr))
+			assert.Equal(t, "password", inputErr.Field)
+		}
+	})
+}
+
+func TestUnlockLink(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{})
+
+	hash, err := hashPassword("hunter2")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("hunter2")))
+	mockRepo.On("GetLink", "", "secret").Return(&repo.Link{Code: "secret", PasswordHash: hash}, nil)
+	mockRepo.On("GetLink", "", "open").Return(&repo.Link{Code: "open"}, nil)
+
+	assert.NoError(t, service.UnlockLink(ctx, "", "secret", "hunter2"))
+	assert.True(t, errors.Is(service.UnlockLink(ctx, "", "secret", "hunter3"), ErrPasswordMismatch))
+	assert.NoError(t, service.UnlockLink(ctx, "", "open", ""))
+
+	redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "secret"})
+	require.NoError(t, err)
+	assert.True(t, redirect.Protected)
+}
@@ -0,0 +1,385 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/repo"
+)
+
+// Quota windows, as named in QuotaError and the quota metrics
+const (
+	QuotaWindowDay    = "day"
+	QuotaWindowMonth  = "month"
+	QuotaWindowActive = "active"
+)
+
+// ErrQuotaExceeded is returned when creating a link would exceed a quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota caps the links of a workspace, or of one of its members
+type Quota = repo.Quota
+
+// QuotaUsage counts the links a quota applies to
+type QuotaUsage = repo.QuotaUsage
+
+// QuotaError describes the quota limit new links would exceed. It wraps
+// ErrQuotaExceeded.
+type QuotaError struct {
+	// Member is the member whose quota is used up; empty for the quota of
+	// the whole workspace
+	Member string
+	// Window is QuotaWindowDay, QuotaWindowMonth or QuotaWindowActive
+	Window string
+	Limit  int64
+	// ResetAt is when the window starts over. It is zero for active links,
+	// which only free up as links end.
+	ResetAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrQuotaExceeded, e.Reason())
+}
+
+// Reason describes the limit that was reached
+func (e *QuotaError) Reason() string {
+	owner := "the workspace"
+	if e.Member != "" {
+		owner = e.Member
+	}
+	links := "links"
+	if e.Limit == 1 {
+		links = "link"
+	}
+	if e.Window == QuotaWindowActive {
+		return fmt.Sprintf("%s may have %d active %s", owner, e.Limit, links)
+	}
+	return fmt.Sprintf("%s may create %d %s per %s", owner, e.Limit, links, e.Window)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaReport is a quota with the usage measured against it. A workspace
+// or member without a quota is reported with zero limits.
+type QuotaReport struct {
+	Quota
+	Usage QuotaUsage
+	// DayResetsAt and MonthResetsAt are when the current day and month
+	// end. Days and months are counted in UTC.
+	DayResetsAt   time.Time
+	MonthResetsAt time.Time
+}
+
+// QuotaGate makes room for new links under quotas
+type QuotaGate interface {
+	// Reserve checks how many of n links member may create in workspace.
+	// The reservation must be released with Done once they are stored.
+	Reserve(ctx context.Context, workspace, member string, n int) (*QuotaReservation, error)
+}
+
+// QuotaReservation is room for links under the quotas of a workspace and
+// member. While it is held no other links are created under the same
+// quotas, so that concurrent requests cannot overshoot them together.
+// Callers should release it with a deferred Done as soon as the links are
+// stored.
+type QuotaReservation struct {
+	// Allowed is how many of the links asked for may be created
+	Allowed int
+	// Err is the *QuotaError stopping the rest; nil if all are allowed
+	Err  error
+	done func(created int)
+}
+
+// Done releases the reservation, reporting how many links were created
+func (r *QuotaReservation) Done(created int) {
+	if r.done != nil {
+		r.done(created)
+		r.done = nil
+	}
+}
+
+// QuotaService manages the quotas of workspaces and their members and
+// reports their usage
+type QuotaService interface {
+	QuotaGate
+	SaveQuota(ctx context.Context, quota *Quota) error
+	DeleteQuota(ctx context.Context, workspace, member string) error
+	GetUsage(ctx context.Context, workspace, member string) (*QuotaReport, error)
+	ListUsage(ctx context.Context, workspace string) ([]*QuotaReport, error)
+}
+
+// QuotaServiceImpl implements QuotaService
+type QuotaServiceImpl struct {
+	repo    repo.QuotaRepository
+	metrics *metrics.Metrics
+	// now is the clock usage windows are measured with
+	now func() time.Time
+	// mu guards locks, which holds the lock of each set of quotas that
+	// has reservations held or waiting
+	mu    sync.Mutex
+	locks map[quotaLockKey]*quotaLock
+}
+
+// quotaLockKey names the quotas a reservation holds: those of a whole
+// workspace, or those of one member of a workspace without a quota
+type quotaLockKey struct {
+	workspace, member string
+}
+
+// quotaLock serialises the reservations under one set of quotas
+type quotaLock struct {
+	// held has room for the token of the one reservation holding the lock,
+	// so that waiting for it can be given up with the request
+	held chan struct{}
+	// refs counts the reservations holding or waiting for the lock
+	refs int
+}
+
+// NewQuotaService creates a new QuotaService. Usage is published to
+// metrics unless it is nil.
+func NewQuotaService(repo repo.QuotaRepository, metrics *metrics.Metrics) QuotaService {
+	return &QuotaServiceImpl{repo: repo, metrics: metrics, now: time.Now, locks: make(map[quotaLockKey]*quotaLock)}
+}
+
+// SaveQuota validates and stores the quota of a workspace or member,
+// replacing any it had
+func (s *QuotaServiceImpl) SaveQuota(ctx context.Context, quota *Quota) error {
+	if quota.Workspace != "" {
+		if err := validateWorkspaceSlug("workspace", quota.Workspace); err != nil {
+			return err
+		}
+	}
+	if quota.Member != "" {
+		email, err := normalizeEmail(quota.Member)
+		if err != nil {
+			return err
+		}
+		quota.Member = email
+	}
+	for _, limit := range []struct {
+		field string
+		value int64
+	}{
+		{"links_per_day", quota.LinksPerDay}, {"links_per_month", quota.LinksPerMonth}, {"active_links", quota.ActiveLinks},
+	} {
+		if limit.value < 0 {
+			return &InputError{Field: limit.field, Reason: "must not be negative"}
+		}
+	}
+	if err := s.repo.SaveQuota(ctx, quota); err != nil {
+		return err
+	}
+	s.forget(quota.Workspace, quota.Member)
+	return nil
+}
+
+// DeleteQuota removes the quota of a workspace, or of one of its members
+// if member is set
+func (s *QuotaServiceImpl) DeleteQuota(ctx context.Context, workspace, member string) error {
+	if member != "" {
+		email, err := normalizeEmail(member)
+		if err != nil {
+			return err
+		}
+		member = email
+	}
+	if err := s.repo.DeleteQuota(ctx, workspace, member); err != nil {
+		return err
+	}
+	s.forget(workspace, member)
+	return nil
+}
+
+// GetUsage reports the quota of a workspace, or of one of its members if
+// member is set, with its usage
+func (s *QuotaServiceImpl) GetUsage(ctx context.Context, workspace, member string) (*QuotaReport, error) {
+	quota, err := s.repo.GetQuota(ctx, workspace, member)
+	if errors.Is(err, ErrNotFound) {
+		quota, err = &Quota{Workspace: workspace, Member: member}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.report(ctx, quota)
+}
+
+// ListUsage reports the quota of a workspace followed by those of its
+// members, each with its usage
+func (s *QuotaServiceImpl) ListUsage(ctx context.Context, workspace string) ([]*QuotaReport, error) {
+	quotas, err := s.repo.ListQuotas(ctx, workspace)
+	if err != nil {
+		return nil, err
+	}
+	if len(quotas) == 0 || quotas[0].Member != "" {
+		quotas = append([]*Quota{{Workspace: workspace}}, quotas...)
+	}
+	reports := make([]*QuotaReport, len(quotas))
+	for i, quota := range quotas {
+		if reports[i], err = s.report(ctx, quota); err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+// Reserve checks the quota of workspace and, if member is set, that of
+// member. Workspaces without quotas get an unlimited reservation that does
+// not hold back other requests. Reservations only wait for others in the
+// same workspace, or for the same member when only members have quotas.
+func (s *QuotaServiceImpl) Reserve(ctx context.Context, workspace, member string, n int) (*QuotaReservation, error) {
+	owners := []string{""}
+	if member != "" {
+		owners = append(owners, member)
+	}
+	var quotas []*Quota
+	for _, owner := range owners {
+		quota, err := s.repo.GetQuota(ctx, workspace, owner)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	if len(quotas) == 0 {
+		return &QuotaReservation{Allowed: n}, nil
+	}
+
+	// A member's links count against the workspace's quota, so while the
+	// workspace has one every reservation in it must take the same lock
+	key := quotaLockKey{workspace: workspace}
+	if quotas[0].Member != "" {
+		key.member = member
+	}
+	unlock, err := s.lock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	reservation := &QuotaReservation{Allowed: n}
+	reports := make([]*QuotaReport, len(quotas))
+	for i, quota := range quotas {
+		report, err := s.report(ctx, quota)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		reports[i] = report
+		for _, window := range report.windows() {
+			if remaining := window.limit - window.used; remaining < int64(reservation.Allowed) {
+				reservation.Allowed = int(max(remaining, 0))
+				reservation.Err = &QuotaError{Member: quota.Member, Window: window.name, Limit: window.limit, ResetAt: window.resetAt}
+			}
+		}
+	}
+	reservation.done = func(created int) {
+		defer unlock()
+		for _, report := range reports {
+			report.Usage.LinksToday += int64(created)
+			report.Usage.LinksThisMonth += int64(created)
+			report.Usage.ActiveLinks += int64(created)
+			s.observe(report)
+		}
+	}
+	return reservation, nil
+}
+
+// lock takes the lock of the quotas named by key and returns the function
+// releasing it. It gives up with ctx.Err() if ctx ends while waiting.
+func (s *QuotaServiceImpl) lock(ctx context.Context, key quotaLockKey) (func(), error) {
+	s.mu.Lock()
+	l := s.locks[key]
+	if l == nil {
+		l = &quotaLock{held: make(chan struct{}, 1)}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	forget := func() {
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, key)
+		}
+		s.mu.Unlock()
+	}
+	select {
+	case l.held <- struct{}{}:
+	case <-ctx.Done():
+		forget()
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-l.held
+		forget()
+	}, nil
+}
+
+// report measures the usage of quota now and publishes it
+func (s *QuotaServiceImpl) report(ctx context.Context, quota *Quota) (*QuotaReport, error) {
+	now := s.now().UTC()
+	periods := repo.UsagePeriods{
+		Day:   time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+		Month: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+		Now:   now,
+	}
+	usage, err := s.repo.GetQuotaUsage(ctx, quota.Workspace, quota.Member, periods)
+	if err != nil {
+		return nil, err
+	}
+	report := &QuotaReport{
+		Quota:         *quota,
+		Usage:         *usage,
+		DayResetsAt:   periods.Day.AddDate(0, 0, 1),
+		MonthResetsAt: periods.Month.AddDate(0, 1, 0),
+	}
+	s.observe(report)
+	return report, nil
+}
+
+// observe publishes the utilisation of a report's limited windows
+func (s *QuotaServiceImpl) observe(report *QuotaReport) {
+	if s.metrics == nil {
+		return
+	}
+	for _, window := range report.windows() {
+		s.metrics.RecordQuotaUsage(report.Workspace, report.Member, window.name, window.used, window.limit)
+	}
+}
+
+// forget drops the published usage of a quota that changed, since windows
+// it no longer limits would otherwise keep their last value
+func (s *QuotaServiceImpl) forget(workspace, member string) {
+	if s.metrics != nil {
+		s.metrics.ForgetQuota(workspace, member)
+	}
+}
+
+// quotaWindow is one limited window of a quota report
+type quotaWindow struct {
+	name    string
+	used    int64
+	limit   int64
+	resetAt time.Time
+}
+
+// windows lists the windows a report's quota limits
+func (r *QuotaReport) windows() []quotaWindow {
+	var windows []quotaWindow
+	for _, window := range []quotaWindow{
+		{QuotaWindowDay, r.Usage.LinksToday, r.LinksPerDay, r.DayResetsAt},
+		{QuotaWindowMonth, r.Usage.LinksThisMonth, r.LinksPerMonth, r.MonthResetsAt},
+		{QuotaWindowActive, r.Usage.ActiveLinks, r.ActiveLinks, time.Time{}},
+	} {
+		if window.limit > 0 {
+			windows = append(windows, window)
+		}
+	}
+	return windows
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/transfer"
+)
+
+// MockQuotaRepository is a mock implementation of repo.QuotaRepository
+type MockQuotaRepository struct {
+	mock.Mock
+}
+
+func (m *MockQuotaRepository) ListQuotas(ctx context.Context, workspace string) ([]*repo.Quota, error) {
+	args := m.Called(workspace)
+	quotas, _ := args.Get(0).([]*repo.Quota)
+	return quotas, args.Error(1)
+}
+
+func (m *MockQuotaRepository) GetQuota(ctx context.Context, workspace, member string) (*repo.Quota, error) {
+	args := m.Called(workspace, member)
+	quota, _ := args.Get(0).(*repo.Quota)
+	return quota, args.Error(1)
+}
+
+func (m *MockQuotaRepository) SaveQuota(ctx context.Context, quota *repo.Quota) error {
+	return m.Called(*quota).Error(0)
+}
+
+func (m *MockQuotaRepository) DeleteQuota(ctx context.Context, workspace, member string) error {
+	return m.Called(workspace, member).Error(0)
+}
+
+func (m *MockQuotaRepository) GetQuotaUsage(ctx context.Context, workspace, member string, periods repo.UsagePeriods) (*repo.QuotaUsage, error) {
+	args := m.Called(workspace, member, periods)
+	usage, _ := args.Get(0).(*repo.QuotaUsage)
+	return usage, args.Error(1)
+}
+
+// fixedQuotaGate allows a fixed number of links and records what was created
+type fixedQuotaGate struct {
+	allowed int
+	created int
+	// held counts the reservations not yet released
+	held int
+}
+
+func (g *fixedQuotaGate) Reserve(ctx context.Context, workspace, member string, n int) (*QuotaReservation, error) {
+	reservation := &QuotaReservation{Allowed: min(n, g.allowed)}
+	if reservation.Allowed < n {
+		reservation.Err = &QuotaError{Member: member, Window: QuotaWindowDay, Limit: int64(g.allowed)}
+	}
+	g.held++
+	reservation.done = func(created int) {
+		g.held--
+		g.allowed -= created
+		g.created += created
+	}
+	return reservation, nil
+}
+
+// noQuota is the error for a workspace without a quota
+func noQuota(workspace string) error {
+	return fmt.Errorf("%w for quota of workspace: %s", ErrNotFound, workspace)
+}
+
+func TestQuotaService(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	periods := repo.UsagePeriods{
+		Day:   time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC),
+		Month: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Now:   now,
+	}
+	newService := func(mockRepo *MockQuotaRepository) *QuotaServiceImpl {
+		service := NewQuotaService(mockRepo, nil).(*QuotaServiceImpl)
+		service.now = func() time.Time { return now }
+		return service
+	}
+
+	t.Run("without quotas everything is allowed", func(t *testing.T) {
+		mockRepo := new(MockQuotaRepository)
+		service := newService(mockRepo)
+		mockRepo.On("GetQuota", "marketing", "").Return(nil, noQuota("marketing"))
+		mockRepo.On("GetQuota", "marketing", "ann@example.com").Return(nil, noQuota("marketing"))
+
+		first, err := service.Reserve(ctx, "marketing", "ann@example.com", 5)
+		require.NoError(t, err)
+		assert.Equal(t, 5, first.Allowed)
+		// Unlimited reservations do not hold back others
+		second, err := service.Reserve(ctx, "marketing", "ann@example.com", 5)
+		require.NoError(t, err)
+		assert.Equal(t, 5, second.Allowed)
+		first.Done(5)
+		second.Done(5)
+		mockRepo.AssertNotCalled(t, "GetQuotaUsage", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("the tightest limit wins", func(t *testing.T) {
+		mockRepo := new(MockQuotaRepository)
+		service := newService(mockRepo)
+		mockRepo.On("GetQuota", "marketing", "").Return(&repo.Quota{Workspace: "marketing", LinksPerDay: 10}, nil)
+		mockRepo.On("GetQuota", "marketing", "ann@example.com").
+			Return(&repo.Quota{Workspace: "marketing", Member: "ann@example.com", LinksPerMonth: 50}, nil)
+		mockRepo.On("GetQuotaUsage", "marketing", "", periods).Return(&repo.QuotaUsage{LinksToday: 8}, nil)
+		mockRepo.On("GetQuotaUsage", "marketing", "ann@example.com", periods).Return(&repo.QuotaUsage{LinksThisMonth: 49}, nil)
+
+		reservation, err := service.Reserve(ctx, "marketing", "ann@example.com", 3)
+		require.NoError(t, err)
+		assert.Equal(t, 1, reservation.Allowed)
+		var quotaErr *QuotaError
+		require.True(t, errors.As(reservation.Err, &quotaErr))
+		assert.Equal(t, &QuotaError{Member: "ann@example.com", Window: QuotaWindowMonth, Limit: 50,
+			ResetAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}, quotaErr)
+		assert.True(t, errors.Is(reservation.Err, ErrQuotaExceeded))
+		reservation.Done(1)
+	})
+
+	t.Run("used up quotas allow nothing", func(t *testing.T) {
+		mockRepo := new(MockQuotaRepository)
+		service := newService(mockRepo)
+		mockRepo.On("GetQuota", "", "").Return(&repo.Quota{ActiveLinks: 100}, nil)
+		mockRepo.On("GetQuotaUsage", "", "", periods).Return(&repo.QuotaUsage{ActiveLinks: 120}, nil)
+
+		reservation, err := service.Reserve(ctx, "", "", 1)
+		require.NoError(t, err)
+		assert.Equal(t, 0, reservation.Allowed)
+		assert.EqualError(t, reservation.Err, "quota exceeded: the workspace may have 100 active links")
+		reservation.Done(0)
+	})
+
+	t.Run("reports without a quota have no limits", func(t *testing.T) {
+		mockRepo := new(MockQuotaRepository)
+		service := newService(mockRepo)
+		mockRepo.On("GetQuota", "marketing", "").Return(nil, noQuota("marketing"))
+		mockRepo.On("GetQuotaUsage", "marketing", "", periods).Return(&repo.QuotaUsage{LinksToday: 3}, nil)
+
+		report, err := service.GetUsage(ctx, "marketing", "")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), report.LinksPerDay)
+		assert.Equal(t, int64(3), report.Usage.LinksToday)
+		assert.Equal(t, time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC), report.DayResetsAt)
+	})
+
+	t.Run("reservations only wait within their workspace", func(t *testing.T) {
+		mockRepo := new(MockQuotaRepository)
+		service := newService(mockRepo)
+		for _, workspace := range []string{"marketing", "sales"} {
+			mockRepo.On("GetQuota", workspace, "").Return(&repo.Quota{Workspace: workspace, LinksPerDay: 10}, nil)
+			mockRepo.On("GetQuota", workspace, mock.Anything).Return(nil, noQuota(workspace))
+			mockRepo.On("GetQuotaUsage", workspace, "", periods).Return(&repo.QuotaUsage{}, nil)
+		}
+
+		held, err := service.Reserve(ctx, "marketing", "ann@example.com", 1)
+		require.NoError(t, err)
+
+		other, err := service.Reserve(ctx, "sales", "bob@example.com", 1)
+		require.NoError(t, err)
+		other.Done(1)
+
+		reserved := make(chan *QuotaReservation)
+		go func() {
+			reservation, _ := service.Reserve(ctx, "marketing", "cat@example.com", 1)
+			reserved <- reservation
+		}()
+		select {
+		case <-reserved:
+			t.Fatal("reservation under the same workspace quota did not wait")
+		case <-time.After(50 * time.Millisecond):
+		}
+		held.Done(1)
+		select {
+		case reservation := <-reserved:
+			reservation.Done(0)
+		case <-time.After(5 * time.Second):
+			t.Fatal("reservation was not granted once the other was released")
+		}
+		assert.Empty(t, service.locks)
+	})
+
+	t.Run("waiting reservations give up with their request", func(t *testing.T) {
+		mockRepo := new(MockQuotaRepository)
+		service := newService(mockRepo)
+		mockRepo.On("GetQuota", "marketing", "").Return(&repo.Quota{Workspace: "marketing", LinksPerDay: 10}, nil)
+		mockRepo.On("GetQuota", "marketing", mock.Anything).Return(nil, noQuota("marketing"))
+		mockRepo.On("GetQuotaUsage", "marketing", "", periods).Return(&repo.QuotaUsage{}, nil)
+
+		held, err := service.Reserve(ctx, "marketing", "ann@example.com", 1)
+		require.NoError(t, err)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = service.Reserve(waitCtx, "marketing", "bob@example.com", 1)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		held.Done(1)
+		assert.Empty(t, service.locks)
+		next, err := service.Reserve(ctx, "marketing", "bob@example.com", 1)
+		require.NoError(t, err)
+		next.Done(0)
+	})
+
+	t.Run("quotas are validated", func(t *testing.T) {
+		mockRepo := new(MockQuotaRepository)
+		service := newService(mockRepo)
+		mockRepo.On("SaveQuota", repo.Quota{Workspace: "marketing", Member: "ann@example.com", LinksPerDay: 5}).Return(nil).Once()
+
+		require.NoError(t, service.SaveQuota(ctx, &Quota{Workspace: "marketing", Member: " Ann@Example.com", LinksPerDay: 5}))
+		err := service.SaveQuota(ctx, &Quota{Workspace: "marketing", LinksPerMonth: -1})
+		var inputErr *InputError
+		require.True(t, errors.As(err, &inputErr))
+		assert.Equal(t, "links_per_month", inputErr.Field)
+		assert.True(t, errors.Is(service.SaveQuota(ctx, &Quota{Workspace: "Marketing"}), ErrInvalidInput))
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestShortenWithQuota(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("links beyond the quota are refused", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		gate := &fixedQuotaGate{allowed: 1}
+		service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Quotas: gate})
+		mockRepo.On("StoreURL", "https://example.com/", "first").Return(nil).Once()
+
+		_, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Alias: "first", Member: "ann@example.com"})
+		require.NoError(t, err)
+		_, err = service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Alias: "second", Member: "ann@example.com"})
+		assert.True(t, errors.Is(err, ErrQuotaExceeded))
+		assert.Equal(t, 1, gate.created)
+		assert.Zero(t, gate.held)
+		mockRepo.AssertNumberOfCalls(t, "StoreURL", 1)
+	})
+
+	t.Run("reservations are released if storing panics", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		gate := &fixedQuotaGate{allowed: 5}
+		service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Quotas: gate})
+		mockRepo.On("StoreURL", mock.Anything, mock.Anything).Run(func(mock.Arguments) { panic("driver bug") })
+		mockRepo.On("StoreURLs", mock.Anything).Run(func(mock.Arguments) { panic("driver bug") })
+
+		assert.Panics(t, func() {
+			service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/", Member: "ann@example.com"})
+		})
+		assert.Panics(t, func() {
+			service.ShortenBatch(ctx, []ShortenRequest{{URL: "https://example.com/", Member: "ann@example.com"}})
+		})
+		assert.Zero(t, gate.held)
+		assert.Zero(t, gate.created)
+	})
+
+	t.Run("batches fill the quota in order", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		gate := &fixedQuotaGate{allowed: 2}
+		service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Quotas: gate})
+		mockRepo.On("StoreURLs", mock.MatchedBy(func(links []*repo.Link) bool {
+			return len(links) == 2 && links[0].CreatedBy == "ann@example.com"
+		})).Return([]error{nil, nil}, nil).Once()
+
+		reqs := make([]ShortenRequest, 3)
+		for i := range reqs {
+			reqs[i] = ShortenRequest{URL: fmt.Sprintf("https://example.com/%d", i), Member: "ann@example.com"}
+		}
+		results, err := service.ShortenBatch(ctx, reqs)
+		require.NoError(t, err)
+		assert.NotNil(t, results[0].Result)
+		assert.NotNil(t, results[1].Result)
+		assert.True(t, errors.Is(results[2].Err, ErrQuotaExceeded))
+		assert.Equal(t, 2, gate.created)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("imports fill the workspace quota in order", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		gate := &fixedQuotaGate{allowed: 2}
+		service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Quotas: gate})
+		mockRepo.On("ImportLinks", mock.MatchedBy(func(links []*repo.Link) bool {
+			return len(links) == 2 && links[0].Code == "one" && links[1].Code == "two"
+		}), ConflictOverwrite, mock.Anything).Return([]repo.ImportResult{
+			{Code: "one", OriginalCode: "one", Outcome: repo.ImportCreated},
+			{Code: "two", OriginalCode: "two", Outcome: repo.ImportOverwritten},
+		}, nil)
+		input := "code,original_url\none,https://one.com\ntwo,https://two.com\nthree,https://three.com\n"
+		importLinks := func(dryRun bool) *ImportReport {
+			dec, err := transfer.NewDecoder(strings.NewReader(input), transfer.FormatCSV)
+			require.NoError(t, err)
+			report, err := service.ImportLinks(ctx, "marketing", dec, ImportOptions{Policy: ConflictOverwrite, DryRun: dryRun})
+			require.NoError(t, err)
+			return report
+		}
+
+		report := importLinks(true)
+		assert.Equal(t, 1, report.Failed)
+		assert.Zero(t, gate.created, "dry runs use none of the quota")
+
+		report = importLinks(false)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 1, report.Overwritten)
+		assert.Equal(t, 1, report.Failed)
+		last := report.Items[len(report.Items)-1]
+		assert.Equal(t, "three", last.Code)
+		assert.Equal(t, 4, last.Line)
+		assert.True(t, errors.Is(last.Err, ErrQuotaExceeded))
+		assert.Equal(t, 1, gate.created, "overwritten links are not new")
+		assert.Zero(t, gate.held)
+	})
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Safety summarises what can be told about a destination from its URL
+// alone, without visiting it
+type Safety struct {
+	// Warnings explain, in plain language, why the destination deserves
+	// a closer look
+	Warnings []string
+}
+
+// Safe reports whether nothing about the destination stood out
+func (s Safety) Safe() bool {
+	return len(s.Warnings) == 0
+}
+
+// CheckSafety inspects a destination URL for signs that it may not be
+// what it seems
+func CheckSafety(rawURL string) Safety {
+	var safety Safety
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		safety.Warnings = append(safety.Warnings, "The destination address could not be read.")
+		return safety
+	}
+
+	if u.Scheme != "https" {
+		safety.Warnings = append(safety.Warnings, "The destination does not use a secure (HTTPS) connection.")
+	}
+	if u.User != nil {
+		safety.Warnings = append(safety.Warnings, "The destination contains a username, which can disguise the real site.")
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		safety.Warnings = append(safety.Warnings, "The destination is a bare IP address rather than a domain name.")
+	}
+	for _, label := range strings.Split(host, ".") {
+		if strings.HasPrefix(label, "xn--") {
+			safety.Warnings = append(safety.Warnings, "The destination's domain uses international characters, which can imitate other domains.")
+			break
+		}
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		safety.Warnings = append(safety.Warnings, "The destination uses an unusual port ("+port+").")
+	}
+	return safety
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSafety(t *testing.T) {
+	for _, tc := range []struct {
+		url      string
+		warnings int
+	}{
+		{"https://example.com/docs", 0},
+		{"http://example.com/", 1},
+		{"https://user@example.com/", 1},
+		{"https://203.0.113.7/login", 1},
+		{"https://xn--pple-43d.com/", 1},
+		{"https://example.com:8443/", 1},
+		{"http://[2001:db8::1]:8080/", 3},
+	} {
+		safety := CheckSafety(tc.url)
+		assert.Len(t, safety.Warnings, tc.warnings, tc.url)
+		assert.Equal(t, tc.warnings == 0, safety.Safe(), tc.url)
+	}
+}
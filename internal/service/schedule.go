@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// checkWindow reports whether link may redirect to its destination at now.
+// Before ActiveFrom it returns ErrNotActive and from ActiveUntil on it
+// returns ErrExpired.
+func checkWindow(link *repo.Link, now time.Time) error {
+	if link.ActiveFrom != nil && now.Before(*link.ActiveFrom) {
+		return fmt.Errorf("%w for code: %s", ErrNotActive, link.Code)
+	}
+	if link.ActiveUntil != nil && !now.Before(*link.ActiveUntil) {
+		return fmt.Errorf("%w: active window ended for code: %s", ErrExpired, link.Code)
+	}
+	return nil
+}
+
+// fallbackRedirect sends a visitor outside link's active window to its
+// fallback destination. The redirect is always temporary, since the link
+// changes destination once the window opens or closes.
+func fallbackRedirect(link *repo.Link) *Redirect {
+	return &Redirect{
+		URL:       link.FallbackURL,
+		Status:    http.StatusFound,
+		Scheduled: true,
+		Fallback:  true,
+	}
+}
+
+// scheduled reports whether a link has an active window
+func scheduled(link *repo.Link) bool {
+	return link.ActiveFrom != nil || link.ActiveUntil != nil
+}
+
+// validateWindow checks that an active window is not empty and that a
+// fallback destination only comes with a window
+func validateWindow(from, until *time.Time, fallbackURL string) error {
+	if from != nil && until != nil && !until.After(*from) {
+		return &InputError{Field: "active_until", Reason: "must be after active_from"}
+	}
+	if fallbackURL != "" && from == nil && until == nil {
+		return &InputError{Field: "fallback_url", Reason: "requires active_from or active_until"}
+	}
+	return nil
+}
+
+// canonicalizeFallback validates a fallback destination like the main one,
+// reporting problems against the fallback_url field
+func (s *URLServiceImpl) canonicalizeFallback(rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", nil
+	}
+	canonical, err := s.canonicalizeURL(rawURL)
+	if err != nil {
+		reason := "malformed URL"
+		var urlErr *URLError
+		if errors.As(err, &urlErr) {
+			reason = urlErr.Reason
+		}
+		return "", &InputError{Field: "fallback_url", Reason: reason}
+	}
+	return canonical, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+func TestScheduledLinks(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{}).(*URLServiceImpl)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	from, until := now.Add(time.Hour), now.Add(48*time.Hour)
+
+	t.Run("window is stored without dedupe", func(t *testing.T) {
+		mockRepo.On("StoreURL", "https://example.com/launch", mock.Anything).Return(nil).Once()
+
+		result, err := service.ShortenURL(ctx, ShortenRequest{
+			URL:         "https://example.com/launch",
+			Dedupe:      true,
+			ActiveFrom:  &from,
+			ActiveUntil: &until,
+			FallbackURL: "HTTPS://Example.com/waitlist",
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, &from, result.ActiveFrom)
+		assert.Equal(t, &until, result.ActiveUntil)
+		assert.Equal(t, "https://example.com/waitlist", result.FallbackURL)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid windows are rejected", func(t *testing.T) {
+		past := now.Add(-time.Hour)
+		for _, tc := range []struct {
+			req   ShortenRequest
+			field string
+		}{
+			{ShortenRequest{ActiveFrom: &until, ActiveUntil: &from}, "active_until"},
+			{ShortenRequest{ActiveUntil: &past}, "active_until"},
+			{ShortenRequest{FallbackURL: "https://example.com/waitlist"}, "fallback_url"},
+			{ShortenRequest{ActiveFrom: &from, FallbackURL: "javascript:alert(1)"}, "fallback_url"},
+		} {
+			tc.req.URL = "https://example.com/launch"
+			_, err := service.ShortenURL(ctx, tc.req)
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), tc.field)
+			assert.Equal(t, tc.field, inputErr.Field)
+		}
+	})
+
+	t.Run("redirects only inside the window", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			from *time.Time
+			till *time.Time
+			want error
+		}{
+			{"before", &from, nil, ErrNotActive},
+			{"inside", nil, &until, nil},
+			{"after", nil, &now, ErrExpired},
+		} {
+			link := &repo.Link{Code: "launch", OriginalURL: "https://example.com/launch", ActiveFrom: tc.from, ActiveUntil: tc.till}
+			mockRepo.On("GetLink", "", "launch").Return(link, nil).Once()
+
+			redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "launch"})
+			if tc.want != nil {
+				assert.True(t, errors.Is(err, tc.want), tc.name)
+				continue
+			}
+			require.NoError(t, err, tc.name)
+			assert.Equal(t, "https://example.com/launch", redirect.URL)
+			assert.True(t, redirect.Scheduled)
+			assert.False(t, redirect.Fallback)
+			assert.False(t, redirect.Cacheable())
+		}
+	})
+
+	t.Run("fallback outside the window", func(t *testing.T) {
+		link := &repo.Link{
+			Code:           "launch",
+			OriginalURL:    "https://example.com/launch",
+			RedirectStatus: http.StatusMovedPermanently,
+			PasswordHash:   "$2a$10$x",
+			ActiveFrom:     &from,
+			FallbackURL:    "https://example.com/waitlist",
+		}
+		mockRepo.On("GetLink", "", "launch").Return(link, nil).Once()
+
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "launch"})
+
+		require.NoError(t, err)
+		assert.Equal(t, &Redirect{URL: "https://example.com/waitlist", Status: http.StatusFound, Scheduled: true, Fallback: true}, redirect)
+	})
+}
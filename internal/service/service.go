@@ -1,85 +1,731 @@
 package service
 
 import (
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math/big"
-	"net/url"
+	"net/http"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/security"
+	"github.com/urlshortener/internal/transfer"
+	"github.com/urlshortener/internal/urlcanon"
 )
 
-// URLService defines the interface for URL shortening operations
+const (
+	// codeLength is the length of generated codes
+	codeLength = 6
+	// maxCodeAttempts bounds retries when a generated code collides
+	maxCodeAttempts = 5
+	// maxTags bounds the number of tags on a single link
+	maxTags = 10
+)
+
+var (
+	aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+	tagPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+	// redirectStatuses are the statuses a link may redirect with
+	redirectStatuses = map[int]bool{
+		http.StatusMovedPermanently: true, http.StatusFound: true,
+		http.StatusTemporaryRedirect: true, http.StatusPermanentRedirect: true,
+	}
+
+	// reservedAliases would shadow fixed routes
+	reservedAliases = map[string]bool{
+		"api": true, "health": true, "metrics": true, "shorten": true,
+		"admin": true, "static": true,
+	}
+)
+
+var (
+	// ErrNotFound is returned when no URL exists for a code
+	ErrNotFound = repo.ErrNotFound
+	// ErrConflict is returned when a code is already in use
+	ErrConflict = repo.ErrConflict
+	// ErrInvalidURL is returned when a URL fails validation
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrBlocked is returned when a URL points at a blocked destination
+	ErrBlocked = errors.New("URL is blocked")
+	// ErrExpired is returned when a link exists but can no longer be followed
+	ErrExpired = errors.New("link has expired")
+	// ErrNotActive is returned when a link's active window has not opened yet
+	ErrNotActive = errors.New("link is not active yet")
+	// ErrInvalidInput is returned when a request field other than the URL is invalid
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// URLError describes why a URL was rejected. It wraps one of the
+// sentinel errors above so callers can match it with errors.Is.
+type URLError struct {
+	URL    string
+	Reason string
+	Err    error
+}
+
+func (e *URLError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.Reason)
+}
+
+func (e *URLError) Unwrap() error {
+	return e.Err
+}
+
+// InputError describes a rejected request field. It wraps ErrInvalidInput.
+type InputError struct {
+	Field  string
+	Reason string
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+func (e *InputError) Unwrap() error {
+	return ErrInvalidInput
+}
+
+// ShortenRequest describes a URL to shorten
+type ShortenRequest struct {
+	// Workspace is the workspace the link is created in; empty is the
+	// default workspace
+	Workspace string
+	// Member is the email of the member creating the link with their API
+	// key; empty otherwise. Their quota applies besides the workspace's.
+	Member string
+	URL    string
+	// Alias is an optional caller-chosen code
+	Alias string
+	Tags  []string
+	// Folder names the folder to put the link in, creating it if needed;
+	// empty leaves the link outside any folder
+	Folder string
+	// Domain is the host of a domain registered to Workspace to create
+	// the link on; empty uses the default domain of BaseURL, which every
+	// workspace shares. Codes are unique per domain.
+	Domain string
+	// Dedupe returns the existing link for the same destination, if any,
+	// instead of creating a new one. Only a link that redirects the same
+	// way, with the same RedirectStatus, PassQuery, PassPath and
+	// Interstitial, is returned. Dedupe is ignored when Alias, Password,
+	// MaxClicks, an active window, a social card, device or geo rules or
+	// variants are set, and by ShortenBatch.
+	Dedupe bool
+	// RedirectStatus is 301, 302, 307 or 308; zero uses the server default
+	RedirectStatus int
+	// PassQuery forwards the visitor's query string to the destination
+	PassQuery bool
+	// PassPath appends any path after the code to the destination path
+	PassPath bool
+	// UTM parameters are added to the destination, replacing any it
+	// already has for the same fields
+	UTM UTMParams
+	// UTMTemplate names a stored template as "team/name"; fields set in
+	// UTM take precedence over the template's
+	UTMTemplate string
+	// Password, if set, must be entered by visitors before they are
+	// redirected. Only its hash is stored.
+	Password string
+	// MaxClicks is how many visits the link allows before it returns 410
+	// Gone; 1 makes a one-time link and zero means unlimited
+	MaxClicks int
+	// ActiveFrom and ActiveUntil bound when the link redirects to URL;
+	// nil leaves that side open. Before ActiveFrom visitors get ErrNotActive
+	// and from ActiveUntil on ErrExpired.
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	// FallbackURL is where visitors outside the active window are sent
+	// instead of getting an error
+	FallbackURL string
+	// Interstitial shows visitors a preview of the destination before
+	// every redirect
+	Interstitial bool
+	// Social is shown to crawlers building link previews instead of the
+	// redirect; the zero value lets them follow it
+	Social SocialCard
+	// DeviceRules send visitors elsewhere depending on their operating
+	// system and device type; the first matching rule wins and visitors
+	// matching none go to URL
+	DeviceRules []DeviceRule
+	// GeoRules send visitors elsewhere depending on their country or
+	// continent; they are only checked when no device rule matched
+	GeoRules []GeoRule
+	// Variants split visitors matching no rule between several
+	// destinations by weight
+	Variants []Variant
+}
+
+// ShortenResult describes a newly created short link
+type ShortenResult struct {
+	Code        string
+	ShortURL    string
+	OriginalURL string
+	Tags        []string
+	Folder      string
+	// Domain is the host of the link's branded domain; empty for the
+	// default domain
+	Domain string
+	// Deduplicated is set when an existing link was returned
+	Deduplicated bool
+	// RedirectStatus is the status visitors are redirected with
+	RedirectStatus int
+	PassQuery      bool
+	PassPath       bool
+	// PasswordProtected is set when visitors must enter a password
+	PasswordProtected bool
+	MaxClicks         int
+	ActiveFrom        *time.Time
+	ActiveUntil       *time.Time
+	FallbackURL       string
+	Interstitial      bool
+	Social            SocialCard
+	DeviceRules       []DeviceRule
+	GeoRules          []GeoRule
+	Variants          []Variant
+}
+
+// RedirectRequest describes a visit to a short link
+type RedirectRequest struct {
+	// Host is the host the visitor asked for. Codes are looked up on the
+	// registered domain with that host, or on the default domain if there
+	// is none.
+	Host string
+	Code string
+	// Path is the escaped path that followed the code, starting with "/";
+	// empty when the visitor asked for the code alone
+	Path string
+	// RawQuery is the visitor's query string, without the "?"
+	RawQuery string
+	// UserAgent is matched against the link's device rules
+	UserAgent string
+	// Location is where the visitor is, matched against the link's geo
+	// rules; the zero value matches none
+	Location Location
+	// Variant is the variant of a split link the visitor was sent to
+	// before, if known
+	Variant string
+	// ClientID identifies the visitor, so that they are sent to the same
+	// variant of a split link each time; empty assigns them at random
+	ClientID string
+}
+
+// Redirect describes where a short link sends its visitors
+type Redirect struct {
+	URL    string
+	Status int
+	// Domain is the domain the link was found on, to be passed to
+	// RecordClick; empty for the default domain
+	Domain string
+	// Workspace is the workspace the link belongs to
+	Workspace string
+	// Protected is set when the visitor must unlock the link with its
+	// password before being redirected
+	Protected bool
+	// Limited is set when the link has a click limit, so every visit must
+	// be counted with RecordClick before redirecting
+	Limited bool
+	// Scheduled is set when the link has an active window, so where it
+	// redirects depends on the time of the visit
+	Scheduled bool
+	// Fallback is set when the visitor is outside the active window and is
+	// sent to the fallback destination. Such visits are not counted.
+	Fallback bool
+	// Interstitial is set when visitors must see a preview of the
+	// destination before being redirected
+	Interstitial bool
+	// CreatedAt is when the link was created
+	CreatedAt time.Time
+	// Social is what crawlers are shown instead of the redirect, if set
+	Social SocialCard
+	// DeviceAware is set when the link has device rules, so where it
+	// redirects depends on the visitor's user agent
+	DeviceAware bool
+	// GeoAware is set when the link has geo rules, so where it redirects
+	// depends on where the visitor is
+	GeoAware bool
+	// Split is set when the link has variants. Variant names the one the
+	// visitor was sent to, if any; it should be remembered for their next
+	// visit and counted with RecordClick.
+	Split   bool
+	Variant string
+	// DeepLink is set when the visitor matched a rule sending them to an
+	// app. It must be opened from a page; URL is where visitors without
+	// the app go.
+	DeepLink string
+}
+
+// Cacheable reports whether clients and proxies may reuse the redirect
+// for later visits
+func (r *Redirect) Cacheable() bool {
+	return !r.Protected && !r.Limited && !r.Scheduled && !r.DeviceAware && !r.GeoAware && !r.Split
+}
+
+// URLService defines the interface for URL shortening operations. Methods
+// taking a domain and code name the domain by host; empty means the
+// default domain. Methods taking a workspace only see that workspace's
+// links; the others serve visitors and find links of any workspace.
 type URLService interface {
-	ShortenURL(originalURL string) (string, string, error)
-	GetOriginalURL(code string) (string, error)
+	ShortenURL(ctx context.Context, req ShortenRequest) (*ShortenResult, error)
+	ShortenBatch(ctx context.Context, reqs []ShortenRequest) ([]BatchResult, error)
+	GetOriginalURL(ctx context.Context, domain, code string) (string, error)
+	ShortURL(ctx context.Context, host, code string) (string, error)
+	ResolveRedirect(ctx context.Context, req RedirectRequest) (*Redirect, error)
+	UnlockLink(ctx context.Context, domain, code, password string) error
+	RecordClick(ctx context.Context, domain, code string, click Click) error
+	GetClickStats(ctx context.Context, workspace, domain, code string) (*ClickStats, error)
+	SetVariantWeights(ctx context.Context, workspace, domain, code string, weights map[string]int) ([]Variant, error)
+	ListLinks(ctx context.Context, filter LinkFilter) (*LinkPage, error)
+	UpdateLink(ctx context.Context, workspace, domain, code string, update LinkUpdate) (*ShortenResult, error)
+	DeleteLink(ctx context.Context, workspace, domain, code string) (*ShortenResult, error)
+	ExportLinks(ctx context.Context, workspace string, enc transfer.Encoder) error
+	ImportLinks(ctx context.Context, workspace string, dec transfer.Decoder, opts ImportOptions) (*ImportReport, error)
+}
+
+// Config holds URLService settings
+type Config struct {
+	// BaseURL is prefixed to codes to build short URLs
+	BaseURL string
+	// Canonicalizer validates destination URLs and produces the form that
+	// is stored; nil uses urlcanon defaults
+	Canonicalizer *urlcanon.Canonicalizer
+	// Validator applies the blocked and allowed domains and the HTTPS
+	// requirement to canonical destinations; nil accepts any
+	Validator *security.URLValidator
+	// DefaultRedirectStatus applies to links without their own status;
+	// zero means 302 Found
+	DefaultRedirectStatus int
+	// UTMTemplates resolves ShortenRequest.UTMTemplate; nil means no
+	// templates exist
+	UTMTemplates repo.UTMTemplateRepository
+	// AlwaysInterstitial shows the preview page before every redirect,
+	// whatever the link's own setting
+	AlwaysInterstitial bool
+	// Metadata is sent each newly created link so that its destination
+	// page can be described; nil disables fetching
+	Metadata MetadataQueue
+	// Domains resolves the branded domains links may be created on; nil
+	// allows only the default domain
+	Domains repo.DomainRepository
+	// Quotas caps the links workspaces and members create; nil leaves
+	// them unlimited
+	Quotas QuotaGate
+	// Events is told when links are created, updated, deleted and
+	// clicked; nil publishes nothing
+	Events EventPublisher
 }
 
 // URLServiceImpl implements URLService
 type URLServiceImpl struct {
-	repo    repo.URLRepository
-	baseURL string
+	repo   repo.URLRepository
+	config Config
+	// now is the clock active windows are checked against
+	now func() time.Time
+	// defaultHost is the host of BaseURL
+	defaultHost string
 }
 
 // NewURLService creates a new URL service
-func NewURLService(repo repo.URLRepository, baseURL string) URLService {
+func NewURLService(repo repo.URLRepository, config Config) URLService {
+	if config.Canonicalizer == nil {
+		config.Canonicalizer = urlcanon.New(urlcanon.Options{})
+	}
+	if !redirectStatuses[config.DefaultRedirectStatus] {
+		config.DefaultRedirectStatus = http.StatusFound
+	}
 	return &URLServiceImpl{
-		repo:    repo,
-		baseURL: baseURL,
+		repo:        repo,
+		config:      config,
+		now:         time.Now,
+		defaultHost: hostOf(config.BaseURL),
+	}
+}
+
+// ShortenURL shortens a URL and returns the code and full short URL. A
+// *QuotaError is returned if the link would exceed a quota.
+func (s *URLServiceImpl) ShortenURL(ctx context.Context, req ShortenRequest) (*ShortenResult, error) {
+	link, err := s.prepareLink(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	found, err := s.storeLink(ctx, req, link)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		s.queueMetadata(link)
+		s.publish(ctx, EventLinkCreated, link, nil)
+	}
+	result := s.result(link)
+	result.Deduplicated = found
+	return result, nil
+}
+
+// storeLink stores a prepared link under the quotas of its creator,
+// regenerating the code on the rare collision. Caller-chosen aliases are
+// never regenerated. It reports whether an existing link was found
+// instead.
+func (s *URLServiceImpl) storeLink(ctx context.Context, req ShortenRequest, link *repo.Link) (bool, error) {
+	reservation, err := s.reserveQuota(ctx, req.Workspace, req.Member, 1)
+	if err != nil {
+		return false, err
+	}
+	created := 0
+	defer func() { reservation.Done(created) }()
+	if reservation.Allowed == 0 {
+		return false, reservation.Err
 	}
+
+	dedupe := req.Dedupe && req.Alias == "" && req.Password == "" && req.MaxClicks == 0 &&
+		req.ActiveFrom == nil && req.ActiveUntil == nil && req.Social.IsZero() &&
+		len(req.DeviceRules) == 0 && len(req.GeoRules) == 0 && len(req.Variants) == 0
+	found := false
+	for attempt := 1; ; attempt++ {
+		if dedupe {
+			found, err = s.repo.FindOrStoreURL(ctx, link)
+		} else {
+			err = s.repo.StoreURL(ctx, link)
+		}
+		if err == nil {
+			break
+		}
+		if req.Alias != "" || !errors.Is(err, ErrConflict) || attempt >= maxCodeAttempts {
+			return false, fmt.Errorf("failed to store URL: %w", err)
+		}
+		if link.Code, err = generateUniqueCode(codeLength); err != nil {
+			return false, fmt.Errorf("failed to generate code: %w", err)
+		}
+	}
+	if !found {
+		created = 1
+	}
+	return found, nil
 }
 
-// ShortenURL shortens a URL and returns the code and full short URL
-func (s *URLServiceImpl) ShortenURL(originalURL string) (string, string, error) {
-	// Validate URL
-	if err := validateURL(originalURL); err != nil {
-		return "", "", err
+// prepareLink validates a request and builds the link to store, generating
+// a code unless an alias was supplied
+func (s *URLServiceImpl) prepareLink(ctx context.Context, req ShortenRequest) (*repo.Link, error) {
+	originalURL, err := s.canonicalizeURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	// UTM parameters are added after canonicalisation so that they survive
+	// tracking-parameter stripping
+	if originalURL, err = s.applyUTM(ctx, originalURL, req); err != nil {
+		return nil, err
 	}
 
-	// Generate a unique code
-	code, err := generateUniqueCode(6)
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRedirectStatus(req.RedirectStatus); err != nil {
+		return nil, err
+	}
+	domain, err := s.linkDomain(ctx, req.Workspace, req.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMaxClicks(req.MaxClicks); err != nil {
+		return nil, err
+	}
+	if err := validateWindow(req.ActiveFrom, req.ActiveUntil, req.FallbackURL); err != nil {
+		return nil, err
+	}
+	if req.ActiveUntil != nil && !req.ActiveUntil.After(s.now()) {
+		return nil, &InputError{Field: "active_until", Reason: "must be in the future"}
+	}
+	fallbackURL, err := s.canonicalizeFallback(req.FallbackURL)
+	if err != nil {
+		return nil, err
+	}
+	social, err := normalizeSocialCard(req.Social)
+	if err != nil {
+		return nil, err
+	}
+	deviceRules, err := s.normalizeDeviceRules(req.DeviceRules)
+	if err != nil {
+		return nil, err
+	}
+	geoRules, err := s.normalizeGeoRules(req.GeoRules)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate code: %w", err)
+		return nil, err
+	}
+	variants, err := s.normalizeVariants(req.Variants)
+	if err != nil {
+		return nil, err
+	}
+	var passwordHash string
+	if req.Password != "" {
+		if passwordHash, err = hashPassword(req.Password); err != nil {
+			return nil, err
+		}
 	}
 
-	// Store the URL
-	if err := s.repo.StoreURL(originalURL, code); err != nil {
-		return "", "", fmt.Errorf("failed to store URL: %w", err)
+	code := req.Alias
+	if code != "" {
+		if err := validateAlias(code); err != nil {
+			return nil, err
+		}
+	} else if code, err = generateUniqueCode(codeLength); err != nil {
+		return nil, fmt.Errorf("failed to generate code: %w", err)
 	}
 
-	// Construct the short URL
-	shortURL := fmt.Sprintf("%s/%s", strings.TrimSuffix(s.baseURL, "/"), code)
+	link := &repo.Link{
+		Workspace:      req.Workspace,
+		CreatedBy:      req.Member,
+		Code:           code,
+		OriginalURL:    originalURL,
+		URLHash:        urlHash(originalURL),
+		Tags:           tags,
+		Folder:         folder,
+		RedirectStatus: req.RedirectStatus,
+		PassQuery:      req.PassQuery,
+		PassPath:       req.PassPath,
+		PasswordHash:   passwordHash,
+		MaxClicks:      req.MaxClicks,
+		ActiveFrom:     req.ActiveFrom,
+		ActiveUntil:    req.ActiveUntil,
+		FallbackURL:    fallbackURL,
+		Interstitial:   req.Interstitial,
+		Social:         social,
+		DeviceRules:    deviceRules,
+		GeoRules:       geoRules,
+		Variants:       variants,
+	}
+	// Links on a branded domain take its default redirect status
+	if domain != nil {
+		link.Domain = domain.Host
+		if link.RedirectStatus == 0 {
+			link.RedirectStatus = domain.RedirectStatus
+		}
+	}
+	return link, nil
+}
+
+// reserveQuota makes room for n links under the quotas of workspace and
+// member
+func (s *URLServiceImpl) reserveQuota(ctx context.Context, workspace, member string, n int) (*QuotaReservation, error) {
+	if s.config.Quotas == nil {
+		return &QuotaReservation{Allowed: n}, nil
+	}
+	return s.config.Quotas.Reserve(ctx, workspace, member, n)
+}
 
-	return code, shortURL, nil
+// result builds the response for a stored link
+func (s *URLServiceImpl) result(link *repo.Link) *ShortenResult {
+	return &ShortenResult{
+		Code:              link.Code,
+		ShortURL:          s.shortURL(link.Domain, link.Code),
+		OriginalURL:       link.OriginalURL,
+		Tags:              link.Tags,
+		Folder:            link.Folder,
+		Domain:            link.Domain,
+		RedirectStatus:    s.redirectStatus(link),
+		PassQuery:         link.PassQuery,
+		PassPath:          link.PassPath,
+		PasswordProtected: link.PasswordHash != "",
+		MaxClicks:         link.MaxClicks,
+		ActiveFrom:        link.ActiveFrom,
+		ActiveUntil:       link.ActiveUntil,
+		FallbackURL:       link.FallbackURL,
+		Interstitial:      link.Interstitial,
+		Social:            link.Social,
+		DeviceRules:       link.DeviceRules,
+		GeoRules:          link.GeoRules,
+		Variants:          link.Variants,
+	}
+}
+
+// redirectStatus returns the status link redirects with
+func (s *URLServiceImpl) redirectStatus(link *repo.Link) int {
+	if link.RedirectStatus != 0 {
+		return link.RedirectStatus
+	}
+	return s.config.DefaultRedirectStatus
 }
 
 // GetOriginalURL retrieves the original URL for a given code
-func (s *URLServiceImpl) GetOriginalURL(code string) (string, error) {
-	return s.repo.GetOriginalURL(code)
+func (s *URLServiceImpl) GetOriginalURL(ctx context.Context, domain, code string) (string, error) {
+	return s.repo.GetOriginalURL(ctx, normalizeHost(domain), code)
 }
 
-// validateURL checks if the provided URL is valid
-func validateURL(rawURL string) error {
-	// Add scheme if missing
-	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
-		rawURL = "http://" + rawURL
+// ShortURL returns the short URL of an existing link visited on host,
+// which is looked up like the Host of a RedirectRequest
+func (s *URLServiceImpl) ShortURL(ctx context.Context, host, code string) (string, error) {
+	var domain string
+	if visited, err := s.visitedDomain(ctx, host); err != nil {
+		return "", err
+	} else if visited != nil {
+		domain = visited.Host
 	}
+	if _, err := s.repo.GetOriginalURL(ctx, domain, code); err != nil {
+		return "", err
+	}
+	return s.shortURL(domain, code), nil
+}
 
-	// Parse URL
-	parsedURL, err := url.Parse(rawURL)
+// ResolveRedirect returns where a visit to a short link redirects to.
+// A path after the code is only accepted by links with PassPath set.
+// Outside the link's active window visitors go to its fallback, if any.
+// Device rules are matched against the visitor's user agent, then geo
+// rules against their location; visitors matching neither are split
+// between the link's variants, if any.
+// An unknown code on a domain with its own 404 page returns a
+// MissingLinkError.
+// ResolveRedirect does not count the visit; see RecordClick.
+func (s *URLServiceImpl) ResolveRedirect(ctx context.Context, req RedirectRequest) (*Redirect, error) {
+	domain, err := s.visitedDomain(ctx, req.Host)
+	if err != nil {
+		return nil, err
+	}
+	var host string
+	if domain != nil {
+		host = domain.Host
+	}
+	link, err := s.repo.GetLink(ctx, host, req.Code)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) && domain != nil && domain.NotFoundURL != "" {
+			return nil, &MissingLinkError{Code: req.Code, NotFoundURL: domain.NotFoundURL}
+		}
+		return nil, err
+	}
+	redirect, err := s.resolve(link, req)
 	if err != nil {
-		return fmt.Errorf("invalid URL: %w", err)
+		return nil, err
 	}
+	redirect.Domain = link.Domain
+	redirect.Workspace = link.Workspace
+	redirect.Interstitial = link.Interstitial || s.config.AlwaysInterstitial
+	redirect.CreatedAt = link.CreatedAt
+	redirect.Social = link.Social
+	return redirect, nil
+}
+
+// resolve works out where a visit to link goes
+func (s *URLServiceImpl) resolve(link *repo.Link, req RedirectRequest) (*Redirect, error) {
+	if err := checkWindow(link, s.now()); err != nil {
+		if link.FallbackURL != "" {
+			return fallbackRedirect(link), nil
+		}
+		return nil, err
+	}
+	if exhausted(link) {
+		return nil, fmt.Errorf("%w: click limit reached for code: %s", ErrExpired, req.Code)
+	}
+	if req.Path != "" && !link.PassPath {
+		return nil, fmt.Errorf("%w for code: %s", ErrNotFound, req.Code)
+	}
+
+	// A matching rule replaces the destination; a deep link must be opened
+	// from a page, which falls back to the web destination
+	destination, deepLink, variant := link.OriginalURL, "", ""
+	if rule := matchDeviceRule(link.DeviceRules, req.UserAgent); rule != nil {
+		if isWebURL(rule.URL) {
+			destination = rule.URL
+		} else {
+			deepLink = rule.URL
+			if rule.FallbackURL != "" {
+				destination = rule.FallbackURL
+			}
+		}
+	} else if rule := matchGeoRule(link.GeoRules, req.Location); rule != nil {
+		destination = rule.URL
+	} else if picked := pickVariant(link, req); picked != nil {
+		destination, variant = picked.URL, picked.Name
+	}
+
+	target, err := passthrough(link, destination, req)
+	if err != nil {
+		return nil, err
+	}
+	return &Redirect{
+		URL:         target,
+		Status:      s.redirectStatus(link),
+		Protected:   link.PasswordHash != "",
+		Limited:     link.MaxClicks > 0,
+		Scheduled:   scheduled(link),
+		DeviceAware: len(link.DeviceRules) > 0,
+		GeoAware:    len(link.GeoRules) > 0,
+		Split:       len(link.Variants) > 0,
+		Variant:     variant,
+		DeepLink:    deepLink,
+	}, nil
+}
+
+// canonicalizeURL validates rawURL and returns the form to store
+func (s *URLServiceImpl) canonicalizeURL(rawURL string) (string, error) {
+	canonical, err := s.config.Canonicalizer.Canonicalize(rawURL)
+	if err != nil {
+		reason := "malformed URL"
+		var canonErr *urlcanon.Error
+		if errors.As(err, &canonErr) {
+			reason = canonErr.Reason
+		}
+		return "", &URLError{URL: rawURL, Reason: reason, Err: ErrInvalidURL}
+	}
+	if s.config.Validator != nil {
+		if err := s.config.Validator.Check(canonical); err != nil {
+			sentinel := ErrInvalidURL
+			if errors.Is(err, security.ErrDomainBlocked) || errors.Is(err, security.ErrDomainNotAllowed) {
+				sentinel = ErrBlocked
+			}
+			return "", &URLError{URL: rawURL, Reason: err.Error(), Err: sentinel}
+		}
+	}
+	return canonical, nil
+}
 
-	// Check if URL has a host
-	if parsedURL.Host == "" {
-		return fmt.Errorf("invalid URL: missing host")
+// validateRedirectStatus checks a requested redirect status, allowing
+// zero for the server default
+func validateRedirectStatus(status int) error {
+	if status != 0 && !redirectStatuses[status] {
+		return &InputError{Field: "redirect_status", Reason: "must be 301, 302, 307 or 308"}
 	}
+	return nil
+}
 
+// validateAlias checks that a caller-chosen code is usable
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return &InputError{Field: "alias", Reason: "must be 3-64 letters, digits, '-' or '_'"}
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return &InputError{Field: "alias", Reason: "is reserved"}
+	}
 	return nil
 }
 
+// normalizeTags lowercases, validates and de-duplicates tags
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, &InputError{Field: "tags", Reason: fmt.Sprintf("at most %d tags are allowed", maxTags)}
+	}
+
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, &InputError{Field: "tags", Reason: fmt.Sprintf("%q must be 1-32 lowercase letters, digits, '-' or '_'", tag)}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
 // generateUniqueCode generates a random alphanumeric code
 func generateUniqueCode(length int) (string, error) {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -95,4 +741,4 @@ func generateUniqueCode(length int) (string, error) {
 	}
 
 	return string(result), nil
-}
\ No newline at end of file
+}
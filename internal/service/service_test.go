@@ -1,10 +1,19 @@
 package service
 
 import (
-	"testing"
-	"strings"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/security"
+	"github.com/urlshortener/internal/transfer"
+	"github.com/urlshortener/internal/urlcanon"
+	"strings"
+	"testing"
+	"time"
 )
 
 // MockURLRepository is a mock implementation of URLRepository
@@ -12,16 +21,83 @@ type MockURLRepository struct {
 	mock.Mock
 }
 
-func (m *MockURLRepository) StoreURL(originalURL, code string) error {
-	args := m.Called(originalURL, code)
+func (m *MockURLRepository) StoreURL(ctx context.Context, link *repo.Link) error {
+	args := m.Called(link.OriginalURL, link.Code)
 	return args.Error(0)
 }
 
-func (m *MockURLRepository) GetOriginalURL(code string) (string, error) {
-	args := m.Called(code)
+func (m *MockURLRepository) StoreURLs(ctx context.Context, links []*repo.Link) ([]error, error) {
+	args := m.Called(links)
+	itemErrs, _ := args.Get(0).([]error)
+	return itemErrs, args.Error(1)
+}
+
+func (m *MockURLRepository) FindOrStoreURL(ctx context.Context, link *repo.Link) (bool, error) {
+	args := m.Called(link.URLHash, link.Code)
+	if existing, ok := args.Get(0).(*repo.Link); ok {
+		*link = *existing
+		return true, args.Error(1)
+	}
+	return false, args.Error(1)
+}
+
+func (m *MockURLRepository) GetOriginalURL(ctx context.Context, domain, code string) (string, error) {
+	args := m.Called(domain, code)
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockURLRepository) GetLink(ctx context.Context, domain, code string) (*repo.Link, error) {
+	args := m.Called(domain, code)
+	link, _ := args.Get(0).(*repo.Link)
+	return link, args.Error(1)
+}
+
+func (m *MockURLRepository) RecordClick(ctx context.Context, domain, code string, click repo.Click) (string, error) {
+	args := m.Called(domain, code, click)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockURLRepository) SetVariantWeights(ctx context.Context, workspace, domain, code string, weights map[string]int) ([]repo.Variant, error) {
+	args := m.Called(workspace, domain, code, weights)
+	variants, _ := args.Get(0).([]repo.Variant)
+	return variants, args.Error(1)
+}
+
+func (m *MockURLRepository) ListLinks(ctx context.Context, filter repo.LinkFilter) ([]*repo.Link, error) {
+	args := m.Called(filter)
+	links, _ := args.Get(0).([]*repo.Link)
+	return links, args.Error(1)
+}
+
+func (m *MockURLRepository) UpdateLink(ctx context.Context, workspace, domain, code string, update repo.LinkUpdate) (*repo.Link, error) {
+	args := m.Called(workspace, domain, code, update)
+	link, _ := args.Get(0).(*repo.Link)
+	return link, args.Error(1)
+}
+
+func (m *MockURLRepository) DeleteLink(ctx context.Context, workspace, domain, code string) (*repo.Link, error) {
+	args := m.Called(workspace, domain, code)
+	link, _ := args.Get(0).(*repo.Link)
+	return link, args.Error(1)
+}
+
+func (m *MockURLRepository) GetClickStats(ctx context.Context, workspace, domain, code string) (*repo.ClickStats, error) {
+	args := m.Called(workspace, domain, code)
+	stats, _ := args.Get(0).(*repo.ClickStats)
+	return stats, args.Error(1)
+}
+
+func (m *MockURLRepository) ExportLinks(ctx context.Context, workspace string, fn func(*repo.Link) error) error {
+	args := m.Called(workspace, fn)
+	return args.Error(0)
+}
+
+func (m *MockURLRepository) ImportLinks(ctx context.Context, links []*repo.Link, opts repo.ImportOptions) ([]repo.ImportResult, error) {
+	args := m.Called(links, opts.Policy, opts.DryRun)
+	results, _ := args.Get(0).([]repo.ImportResult)
+	return results, args.Error(1)
+}
+
 func (m *MockURLRepository) Close() error {
 	args := m.Called()
 	return args.Error(0)
@@ -31,18 +107,18 @@ func (m *MockURLRepository) Close() error {
 
 func TestCodeGeneration(t *testing.T) {
 	mockRepo := new(MockURLRepository)
-	service := NewURLService(mockRepo, "http://localhost:8081")
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081"})
 
 	// Test that code generation produces valid codes through ShortenURL
-	mockRepo.On("StoreURL", "example.com", mock.AnythingOfType("string")).Return(nil).Once()
-	
-	code, shortURL, err := service.ShortenURL("example.com")
-	
+	mockRepo.On("StoreURL", "http://example.com/", mock.AnythingOfType("string")).Return(nil).Once()
+
+	result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "example.com"})
+
 	assert.NoError(t, err)
-	assert.Len(t, code, 6)
-	assert.Contains(t, shortURL, code)
+	assert.Len(t, result.Code, 6)
+	assert.Contains(t, result.ShortURL, result.Code)
 	// Check that code contains only alphanumeric characters
-	for _, char := range code {
+	for _, char := range result.Code {
 		assert.True(t, strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", char))
 	}
 
@@ -53,36 +129,234 @@ func TestCodeGeneration(t *testing.T) {
 
 func TestShortenURL(t *testing.T) {
 	mockRepo := new(MockURLRepository)
-	service := NewURLService(mockRepo, "http://localhost:8081")
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081"})
 
 	t.Run("successful URL shortening", func(t *testing.T) {
-		mockRepo.On("StoreURL", "example.com", mock.AnythingOfType("string")).Return(nil).Once()
+		mockRepo.On("StoreURL", "http://example.com/", mock.AnythingOfType("string")).Return(nil).Once()
 
-		code, shortURL, err := service.ShortenURL("example.com")
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "example.com"})
 
 		assert.NoError(t, err)
-		assert.Len(t, code, 6)
-		assert.Contains(t, shortURL, code)
+		assert.Len(t, result.Code, 6)
+		assert.Contains(t, result.ShortURL, result.Code)
+		assert.Equal(t, "http://example.com/", result.OriginalURL)
 		mockRepo.AssertExpectations(t)
 	})
 
 	t.Run("invalid URL", func(t *testing.T) {
-		code, shortURL, err := service.ShortenURL("")
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: ""})
 
 		assert.Error(t, err)
-		assert.Empty(t, code)
-		assert.Empty(t, shortURL)
+		assert.True(t, errors.Is(err, ErrInvalidURL))
+		var urlErr *URLError
+		assert.True(t, errors.As(err, &urlErr))
+		assert.Equal(t, "missing host", urlErr.Reason)
+		assert.Nil(t, result)
+	})
+
+	t.Run("destination is canonicalised", func(t *testing.T) {
+		mockRepo.On("StoreURL", "https://xn--bcher-kva.example/a", mock.AnythingOfType("string")).Return(nil).Once()
+
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: " HTTPS://Bücher.Example:443/a "})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "https://xn--bcher-kva.example/a", result.OriginalURL)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("malformed host", func(t *testing.T) {
+		_, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "http://exa_mple..com"})
+
+		var urlErr *URLError
+		assert.True(t, errors.As(err, &urlErr))
+		assert.Equal(t, "malformed host", urlErr.Reason)
+	})
+
+	t.Run("retries on code collision", func(t *testing.T) {
+		mockRepo.On("StoreURL", "http://example.org/", mock.AnythingOfType("string")).Return(fmt.Errorf("%w: taken", ErrConflict)).Once()
+		mockRepo.On("StoreURL", "http://example.org/", mock.AnythingOfType("string")).Return(nil).Once()
+
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "example.org"})
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Code, 6)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("gives up after repeated collisions", func(t *testing.T) {
+		mockRepo.On("StoreURL", "http://example.net/", mock.AnythingOfType("string")).Return(ErrConflict).Times(maxCodeAttempts)
+
+		_, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "example.net"})
+
+		assert.True(t, errors.Is(err, ErrConflict))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("alias and tags", func(t *testing.T) {
+		mockRepo.On("StoreURL", "http://example.com/spring", "spring-sale").Return(nil).Once()
+
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{
+			URL:   "http://example.com/spring",
+			Alias: "spring-sale",
+			Tags:  []string{" Email ", "email", "q2"},
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "spring-sale", result.Code)
+		assert.Equal(t, "http://localhost:8081/spring-sale", result.ShortURL)
+		assert.Equal(t, []string{"email", "q2"}, result.Tags)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("alias conflict is not retried", func(t *testing.T) {
+		mockRepo.On("StoreURL", "http://example.com/", "taken").Return(ErrConflict).Once()
+
+		_, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "http://example.com", Alias: "taken"})
+
+		assert.True(t, errors.Is(err, ErrConflict))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid alias and tags", func(t *testing.T) {
+		for _, req := range []ShortenRequest{
+			{URL: "http://example.com", Alias: "a"},
+			{URL: "http://example.com", Alias: "has space"},
+			{URL: "http://example.com", Alias: "health"},
+			{URL: "http://example.com", Tags: []string{"no spaces allowed"}},
+			{URL: "http://example.com", RedirectStatus: 303},
+		} {
+			_, err := service.ShortenURL(context.Background(), req)
+
+			assert.True(t, errors.Is(err, ErrInvalidInput), "%+v", req)
+			var inputErr *InputError
+			assert.True(t, errors.As(err, &inputErr))
+		}
+	})
+}
+
+func TestURLPolicy(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	canonicalizer := urlcanon.New(urlcanon.Options{StripTrackingParams: true})
+	service := NewURLService(mockRepo, Config{
+		BaseURL:       "http://localhost:8081",
+		Canonicalizer: canonicalizer,
+		Validator: security.NewURLValidator(&security.SecurityConfig{
+			BlockedDomains: []string{"evil.example"},
+			RequireHTTPS:   true,
+		}, canonicalizer),
+	})
+
+	t.Run("allowed destination is canonicalised once", func(t *testing.T) {
+		mockRepo.On("StoreURL", "https://example.com/a", mock.AnythingOfType("string")).Return(nil).Once()
+
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "https://example.com/a?utm_source=mail"})
+
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/a", result.OriginalURL)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("blocked host", func(t *testing.T) {
+		_, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "https://EVIL.example/x"})
+
+		assert.True(t, errors.Is(err, ErrBlocked))
+		var urlErr *URLError
+		require.True(t, errors.As(err, &urlErr))
+		assert.Equal(t, "domain is blocked: evil.example", urlErr.Reason)
+	})
+
+	t.Run("https required", func(t *testing.T) {
+		_, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "http://example.com/a"})
+
+		assert.True(t, errors.Is(err, ErrInvalidURL))
+		assert.False(t, errors.Is(err, ErrBlocked))
+	})
+
+	t.Run("batch items", func(t *testing.T) {
+		results, err := service.ShortenBatch(context.Background(), []ShortenRequest{{URL: "https://evil.example/x"}})
+
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.True(t, errors.Is(results[0].Err, ErrBlocked))
+	})
+
+	t.Run("device rules", func(t *testing.T) {
+		_, err := service.ShortenURL(context.Background(), ShortenRequest{
+			URL:         "https://example.com/a",
+			DeviceRules: []DeviceRule{{OS: []string{"android"}, URL: "https://evil.example/app"}},
+		})
+
+		assert.True(t, errors.Is(err, ErrBlocked))
+		var urlErr *URLError
+		require.True(t, errors.As(err, &urlErr))
+		assert.Equal(t, "device_rules[0].url: domain is blocked: evil.example", urlErr.Reason)
+	})
+
+	t.Run("imported links", func(t *testing.T) {
+		dec, err := transfer.NewDecoder(strings.NewReader("code,original_url\nevil,https://evil.example/x\n"), transfer.FormatCSV)
+		require.NoError(t, err)
+
+		report, err := service.ImportLinks(context.Background(), "", dec, ImportOptions{Policy: ConflictRename})
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Failed)
+		require.Len(t, report.Items, 1)
+		assert.True(t, errors.Is(report.Items[0].Err, ErrBlocked))
+	})
+}
+
+func TestShortenURLDedupe(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081"})
+	hash := urlHash("https://example.com/page")
+
+	t.Run("returns existing link", func(t *testing.T) {
+		mockRepo.On("FindOrStoreURL", hash, mock.AnythingOfType("string")).Return(&repo.Link{
+			Code:        "abc123",
+			OriginalURL: "https://example.com/page",
+			Tags:        []string{"email"},
+		}, nil).Once()
+
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "HTTPS://Example.com:443/page", Dedupe: true})
+
+		require.NoError(t, err)
+		assert.True(t, result.Deduplicated)
+		assert.Equal(t, "abc123", result.Code)
+		assert.Equal(t, "https://example.com/page", result.OriginalURL)
+		assert.Equal(t, []string{"email"}, result.Tags)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("creates link when none exists", func(t *testing.T) {
+		mockRepo.On("FindOrStoreURL", hash, mock.AnythingOfType("string")).Return(nil, nil).Once()
+
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "https://example.com/page", Dedupe: true})
+
+		require.NoError(t, err)
+		assert.False(t, result.Deduplicated)
+		assert.Len(t, result.Code, 6)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("ignored with alias", func(t *testing.T) {
+		mockRepo.On("StoreURL", "https://example.com/page", "my-page").Return(nil).Once()
+
+		result, err := service.ShortenURL(context.Background(), ShortenRequest{URL: "https://example.com/page", Alias: "my-page", Dedupe: true})
+
+		require.NoError(t, err)
+		assert.False(t, result.Deduplicated)
+		mockRepo.AssertExpectations(t)
 	})
 }
 
 func TestGetOriginalURL(t *testing.T) {
 	mockRepo := new(MockURLRepository)
-	service := NewURLService(mockRepo, "http://localhost:8081")
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081"})
 
 	t.Run("successful URL retrieval", func(t *testing.T) {
-		mockRepo.On("GetOriginalURL", "abc123").Return("http://example.com", nil).Once()
+		mockRepo.On("GetOriginalURL", "", "abc123").Return("http://example.com", nil).Once()
 
-		url, err := service.GetOriginalURL("abc123")
+		url, err := service.GetOriginalURL(context.Background(), "", "abc123")
 
 		assert.NoError(t, err)
 		assert.Equal(t, "http://example.com", url)
@@ -90,12 +364,162 @@ func TestGetOriginalURL(t *testing.T) {
 	})
 
 	t.Run("URL not found", func(t *testing.T) {
-		mockRepo.On("GetOriginalURL", "notfound").Return("", assert.AnError).Once()
+		mockRepo.On("GetOriginalURL", "", "notfound").Return("", assert.AnError).Once()
 
-		url, err := service.GetOriginalURL("notfound")
+		url, err := service.GetOriginalURL(context.Background(), "", "notfound")
 
 		assert.Error(t, err)
 		assert.Empty(t, url)
 		mockRepo.AssertExpectations(t)
 	})
-}
\ No newline at end of file
+}
+
+func TestShortURL(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081/"})
+	mockRepo.On("GetOriginalURL", "", "abc123").Return("http://example.com", nil).Once()
+	mockRepo.On("GetOriginalURL", "", "missing").Return("", ErrNotFound).Once()
+
+	shortURL, err := service.ShortURL(context.Background(), "", "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8081/abc123", shortURL)
+
+	_, err = service.ShortURL(context.Background(), "", "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRedirectStatus(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	ctx := context.Background()
+
+	t.Run("per-link status is stored and reported", func(t *testing.T) {
+		service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081"})
+		mockRepo.On("StoreURL", "https://example.com/api", "api-v1").Return(nil).Once()
+
+		result, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/api", Alias: "api-v1", RedirectStatus: 307})
+
+		require.NoError(t, err)
+		assert.Equal(t, 307, result.RedirectStatus)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("links without a status use the server default", func(t *testing.T) {
+		service := NewURLService(mockRepo, Config{DefaultRedirectStatus: 301})
+		mockRepo.On("GetLink", "", "plain").Return(&repo.Link{Code: "plain", OriginalURL: "https://example.com/"}, nil).Once()
+		mockRepo.On("GetLink", "", "temp").Return(&repo.Link{Code: "temp", OriginalURL: "https://example.com/t", RedirectStatus: 302}, nil).Once()
+
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "plain"})
+		require.NoError(t, err)
+		assert.Equal(t, &Redirect{URL: "https://example.com/", Status: 301}, redirect)
+
+		redirect, err = service.ResolveRedirect(ctx, RedirectRequest{Code: "temp"})
+		require.NoError(t, err)
+		assert.Equal(t, 302, redirect.Status)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid default falls back to 302", func(t *testing.T) {
+		service := NewURLService(mockRepo, Config{DefaultRedirectStatus: 200})
+		mockRepo.On("GetLink", "", "plain").Return(&repo.Link{Code: "plain", OriginalURL: "https://example.com/"}, nil).Once()
+
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "plain"})
+		require.NoError(t, err)
+		assert.Equal(t, 302, redirect.Status)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		service := NewURLService(mockRepo, Config{})
+		mockRepo.On("GetLink", "", "missing").Return(nil, ErrNotFound).Once()
+
+		_, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "missing"})
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestInterstitial(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	ctx := context.Background()
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	t.Run("per-link setting", func(t *testing.T) {
+		service := NewURLService(mockRepo, Config{})
+		mockRepo.On("GetLink", "", "careful").Return(&repo.Link{Code: "careful", OriginalURL: "https://example.com/", CreatedAt: created, Interstitial: true}, nil).Once()
+		mockRepo.On("GetLink", "", "plain").Return(&repo.Link{Code: "plain", OriginalURL: "https://example.com/", CreatedAt: created}, nil).Once()
+
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "careful"})
+		require.NoError(t, err)
+		assert.True(t, redirect.Interstitial)
+		assert.Equal(t, created, redirect.CreatedAt)
+
+		redirect, err = service.ResolveRedirect(ctx, RedirectRequest{Code: "plain"})
+		require.NoError(t, err)
+		assert.False(t, redirect.Interstitial)
+	})
+
+	t.Run("global setting overrides links", func(t *testing.T) {
+		service := NewURLService(mockRepo, Config{AlwaysInterstitial: true})
+		mockRepo.On("GetLink", "", "plain").Return(&repo.Link{Code: "plain", OriginalURL: "https://example.com/"}, nil).Once()
+
+		redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "plain"})
+		require.NoError(t, err)
+		assert.True(t, redirect.Interstitial)
+	})
+}
+
+func TestShortenBatch(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081"})
+
+	t.Run("per-item results", func(t *testing.T) {
+		mockRepo.On("StoreURLs", mock.MatchedBy(func(links []*repo.Link) bool {
+			return len(links) == 2 && links[0].Code == "first" && links[1].Code == "taken"
+		})).Return([]error{nil, fmt.Errorf("%w: taken", ErrConflict)}, nil).Once()
+
+		results, err := service.ShortenBatch(context.Background(), []ShortenRequest{
+			{URL: "http://example.com/1", Alias: "first"},
+			{URL: ""},
+			{URL: "http://example.com/2", Alias: "taken"},
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, results, 3)
+		assert.Equal(t, "first", results[0].Result.Code)
+		assert.True(t, errors.Is(results[1].Err, ErrInvalidURL))
+		assert.True(t, errors.Is(results[2].Err, ErrConflict))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("generated code collisions are retried", func(t *testing.T) {
+		mockRepo.On("StoreURLs", mock.MatchedBy(func(links []*repo.Link) bool {
+			return len(links) == 2
+		})).Return([]error{nil, ErrConflict}, nil).Once()
+		mockRepo.On("StoreURLs", mock.MatchedBy(func(links []*repo.Link) bool {
+			return len(links) == 1 && links[0].OriginalURL == "http://example.com/b"
+		})).Return([]error{nil}, nil).Once()
+
+		results, err := service.ShortenBatch(context.Background(), []ShortenRequest{
+			{URL: "http://example.com/a"},
+			{URL: "http://example.com/b"},
+		})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, results[0].Result)
+		assert.NotNil(t, results[1].Result)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("failed chunk marks remaining items", func(t *testing.T) {
+		mockRepo.On("StoreURLs", mock.Anything).Return(nil, errors.New("database is locked")).Once()
+
+		results, err := service.ShortenBatch(context.Background(), []ShortenRequest{
+			{URL: "http://example.com/a"},
+			{URL: "http://example.com/b"},
+		})
+
+		assert.NoError(t, err)
+		assert.Error(t, results[0].Err)
+		assert.Error(t, results[1].Err)
+		mockRepo.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// Limits on social card details, in characters
+const (
+	maxSocialTitleLength       = 200
+	maxSocialDescriptionLength = 500
+	maxSocialImageURLLength    = 2048
+)
+
+// SocialCard holds the Open Graph and Twitter Card details crawlers are
+// shown for a link
+type SocialCard = repo.SocialCard
+
+// normalizeSocialCard trims a card's details and checks their lengths. The
+// image must be an absolute http or https URL, since crawlers fetch it
+// without any page to resolve it against.
+func normalizeSocialCard(card SocialCard) (SocialCard, error) {
+	card = SocialCard{
+		Title:       strings.TrimSpace(card.Title),
+		Description: strings.TrimSpace(card.Description),
+		ImageURL:    strings.TrimSpace(card.ImageURL),
+	}
+	if utf8.RuneCountInString(card.Title) > maxSocialTitleLength {
+		return SocialCard{}, &InputError{Field: "social.title", Reason: "is too long"}
+	}
+	if utf8.RuneCountInString(card.Description) > maxSocialDescriptionLength {
+		return SocialCard{}, &InputError{Field: "social.description", Reason: "is too long"}
+	}
+	if card.ImageURL != "" {
+		u, err := url.Parse(card.ImageURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+			return SocialCard{}, &InputError{Field: "social.image_url", Reason: "must be an absolute http or https URL"}
+		}
+		if len(card.ImageURL) > maxSocialImageURLLength {
+			return SocialCard{}, &InputError{Field: "social.image_url", Reason: "is too long"}
+		}
+	}
+	return card, nil
+}
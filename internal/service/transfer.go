@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/transfer"
+)
+
+const (
+	// importChunkSize is the number of links written per import transaction
+	importChunkSize = 500
+	// maxReportItems bounds the per-link details kept in an ImportReport
+	maxReportItems = 1000
+)
+
+// importCodePattern is looser than aliasPattern so that short codes from
+// other shorteners can be migrated unchanged
+var importCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ConflictPolicy decides what an import does with a code that already exists
+type ConflictPolicy = repo.ConflictPolicy
+
+// Conflict policies accepted by ImportLinks
+const (
+	ConflictSkip      = repo.ConflictSkip
+	ConflictOverwrite = repo.ConflictOverwrite
+	ConflictRename    = repo.ConflictRename
+)
+
+// ParseConflictPolicy parses a policy name, defaulting to skip when empty
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return policy, nil
+	default:
+		return "", &InputError{Field: "policy", Reason: "must be skip, overwrite or rename"}
+	}
+}
+
+// ImportOptions controls ImportLinks
+type ImportOptions struct {
+	Policy ConflictPolicy
+	// DryRun validates the input and resolves conflicts without storing anything
+	DryRun bool
+}
+
+// ImportItem describes a link that was not simply created
+type ImportItem struct {
+	Line    int
+	Code    string
+	NewCode string
+	Outcome repo.ImportOutcome
+	Err     error
+}
+
+// ImportReport summarises an import
+type ImportReport struct {
+	DryRun      bool
+	Policy      ConflictPolicy
+	Total       int
+	Created     int
+	Skipped     int
+	Overwritten int
+	Renamed     int
+	Failed      int
+	// Items lists skipped, renamed, overwritten and failed links, up to
+	// maxReportItems; Truncated is set if more were omitted
+	Items     []ImportItem
+	Truncated bool
+}
+
+// add records the outcome of one link
+func (r *ImportReport) add(item ImportItem) {
+	r.Total++
+	switch item.Outcome {
+	case repo.ImportCreated:
+		r.Created++
+		return
+	case repo.ImportSkipped:
+		r.Skipped++
+	case repo.ImportOverwritten:
+		r.Overwritten++
+	case repo.ImportRenamed:
+		r.Renamed++
+	default:
+		r.Failed++
+	}
+	if len(r.Items) < maxReportItems {
+		r.Items = append(r.Items, item)
+	} else {
+		r.Truncated = true
+	}
+}
+
+// ExportLinks writes every link of workspace to enc
+func (s *URLServiceImpl) ExportLinks(ctx context.Context, workspace string, enc transfer.Encoder) error {
+	if err := s.repo.ExportLinks(ctx, workspace, enc.Encode); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// ImportLinks reads links from dec and stores them in workspace, in
+// chunks. Links of other workspaces are never overwritten. Records that
+// fail to parse or validate are reported and skipped; the returned error
+// is non-nil only if reading or storing had to stop part way, in which
+// case the report covers the links processed so far. Imported links count
+// against the workspace's quota: each chunk reserves room for all of its
+// links before conflicts are resolved, and links beyond the room left are
+// reported failed with a *QuotaError.
+func (s *URLServiceImpl) ImportLinks(ctx context.Context, workspace string, dec transfer.Decoder, opts ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{DryRun: opts.DryRun, Policy: opts.Policy}
+	repoOpts := repo.ImportOptions{
+		Policy: opts.Policy,
+		DryRun: opts.DryRun,
+		NewCode: func() (string, error) {
+			return generateUniqueCode(codeLength)
+		},
+	}
+
+	var links []*repo.Link
+	var lines []int
+	flush := func() error {
+		if len(links) == 0 {
+			return nil
+		}
+		reservation, err := s.reserveQuota(ctx, workspace, "", len(links))
+		if err != nil {
+			return err
+		}
+		created := 0
+		defer func() { reservation.Done(created) }()
+
+		var results []repo.ImportResult
+		if reservation.Allowed > 0 {
+			if results, err = s.repo.ImportLinks(ctx, links[:reservation.Allowed], repoOpts); err != nil {
+				return err
+			}
+		}
+		for i, result := range results {
+			item := ImportItem{Line: lines[i], Code: result.OriginalCode, Outcome: result.Outcome, Err: result.Err}
+			if result.Outcome == repo.ImportRenamed {
+				item.NewCode = result.Code
+			}
+			report.add(item)
+			if !opts.DryRun {
+				if result.Outcome == repo.ImportCreated || result.Outcome == repo.ImportRenamed {
+					created++
+				}
+				s.publishImported(ctx, links[i], result.Outcome)
+			}
+		}
+		for i := reservation.Allowed; i < len(links); i++ {
+			report.add(ImportItem{Line: lines[i], Code: links[i].Code, Outcome: repo.ImportFailed, Err: reservation.Err})
+		}
+		links, lines = links[:0], lines[:0]
+		return nil
+	}
+
+	for {
+		link, line, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var recordErr *transfer.RecordError
+		if errors.As(err, &recordErr) {
+			report.add(ImportItem{Line: line, Outcome: repo.ImportFailed, Err: &InputError{Field: "record", Reason: recordErr.Error()}})
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read import: %w", err)
+		}
+
+		if err := s.validateImport(ctx, workspace, link); err != nil {
+			report.add(ImportItem{Line: line, Code: link.Code, Outcome: repo.ImportFailed, Err: err})
+			continue
+		}
+		links = append(links, link)
+		lines = append(lines, line)
+		if len(links) == importChunkSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// publishImported tells the event publisher, if any, about a link written
+// by an import
+func (s *URLServiceImpl) publishImported(ctx context.Context, link *repo.Link, outcome repo.ImportOutcome) {
+	switch outcome {
+	case repo.ImportCreated, repo.ImportRenamed:
+		s.publish(ctx, EventLinkCreated, link, nil)
+	case repo.ImportOverwritten:
+		s.publish(ctx, EventLinkUpdated, link, nil)
+	}
+}
+
+// validateImport applies the same checks as ShortenURL to a link imported
+// into workspace, canonicalising its URL and tags in place
+func (s *URLServiceImpl) validateImport(ctx context.Context, workspace string, link *repo.Link) error {
+	originalURL, err := s.canonicalizeURL(link.OriginalURL)
+	if err != nil {
+		return err
+	}
+	if !importCodePattern.MatchString(link.Code) {
+		return &InputError{Field: "code", Reason: "must be 1-64 letters, digits, '-' or '_'"}
+	}
+	if reservedAliases[strings.ToLower(link.Code)] {
+		return &InputError{Field: "code", Reason: "is reserved"}
+	}
+	tags, err := normalizeTags(link.Tags)
+	if err != nil {
+		return err
+	}
+	if link.Folder, err = normalizeFolder(link.Folder); err != nil {
+		return err
+	}
+	domain, err := s.linkDomain(ctx, workspace, link.Domain)
+	if err != nil {
+		return err
+	}
+	link.Workspace = workspace
+	link.Domain = ""
+	if domain != nil {
+		link.Domain = domain.Host
+	}
+	if err := validateRedirectStatus(link.RedirectStatus); err != nil {
+		return err
+	}
+	if err := validateMaxClicks(link.MaxClicks); err != nil {
+		return err
+	}
+	if link.PasswordHash != "" {
+		if err := validatePasswordHash(link.PasswordHash); err != nil {
+			return err
+		}
+	}
+	if err := validateWindow(link.ActiveFrom, link.ActiveUntil, link.FallbackURL); err != nil {
+		return err
+	}
+	if link.FallbackURL, err = s.canonicalizeFallback(link.FallbackURL); err != nil {
+		return err
+	}
+	if link.Social, err = normalizeSocialCard(link.Social); err != nil {
+		return err
+	}
+	if link.DeviceRules, err = s.normalizeDeviceRules(link.DeviceRules); err != nil {
+		return err
+	}
+	if link.GeoRules, err = s.normalizeGeoRules(link.GeoRules); err != nil {
+		return err
+	}
+	if link.Variants, err = s.normalizeVariants(link.Variants); err != nil {
+		return err
+	}
+	link.OriginalURL = originalURL
+	link.URLHash = urlHash(originalURL)
+	link.Tags = tags
+	return nil
+}
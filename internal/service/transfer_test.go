@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/transfer"
+)
+
+func TestImportLinks(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081"})
+
+	input := "code,original_url,clicks,tags\n" +
+		"ok1,https://one.com,1,Email\n" +
+		"bad,,0,\n" +
+		"ok2,https://two.com,notanumber,\n" +
+		"health,https://three.com,0,\n" +
+		"ok3,https://four.com,4,\n"
+	dec, err := transfer.NewDecoder(strings.NewReader(input), transfer.FormatCSV)
+	require.NoError(t, err)
+
+	mockRepo.On("ImportLinks", mock.MatchedBy(func(links []*repo.Link) bool {
+		return len(links) == 2 && links[0].Code == "ok1" && links[0].Tags[0] == "email" && links[1].Code == "ok3"
+	}), ConflictRename, true).Return([]repo.ImportResult{
+		{Code: "ok1", OriginalCode: "ok1", Outcome: repo.ImportCreated},
+		{Code: "Xy12ab", OriginalCode: "ok3", Outcome: repo.ImportRenamed},
+	}, nil).Once()
+
+	report, err := service.ImportLinks(context.Background(), "", dec, ImportOptions{Policy: ConflictRename, DryRun: true})
+	require.NoError(t, err)
+
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 5, report.Total)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Renamed)
+	assert.Equal(t, 3, report.Failed)
+	require.Len(t, report.Items, 4)
+
+	assert.Equal(t, 3, report.Items[0].Line)
+	assert.True(t, errors.Is(report.Items[0].Err, ErrInvalidURL))
+	assert.Equal(t, 4, report.Items[1].Line)
+	assert.True(t, errors.Is(report.Items[1].Err, ErrInvalidInput))
+	assert.Equal(t, "health", report.Items[2].Code)
+	assert.True(t, errors.Is(report.Items[2].Err, ErrInvalidInput))
+	assert.Equal(t, "ok3", report.Items[3].Code)
+	assert.Equal(t, "Xy12ab", report.Items[3].NewCode)
+	assert.Equal(t, 6, report.Items[3].Line)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	policy, err := ParseConflictPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, ConflictSkip, policy)
+
+	policy, err = ParseConflictPolicy("overwrite")
+	assert.NoError(t, err)
+	assert.Equal(t, ConflictOverwrite, policy)
+
+	_, err = ParseConflictPolicy("merge")
+	assert.True(t, errors.Is(err, ErrInvalidInput))
+}
+
+func TestImportLinksPublishesEvents(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	events := &recordingPublisher{}
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8081", Events: events})
+
+	input := "code,original_url\n" +
+		"new1,https://one.com\n" +
+		"same,https://two.com\n" +
+		"kept,https://three.com\n" +
+		"taken,https://four.com\n"
+	newDecoder := func() transfer.Decoder {
+		dec, err := transfer.NewDecoder(strings.NewReader(input), transfer.FormatCSV)
+		require.NoError(t, err)
+		return dec
+	}
+	mockRepo.On("ImportLinks", mock.Anything, ConflictOverwrite, mock.Anything).Return([]repo.ImportResult{
+		{Code: "new1", OriginalCode: "new1", Outcome: repo.ImportCreated},
+		{Code: "same", OriginalCode: "same", Outcome: repo.ImportOverwritten},
+		{Code: "kept", OriginalCode: "kept", Outcome: repo.ImportSkipped},
+		{Code: "taken", OriginalCode: "taken", Outcome: repo.ImportFailed, Err: errors.New("disk full")},
+	}, nil)
+
+	_, err := service.ImportLinks(context.Background(), "sales", newDecoder(), ImportOptions{Policy: ConflictOverwrite, DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, events.events, "dry runs publish nothing")
+
+	_, err = service.ImportLinks(context.Background(), "sales", newDecoder(), ImportOptions{Policy: ConflictOverwrite})
+	require.NoError(t, err)
+	require.Len(t, events.events, 2)
+	assert.Equal(t, EventLinkCreated, events.events[0].Type)
+	assert.Equal(t, "sales", events.events[0].Workspace)
+	assert.Equal(t, "new1", events.events[0].Link.Code)
+	assert.Equal(t, EventLinkUpdated, events.events[1].Type)
+	assert.Equal(t, "same", events.events[1].Link.Code)
+}
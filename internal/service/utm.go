@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// maxUTMValueLength bounds a single UTM parameter value
+const maxUTMValueLength = 200
+
+// templateNamePattern matches team and template names
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// UTMParams are the standard campaign tracking parameters
+type UTMParams = repo.UTMParams
+
+// UTMTemplate is a named set of UTM parameters owned by a team
+type UTMTemplate = repo.UTMTemplate
+
+// UTMTemplateService manages reusable UTM parameter sets. Links reference
+// a template of their workspace as "team/name" in
+// ShortenRequest.UTMTemplate.
+type UTMTemplateService interface {
+	SaveTemplate(ctx context.Context, tmpl *UTMTemplate) error
+	GetTemplate(ctx context.Context, workspace, team, name string) (*UTMTemplate, error)
+	ListTemplates(ctx context.Context, workspace, team string) ([]*UTMTemplate, error)
+	DeleteTemplate(ctx context.Context, workspace, team, name string) error
+}
+
+// UTMTemplateServiceImpl implements UTMTemplateService
+type UTMTemplateServiceImpl struct {
+	repo repo.UTMTemplateRepository
+}
+
+// NewUTMTemplateService creates a new UTMTemplateService
+func NewUTMTemplateService(repo repo.UTMTemplateRepository) UTMTemplateService {
+	return &UTMTemplateServiceImpl{repo: repo}
+}
+
+// SaveTemplate validates and stores a template, replacing any existing
+// template of tmpl.Workspace with the same team and name
+func (s *UTMTemplateServiceImpl) SaveTemplate(ctx context.Context, tmpl *UTMTemplate) error {
+	if err := validateTemplateName(tmpl.Team, tmpl.Name); err != nil {
+		return err
+	}
+	params, err := normalizeUTM(tmpl.Params)
+	if err != nil {
+		return err
+	}
+	if params == (UTMParams{}) {
+		return &InputError{Field: "utm", Reason: "a template must set at least one parameter"}
+	}
+	tmpl.Params = params
+	return s.repo.SaveUTMTemplate(ctx, tmpl)
+}
+
+// GetTemplate retrieves a team's template by name
+func (s *UTMTemplateServiceImpl) GetTemplate(ctx context.Context, workspace, team, name string) (*UTMTemplate, error) {
+	if err := validateTemplateName(team, name); err != nil {
+		return nil, err
+	}
+	return s.repo.GetUTMTemplate(ctx, workspace, team, name)
+}
+
+// ListTemplates returns a team's templates ordered by name
+func (s *UTMTemplateServiceImpl) ListTemplates(ctx context.Context, workspace, team string) ([]*UTMTemplate, error) {
+	if !templateNamePattern.MatchString(team) {
+		return nil, &InputError{Field: "team", Reason: "must be 1-64 lowercase letters, digits, '-' or '_'"}
+	}
+	return s.repo.ListUTMTemplates(ctx, workspace, team)
+}
+
+// DeleteTemplate removes a team's template
+func (s *UTMTemplateServiceImpl) DeleteTemplate(ctx context.Context, workspace, team, name string) error {
+	if err := validateTemplateName(team, name); err != nil {
+		return err
+	}
+	return s.repo.DeleteUTMTemplate(ctx, workspace, team, name)
+}
+
+// applyUTM adds the request's UTM parameters, starting from its template
+// if one is named, to the canonical destination URL
+func (s *URLServiceImpl) applyUTM(ctx context.Context, destination string, req ShortenRequest) (string, error) {
+	params := req.UTM
+	if req.UTMTemplate != "" {
+		tmpl, err := s.lookupUTMTemplate(ctx, req.Workspace, req.UTMTemplate)
+		if err != nil {
+			return "", err
+		}
+		params = overlayUTM(tmpl.Params, params)
+	}
+
+	params, err := normalizeUTM(params)
+	if err != nil {
+		return "", err
+	}
+	if params == (UTMParams{}) {
+		return destination, nil
+	}
+	if params.Source == "" {
+		return "", &InputError{Field: "utm.source", Reason: "is required when other UTM parameters are set"}
+	}
+	return withUTM(destination, params)
+}
+
+// lookupUTMTemplate finds the template of workspace referenced as
+// "team/name"
+func (s *URLServiceImpl) lookupUTMTemplate(ctx context.Context, workspace, ref string) (*UTMTemplate, error) {
+	team, name, _ := strings.Cut(ref, "/")
+	if validateTemplateName(team, name) != nil {
+		return nil, &InputError{Field: "utm.template", Reason: `must be of the form "team/name"`}
+	}
+	if s.config.UTMTemplates == nil {
+		return nil, &InputError{Field: "utm.template", Reason: fmt.Sprintf("%q does not exist", ref)}
+	}
+
+	tmpl, err := s.config.UTMTemplates.GetUTMTemplate(ctx, workspace, team, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, &InputError{Field: "utm.template", Reason: fmt.Sprintf("%q does not exist", ref)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load UTM template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// overlayUTM returns base with every field set in override replaced
+func overlayUTM(base, override UTMParams) UTMParams {
+	pick := func(b, o string) string {
+		if o != "" {
+			return o
+		}
+		return b
+	}
+	return UTMParams{
+		Source:   pick(base.Source, override.Source),
+		Medium:   pick(base.Medium, override.Medium),
+		Campaign: pick(base.Campaign, override.Campaign),
+		Term:     pick(base.Term, override.Term),
+		Content:  pick(base.Content, override.Content),
+	}
+}
+
+// utmField is one UTM parameter, named without its "utm_" prefix
+type utmField struct {
+	name  string
+	value *string
+}
+
+// utmFields lists the parameters of p in the conventional order
+func utmFields(p *UTMParams) []utmField {
+	return []utmField{
+		{"source", &p.Source},
+		{"medium", &p.Medium},
+		{"campaign", &p.Campaign},
+		{"term", &p.Term},
+		{"content", &p.Content},
+	}
+}
+
+// normalizeUTM trims every value and rejects ones that are too long or
+// contain control characters
+func normalizeUTM(p UTMParams) (UTMParams, error) {
+	for _, field := range utmFields(&p) {
+		*field.value = strings.TrimSpace(*field.value)
+		if len(*field.value) > maxUTMValueLength {
+			return UTMParams{}, &InputError{Field: "utm." + field.name, Reason: fmt.Sprintf("must be at most %d characters", maxUTMValueLength)}
+		}
+		if strings.IndexFunc(*field.value, unicode.IsControl) >= 0 {
+			return UTMParams{}, &InputError{Field: "utm." + field.name, Reason: "must not contain control characters"}
+		}
+	}
+	return p, nil
+}
+
+// withUTM sets the UTM parameters in p on rawURL. Any parameter already in
+// the URL for a field being set is replaced, whatever its letter case, so
+// hand-written tags are never duplicated. Other parameters keep their
+// order and encoding, and the UTM parameters are appended after them.
+func withUTM(rawURL string, p UTMParams) (string, error) {
+	dest, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse destination: %w", err)
+	}
+
+	replaced := make(map[string]bool)
+	var pairs []string
+	for _, field := range utmFields(&p) {
+		if *field.value != "" {
+			name := "utm_" + field.name
+			replaced[name] = true
+			pairs = append(pairs, name+"="+url.QueryEscape(*field.value))
+		}
+	}
+
+	var kept []string
+	for _, pair := range strings.Split(dest.RawQuery, "&") {
+		if pair != "" && !replaced[strings.ToLower(queryName(pair))] {
+			kept = append(kept, pair)
+		}
+	}
+	dest.RawQuery = strings.Join(append(kept, pairs...), "&")
+	return dest.String(), nil
+}
+
+// validateTemplateName checks a template's team and name
+func validateTemplateName(team, name string) error {
+	if !templateNamePattern.MatchString(team) {
+		return &InputError{Field: "team", Reason: "must be 1-64 lowercase letters, digits, '-' or '_'"}
+	}
+	if !templateNamePattern.MatchString(name) {
+		return &InputError{Field: "name", Reason: "must be 1-64 lowercase letters, digits, '-' or '_'"}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/urlcanon"
+)
+
+// MockUTMTemplateRepository is a mock implementation of repo.UTMTemplateRepository
+type MockUTMTemplateRepository struct {
+	mock.Mock
+}
+
+func (m *MockUTMTemplateRepository) SaveUTMTemplate(ctx context.Context, tmpl *repo.UTMTemplate) error {
+	return m.Called(tmpl).Error(0)
+}
+
+func (m *MockUTMTemplateRepository) GetUTMTemplate(ctx context.Context, workspace, team, name string) (*repo.UTMTemplate, error) {
+	args := m.Called(workspace, team, name)
+	tmpl, _ := args.Get(0).(*repo.UTMTemplate)
+	return tmpl, args.Error(1)
+}
+
+func (m *MockUTMTemplateRepository) ListUTMTemplates(ctx context.Context, workspace, team string) ([]*repo.UTMTemplate, error) {
+	args := m.Called(workspace, team)
+	templates, _ := args.Get(0).([]*repo.UTMTemplate)
+	return templates, args.Error(1)
+}
+
+func (m *MockUTMTemplateRepository) DeleteUTMTemplate(ctx context.Context, workspace, team, name string) error {
+	return m.Called(workspace, team, name).Error(0)
+}
+
+func TestWithUTM(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		params UTMParams
+		want   string
+	}{
+		{"no query", "https://example.com/", UTMParams{Source: "news", Medium: "email"}, "https://example.com/?utm_source=news&utm_medium=email"},
+		{"values are encoded", "https://example.com/", UTMParams{Source: "news", Campaign: "spring sale & more"}, "https://example.com/?utm_source=news&utm_campaign=spring+sale+%26+more"},
+		{"other parameters kept in place", "https://example.com/p?id=1&b=x%20y", UTMParams{Source: "news"}, "https://example.com/p?id=1&b=x%20y&utm_source=news"},
+		{"existing values replaced regardless of case", "https://example.com/?UTM_Source=old&utm_medium=cpc&utm_source=older", UTMParams{Source: "news"}, "https://example.com/?utm_medium=cpc&utm_source=news"},
+		{"fragment stays last", "https://example.com/#top", UTMParams{Source: "news"}, "https://example.com/?utm_source=news#top"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := withUTM(tc.url, tc.params)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestShortenURLWithUTM(t *testing.T) {
+	ctx := context.Background()
+	templates := new(MockUTMTemplateRepository)
+	templates.On("GetUTMTemplate", "", "growth", "newsletter").Return(&repo.UTMTemplate{
+		Team: "growth", Name: "newsletter",
+		Params: UTMParams{Source: "newsletter", Medium: "email", Campaign: "weekly"},
+	}, nil)
+	templates.On("GetUTMTemplate", "", "growth", "missing").Return(nil, fmt.Errorf("%w for UTM template: growth/missing", ErrNotFound))
+
+	t.Run("template with overrides survives tracking stripping", func(t *testing.T) {
+		mockRepo := new(MockURLRepository)
+		service := NewURLService(mockRepo, Config{
+			Canonicalizer: urlcanon.New(urlcanon.Options{StripTrackingParams: true}),
+			UTMTemplates:  templates,
+		})
+		want := "https://example.com/?utm_source=newsletter&utm_medium=email&utm_campaign=spring&utm_content=hero"
+		mockRepo.On("StoreURL", want, "spring").Return(nil).Once()
+
+		result, err := service.ShortenURL(ctx, ShortenRequest{
+			URL:         "https://example.com/?utm_source=typo",
+			Alias:       "spring",
+			UTM:         UTMParams{Campaign: " spring ", Content: "hero"},
+			UTMTemplate: "growth/newsletter",
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, want, result.OriginalURL)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid parameters are rejected", func(t *testing.T) {
+		service := NewURLService(new(MockURLRepository), Config{UTMTemplates: templates})
+		for _, tc := range []struct {
+			req   ShortenRequest
+			field string
+		}{
+			{ShortenRequest{URL: "https://example.com", UTM: UTMParams{Medium: "email"}}, "utm.source"},
+			{ShortenRequest{URL: "https://example.com", UTM: UTMParams{Source: "a\nb"}}, "utm.source"},
+			{ShortenRequest{URL: "https://example.com", UTM: UTMParams{Source: "a", Term: strings.Repeat("x", 201)}}, "utm.term"},
+			{ShortenRequest{URL: "https://example.com", UTMTemplate: "newsletter"}, "utm.template"},
+			{ShortenRequest{URL: "https://example.com", UTMTemplate: "growth/missing"}, "utm.template"},
+		} {
+			_, err := service.ShortenURL(ctx, tc.req)
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), "got %v", err)
+			assert.Equal(t, tc.field, inputErr.Field)
+		}
+	})
+}
+
+func TestUTMTemplateService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("save normalises parameters", func(t *testing.T) {
+		mockRepo := new(MockUTMTemplateRepository)
+		service := NewUTMTemplateService(mockRepo)
+		mockRepo.On("SaveUTMTemplate", mock.MatchedBy(func(tmpl *repo.UTMTemplate) bool {
+			return tmpl.Params == UTMParams{Source: "google", Medium: "cpc"}
+		})).Return(nil).Once()
+
+		err := service.SaveTemplate(ctx, &UTMTemplate{Team: "growth", Name: "ads", Params: UTMParams{Source: " google", Medium: "cpc "}})
+
+		require.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid templates are rejected", func(t *testing.T) {
+		service := NewUTMTemplateService(new(MockUTMTemplateRepository))
+
+		err := service.SaveTemplate(ctx, &UTMTemplate{Team: "Growth", Name: "ads", Params: UTMParams{Source: "x"}})
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+
+		err = service.SaveTemplate(ctx, &UTMTemplate{Team: "growth", Name: "empty", Params: UTMParams{Source: "  "}})
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+
+		_, err = service.ListTemplates(ctx, "", "a/b")
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+const (
+	// maxVariants bounds how many destinations a link may be split between
+	maxVariants = 10
+	// maxVariantWeight bounds a single variant's weight
+	maxVariantWeight = 1000
+)
+
+// variantNamePattern matches the names of variants
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Variant is one of the destinations of a split link
+type Variant = repo.Variant
+
+// normalizeVariants checks a link's variants and returns them with names
+// lowercased, missing names filled in as "a", "b", ... and destinations
+// canonicalised
+func (s *URLServiceImpl) normalizeVariants(variants []Variant) ([]Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < 2 || len(variants) > maxVariants {
+		return nil, &InputError{Field: "variants", Reason: fmt.Sprintf("must have between 2 and %d variants", maxVariants)}
+	}
+
+	normalized := make([]Variant, len(variants))
+	seen := make(map[string]bool, len(variants))
+	total := 0
+	for i, variant := range variants {
+		field := fmt.Sprintf("variants[%d]", i)
+		name := strings.ToLower(strings.TrimSpace(variant.Name))
+		if name == "" {
+			name = string(rune('a' + i))
+		}
+		if !variantNamePattern.MatchString(name) {
+			return nil, &InputError{Field: field + ".name", Reason: "must be 1-32 lowercase letters, digits, '-' or '_'"}
+		}
+		if seen[name] {
+			return nil, &InputError{Field: field + ".name", Reason: "is used by another variant"}
+		}
+		seen[name] = true
+		if err := validateWeight(field+".weight", variant.Weight); err != nil {
+			return nil, err
+		}
+		total += variant.Weight
+		target := strings.TrimSpace(variant.URL)
+		if target == "" {
+			return nil, &InputError{Field: field + ".url", Reason: "is required"}
+		}
+		canonical, err := s.canonicalizeRuleURL(field+".url", target)
+		if err != nil {
+			return nil, err
+		}
+		normalized[i] = Variant{Name: name, URL: canonical, Weight: variant.Weight, Clicks: variant.Clicks}
+	}
+	if total == 0 {
+		return nil, &InputError{Field: "variants", Reason: "at least one variant must have a positive weight"}
+	}
+	return normalized, nil
+}
+
+// validateWeight checks a variant weight
+func validateWeight(field string, weight int) error {
+	if weight < 0 || weight > maxVariantWeight {
+		return &InputError{Field: field, Reason: fmt.Sprintf("must be between 0 and %d", maxVariantWeight)}
+	}
+	return nil
+}
+
+// pickVariant chooses the variant of link a visitor is sent to, or nil if
+// it has none to send them to. A visitor already assigned to a variant
+// keeps it while its weight is positive. Others are assigned by weight,
+// from a hash of their client ID so that they land on the same variant
+// again, or at random without one.
+func pickVariant(link *repo.Link, req RedirectRequest) *Variant {
+	total := 0
+	for i := range link.Variants {
+		if req.Variant != "" && link.Variants[i].Name == req.Variant && link.Variants[i].Weight > 0 {
+			return &link.Variants[i]
+		}
+		total += link.Variants[i].Weight
+	}
+	if total == 0 {
+		return nil
+	}
+
+	var point int
+	if req.ClientID != "" {
+		hash := fnv.New64a()
+		hash.Write([]byte(link.Code + "\x00" + req.ClientID))
+		point = int(hash.Sum64() % uint64(total))
+	} else {
+		point = rand.N(total)
+	}
+	for i := range link.Variants {
+		if point < link.Variants[i].Weight {
+			return &link.Variants[i]
+		}
+		point -= link.Variants[i].Weight
+	}
+	return nil
+}
+
+// SetVariantWeights changes the weights of some of the variants of a split
+// link of workspace and returns all of them. At least one variant must keep a
+// positive weight. Visitors already assigned to a variant stay with it
+// unless its weight drops to zero.
+func (s *URLServiceImpl) SetVariantWeights(ctx context.Context, workspace, domain, code string, weights map[string]int) ([]Variant, error) {
+	if len(weights) == 0 {
+		return nil, &InputError{Field: "weights", Reason: "must name at least one variant"}
+	}
+	for name, weight := range weights {
+		if err := validateWeight("weights."+name, weight); err != nil {
+			return nil, err
+		}
+	}
+
+	domain = normalizeHost(domain)
+	link, err := s.repo.GetLink(ctx, domain, code)
+	if err != nil {
+		return nil, err
+	}
+	if link.Workspace != workspace {
+		return nil, fmt.Errorf("%w for code: %s", ErrNotFound, code)
+	}
+	if len(link.Variants) == 0 {
+		return nil, &InputError{Field: "weights", Reason: "the link has no variants"}
+	}
+	total := 0
+	for _, variant := range link.Variants {
+		weight, ok := weights[variant.Name]
+		if !ok {
+			weight = variant.Weight
+		}
+		total += weight
+	}
+	for name := range weights {
+		if !hasVariant(link.Variants, name) {
+			return nil, &InputError{Field: "weights." + name, Reason: "is not a variant of this link"}
+		}
+	}
+	if total == 0 {
+		return nil, &InputError{Field: "weights", Reason: "at least one variant must keep a positive weight"}
+	}
+	variants, err := s.repo.SetVariantWeights(ctx, workspace, domain, code, weights)
+	if err != nil {
+		return nil, err
+	}
+	link.Variants = variants
+	s.publish(ctx, EventLinkUpdated, link, nil)
+	return variants, nil
+}
+
+func hasVariant(variants []Variant, name string) bool {
+	for _, variant := range variants {
+		if variant.Name == name {
+			return true
+		}
+	}
+	return false
+}
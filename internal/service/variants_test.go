@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+func TestNormalizeVariants(t *testing.T) {
+	s := NewURLService(new(MockURLRepository), Config{}).(*URLServiceImpl)
+
+	variants, err := s.normalizeVariants([]Variant{
+		{URL: "HTTPS://Example.com/a", Weight: 70},
+		{Name: " Blue ", URL: "https://example.com/b", Weight: 30},
+		{URL: "https://example.com/c"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 70},
+		{Name: "blue", URL: "https://example.com/b", Weight: 30},
+		{Name: "c", URL: "https://example.com/c"},
+	}, variants)
+
+	variants, err = s.normalizeVariants(nil)
+	require.NoError(t, err)
+	assert.Nil(t, variants)
+
+	valid := Variant{URL: "https://example.com", Weight: 1}
+	tests := []struct {
+		name     string
+		variants []Variant
+		field    string
+	}{
+		{"one variant", []Variant{valid}, "variants"},
+		{"too many", make([]Variant, maxVariants+1), "variants"},
+		{"bad name", []Variant{valid, {Name: "no spaces", URL: "https://example.com", Weight: 1}}, "variants[1].name"},
+		{"duplicate name", []Variant{{Name: "b", URL: "https://example.com", Weight: 1}, valid}, "variants[1].name"},
+		{"negative weight", []Variant{valid, {URL: "https://example.com", Weight: -1}}, "variants[1].weight"},
+		{"large weight", []Variant{valid, {URL: "https://example.com", Weight: maxVariantWeight + 1}}, "variants[1].weight"},
+		{"missing url", []Variant{valid, {Weight: 1}}, "variants[1].url"},
+		{"deep link", []Variant{valid, {URL: "myapp://open", Weight: 1}}, "variants[1].url"},
+		{"no weight", []Variant{{URL: "https://example.com"}, {URL: "https://example.org"}}, "variants"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.normalizeVariants(tt.variants)
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), "%v", err)
+			assert.Equal(t, tt.field, inputErr.Field)
+		})
+	}
+}
+
+func TestPickVariant(t *testing.T) {
+	link := &repo.Link{Code: "split", Variants: []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 75},
+		{Name: "b", URL: "https://example.com/b", Weight: 25},
+		{Name: "off", URL: "https://example.com/off", Weight: 0},
+	}}
+
+	t.Run("weights", func(t *testing.T) {
+		counts := make(map[string]int)
+		for i := 0; i < 4000; i++ {
+			counts[pickVariant(link, RedirectRequest{ClientID: fmt.Sprintf("client-%d", i)}).Name]++
+		}
+		assert.InDelta(t, 3000, counts["a"], 200)
+		assert.InDelta(t, 1000, counts["b"], 200)
+		assert.Zero(t, counts["off"])
+	})
+
+	t.Run("clients keep their variant", func(t *testing.T) {
+		first := pickVariant(link, RedirectRequest{ClientID: "203.0.113.5 Firefox"})
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, first, pickVariant(link, RedirectRequest{ClientID: "203.0.113.5 Firefox"}))
+		}
+	})
+
+	t.Run("assigned visitors stay while the variant is on", func(t *testing.T) {
+		assert.Equal(t, "b", pickVariant(link, RedirectRequest{Variant: "b", ClientID: "x"}).Name)
+		assert.NotEqual(t, "off", pickVariant(link, RedirectRequest{Variant: "off"}).Name)
+		assert.NotEqual(t, "gone", pickVariant(link, RedirectRequest{Variant: "gone"}).Name)
+	})
+
+	t.Run("no weight left", func(t *testing.T) {
+		off := &repo.Link{Code: "off", Variants: []Variant{{Name: "a", URL: "https://example.com/a"}}}
+		assert.Nil(t, pickVariant(off, RedirectRequest{ClientID: "x"}))
+	})
+}
+
+func TestSplitLinks(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080"})
+	ctx := context.Background()
+
+	// Split links are never deduplicated, so StoreURL is used
+	mockRepo.On("StoreURL", "https://example.com/", mock.Anything).Return(nil).Once()
+	result, err := service.ShortenURL(ctx, ShortenRequest{
+		URL:    "https://example.com/",
+		Dedupe: true,
+		Variants: []Variant{
+			{URL: "https://example.com/a", Weight: 50},
+			{URL: "https://example.com/b", Weight: 50},
+		},
+	})
+	require.NoError(t, err)
+	assert.Len(t, result.Variants, 2)
+	mockRepo.AssertNotCalled(t, "FindOrStoreURL", mock.Anything, mock.Anything)
+
+	link := &repo.Link{
+		Code:        "split",
+		OriginalURL: "https://example.com/",
+		PassQuery:   true,
+		GeoRules:    []GeoRule{{Countries: []string{"DE"}, URL: "https://example.de/"}},
+		Variants: []Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		},
+	}
+	mockRepo.On("GetLink", "", "split").Return(link, nil)
+
+	redirect, err := service.ResolveRedirect(ctx, RedirectRequest{Code: "split", RawQuery: "x=1", Variant: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/b?x=1", redirect.URL)
+	assert.Equal(t, "b", redirect.Variant)
+	assert.True(t, redirect.Split)
+	assert.False(t, redirect.Cacheable())
+
+	// Rules come before variants
+	redirect, err = service.ResolveRedirect(ctx, RedirectRequest{Code: "split", Location: Location{Country: "DE"}, Variant: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.de/", redirect.URL)
+	assert.Empty(t, redirect.Variant)
+
+	t.Run("weights", func(t *testing.T) {
+		updated := []Variant{{Name: "a", Weight: 0}, {Name: "b", Weight: 1}}
+		mockRepo.On("SetVariantWeights", "", "", "split", map[string]int{"a": 0}).Return(updated, nil).Once()
+		variants, err := service.SetVariantWeights(ctx, "", "", "split", map[string]int{"a": 0})
+		require.NoError(t, err)
+		assert.Equal(t, updated, variants)
+
+		tests := []struct {
+			name    string
+			weights map[string]int
+			field   string
+		}{
+			{"none", map[string]int{}, "weights"},
+			{"unknown variant", map[string]int{"c": 1}, "weights.c"},
+			{"out of range", map[string]int{"a": -1}, "weights.a"},
+			{"all off", map[string]int{"a": 0, "b": 0}, "weights"},
+		}
+		for _, tt := range tests {
+			_, err := service.SetVariantWeights(ctx, "", "", "split", tt.weights)
+			var inputErr *InputError
+			require.True(t, errors.As(err, &inputErr), "%s: %v", tt.name, err)
+			assert.Equal(t, tt.field, inputErr.Field, tt.name)
+		}
+
+		mockRepo.On("GetLink", "", "plain").Return(&repo.Link{Code: "plain", OriginalURL: "https://example.com/"}, nil)
+		_, err = service.SetVariantWeights(ctx, "", "", "plain", map[string]int{"a": 1})
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+	})
+	mockRepo.AssertExpectations(t)
+}
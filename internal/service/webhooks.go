@@ -0,0 +1,648 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urlshortener/internal/metrics"
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/webhook"
+)
+
+// Link event types, as named in webhook event filters and payloads
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	EventLinkClicked = "link.clicked"
+)
+
+// linkEventTypes lists every event type in the order filters are stored
+var linkEventTypes = []string{EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventLinkClicked}
+
+const (
+	// webhookSecretPrefix starts every generated webhook secret
+	webhookSecretPrefix = "whsec_"
+	// webhookSecretLength is the number of random characters after the
+	// prefix of a generated secret
+	webhookSecretLength = 40
+	// Secrets chosen by the caller must be this long
+	minWebhookSecretLength = 16
+	maxWebhookSecretLength = 128
+	// maxWebhookURLLength bounds webhook URLs
+	maxWebhookURLLength = 2048
+	// defaultDeliveryLimit is how many deliveries a log listing returns
+	// when the caller does not say
+	defaultDeliveryLimit = 50
+	// maxDeliveryLimit bounds how many deliveries a log listing may return
+	maxDeliveryLimit = 200
+	// deliveryBatchSize is how many due deliveries a worker claims at once
+	deliveryBatchSize = 10
+	// deliveryLeaseMargin is added to WebhookOptions.Timeout to give the
+	// lease on a claimed delivery, so that it only runs out if the
+	// attempt was interrupted
+	deliveryLeaseMargin = 30 * time.Second
+	// pruneInterval is how often old deliveries are pruned from the log
+	pruneInterval = time.Hour
+)
+
+// Webhook is a URL the events of a workspace's links are sent to
+type Webhook = repo.Webhook
+
+// WebhookDelivery is an event queued for, or sent to, one webhook
+type WebhookDelivery = repo.WebhookDelivery
+
+// DeliveryPending marks deliveries still waiting for an attempt
+const DeliveryPending = repo.DeliveryPending
+
+// LinkEvent is something that happened to a link
+type LinkEvent struct {
+	// Type is one of the EventLink constants
+	Type      string
+	Workspace string
+	Link      *ShortenResult
+	// Click is the visit, for EventLinkClicked
+	Click *Click
+	// OccurredAt is when it happened; zero means now
+	OccurredAt time.Time
+	// LoadLink finds Link for an event passed to Enqueue without one. It
+	// is called by the background worker.
+	LoadLink func(ctx context.Context) (*ShortenResult, error)
+}
+
+// EventPublisher is told what happens to links, so that webhooks can be
+// sent their events
+type EventPublisher interface {
+	// Wants reports whether any webhook of workspace might want events of
+	// a type, so that callers can avoid building events nobody wants
+	Wants(ctx context.Context, workspace, eventType string) bool
+	// Publish queues an event for the webhooks of its workspace wanting
+	// it. Failures are logged rather than returned: the change the event
+	// describes has already been made.
+	Publish(ctx context.Context, event LinkEvent)
+	// Enqueue hands an event to the background worker to be published,
+	// for callers such as redirects that must not wait for the database.
+	// It never blocks, and reports false if the event was dropped because
+	// the worker is too far behind.
+	Enqueue(event LinkEvent) bool
+}
+
+// WebhookSender delivers a signed payload to a webhook
+type WebhookSender interface {
+	Send(ctx context.Context, msg webhook.Message) (int, error)
+}
+
+// WebhookService manages the webhooks of workspaces and delivers their
+// events
+type WebhookService interface {
+	EventPublisher
+	ListWebhooks(ctx context.Context, workspace string) ([]*Webhook, error)
+	GetWebhook(ctx context.Context, workspace string, id int64) (*Webhook, error)
+	// CreateWebhook validates and stores a webhook, generating its secret
+	// if it has none
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	DeleteWebhook(ctx context.Context, workspace string, id int64) error
+	// ListDeliveries returns the latest deliveries to a webhook, newest
+	// first; zero limit means a default
+	ListDeliveries(ctx context.Context, workspace string, id int64, limit int) ([]*WebhookDelivery, error)
+	// Run delivers queued events with workers goroutines until ctx is
+	// done, publishes the events passed to Enqueue, and prunes the
+	// delivery log
+	Run(ctx context.Context, workers int)
+}
+
+// WebhookOptions controls how deliveries are retried and kept. Zero
+// fields take the defaults below.
+type WebhookOptions struct {
+	// Timeout bounds each attempt; default webhook.DefaultTimeout
+	Timeout time.Duration
+	// MaxAttempts is how many attempts a delivery gets before it is
+	// marked failed; default 10
+	MaxAttempts int
+	// RetryBackoff is the wait after the first failed attempt, doubling
+	// after each later one; default 30s
+	RetryBackoff time.Duration
+	// MaxBackoff caps the wait between attempts; default 6h
+	MaxBackoff time.Duration
+	// Retention is how long delivered and failed deliveries stay in the
+	// log; default 7 days
+	Retention time.Duration
+	// PollInterval is how often idle workers look for due deliveries;
+	// default 1s
+	PollInterval time.Duration
+	// QueueSize is how many events passed to Enqueue may wait to be
+	// published before new ones are dropped; default 1000
+	QueueSize int
+}
+
+// webhookPayload is the JSON body sent to webhooks
+type webhookPayload struct {
+	Event      string        `json:"event"`
+	OccurredAt time.Time     `json:"occurred_at"`
+	Workspace  string        `json:"workspace"`
+	Link       webhookLink   `json:"link"`
+	Click      *webhookClick `json:"click,omitempty"`
+}
+
+// webhookLink describes the link an event is about
+type webhookLink struct {
+	Code        string   `json:"code"`
+	Domain      string   `json:"domain,omitempty"`
+	ShortURL    string   `json:"short_url"`
+	OriginalURL string   `json:"original_url"`
+	Tags        []string `json:"tags"`
+	Folder      string   `json:"folder,omitempty"`
+}
+
+// webhookClick describes a visit to a link
+type webhookClick struct {
+	Country string `json:"country,omitempty"`
+	Variant string `json:"variant,omitempty"`
+}
+
+// WebhookServiceImpl implements WebhookService
+type WebhookServiceImpl struct {
+	repo    repo.WebhookRepository
+	sender  WebhookSender
+	opts    WebhookOptions
+	metrics *metrics.Metrics
+	logger  *logrus.Logger
+	// now is the clock retries are scheduled with
+	now func() time.Time
+	// wake tells idle workers that deliveries were queued
+	wake chan struct{}
+	// events holds the events passed to Enqueue until they are published
+	events chan LinkEvent
+
+	// mu guards subscribed, which maps each workspace to the event types
+	// its webhooks want. It is loaded when first needed and dropped when
+	// webhooks change. generation counts the changes, so that a load begun
+	// before one is not kept after it.
+	mu         sync.RWMutex
+	subscribed map[string]map[string]bool
+	generation uint64
+}
+
+// NewWebhookService creates a new WebhookService. Deliveries are counted
+// in metrics unless it is nil. Nothing is delivered until Run is called.
+func NewWebhookService(repo repo.WebhookRepository, sender WebhookSender, opts WebhookOptions, metrics *metrics.Metrics, logger *logrus.Logger) WebhookService {
+	if opts.Timeout <= 0 {
+		opts.Timeout = webhook.DefaultTimeout
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 10
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 6 * time.Hour
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 7 * 24 * time.Hour
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+	return &WebhookServiceImpl{
+		repo:    repo,
+		sender:  sender,
+		opts:    opts,
+		metrics: metrics,
+		logger:  logger,
+		now:     time.Now,
+		wake:    make(chan struct{}, 1),
+		events:  make(chan LinkEvent, opts.QueueSize),
+	}
+}
+
+// ListWebhooks returns the webhooks of a workspace, oldest first
+func (s *WebhookServiceImpl) ListWebhooks(ctx context.Context, workspace string) ([]*Webhook, error) {
+	return s.repo.ListWebhooks(ctx, workspace)
+}
+
+// GetWebhook returns a webhook of a workspace
+func (s *WebhookServiceImpl) GetWebhook(ctx context.Context, workspace string, id int64) (*Webhook, error) {
+	return s.repo.GetWebhook(ctx, workspace, id)
+}
+
+// CreateWebhook validates and stores a webhook. Its events are checked
+// and put in a canonical order, and a secret is generated unless one was
+// given.
+func (s *WebhookServiceImpl) CreateWebhook(ctx context.Context, hook *Webhook) error {
+	if err := validateWebhookURL(hook.URL); err != nil {
+		return err
+	}
+	events, err := normalizeEventTypes(hook.Events)
+	if err != nil {
+		return err
+	}
+	hook.Events = events
+
+	if hook.Secret == "" {
+		random, err := generateUniqueCode(webhookSecretLength)
+		if err != nil {
+			return fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		hook.Secret = webhookSecretPrefix + random
+	} else if len(hook.Secret) < minWebhookSecretLength || len(hook.Secret) > maxWebhookSecretLength {
+		return &InputError{Field: "secret", Reason: fmt.Sprintf("must be %d-%d characters", minWebhookSecretLength, maxWebhookSecretLength)}
+	}
+
+	if err := s.repo.CreateWebhook(ctx, hook); err != nil {
+		return err
+	}
+	s.forgetSubscriptions()
+	return nil
+}
+
+// DeleteWebhook removes a webhook of a workspace with its pending and
+// logged deliveries
+func (s *WebhookServiceImpl) DeleteWebhook(ctx context.Context, workspace string, id int64) error {
+	if err := s.repo.DeleteWebhook(ctx, workspace, id); err != nil {
+		return err
+	}
+	s.forgetSubscriptions()
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries to a webhook of workspace,
+// newest first
+func (s *WebhookServiceImpl) ListDeliveries(ctx context.Context, workspace string, id int64, limit int) ([]*WebhookDelivery, error) {
+	if limit == 0 {
+		limit = defaultDeliveryLimit
+	}
+	if limit < 1 || limit > maxDeliveryLimit {
+		return nil, &InputError{Field: "limit", Reason: fmt.Sprintf("must be between 1 and %d", maxDeliveryLimit)}
+	}
+	return s.repo.ListDeliveries(ctx, workspace, id, limit)
+}
+
+// Wants reports whether any webhook of workspace wants events of
+// eventType. If the webhooks cannot be read it answers true, leaving
+// Publish to find out.
+func (s *WebhookServiceImpl) Wants(ctx context.Context, workspace, eventType string) bool {
+	subscribed, err := s.subscriptions(ctx)
+	if err != nil {
+		return true
+	}
+	return subscribed[workspace][eventType]
+}
+
+// Publish queues event for the webhooks of its workspace wanting it, and
+// wakes a worker to deliver it
+func (s *WebhookServiceImpl) Publish(ctx context.Context, event LinkEvent) {
+	// The change has been made, so the event is queued even if the
+	// request that made it goes away
+	ctx = context.WithoutCancel(ctx)
+	fields := logrus.Fields{"event": event.Type, "workspace": event.Workspace, "code": event.Link.Code}
+	subscribed, err := s.subscriptions(ctx)
+	if err == nil && !subscribed[event.Workspace][event.Type] {
+		return
+	}
+
+	occurredAt := event.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = s.now()
+	}
+	payload := webhookPayload{
+		Event:      event.Type,
+		OccurredAt: occurredAt.UTC(),
+		Workspace:  event.Workspace,
+		Link: webhookLink{
+			Code:        event.Link.Code,
+			Domain:      event.Link.Domain,
+			ShortURL:    event.Link.ShortURL,
+			OriginalURL: event.Link.OriginalURL,
+			Tags:        event.Link.Tags,
+			Folder:      event.Link.Folder,
+		},
+	}
+	if payload.Link.Tags == nil {
+		payload.Link.Tags = []string{}
+	}
+	if event.Click != nil {
+		payload.Click = &webhookClick{Country: event.Click.Country, Variant: event.Click.Variant}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		s.logger.WithFields(fields).WithError(err).Error("Failed to encode webhook event")
+		return
+	}
+
+	queued, err := s.repo.EnqueueDeliveries(ctx, event.Workspace, event.Type, body)
+	if err != nil {
+		s.logger.WithFields(fields).WithError(err).Error("Failed to queue webhook event")
+		return
+	}
+	if queued > 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Enqueue hands event to the background worker to be published
+func (s *WebhookServiceImpl) Enqueue(event LinkEvent) bool {
+	select {
+	case s.events <- event:
+		return true
+	default:
+		s.logger.WithFields(logrus.Fields{"event": event.Type, "workspace": event.Workspace}).
+			Warn("Webhook event queue is full; event dropped")
+		return false
+	}
+}
+
+// publishQueued loads the link of an event passed to Enqueue, if needed,
+// and publishes it
+func (s *WebhookServiceImpl) publishQueued(ctx context.Context, event LinkEvent) {
+	if event.Link == nil {
+		link, err := event.LoadLink(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.WithFields(logrus.Fields{"event": event.Type, "workspace": event.Workspace}).
+					WithError(err).Warn("Failed to load link of webhook event")
+			}
+			return
+		}
+		event.Link = link
+	}
+	s.Publish(ctx, event)
+}
+
+// Run delivers queued events with workers goroutines until ctx is done.
+// Workers poll for due deliveries, and are woken as soon as new ones are
+// queued. Another goroutine publishes the events passed to Enqueue, and
+// the delivery log is pruned once an hour.
+func (s *WebhookServiceImpl) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-s.events:
+				s.publishQueued(ctx, event)
+			}
+		}
+	}()
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(s.opts.PollInterval)
+			defer ticker.Stop()
+			for {
+				if s.deliverDue(ctx) {
+					// A full batch was claimed; there may be more
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-s.wake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			s.prune(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// deliverDue claims and attempts the deliveries that are due, and reports
+// whether a whole batch was claimed
+func (s *WebhookServiceImpl) deliverDue(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deliveries, err := s.repo.ClaimDeliveries(ctx, s.now(), s.opts.Timeout+deliveryLeaseMargin, deliveryBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.WithError(err).Error("Failed to claim webhook deliveries")
+		}
+		return false
+	}
+	for _, delivery := range deliveries {
+		s.deliver(ctx, delivery)
+	}
+	return len(deliveries) == deliveryBatchSize
+}
+
+// deliver makes one attempt at a claimed delivery and records how it
+// went. Failed attempts are retried with exponential backoff until
+// MaxAttempts have been made.
+func (s *WebhookServiceImpl) deliver(ctx context.Context, delivery *WebhookDelivery) {
+	start := time.Now()
+	sendCtx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	status, err := s.sender.Send(sendCtx, webhook.Message{
+		URL:        delivery.URL,
+		Secret:     delivery.Secret,
+		Event:      delivery.Event,
+		DeliveryID: delivery.ID,
+		Body:       delivery.Payload,
+	})
+	cancel()
+	if err != nil && ctx.Err() != nil {
+		// Shutting down; the delivery is retried once its lease is up
+		return
+	}
+
+	delivery.ResponseStatus = status
+	delivery.Error = ""
+	outcome := "delivered"
+	switch {
+	case err == nil:
+		delivery.Status = repo.DeliveryDelivered
+		delivery.NextAttemptAt = s.now()
+	case delivery.Attempts >= s.opts.MaxAttempts:
+		delivery.Status = repo.DeliveryFailed
+		delivery.NextAttemptAt = s.now()
+		delivery.Error = err.Error()
+		outcome = "failed"
+	default:
+		delivery.Status = DeliveryPending
+		delivery.NextAttemptAt = s.now().Add(s.backoff(delivery.Attempts))
+		delivery.Error = err.Error()
+		outcome = "retrying"
+	}
+	duration := time.Since(start)
+	if s.metrics != nil {
+		s.metrics.RecordWebhookDelivery(outcome, duration.Seconds())
+	}
+
+	fields := logrus.Fields{
+		"delivery_id": delivery.ID,
+		"webhook_id":  delivery.WebhookID,
+		"event":       delivery.Event,
+		"attempt":     delivery.Attempts,
+		"status":      status,
+		"duration_ms": duration.Milliseconds(),
+	}
+	if err := s.repo.CompleteDelivery(ctx, delivery); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			s.logger.WithFields(fields).WithError(err).Error("Failed to record webhook delivery")
+		}
+		return
+	}
+	switch outcome {
+	case "delivered":
+		s.logger.WithFields(fields).Info("Webhook delivered")
+	case "failed":
+		s.logger.WithFields(fields).WithField("reason", delivery.Error).Warn("Webhook delivery failed; giving up")
+	default:
+		s.logger.WithFields(fields).WithFields(logrus.Fields{
+			"reason":     delivery.Error,
+			"next_retry": delivery.NextAttemptAt,
+		}).Info("Webhook delivery failed; will retry")
+	}
+}
+
+// backoff returns the wait after a delivery's attempts failed:
+// RetryBackoff after the first, doubling after each later one, up to
+// MaxBackoff
+func (s *WebhookServiceImpl) backoff(attempts int) time.Duration {
+	wait := s.opts.RetryBackoff
+	for i := 1; i < attempts && wait < s.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, s.opts.MaxBackoff)
+}
+
+// prune removes deliveries older than the retention from the log
+func (s *WebhookServiceImpl) prune(ctx context.Context) {
+	pruned, err := s.repo.PruneDeliveries(ctx, s.now().Add(-s.opts.Retention))
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.WithError(err).Error("Failed to prune webhook deliveries")
+		}
+		return
+	}
+	if pruned > 0 {
+		s.logger.WithField("deliveries", pruned).Info("Pruned webhook delivery log")
+	}
+}
+
+// subscriptions returns the event types wanted by each workspace's
+// webhooks, loading them if needed
+func (s *WebhookServiceImpl) subscriptions(ctx context.Context) (map[string]map[string]bool, error) {
+	s.mu.RLock()
+	subscribed, generation := s.subscribed, s.generation
+	s.mu.RUnlock()
+	if subscribed != nil {
+		return subscribed, nil
+	}
+
+	webhooks, err := s.repo.ListAllWebhooks(ctx)
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to load webhooks")
+		return nil, err
+	}
+	subscribed = make(map[string]map[string]bool)
+	for _, hook := range webhooks {
+		if subscribed[hook.Workspace] == nil {
+			subscribed[hook.Workspace] = make(map[string]bool)
+		}
+		events := hook.Events
+		if len(events) == 0 {
+			events = linkEventTypes
+		}
+		for _, event := range events {
+			subscribed[hook.Workspace][event] = true
+		}
+	}
+	s.mu.Lock()
+	// Webhooks that changed while loading may be missing from the result,
+	// so it is only good for this call
+	if s.generation == generation {
+		s.subscribed = subscribed
+	}
+	s.mu.Unlock()
+	return subscribed, nil
+}
+
+// forgetSubscriptions drops the loaded subscriptions after webhooks
+// changed
+func (s *WebhookServiceImpl) forgetSubscriptions() {
+	s.mu.Lock()
+	s.subscribed = nil
+	s.generation++
+	s.mu.Unlock()
+}
+
+// validateWebhookURL checks that a webhook URL is an absolute http or
+// https URL
+func validateWebhookURL(rawURL string) error {
+	if rawURL == "" {
+		return &InputError{Field: "url", Reason: "is required"}
+	}
+	if len(rawURL) > maxWebhookURLLength {
+		return &InputError{Field: "url", Reason: fmt.Sprintf("must be at most %d characters", maxWebhookURLLength)}
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return &InputError{Field: "url", Reason: "must be an absolute http or https URL"}
+	}
+	return nil
+}
+
+// normalizeEventTypes checks a webhook's event filter and returns it
+// without duplicates, in the order of linkEventTypes
+func normalizeEventTypes(events []string) ([]string, error) {
+	wanted := make(map[string]bool, len(events))
+	for _, event := range events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if !slices.Contains(linkEventTypes, event) {
+			return nil, &InputError{Field: "events", Reason: fmt.Sprintf("must only name %s", strings.Join(linkEventTypes, ", "))}
+		}
+		wanted[event] = true
+	}
+	normalized := []string{}
+	for _, event := range linkEventTypes {
+		if wanted[event] {
+			normalized = append(normalized, event)
+		}
+	}
+	return normalized, nil
+}
+
+// publish tells the event publisher, if any, what happened to link
+func (s *URLServiceImpl) publish(ctx context.Context, eventType string, link *repo.Link, click *Click) {
+	if s.config.Events == nil {
+		return
+	}
+	s.config.Events.Publish(ctx, LinkEvent{
+		Type:      eventType,
+		Workspace: link.Workspace,
+		Link:      s.result(link),
+		Click:     click,
+	})
+}
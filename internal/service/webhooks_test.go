@@ -0,0 +1,491 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+	"github.com/urlshortener/internal/webhook"
+)
+
+// memoryWebhooks is an in-memory repo.WebhookRepository
+type memoryWebhooks struct {
+	mu         sync.Mutex
+	webhooks   []*Webhook
+	deliveries []*WebhookDelivery
+	// listed counts the loads of every workspace's webhooks
+	listed int
+	// loaded, if set, runs after every workspace's webhooks were read
+	loaded func()
+}
+
+func (m *memoryWebhooks) ListWebhooks(ctx context.Context, workspace string) ([]*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var webhooks []*Webhook
+	for _, hook := range m.webhooks {
+		if hook.Workspace == workspace {
+			webhooks = append(webhooks, hook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *memoryWebhooks) ListAllWebhooks(ctx context.Context) ([]*Webhook, error) {
+	m.mu.Lock()
+	m.listed++
+	webhooks, loaded := append([]*Webhook(nil), m.webhooks...), m.loaded
+	m.mu.Unlock()
+	if loaded != nil {
+		loaded()
+	}
+	return webhooks, nil
+}
+
+func (m *memoryWebhooks) GetWebhook(ctx context.Context, workspace string, id int64) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, hook := range m.webhooks {
+		if hook.Workspace == workspace && hook.ID == id {
+			return hook, nil
+		}
+	}
+	return nil, repo.ErrNotFound
+}
+
+func (m *memoryWebhooks) CreateWebhook(ctx context.Context, hook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook.ID = int64(len(m.webhooks) + 1)
+	hook.CreatedAt = time.Now()
+	m.webhooks = append(m.webhooks, hook)
+	return nil
+}
+
+func (m *memoryWebhooks) DeleteWebhook(ctx context.Context, workspace string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, hook := range m.webhooks {
+		if hook.Workspace == workspace && hook.ID == id {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return repo.ErrNotFound
+}
+
+func (m *memoryWebhooks) EnqueueDeliveries(ctx context.Context, workspace, event string, payload []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var queued int64
+	for _, hook := range m.webhooks {
+		if hook.Workspace != workspace || (len(hook.Events) > 0 && !strings.Contains(strings.Join(hook.Events, ","), event)) {
+			continue
+		}
+		m.deliveries = append(m.deliveries, &WebhookDelivery{
+			ID:            int64(len(m.deliveries) + 1),
+			WebhookID:     hook.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        repo.DeliveryPending,
+			NextAttemptAt: time.Now(),
+			CreatedAt:     time.Now(),
+		})
+		queued++
+	}
+	return queued, nil
+}
+
+func (m *memoryWebhooks) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var claimed []*WebhookDelivery
+	for _, delivery := range m.deliveries {
+		if len(claimed) == limit || delivery.Status != repo.DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		delivery.Attempts++
+		delivery.LastAttemptAt = &now
+		delivery.NextAttemptAt = now.Add(lease)
+		for _, hook := range m.webhooks {
+			if hook.ID == delivery.WebhookID {
+				delivery.URL, delivery.Secret = hook.URL, hook.Secret
+			}
+		}
+		copied := *delivery
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+func (m *memoryWebhooks) CompleteDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, stored := range m.deliveries {
+		if stored.ID == delivery.ID {
+			stored.Status = delivery.Status
+			stored.NextAttemptAt = delivery.NextAttemptAt
+			stored.ResponseStatus = delivery.ResponseStatus
+			stored.Error = delivery.Error
+			return nil
+		}
+	}
+	return repo.ErrNotFound
+}
+
+func (m *memoryWebhooks) ListDeliveries(ctx context.Context, workspace string, webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	if _, err := m.GetWebhook(ctx, workspace, webhookID); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deliveries []*WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			copied := *m.deliveries[i]
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *memoryWebhooks) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// recordingPublisher remembers the events published
+type recordingPublisher struct {
+	// wanted names the workspace whose webhooks want every event
+	wanted string
+	events []LinkEvent
+	// queued holds the events passed to Enqueue
+	queued []LinkEvent
+}
+
+func (p *recordingPublisher) Wants(ctx context.Context, workspace, eventType string) bool {
+	return workspace == p.wanted
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event LinkEvent) {
+	p.events = append(p.events, event)
+}
+
+func (p *recordingPublisher) Enqueue(event LinkEvent) bool {
+	p.queued = append(p.queued, event)
+	return true
+}
+
+func TestCreateWebhook(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	service := NewWebhookService(&memoryWebhooks{}, webhook.NewSender(webhook.Options{}), WebhookOptions{}, nil, logger)
+	ctx := context.Background()
+
+	t.Run("secret is generated and events are normalized", func(t *testing.T) {
+		hook := &Webhook{Workspace: "sales", URL: "https://crm.example.com/hooks", Events: []string{"link.clicked", " Link.Created ", "link.clicked"}}
+		require.NoError(t, service.CreateWebhook(ctx, hook))
+		assert.NotZero(t, hook.ID)
+		assert.True(t, strings.HasPrefix(hook.Secret, "whsec_"))
+		assert.Len(t, hook.Secret, len("whsec_")+40)
+		assert.Equal(t, []string{"link.created", "link.clicked"}, hook.Events)
+	})
+
+	t.Run("chosen secret is kept", func(t *testing.T) {
+		hook := &Webhook{Workspace: "sales", URL: "http://crm.example.com/hooks", Secret: "0123456789abcdef"}
+		require.NoError(t, service.CreateWebhook(ctx, hook))
+		assert.Equal(t, "0123456789abcdef", hook.Secret)
+		assert.Empty(t, hook.Events)
+	})
+
+	invalid := []struct {
+		name  string
+		hook  Webhook
+		field string
+	}{
+		{"missing url", Webhook{}, "url"},
+		{"relative url", Webhook{URL: "/hooks"}, "url"},
+		{"other scheme", Webhook{URL: "ftp://crm.example.com/hooks"}, "url"},
+		{"unknown event", Webhook{URL: "https://crm.example.com", Events: []string{"link.viewed"}}, "events"},
+		{"short secret", Webhook{URL: "https://crm.example.com", Secret: "short"}, "secret"},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			var inputErr *InputError
+			require.True(t, errors.As(service.CreateWebhook(ctx, &tc.hook), &inputErr))
+			assert.Equal(t, tc.field, inputErr.Field)
+		})
+	}
+
+	t.Run("delivery limit", func(t *testing.T) {
+		var inputErr *InputError
+		_, err := service.ListDeliveries(ctx, "sales", 1, 500)
+		require.True(t, errors.As(err, &inputErr))
+		assert.Equal(t, "limit", inputErr.Field)
+	})
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	service := NewWebhookService(&memoryWebhooks{}, nil, WebhookOptions{MaxBackoff: 5 * time.Minute}, nil, logger).(*WebhookServiceImpl)
+
+	assert.Equal(t, 30*time.Second, service.backoff(1))
+	assert.Equal(t, time.Minute, service.backoff(2))
+	assert.Equal(t, 4*time.Minute, service.backoff(4))
+	assert.Equal(t, 5*time.Minute, service.backoff(5))
+	assert.Equal(t, 5*time.Minute, service.backoff(60))
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 10)
+	var mu sync.Mutex
+	failures := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header, body: body}
+		mu.Lock()
+		defer mu.Unlock()
+		if failures[r.URL.Path] != 0 {
+			failures[r.URL.Path]--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	store := &memoryWebhooks{}
+	logger, _ := test.NewNullLogger()
+	sender := webhook.NewSender(webhook.Options{AllowPrivate: true, Timeout: 2 * time.Second})
+	service := NewWebhookService(store, sender, WebhookOptions{
+		MaxAttempts:  3,
+		RetryBackoff: 10 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+	}, nil, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Run(ctx, 2)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	receive := func() received {
+		select {
+		case got := <-requests:
+			return got
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook was not called")
+			return received{}
+		}
+	}
+	// settled waits for a webhook's latest delivery to stop being pending
+	settled := func(hook *Webhook) *WebhookDelivery {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			deliveries, err := service.ListDeliveries(ctx, hook.Workspace, hook.ID, 1)
+			require.NoError(t, err)
+			if len(deliveries) > 0 && deliveries[0].Status != repo.DeliveryPending {
+				return deliveries[0]
+			}
+		}
+		t.Fatal("delivery did not settle")
+		return nil
+	}
+	link := &ShortenResult{Code: "abc123", ShortURL: "http://localhost:8080/abc123", OriginalURL: "https://example.com/a"}
+
+	t.Run("signed payload is delivered", func(t *testing.T) {
+		hook := &Webhook{Workspace: "sales", URL: server.URL + "/crm"}
+		require.NoError(t, service.CreateWebhook(ctx, hook))
+		assert.True(t, service.Wants(ctx, "sales", EventLinkCreated))
+		assert.False(t, service.Wants(ctx, "marketing", EventLinkCreated), "only the workspace's webhooks count")
+
+		service.Publish(ctx, LinkEvent{Type: EventLinkCreated, Workspace: "sales", Link: link})
+		got := receive()
+		assert.Equal(t, EventLinkCreated, got.header.Get(webhook.EventHeader))
+		assert.True(t, webhook.Verify(hook.Secret, got.header.Get(webhook.TimestampHeader), got.header.Get(webhook.SignatureHeader), got.body))
+
+		var payload map[string]any
+		require.NoError(t, json.Unmarshal(got.body, &payload))
+		assert.Equal(t, "link.created", payload["event"])
+		assert.Equal(t, "sales", payload["workspace"])
+		assert.NotEmpty(t, payload["occurred_at"])
+		assert.Equal(t, map[string]any{
+			"code":         "abc123",
+			"short_url":    "http://localhost:8080/abc123",
+			"original_url": "https://example.com/a",
+			"tags":         []any{},
+		}, payload["link"])
+		assert.NotContains(t, payload, "click")
+
+		delivery := settled(hook)
+		assert.Equal(t, repo.DeliveryDelivered, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusNoContent, delivery.ResponseStatus)
+	})
+
+	t.Run("failed attempts are retried", func(t *testing.T) {
+		mu.Lock()
+		failures["/flaky"] = 2
+		mu.Unlock()
+		hook := &Webhook{Workspace: "support", URL: server.URL + "/flaky", Events: []string{EventLinkClicked}}
+		require.NoError(t, service.CreateWebhook(ctx, hook))
+
+		service.Publish(ctx, LinkEvent{Type: EventLinkClicked, Workspace: "support", Link: link, Click: &Click{Country: "DE"}})
+		first, second, third := receive(), receive(), receive()
+		assert.Equal(t, first.header.Get(webhook.DeliveryHeader), third.header.Get(webhook.DeliveryHeader))
+		assert.Equal(t, string(first.body), string(second.body))
+		assert.Contains(t, string(third.body), `"click":{"country":"DE"}`)
+
+		delivery := settled(hook)
+		assert.Equal(t, repo.DeliveryDelivered, delivery.Status)
+		assert.Equal(t, 3, delivery.Attempts)
+		assert.Empty(t, delivery.Error)
+	})
+
+	t.Run("deliveries fail after the last attempt", func(t *testing.T) {
+		mu.Lock()
+		failures["/down"] = 100
+		mu.Unlock()
+		hook := &Webhook{Workspace: "ops", URL: server.URL + "/down"}
+		require.NoError(t, service.CreateWebhook(ctx, hook))
+
+		service.Publish(ctx, LinkEvent{Type: EventLinkDeleted, Workspace: "ops", Link: link})
+		delivery := settled(hook)
+		assert.Equal(t, repo.DeliveryFailed, delivery.Status)
+		assert.Equal(t, 3, delivery.Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+		assert.Equal(t, "unexpected status 503", delivery.Error)
+		for range 3 {
+			receive()
+		}
+	})
+
+	t.Run("unwanted events are not queued", func(t *testing.T) {
+		// support only wants clicks, and nobody has webhooks in marketing
+		service.Publish(ctx, LinkEvent{Type: EventLinkCreated, Workspace: "support", Link: link})
+		service.Publish(ctx, LinkEvent{Type: EventLinkCreated, Workspace: "marketing", Link: link})
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, requests)
+		store.mu.Lock()
+		assert.Len(t, store.deliveries, 3)
+		store.mu.Unlock()
+	})
+
+	t.Run("enqueued events are published by the worker", func(t *testing.T) {
+		service.Enqueue(LinkEvent{Type: EventLinkClicked, Workspace: "support", Click: &Click{Variant: "b"},
+			LoadLink: func(ctx context.Context) (*ShortenResult, error) { return link, nil }})
+		got := receive()
+		assert.Contains(t, string(got.body), `"code":"abc123"`)
+		assert.Contains(t, string(got.body), `"click":{"variant":"b"}`)
+	})
+
+	t.Run("subscriptions are reloaded after changes", func(t *testing.T) {
+		store.mu.Lock()
+		listed := store.listed
+		store.mu.Unlock()
+		service.Wants(ctx, "sales", EventLinkUpdated)
+		store.mu.Lock()
+		assert.Equal(t, listed, store.listed, "subscriptions are cached")
+		store.mu.Unlock()
+
+		for _, hook := range []*Webhook{{Workspace: "sales", ID: 1}, {Workspace: "ops", ID: 3}} {
+			require.NoError(t, service.DeleteWebhook(ctx, hook.Workspace, hook.ID))
+		}
+		assert.False(t, service.Wants(ctx, "sales", EventLinkUpdated))
+		assert.True(t, service.Wants(ctx, "support", EventLinkClicked))
+	})
+}
+
+func TestWebhookSubscriptionsChangedWhileLoading(t *testing.T) {
+	store := &memoryWebhooks{}
+	logger, _ := test.NewNullLogger()
+	service := NewWebhookService(store, nil, WebhookOptions{}, nil, logger)
+	ctx := context.Background()
+
+	// A webhook created while the subscriptions are being loaded
+	store.loaded = func() {
+		store.mu.Lock()
+		store.loaded = nil
+		store.mu.Unlock()
+		require.NoError(t, service.CreateWebhook(ctx, &Webhook{Workspace: "sales", URL: "https://crm.example.com/hooks"}))
+	}
+	assert.False(t, service.Wants(ctx, "sales", EventLinkCreated), "the load began before the webhook existed")
+	assert.True(t, service.Wants(ctx, "sales", EventLinkCreated), "the stale load was not cached")
+}
+
+func TestURLServicePublishesEvents(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	events := &recordingPublisher{}
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Events: events})
+	ctx := context.Background()
+
+	mockRepo.On("StoreURL", mock.Anything, mock.Anything).Return(nil)
+	created, err := service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/a", Workspace: "sales"})
+	require.NoError(t, err)
+
+	mockRepo.On("FindOrStoreURL", mock.Anything, mock.Anything).
+		Return(&repo.Link{Code: "exist1", OriginalURL: "https://example.com/a"}, nil)
+	_, err = service.ShortenURL(ctx, ShortenRequest{URL: "https://example.com/a", Dedupe: true})
+	require.NoError(t, err)
+
+	tags := []string{"crm"}
+	mockRepo.On("UpdateLink", "sales", "", created.Code, repo.LinkUpdate{Tags: &tags}).
+		Return(&repo.Link{Code: created.Code, Workspace: "sales", Tags: tags}, nil)
+	_, err = service.UpdateLink(ctx, "sales", "", created.Code, LinkUpdate{Tags: &[]string{"CRM"}})
+	require.NoError(t, err)
+
+	mockRepo.On("DeleteLink", "sales", "", created.Code).
+		Return(&repo.Link{Code: created.Code, Workspace: "sales", OriginalURL: "https://example.com/a"}, nil)
+	deleted, err := service.DeleteLink(ctx, "sales", "", created.Code)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/a", deleted.OriginalURL)
+
+	mockRepo.On("DeleteLink", "sales", "", "nosuch").Return(nil, repo.ErrNotFound)
+	_, err = service.DeleteLink(ctx, "sales", "", "nosuch")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// Clicks are only handed over when the link's workspace wants them,
+	// and the link is only looked up by the worker
+	mockRepo.On("RecordClick", "", "click1", Click{Country: "FR"}).Return("sales", nil)
+	require.NoError(t, service.RecordClick(ctx, "", "click1", Click{Country: "FR"}))
+	assert.Empty(t, events.queued)
+	events.wanted = "sales"
+	require.NoError(t, service.RecordClick(ctx, "", "click1", Click{Country: "FR"}))
+	mockRepo.AssertNotCalled(t, "GetLink", "", "click1")
+	require.Len(t, events.queued, 1)
+	clicked := events.queued[0]
+	assert.Equal(t, EventLinkClicked, clicked.Type)
+	assert.Equal(t, "sales", clicked.Workspace)
+	assert.Equal(t, &Click{Country: "FR"}, clicked.Click)
+	assert.False(t, clicked.OccurredAt.IsZero())
+	mockRepo.On("GetLink", "", "click1").Return(&repo.Link{Code: "click1", Workspace: "sales"}, nil)
+	loaded, err := clicked.LoadLink(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "click1", loaded.Code)
+
+	require.Len(t, events.events, 3)
+	assert.Equal(t, EventLinkCreated, events.events[0].Type)
+	assert.Equal(t, "sales", events.events[0].Workspace)
+	assert.Equal(t, created.Code, events.events[0].Link.Code)
+	assert.Equal(t, EventLinkUpdated, events.events[1].Type)
+	assert.Equal(t, []string{"crm"}, events.events[1].Link.Tags)
+	assert.Equal(t, EventLinkDeleted, events.events[2].Type)
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+const (
+	// apiKeyPrefix starts every API key, so that keys are recognisable in
+	// configuration and leaked-secret scans
+	apiKeyPrefix = "usk_"
+	// apiKeyLength is the number of random characters after the prefix
+	apiKeyLength = 40
+	// apiKeyShownLength is how much of a key is kept to tell keys apart
+	apiKeyShownLength = len(apiKeyPrefix) + 8
+	// maxWorkspaceNameLength bounds workspace and API key names
+	maxWorkspaceNameLength = 100
+)
+
+// Roles a member can have in a workspace
+const (
+	// RoleAdmin manages the workspace's links, folders, tags, domains and
+	// UTM templates through the admin API
+	RoleAdmin = "admin"
+	// RoleMember can only create links
+	RoleMember = "member"
+)
+
+// workspaceSlugPattern matches workspace slugs
+var workspaceSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ErrInvalidAPIKey is returned when an API key is malformed, unknown or
+// revoked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// Workspace is an isolated set of links and everything organising them
+type Workspace = repo.Workspace
+
+// Member is a person belonging to a workspace
+type Member = repo.Member
+
+// APIKey lets a member call the API on behalf of their workspace
+type APIKey = repo.APIKey
+
+// Principal is who a request acts for
+type Principal struct {
+	// Workspace is the workspace the request is confined to
+	Workspace string
+	// Member is the email of the member whose API key was used; empty for
+	// the admin token
+	Member string
+	// Role is RoleAdmin or RoleMember
+	Role string
+}
+
+// IsAdmin reports whether the principal may use the admin API
+func (p *Principal) IsAdmin() bool {
+	return p != nil && p.Role == RoleAdmin
+}
+
+// WorkspaceService manages workspaces, their members and API keys. The
+// default workspace, with an empty slug, always exists and holds the
+// links created without an API key.
+type WorkspaceService interface {
+	ListWorkspaces(ctx context.Context) ([]*Workspace, error)
+	GetWorkspace(ctx context.Context, slug string) (*Workspace, error)
+	CreateWorkspace(ctx context.Context, workspace *Workspace) error
+	DeleteWorkspace(ctx context.Context, slug string) error
+	ListMembers(ctx context.Context, workspace string) ([]*Member, error)
+	SaveMember(ctx context.Context, member *Member) error
+	RemoveMember(ctx context.Context, workspace, email string) error
+	ListAPIKeys(ctx context.Context, workspace string) ([]*APIKey, error)
+	CreateAPIKey(ctx context.Context, key *APIKey) (string, error)
+	DeleteAPIKey(ctx context.Context, workspace string, id int64) error
+	Authenticate(ctx context.Context, secret string) (*Principal, error)
+}
+
+// WorkspaceServiceImpl implements WorkspaceService
+type WorkspaceServiceImpl struct {
+	repo repo.WorkspaceRepository
+}
+
+// NewWorkspaceService creates a new WorkspaceService
+func NewWorkspaceService(repo repo.WorkspaceRepository) WorkspaceService {
+	return &WorkspaceServiceImpl{repo: repo}
+}
+
+// ListWorkspaces returns every workspace but the default one, ordered by
+// slug
+func (s *WorkspaceServiceImpl) ListWorkspaces(ctx context.Context) ([]*Workspace, error) {
+	return s.repo.ListWorkspaces(ctx)
+}
+
+// GetWorkspace retrieves a workspace by slug
+func (s *WorkspaceServiceImpl) GetWorkspace(ctx context.Context, slug string) (*Workspace, error) {
+	if err := validateWorkspaceSlug("workspace", slug); err != nil {
+		return nil, err
+	}
+	return s.repo.GetWorkspace(ctx, slug)
+}
+
+// CreateWorkspace validates and stores a new, empty workspace
+func (s *WorkspaceServiceImpl) CreateWorkspace(ctx context.Context, workspace *Workspace) error {
+	if err := validateWorkspaceSlug("slug", workspace.Slug); err != nil {
+		return err
+	}
+	workspace.Name = strings.TrimSpace(workspace.Name)
+	if workspace.Name == "" {
+		workspace.Name = workspace.Slug
+	}
+	if err := validateName(workspace.Name); err != nil {
+		return err
+	}
+	return s.repo.CreateWorkspace(ctx, workspace)
+}
+
+// DeleteWorkspace removes a workspace with its members and API keys. Its
+// links and domains must be deleted or moved first.
+func (s *WorkspaceServiceImpl) DeleteWorkspace(ctx context.Context, slug string) error {
+	if err := validateWorkspaceSlug("workspace", slug); err != nil {
+		return err
+	}
+	return s.repo.DeleteWorkspace(ctx, slug)
+}
+
+// ListMembers returns the members of a workspace ordered by email
+func (s *WorkspaceServiceImpl) ListMembers(ctx context.Context, workspace string) ([]*Member, error) {
+	if _, err := s.GetWorkspace(ctx, workspace); err != nil {
+		return nil, err
+	}
+	return s.repo.ListMembers(ctx, workspace)
+}
+
+// SaveMember adds a member to a workspace or changes their role
+func (s *WorkspaceServiceImpl) SaveMember(ctx context.Context, member *Member) error {
+	if err := validateWorkspaceSlug("workspace", member.Workspace); err != nil {
+		return err
+	}
+	email, err := normalizeEmail(member.Email)
+	if err != nil {
+		return err
+	}
+	if member.Role != RoleAdmin && member.Role != RoleMember {
+		return &InputError{Field: "role", Reason: "must be admin or member"}
+	}
+	member.Email = email
+	return s.repo.SaveMember(ctx, member)
+}
+
+// RemoveMember removes a member from a workspace, revoking their API keys
+func (s *WorkspaceServiceImpl) RemoveMember(ctx context.Context, workspace, email string) error {
+	if err := validateWorkspaceSlug("workspace", workspace); err != nil {
+		return err
+	}
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	return s.repo.RemoveMember(ctx, workspace, email)
+}
+
+// ListAPIKeys returns the API keys of a workspace, oldest first
+func (s *WorkspaceServiceImpl) ListAPIKeys(ctx context.Context, workspace string) ([]*APIKey, error) {
+	if _, err := s.GetWorkspace(ctx, workspace); err != nil {
+		return nil, err
+	}
+	return s.repo.ListAPIKeys(ctx, workspace)
+}
+
+// CreateAPIKey issues a key for key.Member and returns it. Only its hash
+// is stored, so the key cannot be shown again.
+func (s *WorkspaceServiceImpl) CreateAPIKey(ctx context.Context, key *APIKey) (string, error) {
+	if err := validateWorkspaceSlug("workspace", key.Workspace); err != nil {
+		return "", err
+	}
+	email, err := normalizeEmail(key.Member)
+	if err != nil {
+		return "", err
+	}
+	key.Member = email
+	key.Name = strings.TrimSpace(key.Name)
+	if err := validateName(key.Name); err != nil {
+		return "", err
+	}
+
+	random, err := generateUniqueCode(apiKeyLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret := apiKeyPrefix + random
+	key.Prefix = secret[:apiKeyShownLength]
+	key.KeyHash = apiKeyHash(secret)
+	if err := s.repo.CreateAPIKey(ctx, key); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// DeleteAPIKey revokes an API key of a workspace
+func (s *WorkspaceServiceImpl) DeleteAPIKey(ctx context.Context, workspace string, id int64) error {
+	if err := validateWorkspaceSlug("workspace", workspace); err != nil {
+		return err
+	}
+	return s.repo.DeleteAPIKey(ctx, workspace, id)
+}
+
+// Authenticate returns who the API key secret acts for, or
+// ErrInvalidAPIKey if it is not a current key
+func (s *WorkspaceServiceImpl) Authenticate(ctx context.Context, secret string) (*Principal, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) || len(secret) != len(apiKeyPrefix)+apiKeyLength {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := s.repo.UseAPIKey(ctx, apiKeyHash(secret))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Workspace: key.Workspace, Member: key.Member, Role: key.Role}, nil
+}
+
+// IsAPIKey reports whether credential looks like an API key rather than
+// the admin token
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, apiKeyPrefix)
+}
+
+// apiKeyHash returns the digest stored for an API key
+func apiKeyHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateWorkspaceSlug checks a workspace slug given in field
+func validateWorkspaceSlug(field, slug string) error {
+	if !workspaceSlugPattern.MatchString(slug) {
+		return &InputError{Field: field, Reason: "must be 1-63 lowercase letters, digits or '-'"}
+	}
+	return nil
+}
+
+// validateName checks the display name of a workspace or API key
+func validateName(name string) error {
+	if utf8.RuneCountInString(name) > maxWorkspaceNameLength {
+		return &InputError{Field: "name", Reason: fmt.Sprintf("must be at most %d characters", maxWorkspaceNameLength)}
+	}
+	return nil
+}
+
+// normalizeEmail lowercases and validates a member's email address
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", &InputError{Field: "email", Reason: "must be an email address such as ann@example.com"}
+	}
+	return email, nil
+}
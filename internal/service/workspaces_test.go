@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+// MockWorkspaceRepository is a mock implementation of repo.WorkspaceRepository
+type MockWorkspaceRepository struct {
+	mock.Mock
+}
+
+func (m *MockWorkspaceRepository) ListWorkspaces(ctx context.Context) ([]*repo.Workspace, error) {
+	args := m.Called()
+	workspaces, _ := args.Get(0).([]*repo.Workspace)
+	return workspaces, args.Error(1)
+}
+
+func (m *MockWorkspaceRepository) GetWorkspace(ctx context.Context, slug string) (*repo.Workspace, error) {
+	args := m.Called(slug)
+	workspace, _ := args.Get(0).(*repo.Workspace)
+	return workspace, args.Error(1)
+}
+
+func (m *MockWorkspaceRepository) CreateWorkspace(ctx context.Context, workspace *repo.Workspace) error {
+	return m.Called(*workspace).Error(0)
+}
+
+func (m *MockWorkspaceRepository) DeleteWorkspace(ctx context.Context, slug string) error {
+	return m.Called(slug).Error(0)
+}
+
+func (m *MockWorkspaceRepository) ListMembers(ctx context.Context, workspace string) ([]*repo.Member, error) {
+	args := m.Called(workspace)
+	members, _ := args.Get(0).([]*repo.Member)
+	return members, args.Error(1)
+}
+
+func (m *MockWorkspaceRepository) SaveMember(ctx context.Context, member *repo.Member) error {
+	return m.Called(*member).Error(0)
+}
+
+func (m *MockWorkspaceRepository) RemoveMember(ctx context.Context, workspace, email string) error {
+	return m.Called(workspace, email).Error(0)
+}
+
+func (m *MockWorkspaceRepository) ListAPIKeys(ctx context.Context, workspace string) ([]*repo.APIKey, error) {
+	args := m.Called(workspace)
+	keys, _ := args.Get(0).([]*repo.APIKey)
+	return keys, args.Error(1)
+}
+
+func (m *MockWorkspaceRepository) CreateAPIKey(ctx context.Context, key *repo.APIKey) error {
+	return m.Called(key).Error(0)
+}
+
+func (m *MockWorkspaceRepository) DeleteAPIKey(ctx context.Context, workspace string, id int64) error {
+	return m.Called(workspace, id).Error(0)
+}
+
+func (m *MockWorkspaceRepository) UseAPIKey(ctx context.Context, hash string) (*repo.APIKey, error) {
+	args := m.Called(hash)
+	key, _ := args.Get(0).(*repo.APIKey)
+	return key, args.Error(1)
+}
+
+func TestWorkspaceService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("slugs are validated and names default to them", func(t *testing.T) {
+		mockRepo := new(MockWorkspaceRepository)
+		service := NewWorkspaceService(mockRepo)
+		mockRepo.On("CreateWorkspace", repo.Workspace{Slug: "marketing", Name: "marketing"}).Return(nil).Once()
+
+		require.NoError(t, service.CreateWorkspace(ctx, &Workspace{Slug: "marketing", Name: "  "}))
+		for _, slug := range []string{"", "Marketing", "-team", "a/b", strings.Repeat("a", 64)} {
+			assert.True(t, errors.Is(service.CreateWorkspace(ctx, &Workspace{Slug: slug}), ErrInvalidInput), slug)
+		}
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("members are normalised", func(t *testing.T) {
+		mockRepo := new(MockWorkspaceRepository)
+		service := NewWorkspaceService(mockRepo)
+		mockRepo.On("SaveMember", repo.Member{Workspace: "marketing", Email: "ann@example.com", Role: RoleAdmin}).Return(nil).Once()
+
+		require.NoError(t, service.SaveMember(ctx, &Member{Workspace: "marketing", Email: " Ann@Example.com ", Role: RoleAdmin}))
+		assert.True(t, errors.Is(service.SaveMember(ctx, &Member{Workspace: "marketing", Email: "Ann <ann@example.com>", Role: RoleAdmin}), ErrInvalidInput))
+		assert.True(t, errors.Is(service.SaveMember(ctx, &Member{Workspace: "marketing", Email: "ann@example.com", Role: "owner"}), ErrInvalidInput))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("only the hash of a new key is stored", func(t *testing.T) {
+		mockRepo := new(MockWorkspaceRepository)
+		service := NewWorkspaceService(mockRepo)
+		var stored *repo.APIKey
+		mockRepo.On("CreateAPIKey", mock.Anything).Run(func(args mock.Arguments) {
+			stored = args.Get(0).(*repo.APIKey)
+		}).Return(nil).Once()
+
+		secret, err := service.CreateAPIKey(ctx, &APIKey{Workspace: "marketing", Member: "ann@example.com", Name: "ci"})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(secret, "usk_"))
+		assert.Len(t, secret, 44)
+		assert.Equal(t, secret[:12], stored.Prefix)
+		assert.Equal(t, apiKeyHash(secret), stored.KeyHash)
+		assert.NotContains(t, stored.KeyHash, secret)
+	})
+
+	t.Run("authenticate", func(t *testing.T) {
+		mockRepo := new(MockWorkspaceRepository)
+		service := NewWorkspaceService(mockRepo)
+		secret := "usk_" + strings.Repeat("a", 40)
+		mockRepo.On("UseAPIKey", apiKeyHash(secret)).
+			Return(&repo.APIKey{Workspace: "marketing", Member: "ann@example.com", Role: RoleMember}, nil).Once()
+		mockRepo.On("UseAPIKey", apiKeyHash("usk_"+strings.Repeat("b", 40))).
+			Return(nil, fmt.Errorf("%w for API key: ", ErrNotFound)).Once()
+
+		principal, err := service.Authenticate(ctx, secret)
+		require.NoError(t, err)
+		assert.Equal(t, &Principal{Workspace: "marketing", Member: "ann@example.com", Role: RoleMember}, principal)
+		assert.False(t, principal.IsAdmin())
+
+		_, err = service.Authenticate(ctx, "usk_"+strings.Repeat("b", 40))
+		assert.True(t, errors.Is(err, ErrInvalidAPIKey))
+		_, err = service.Authenticate(ctx, "usk_short")
+		assert.True(t, errors.Is(err, ErrInvalidAPIKey))
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestWorkspaceIsolation(t *testing.T) {
+	mockRepo := new(MockURLRepository)
+	domains := new(MockDomainRepository)
+	templates := new(MockUTMTemplateRepository)
+	service := NewURLService(mockRepo, Config{BaseURL: "http://localhost:8080", Domains: domains, UTMTemplates: templates})
+	ctx := context.Background()
+
+	domains.On("GetDomain", "mk.example.com").Return(&repo.Domain{Host: "mk.example.com", Workspace: "marketing"}, nil)
+
+	t.Run("links can only be created on the workspace's domains", func(t *testing.T) {
+		mockRepo.On("StoreURL", "https://example.com/", "promo").Return(nil).Once()
+
+		result, err := service.ShortenURL(ctx, ShortenRequest{Workspace: "marketing", URL: "https://example.com/", Alias: "promo", Domain: "mk.example.com"})
+		require.NoError(t, err)
+		assert.Equal(t, "mk.example.com", result.Domain)
+
+		_, err = service.ShortenURL(ctx, ShortenRequest{Workspace: "sales", URL: "https://example.com/", Alias: "promo", Domain: "mk.example.com"})
+		var inputErr *InputError
+		require.True(t, errors.As(err, &inputErr))
+		assert.Equal(t, "domain", inputErr.Field)
+		assert.Equal(t, "is not a registered domain", inputErr.Reason)
+	})
+
+	t.Run("templates come from the link's workspace", func(t *testing.T) {
+		templates.On("GetUTMTemplate", "sales", "growth", "newsletter").Return(nil, fmt.Errorf("%w for template: growth/newsletter", ErrNotFound)).Once()
+
+		_, err := service.ShortenURL(ctx, ShortenRequest{Workspace: "sales", URL: "https://example.com/", UTMTemplate: "growth/newsletter"})
+		assert.True(t, errors.Is(err, ErrInvalidInput))
+		templates.AssertExpectations(t)
+	})
+
+	t.Run("variants of other workspaces' links are not found", func(t *testing.T) {
+		mockRepo.On("GetLink", "mk.example.com", "promo").
+			Return(&repo.Link{Code: "promo", Domain: "mk.example.com", Workspace: "marketing", Variants: []repo.Variant{{Name: "a", Weight: 1}}}, nil).Once()
+
+		_, err := service.SetVariantWeights(ctx, "sales", "mk.example.com", "promo", map[string]int{"a": 2})
+		assert.True(t, errors.Is(err, ErrNotFound))
+		mockRepo.AssertNotCalled(t, "SetVariantWeights", "sales", "mk.example.com", "promo", mock.Anything)
+	})
+
+	t.Run("domains of other workspaces are not found", func(t *testing.T) {
+		domainService := NewDomainService(domains, "http://localhost:8080")
+
+		_, err := domainService.GetDomain(ctx, "sales", "mk.example.com")
+		assert.True(t, errors.Is(err, ErrNotFound))
+		domain, err := domainService.GetDomain(ctx, "marketing", "mk.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "marketing", domain.Workspace)
+	})
+}
@@ -0,0 +1,483 @@
+// Package transfer encodes and decodes links in portable formats for
+// backup, migration and bulk import.
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urlshortener/internal/repo"
+)
+
+// Format is a supported interchange format
+type Format string
+
+const (
+	// FormatCSV is comma-separated values with a header row
+	FormatCSV Format = "csv"
+	// FormatJSONL is one JSON object per line
+	FormatJSONL Format = "jsonl"
+)
+
+// maxLineBytes bounds a single record when decoding JSON Lines
+const maxLineBytes = 64 << 10
+
+// csvHeader lists the CSV columns in export order. Tags are joined with ";";
+// device and geo rules are JSON arrays.
+var csvHeader = []string{"code", "original_url", "created_at", "clicks", "last_clicked_at", "tags", "redirect_status",
+	"pass_query", "pass_path", "password_hash", "max_clicks", "active_from", "active_until", "fallback_url",
+	"interstitial", "social_title", "social_description", "social_image_url", "device_rules", "geo_rules", "variants", "folder", "domain"}
+
+// ParseFormat parses a format name, accepting common aliases
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "csv":
+		return FormatCSV, nil
+	case "jsonl", "ndjson", "jsonlines":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q (want csv or jsonl)", name)
+	}
+}
+
+// FormatForMediaType maps a Content-Type or Accept media type to a format
+func FormatForMediaType(mediaType string) (Format, bool) {
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV, true
+	case "application/x-ndjson", "application/jsonl":
+		return FormatJSONL, true
+	default:
+		return "", false
+	}
+}
+
+// ContentType returns the media type for the format
+func (f Format) ContentType() string {
+	if f == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Record is the portable representation of a link
+type Record struct {
+	Code           string     `json:"code"`
+	OriginalURL    string     `json:"original_url"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	Clicks         int64      `json:"clicks"`
+	LastClickedAt  *time.Time `json:"last_clicked_at,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	Folder         string     `json:"folder,omitempty"`
+	Domain         string     `json:"domain,omitempty"`
+	RedirectStatus int        `json:"redirect_status,omitempty"`
+	PassQuery      bool       `json:"pass_query,omitempty"`
+	PassPath       bool       `json:"pass_path,omitempty"`
+	PasswordHash   string     `json:"password_hash,omitempty"`
+	MaxClicks      int        `json:"max_clicks,omitempty"`
+	ActiveFrom     *time.Time `json:"active_from,omitempty"`
+	ActiveUntil    *time.Time `json:"active_until,omitempty"`
+	FallbackURL    string     `json:"fallback_url,omitempty"`
+	Interstitial   bool       `json:"interstitial,omitempty"`
+	// SocialTitle, SocialDescription and SocialImageURL make up the link's
+	// social card
+	SocialTitle       string            `json:"social_title,omitempty"`
+	SocialDescription string            `json:"social_description,omitempty"`
+	SocialImageURL    string            `json:"social_image_url,omitempty"`
+	DeviceRules       []repo.DeviceRule `json:"device_rules,omitempty"`
+	GeoRules          []repo.GeoRule    `json:"geo_rules,omitempty"`
+	Variants          []repo.Variant    `json:"variants,omitempty"`
+}
+
+// NewRecord converts a stored link into a record
+func NewRecord(link *repo.Link) Record {
+	record := Record{
+		Code:           link.Code,
+		OriginalURL:    link.OriginalURL,
+		Clicks:         link.Clicks,
+		LastClickedAt:  link.LastClickedAt,
+		Tags:           link.Tags,
+		Folder:         link.Folder,
+		Domain:         link.Domain,
+		RedirectStatus: link.RedirectStatus,
+		PassQuery:      link.PassQuery,
+		PassPath:       link.PassPath,
+		PasswordHash:   link.PasswordHash,
+		MaxClicks:      link.MaxClicks,
+		ActiveFrom:     link.ActiveFrom,
+		ActiveUntil:    link.ActiveUntil,
+		FallbackURL:    link.FallbackURL,
+		Interstitial:   link.Interstitial,
+
+		SocialTitle:       link.Social.Title,
+		SocialDescription: link.Social.Description,
+		SocialImageURL:    link.Social.ImageURL,
+		DeviceRules:       link.DeviceRules,
+		GeoRules:          link.GeoRules,
+		Variants:          link.Variants,
+	}
+	if !link.CreatedAt.IsZero() {
+		createdAt := link.CreatedAt.UTC()
+		record.CreatedAt = &createdAt
+	}
+	return record
+}
+
+// Link converts a record into a link ready to be stored
+func (rec Record) Link() *repo.Link {
+	link := &repo.Link{
+		Code:           rec.Code,
+		OriginalURL:    rec.OriginalURL,
+		Clicks:         rec.Clicks,
+		LastClickedAt:  rec.LastClickedAt,
+		Tags:           rec.Tags,
+		Folder:         rec.Folder,
+		Domain:         rec.Domain,
+		RedirectStatus: rec.RedirectStatus,
+		PassQuery:      rec.PassQuery,
+		PassPath:       rec.PassPath,
+		PasswordHash:   rec.PasswordHash,
+		MaxClicks:      rec.MaxClicks,
+		ActiveFrom:     rec.ActiveFrom,
+		ActiveUntil:    rec.ActiveUntil,
+		FallbackURL:    rec.FallbackURL,
+		Interstitial:   rec.Interstitial,
+		Social: repo.SocialCard{
+			Title:       rec.SocialTitle,
+			Description: rec.SocialDescription,
+			ImageURL:    rec.SocialImageURL,
+		},
+		DeviceRules: rec.DeviceRules,
+		GeoRules:    rec.GeoRules,
+		Variants:    rec.Variants,
+	}
+	if rec.CreatedAt != nil {
+		link.CreatedAt = rec.CreatedAt.UTC()
+	}
+	return link
+}
+
+// Encoder writes links in a given format
+type Encoder interface {
+	Encode(link *repo.Link) error
+	// Flush writes any buffered data; call it once after the last Encode
+	Flush() error
+}
+
+// NewEncoder returns an encoder writing format to w
+func NewEncoder(w io.Writer, format Format) Encoder {
+	if format == FormatCSV {
+		return &csvEncoder{w: csv.NewWriter(w)}
+	}
+	return &jsonlEncoder{bw: bufio.NewWriter(w)}
+}
+
+type csvEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvEncoder) Encode(link *repo.Link) error {
+	if !e.wroteHeader {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+
+	record := NewRecord(link)
+	deviceRules, err := formatRules(record.DeviceRules)
+	if err != nil {
+		return err
+	}
+	geoRules, err := formatRules(record.GeoRules)
+	if err != nil {
+		return err
+	}
+	variants, err := formatRules(record.Variants)
+	if err != nil {
+		return err
+	}
+	return e.w.Write([]string{
+		record.Code,
+		record.OriginalURL,
+		formatTime(record.CreatedAt),
+		strconv.FormatInt(record.Clicks, 10),
+		formatTime(record.LastClickedAt),
+		strings.Join(record.Tags, ";"),
+		formatOptional(record.RedirectStatus),
+		formatFlag(record.PassQuery),
+		formatFlag(record.PassPath),
+		record.PasswordHash,
+		formatOptional(record.MaxClicks),
+		formatTime(record.ActiveFrom),
+		formatTime(record.ActiveUntil),
+		record.FallbackURL,
+		formatFlag(record.Interstitial),
+		record.SocialTitle,
+		record.SocialDescription,
+		record.SocialImageURL,
+		deviceRules,
+		geoRules,
+		variants,
+		record.Folder,
+		record.Domain,
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	if !e.wroteHeader {
+		// An empty export still gets a header so it can be re-imported
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlEncoder struct {
+	bw *bufio.Writer
+}
+
+func (e *jsonlEncoder) Encode(link *repo.Link) error {
+	// json.Encoder terminates each value with a newline
+	return json.NewEncoder(e.bw).Encode(NewRecord(link))
+}
+
+func (e *jsonlEncoder) Flush() error {
+	return e.bw.Flush()
+}
+
+// RecordError reports a record that could not be parsed. Decoding may
+// continue after a RecordError; any other error is fatal.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads links in a given format
+type Decoder interface {
+	// Decode returns the next link and the line it started on. It returns
+	// io.EOF when the input is exhausted.
+	Decode() (*repo.Link, int, error)
+}
+
+// NewDecoder returns a decoder reading format from r. For CSV the header
+// row is read immediately and must contain code and original_url columns.
+func NewDecoder(r io.Reader, format Format) (Decoder, error) {
+	if format == FormatCSV {
+		return newCSVDecoder(r)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineBytes)
+	return &jsonlDecoder{scanner: scanner}, nil
+}
+
+type csvDecoder struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVDecoder(r io.Reader) (*csvDecoder, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("CSV input is empty")
+		}
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"code", "original_url"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+	return &csvDecoder{r: reader, columns: columns}, nil
+}
+
+func (d *csvDecoder) Decode() (*repo.Link, int, error) {
+	fields, err := d.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, parseErr.StartLine, &RecordError{Line: parseErr.StartLine, Err: parseErr.Err}
+		}
+		return nil, 0, err
+	}
+	line, _ := d.r.FieldPos(0)
+
+	field := func(name string) string {
+		if i, ok := d.columns[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+
+	record := Record{
+		Code:        field("code"),
+		OriginalURL: field("original_url"),
+	}
+	if tags := field("tags"); tags != "" {
+		record.Tags = strings.Split(tags, ";")
+	}
+	if record.CreatedAt, err = parseTime(field("created_at")); err != nil {
+		return nil, line, &RecordError{Line: line, Err: fmt.Errorf("created_at: %w", err)}
+	}
+	if record.LastClickedAt, err = parseTime(field("last_clicked_at")); err != nil {
+		return nil, line, &RecordError{Line: line, Err: fmt.Errorf("last_clicked_at: %w", err)}
+	}
+	if clicks := field("clicks"); clicks != "" {
+		if record.Clicks, err = strconv.ParseInt(clicks, 10, 64); err != nil || record.Clicks < 0 {
+			return nil, line, &RecordError{Line: line, Err: fmt.Errorf("clicks: invalid count %q", clicks)}
+		}
+	}
+	if status := field("redirect_status"); status != "" {
+		if record.RedirectStatus, err = strconv.Atoi(status); err != nil {
+			return nil, line, &RecordError{Line: line, Err: fmt.Errorf("redirect_status: invalid status %q", status)}
+		}
+	}
+	if maxClicks := field("max_clicks"); maxClicks != "" {
+		if record.MaxClicks, err = strconv.Atoi(maxClicks); err != nil || record.MaxClicks < 0 {
+			return nil, line, &RecordError{Line: line, Err: fmt.Errorf("max_clicks: invalid count %q", maxClicks)}
+		}
+	}
+	if record.ActiveFrom, err = parseTime(field("active_from")); err != nil {
+		return nil, line, &RecordError{Line: line, Err: fmt.Errorf("active_from: %w", err)}
+	}
+	if record.ActiveUntil, err = parseTime(field("active_until")); err != nil {
+		return nil, line, &RecordError{Line: line, Err: fmt.Errorf("active_until: %w", err)}
+	}
+	record.Folder = field("folder")
+	record.Domain = field("domain")
+	record.PasswordHash = field("password_hash")
+	record.FallbackURL = field("fallback_url")
+	record.SocialTitle = field("social_title")
+	record.SocialDescription = field("social_description")
+	record.SocialImageURL = field("social_image_url")
+	if err := parseRules(field("device_rules"), &record.DeviceRules); err != nil {
+		return nil, line, &RecordError{Line: line, Err: fmt.Errorf("device_rules: %w", err)}
+	}
+	if err := parseRules(field("geo_rules"), &record.GeoRules); err != nil {
+		return nil, line, &RecordError{Line: line, Err: fmt.Errorf("geo_rules: %w", err)}
+	}
+	if err := parseRules(field("variants"), &record.Variants); err != nil {
+		return nil, line, &RecordError{Line: line, Err: fmt.Errorf("variants: %w", err)}
+	}
+	flags := map[string]*bool{"pass_query": &record.PassQuery, "pass_path": &record.PassPath, "interstitial": &record.Interstitial}
+	for name, flag := range flags {
+		if value := field(name); value != "" {
+			if *flag, err = strconv.ParseBool(value); err != nil {
+				return nil, line, &RecordError{Line: line, Err: fmt.Errorf("%s: invalid flag %q", name, value)}
+			}
+		}
+	}
+	return record.Link(), line, nil
+}
+
+type jsonlDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (d *jsonlDecoder) Decode() (*repo.Link, int, error) {
+	for d.scanner.Scan() {
+		d.line++
+		data := strings.TrimSpace(d.scanner.Text())
+		if data == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, d.line, &RecordError{Line: d.line, Err: err}
+		}
+		if record.Clicks < 0 {
+			return nil, d.line, &RecordError{Line: d.line, Err: fmt.Errorf("clicks: invalid count %d", record.Clicks)}
+		}
+		return record.Link(), d.line, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, d.line + 1, err
+	}
+	return nil, d.line, io.EOF
+}
+
+// formatOptional renders an optional number such as a redirect status,
+// leaving zero empty
+func formatOptional(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// formatFlag renders an optional flag, leaving false empty
+func formatFlag(flag bool) string {
+	if !flag {
+		return ""
+	}
+	return "true"
+}
+
+// formatTime renders an optional timestamp as RFC 3339
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatRules renders optional device or geo rules, or variants, as a JSON
+// array
+func formatRules[T repo.DeviceRule | repo.GeoRule | repo.Variant](rules []T) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(rules)
+	return string(data), err
+}
+
+// parseRules parses optional device or geo rules, or variants, from a JSON
+// array
+func parseRules[T repo.DeviceRule | repo.GeoRule | repo.Variant](value string, rules *[]T) error {
+	if value == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(value), rules)
+}
+
+// parseTime parses an optional RFC 3339 timestamp
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
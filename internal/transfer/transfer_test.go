@@ -0,0 +1,173 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/repo"
+)
+
+func sampleLinks() []*repo.Link {
+	clicked := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+	launch := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	return []*repo.Link{
+		{
+			Code:          "abc123",
+			OriginalURL:   "https://example.com/a?x=1,2",
+			CreatedAt:     time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+			Clicks:        42,
+			LastClickedAt: &clicked,
+			Tags:          []string{"email", "spring"},
+			Folder:        "newsletters",
+			Domain:        "go.example.com",
+		},
+		{
+			Code:           "def456",
+			OriginalURL:    "https://example.com/b",
+			CreatedAt:      time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
+			RedirectStatus: 308,
+			PassQuery:      true,
+			PassPath:       true,
+			PasswordHash:   "$2a$10$abcdefghijklmnopqrstuv",
+			MaxClicks:      1,
+			ActiveFrom:     &launch,
+			FallbackURL:    "https://example.com/soon",
+			Interstitial:   true,
+			Social:         repo.SocialCard{Title: "Launch day", ImageURL: "https://example.com/card.png"},
+			DeviceRules: []repo.DeviceRule{
+				{OS: []string{"ios"}, URL: "myapp://launch", FallbackURL: "https://apps.apple.com/app/id1"},
+				{Device: []string{"desktop"}, URL: "https://example.com/desktop"},
+			},
+			GeoRules: []repo.GeoRule{{Countries: []string{"DE", "AT"}, URL: "https://example.de"}},
+			Variants: []repo.Variant{
+				{Name: "a", URL: "https://example.com/a", Weight: 80, Clicks: 12},
+				{Name: "b", URL: "https://example.com/b", Weight: 20},
+			},
+		},
+	}
+}
+
+func decodeAll(t *testing.T, dec Decoder) []*repo.Link {
+	t.Helper()
+	var links []*repo.Link
+	for {
+		link, _, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			return links
+		}
+		require.NoError(t, err)
+		links = append(links, link)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatCSV, FormatJSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf, format)
+			for _, link := range sampleLinks() {
+				require.NoError(t, enc.Encode(link))
+			}
+			require.NoError(t, enc.Flush())
+
+			dec, err := NewDecoder(&buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, sampleLinks(), decodeAll(t, dec))
+		})
+	}
+}
+
+func TestEmptyCSVExportHasHeader(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewEncoder(&buf, FormatCSV).Flush())
+	assert.Equal(t, "code,original_url,created_at,clicks,last_clicked_at,tags,redirect_status,pass_query,pass_path,password_hash,max_clicks,active_from,active_until,fallback_url,interstitial,social_title,social_description,social_image_url,device_rules,geo_rules,variants,folder,domain\n", buf.String())
+
+	dec, err := NewDecoder(&buf, FormatCSV)
+	require.NoError(t, err)
+	assert.Empty(t, decodeAll(t, dec))
+}
+
+func TestCSVDecoder(t *testing.T) {
+	t.Run("columns in any order with extras", func(t *testing.T) {
+		input := "\ufeffOriginal_URL,notes,code\nhttps://example.com,hello,abc\n"
+		dec, err := NewDecoder(strings.NewReader(input), FormatCSV)
+		require.NoError(t, err)
+
+		link, line, err := dec.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, 2, line)
+		assert.Equal(t, "abc", link.Code)
+		assert.Equal(t, "https://example.com", link.OriginalURL)
+		assert.True(t, link.CreatedAt.IsZero())
+	})
+
+	t.Run("missing required column", func(t *testing.T) {
+		_, err := NewDecoder(strings.NewReader("code,url\n"), FormatCSV)
+		assert.Error(t, err)
+	})
+
+	t.Run("bad records are reported and skipped", func(t *testing.T) {
+		input := "code,original_url,clicks,created_at,redirect_status\n" +
+			"a,https://a.com,many,,\n" +
+			"b,https://b.com,1,yesterday,\n" +
+			"c,https://c.com,1,,moved\n" +
+			"d,https://d.com,2,,301\n"
+		dec, err := NewDecoder(strings.NewReader(input), FormatCSV)
+		require.NoError(t, err)
+
+		var recordErr *RecordError
+		_, line, err := dec.Decode()
+		assert.True(t, errors.As(err, &recordErr))
+		assert.Equal(t, 2, line)
+
+		_, line, err = dec.Decode()
+		assert.True(t, errors.As(err, &recordErr))
+		assert.Equal(t, 3, line)
+
+		_, line, err = dec.Decode()
+		assert.True(t, errors.As(err, &recordErr))
+		assert.Equal(t, 4, line)
+
+		link, _, err := dec.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), link.Clicks)
+		assert.Equal(t, 301, link.RedirectStatus)
+	})
+}
+
+func TestJSONLDecoder(t *testing.T) {
+	input := "{\"code\":\"a\",\"original_url\":\"https://a.com\"}\n\nnot json\n{\"code\":\"b\",\"original_url\":\"https://b.com\",\"clicks\":-1}\n"
+	dec, err := NewDecoder(strings.NewReader(input), FormatJSONL)
+	require.NoError(t, err)
+
+	link, line, err := dec.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, "a", link.Code)
+	assert.Equal(t, 1, line)
+
+	var recordErr *RecordError
+	_, line, err = dec.Decode()
+	assert.True(t, errors.As(err, &recordErr))
+	assert.Equal(t, 3, line)
+
+	_, _, err = dec.Decode()
+	assert.True(t, errors.As(err, &recordErr))
+
+	_, _, err = dec.Decode()
+	assert.True(t, errors.Is(err, io.EOF))
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("NDJSON")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSONL, format)
+
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,247 @@
+// Package urlcanon validates destination URLs and rewrites them into a
+// single canonical form, so that every entry point accepts the same URLs
+// and equivalent URLs are stored identically.
+package urlcanon
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultMaxLength is used when Options.MaxLength is zero
+const DefaultMaxLength = 2048
+
+// maxHostLength is the longest DNS name allowed by RFC 1035
+const maxHostLength = 253
+
+// DefaultTrackingParams are removed by StripTrackingParams when
+// Options.TrackingParams is empty. Entries ending in "*" match by prefix.
+var DefaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid",
+	"mc_cid", "mc_eid", "igshid", "yclid", "_ga", "_gl",
+}
+
+// defaultPorts are omitted from canonical URLs
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// hostProfile converts internationalised domain names to punycode and
+// rejects names that are not valid hostnames
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.ValidateLabels(true),
+	idna.StrictDomainName(false),
+	idna.VerifyDNSLength(true),
+)
+
+// Options controls the optional rewrites applied by a Canonicalizer
+type Options struct {
+	// MaxLength bounds the input length; zero means DefaultMaxLength
+	MaxLength int
+	// StripFragment removes the #fragment
+	StripFragment bool
+	// SortQuery orders query parameters by name
+	SortQuery bool
+	// StripTrackingParams removes analytics parameters such as utm_source
+	StripTrackingParams bool
+	// TrackingParams overrides DefaultTrackingParams
+	TrackingParams []string
+}
+
+// Error describes why a URL was rejected
+type Error struct {
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "invalid URL: " + e.Reason
+}
+
+// Canonicalizer validates and canonicalises URLs. It is safe for
+// concurrent use.
+type Canonicalizer struct {
+	opts Options
+}
+
+// New creates a Canonicalizer with the given options
+func New(opts Options) *Canonicalizer {
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = DefaultMaxLength
+	}
+	if len(opts.TrackingParams) == 0 {
+		opts.TrackingParams = DefaultTrackingParams
+	}
+	return &Canonicalizer{opts: opts}
+}
+
+// Canonicalize returns the canonical form of rawURL: surrounding space is
+// trimmed, a missing scheme becomes http, scheme and host are lowercased,
+// internationalised hosts are converted to punycode, default ports are
+// dropped and an empty path becomes "/". Fragments and query parameters
+// are rewritten as configured. Only http and https URLs with a valid host
+// are accepted; any other input returns an *Error.
+func (c *Canonicalizer) Canonicalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if len(rawURL) > c.opts.MaxLength {
+		return "", &Error{Reason: fmt.Sprintf("exceeds maximum length of %d characters", c.opts.MaxLength)}
+	}
+	if !hasScheme(rawURL) {
+		rawURL = "http://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", &Error{Reason: "malformed URL"}
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", &Error{Reason: fmt.Sprintf("unsupported scheme %q", u.Scheme)}
+	}
+	if u.Opaque != "" {
+		return "", &Error{Reason: "malformed URL"}
+	}
+
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", &Error{Reason: "invalid port"}
+		}
+	}
+	if port != "" && port != defaultPorts[u.Scheme] {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if c.opts.StripTrackingParams || c.opts.SortQuery {
+		u.RawQuery = c.rewriteQuery(u.RawQuery)
+	}
+	if c.opts.StripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+	return u.String(), nil
+}
+
+// hasScheme reports whether rawURL starts with a scheme. Input such as
+// "example.com:8080/path" parses as scheme "example.com", so only
+// "scheme://" prefixes and colon-prefixed schemes without a dot or port
+// number (javascript:, mailto:) are treated as schemes.
+func hasScheme(rawURL string) bool {
+	i := strings.Index(rawURL, ":")
+	if i <= 0 {
+		return false
+	}
+	scheme, rest := rawURL[:i], rawURL[i+1:]
+	for j, r := range scheme {
+		isAlpha := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		isOther := (r >= '0' && r <= '9') || r == '+' || r == '-'
+		if !isAlpha && (j == 0 || !isOther) {
+			return false
+		}
+	}
+	if strings.HasPrefix(rest, "//") {
+		return true
+	}
+	// host:port
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	if _, err := strconv.Atoi(rest[:end]); err == nil {
+		return false
+	}
+	return true
+}
+
+// canonicalHost lowercases host, converting IDNs to punycode and checking
+// that IP literals and DNS names are well formed
+func canonicalHost(host string) (string, error) {
+	if host == "" {
+		return "", &Error{Reason: "missing host"}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	if strings.Contains(host, ":") {
+		return "", &Error{Reason: "malformed host"}
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	ascii, err := hostProfile.ToASCII(host)
+	if err != nil || ascii == "" || len(ascii) > maxHostLength {
+		return "", &Error{Reason: "malformed host"}
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", &Error{Reason: "malformed host"}
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '-' && r != '_' {
+				return "", &Error{Reason: "malformed host"}
+			}
+		}
+	}
+	return ascii, nil
+}
+
+// rewriteQuery removes tracking parameters and sorts the remainder as
+// configured. Parameter order within the same name is preserved.
+func (c *Canonicalizer) rewriteQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		if param == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(param, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if c.opts.StripTrackingParams && c.isTrackingParam(name) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	if c.opts.SortQuery {
+		sort.SliceStable(kept, func(i, j int) bool {
+			ni, _, _ := strings.Cut(kept[i], "=")
+			nj, _, _ := strings.Cut(kept[j], "=")
+			return ni < nj
+		})
+	}
+	return strings.Join(kept, "&")
+}
+
+// isTrackingParam reports whether name matches a configured tracking parameter
+func (c *Canonicalizer) isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range c.opts.TrackingParams {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package urlcanon
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	c := New(Options{})
+
+	for raw, want := range map[string]string{
+		"example.com":                             "http://example.com/",
+		"  example.com/path  ":                    "http://example.com/path",
+		"HTTP://EXAMPLE.com:80":                   "http://example.com/",
+		"https://example.com:443/a?b=1":           "https://example.com/a?b=1",
+		"https://example.com:8443/a":              "https://example.com:8443/a",
+		"example.com:8080/x":                      "http://example.com:8080/x",
+		"localhost:3000":                          "http://localhost:3000/",
+		"https://Example.com/Path/Case":           "https://example.com/Path/Case",
+		"https://example.com./":                   "https://example.com/",
+		"http://[2001:DB8::1]:80/x":               "http://[2001:db8::1]/x",
+		"http://192.168.0.1:8080":                 "http://192.168.0.1:8080/",
+		"https://bücher.example/straße":           "https://xn--bcher-kva.example/stra%C3%9Fe",
+		"https://ÉCOLE.fr":                        "https://xn--cole-9oa.fr/",
+		"https://example.com/a?utm_source=x#frag": "https://example.com/a?utm_source=x#frag",
+		"https://my_host.example.com/":            "https://my_host.example.com/",
+	} {
+		got, err := c.Canonicalize(raw)
+		if assert.NoError(t, err, raw) {
+			assert.Equal(t, want, got, raw)
+		}
+	}
+}
+
+func TestCanonicalizeRejects(t *testing.T) {
+	c := New(Options{MaxLength: 40})
+
+	for raw, reason := range map[string]string{
+		"":                                  "missing host",
+		"http://":                           "missing host",
+		"javascript:alert(1)":               `unsupported scheme "javascript"`,
+		"mailto:someone@example.com":        `unsupported scheme "mailto"`,
+		"ftp://example.com/file":            `unsupported scheme "ftp"`,
+		"http:example.com":                  "malformed URL",
+		"http://exa mple.com":               "malformed URL",
+		"http://example..com":               "malformed host",
+		"http://-example.com":               "malformed host",
+		"http://exam!ple.com":               "malformed host",
+		"http://example.com:99999":          "invalid port",
+		"http://[::1":                       "malformed URL",
+		"http://" + strings.Repeat("a", 40): "exceeds maximum length of 40 characters",
+	} {
+		_, err := c.Canonicalize(raw)
+		var canonErr *Error
+		if assert.True(t, errors.As(err, &canonErr), raw) {
+			assert.Equal(t, reason, canonErr.Reason, raw)
+		}
+	}
+}
+
+func TestCanonicalizeOptions(t *testing.T) {
+	raw := "https://example.com/a?z=1&utm_source=news&a=2&fbclid=abc&a=1#section"
+
+	t.Run("fragment", func(t *testing.T) {
+		got, err := New(Options{StripFragment: true}).Canonicalize(raw)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/a?z=1&utm_source=news&a=2&fbclid=abc&a=1", got)
+	})
+
+	t.Run("tracking params", func(t *testing.T) {
+		got, err := New(Options{StripTrackingParams: true}).Canonicalize(raw)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/a?z=1&a=2&a=1#section", got)
+	})
+
+	t.Run("sorted query keeps repeated values in order", func(t *testing.T) {
+		got, err := New(Options{SortQuery: true, StripTrackingParams: true}).Canonicalize(raw)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/a?a=2&a=1&z=1#section", got)
+	})
+
+	t.Run("custom tracking params", func(t *testing.T) {
+		got, err := New(Options{StripTrackingParams: true, TrackingParams: []string{"ref", "src_*"}}).
+			Canonicalize("https://example.com/?ref=tw&src_x=1&utm_source=kept")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/?utm_source=kept", got)
+	})
+
+	t.Run("only tracking params", func(t *testing.T) {
+		got, err := New(Options{StripTrackingParams: true}).Canonicalize("https://example.com/?utm_source=x")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/", got)
+	})
+}
@@ -0,0 +1,153 @@
+// Package useragent classifies clients by their User-Agent header: crawlers
+// apart from people, and operating system and device type.
+package useragent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// crawlerTokens identify link unfurlers, social media crawlers and search
+// engine bots, lowercased. Generic words such as "bot" are avoided, since
+// they also appear in the names of some phones, and so are app names that
+// also appear in the in-app browsers of those apps.
+var crawlerTokens = []string{
+	"facebookexternalhit",
+	"facebookcatalog",
+	"facebot",
+	"meta-externalagent",
+	"twitterbot",
+	"slackbot",
+	"slack-imgproxy",
+	"linkedinbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"skypeuripreview",
+	"microsoftpreview",
+	"pinterestbot",
+	"redditbot",
+	"vkshare",
+	"line-poker",
+	"kakaotalk-scrap",
+	"embedly",
+	"iframely",
+	"mastodon",
+	"bluesky cardyb",
+	"applebot",
+	"googlebot",
+	"google-inspectiontool",
+	"bingbot",
+	"bingpreview",
+	"duckduckbot",
+	"yandexbot",
+	"baiduspider",
+	"qwantify",
+}
+
+// IsCrawler reports whether userAgent belongs to a crawler that reads
+// pages to build link previews or search results, rather than a person
+func IsCrawler(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, token := range crawlerTokens {
+		if strings.Contains(userAgent, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// OS is a client operating system family
+type OS string
+
+const (
+	OSIOS      OS = "ios"
+	OSAndroid  OS = "android"
+	OSWindows  OS = "windows"
+	OSMacOS    OS = "macos"
+	OSLinux    OS = "linux"
+	OSChromeOS OS = "chromeos"
+	OSOther    OS = "other"
+)
+
+// Device is a client device type
+type Device string
+
+const (
+	DeviceMobile  Device = "mobile"
+	DeviceTablet  Device = "tablet"
+	DeviceDesktop Device = "desktop"
+	DeviceBot     Device = "bot"
+)
+
+var (
+	knownOSes    = map[OS]bool{OSIOS: true, OSAndroid: true, OSWindows: true, OSMacOS: true, OSLinux: true, OSChromeOS: true, OSOther: true}
+	knownDevices = map[Device]bool{DeviceMobile: true, DeviceTablet: true, DeviceDesktop: true, DeviceBot: true}
+)
+
+// ParseOS parses an operating system name such as "ios" or "android"
+func ParseOS(name string) (OS, error) {
+	os := OS(strings.ToLower(strings.TrimSpace(name)))
+	if !knownOSes[os] {
+		return "", fmt.Errorf("unknown operating system %q (want ios, android, windows, macos, linux, chromeos or other)", name)
+	}
+	return os, nil
+}
+
+// ParseDevice parses a device type such as "mobile" or "tablet"
+func ParseDevice(name string) (Device, error) {
+	device := Device(strings.ToLower(strings.TrimSpace(name)))
+	if !knownDevices[device] {
+		return "", fmt.Errorf("unknown device type %q (want mobile, tablet, desktop or bot)", name)
+	}
+	return device, nil
+}
+
+// Client describes the software and hardware a request came from
+type Client struct {
+	OS     OS
+	Device Device
+}
+
+// Parse works out a client's operating system and device type from its
+// User-Agent header. Crawlers are reported as DeviceBot. iPads that ask
+// for desktop sites send a Mac user agent and are reported as macOS
+// desktops, as there is no way to tell them apart.
+func Parse(userAgent string) Client {
+	if IsCrawler(userAgent) {
+		return Client{OS: OSOther, Device: DeviceBot}
+	}
+	ua := strings.ToLower(userAgent)
+	mobile := strings.Contains(ua, "mobile")
+	switch {
+	case strings.Contains(ua, "windows phone"):
+		// Checked first, as its user agent also claims Android and iPhone
+		return Client{OS: OSOther, Device: DeviceMobile}
+	case strings.Contains(ua, "ipad"):
+		return Client{OS: OSIOS, Device: DeviceTablet}
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"):
+		return Client{OS: OSIOS, Device: DeviceMobile}
+	case strings.Contains(ua, "android"):
+		// Android tablets leave "Mobile" out of their user agent
+		if mobile {
+			return Client{OS: OSAndroid, Device: DeviceMobile}
+		}
+		return Client{OS: OSAndroid, Device: DeviceTablet}
+	case strings.Contains(ua, "windows"):
+		return Client{OS: OSWindows, Device: DeviceDesktop}
+	case strings.Contains(ua, "cros"):
+		return Client{OS: OSChromeOS, Device: DeviceDesktop}
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		return Client{OS: OSMacOS, Device: DeviceDesktop}
+	case strings.Contains(ua, "linux"):
+		return Client{OS: OSLinux, Device: DeviceDesktop}
+	}
+	switch {
+	case strings.Contains(ua, "tablet"):
+		return Client{OS: OSOther, Device: DeviceTablet}
+	case mobile:
+		return Client{OS: OSOther, Device: DeviceMobile}
+	default:
+		return Client{OS: OSOther, Device: DeviceDesktop}
+	}
+}
@@ -0,0 +1,62 @@
+package useragent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCrawler(t *testing.T) {
+	for userAgent, want := range map[string]bool{
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)": true,
+		"Twitterbot/1.0": true,
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)":                                                                              true,
+		"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)":                                                                       true,
+		"TelegramBot (like TwitterBot)":                                                                                                           true,
+		"WhatsApp/2.23.20.0 A":                                                                                                                    true,
+		"LinkedInBot/1.0 (compatible; Mozilla/5.0; Apache-HttpClient +http://www.linkedin.com)":                                                   true,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                                true,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36":                             false,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1": false,
+		"Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36":                     false,
+		"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36 [Pinterest/Android]":   false,
+		"curl/8.4.0": false,
+		"":           false,
+	} {
+		assert.Equal(t, want, IsCrawler(userAgent), userAgent)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for userAgent, want := range map[string]Client{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1":                     {OSIOS, DeviceMobile},
+		"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1":                              {OSIOS, DeviceTablet},
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Mobile Safari/537.36":                                           {OSAndroid, DeviceMobile},
+		"Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36":                                                  {OSAndroid, DeviceTablet},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36":                                                 {OSWindows, DeviceDesktop},
+		"Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0 Mobile Safari/537.36 Edge/15.15063": {OSOther, DeviceMobile},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15":                                       {OSMacOS, DeviceDesktop},
+		"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36":                                                  {OSChromeOS, DeviceDesktop},
+		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0":                                                                              {OSLinux, DeviceDesktop},
+		"Mozilla/5.0 (Mobile; rv:48.0) Gecko/48.0 Firefox/48.0 KAIOS/2.5":                                                                                             {OSOther, DeviceMobile},
+		"Twitterbot/1.0": {OSOther, DeviceBot},
+		"curl/8.4.0":     {OSOther, DeviceDesktop},
+		"":               {OSOther, DeviceDesktop},
+	} {
+		assert.Equal(t, want, Parse(userAgent), userAgent)
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	os, err := ParseOS(" iOS ")
+	assert.NoError(t, err)
+	assert.Equal(t, OSIOS, os)
+	_, err = ParseOS("symbian")
+	assert.Error(t, err)
+
+	device, err := ParseDevice("Tablet")
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceTablet, device)
+	_, err = ParseDevice("watch")
+	assert.Error(t, err)
+}
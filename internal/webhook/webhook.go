@@ -0,0 +1,164 @@
+// Package webhook signs and sends webhook payloads. Each request carries
+// an HMAC-SHA256 signature of its timestamp and body, keyed with the
+// webhook's secret, so that receivers can check where it came from and
+// that it is recent. Like destination fetches, deliveries refuse to
+// connect to private, loopback and other non-public addresses unless
+// configured otherwise.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urlshortener/internal/security"
+)
+
+// Headers sent with every delivery
+const (
+	// EventHeader names the event type
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader identifies the delivery; retries send the same ID
+	DeliveryHeader = "X-Webhook-Delivery"
+	// TimestampHeader is when the delivery was sent, in Unix seconds
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of the
+	// timestamp, a ".", and the body
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Defaults for Options
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultUserAgent = "URLShortener-Webhook/1.0"
+)
+
+// maxResponseBytes bounds how much of a response is read before the
+// connection is reused
+const maxResponseBytes = 64 << 10
+
+// signaturePrefix names the algorithm in SignatureHeader
+const signaturePrefix = "sha256="
+
+// Options controls a Sender
+type Options struct {
+	// Timeout bounds a whole delivery; zero means DefaultTimeout
+	Timeout time.Duration
+	// UserAgent is sent with every request; empty means DefaultUserAgent
+	UserAgent string
+	// AllowPrivate permits deliveries to non-public addresses, such as
+	// receivers on the same private network
+	AllowPrivate bool
+}
+
+// Message is a payload to deliver to a webhook
+type Message struct {
+	URL        string
+	Secret     string
+	Event      string
+	DeliveryID int64
+	Body       []byte
+}
+
+// Sender delivers messages. It is safe for concurrent use.
+type Sender struct {
+	client *http.Client
+	opts   Options
+	// now is the clock timestamps are taken from
+	now func() time.Time
+}
+
+// NewSender returns a sender using opts
+func NewSender(opts Options) *Sender {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = security.RefuseNonPublic
+	}
+	transport := &http.Transport{
+		// No proxy: the dialer must see the receiver's own address
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		// Receivers must answer themselves; a redirect is a failure
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Sender{client: client, opts: opts, now: time.Now}
+}
+
+// Send posts msg to its URL, signed with its secret. It returns the status
+// of the response, zero if there was none, and an error unless the status
+// is 2xx.
+func (s *Sender) Send(ctx context.Context, msg Message) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.URL, bytes.NewReader(msg.Body))
+	if err != nil {
+		return 0, fmt.Errorf("invalid URL: %w", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return 0, fmt.Errorf("unsupported scheme %q", req.URL.Scheme)
+	}
+	timestamp := s.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.opts.UserAgent)
+	req.Header.Set(EventHeader, msg.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(msg.DeliveryID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(msg.Secret, timestamp, msg.Body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the SignatureHeader value for body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature, the SignatureHeader of a delivery, was
+// made with secret for body and timestamp, its TimestampHeader. Receivers
+// should also reject timestamps too far from their own clock, so that
+// captured deliveries cannot be replayed.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature))
+}
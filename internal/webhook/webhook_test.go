@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urlshortener/internal/security"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"link.created"}`)
+	signature := Sign("secret", 1700000000, body)
+	assert.Equal(t, "sha256=", signature[:7])
+	assert.Len(t, signature, 7+64)
+
+	assert.True(t, Verify("secret", "1700000000", signature, body))
+	assert.False(t, Verify("other", "1700000000", signature, body), "wrong secret")
+	assert.False(t, Verify("secret", "1700000001", signature, body), "wrong timestamp")
+	assert.False(t, Verify("secret", "1700000000", signature, []byte(`{}`)), "wrong body")
+	assert.False(t, Verify("secret", "soon", signature, body))
+	assert.False(t, Verify("secret", "1700000000", signature[7:], body), "missing algorithm")
+}
+
+func TestSend(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 1)
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header, body: body}
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/hooks", http.StatusFound)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sender := NewSender(Options{AllowPrivate: true, Timeout: 2 * time.Second})
+	sender.now = func() time.Time { return time.Unix(1700000000, 0) }
+	ctx := context.Background()
+	msg := Message{URL: server.URL + "/hooks", Secret: "secret", Event: "link.created", DeliveryID: 42, Body: []byte(`{"a":1}`)}
+
+	t.Run("signed delivery", func(t *testing.T) {
+		code, err := sender.Send(ctx, msg)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, code)
+
+		got := <-requests
+		assert.Equal(t, `{"a":1}`, string(got.body))
+		assert.Equal(t, "application/json", got.header.Get("Content-Type"))
+		assert.Equal(t, DefaultUserAgent, got.header.Get("User-Agent"))
+		assert.Equal(t, "link.created", got.header.Get(EventHeader))
+		assert.Equal(t, "42", got.header.Get(DeliveryHeader))
+		assert.Equal(t, strconv.Itoa(1700000000), got.header.Get(TimestampHeader))
+		assert.True(t, Verify("secret", got.header.Get(TimestampHeader), got.header.Get(SignatureHeader), got.body))
+	})
+
+	t.Run("error statuses fail", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		defer func() { status = http.StatusNoContent }()
+
+		code, err := sender.Send(ctx, msg)
+		assert.EqualError(t, err, "unexpected status 503")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		<-requests
+	})
+
+	t.Run("redirects are not followed", func(t *testing.T) {
+		moved := msg
+		moved.URL = server.URL + "/moved"
+		code, err := sender.Send(ctx, moved)
+		assert.EqualError(t, err, "unexpected status 302")
+		assert.Equal(t, http.StatusFound, code)
+		<-requests
+		assert.Empty(t, requests)
+	})
+
+	t.Run("private addresses are refused by default", func(t *testing.T) {
+		code, err := NewSender(Options{}).Send(ctx, msg)
+		assert.True(t, errors.Is(err, security.ErrBlockedAddress))
+		assert.Zero(t, code)
+		assert.Empty(t, requests)
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		ftp := msg
+		ftp.URL = "ftp://example.com/hooks"
+		_, err := sender.Send(ctx, ftp)
+		assert.EqualError(t, err, `unsupported scheme "ftp"`)
+	})
+}
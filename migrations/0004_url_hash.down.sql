@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_urls_url_hash;
+ALTER TABLE urls DROP COLUMN url_hash;
@@ -0,0 +1,6 @@
+-- url_hash is a digest of the canonical destination, used to find an
+-- existing link for a URL without scanning. Links stored before this
+-- migration have no hash and are never matched.
+ALTER TABLE urls ADD COLUMN url_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_urls_url_hash ON urls(url_hash);
@@ -0,0 +1 @@
+ALTER TABLE urls DROP COLUMN redirect_status;
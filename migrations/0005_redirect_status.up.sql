@@ -0,0 +1,2 @@
+-- NULL means the server-wide default redirect status
+ALTER TABLE urls ADD COLUMN redirect_status INTEGER;
@@ -0,0 +1,3 @@
+-- Forward the visitor's query string and any path after the code to the destination
+ALTER TABLE urls ADD COLUMN pass_query BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN pass_path BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS utm_templates (
+    team TEXT NOT NULL,
+    name TEXT NOT NULL,
+    utm_source TEXT,
+    utm_medium TEXT,
+    utm_campaign TEXT,
+    utm_term TEXT,
+    utm_content TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (team, name)
+);
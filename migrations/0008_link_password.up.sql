@@ -0,0 +1,2 @@
+-- bcrypt hash of the password visitors must enter; NULL means the link is open
+ALTER TABLE urls ADD COLUMN password_hash TEXT;
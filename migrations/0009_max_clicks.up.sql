@@ -0,0 +1,3 @@
+-- Visits allowed before the link stops redirecting; NULL means unlimited.
+-- The remaining count is max_clicks - clicks.
+ALTER TABLE urls ADD COLUMN max_clicks INTEGER;
@@ -0,0 +1,6 @@
+-- The link only redirects to original_url between active_from and
+-- active_until; NULL leaves that side open. Outside the window visitors go
+-- to fallback_url if set.
+ALTER TABLE urls ADD COLUMN active_from TIMESTAMP;
+ALTER TABLE urls ADD COLUMN active_until TIMESTAMP;
+ALTER TABLE urls ADD COLUMN fallback_url TEXT;
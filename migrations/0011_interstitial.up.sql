@@ -0,0 +1,2 @@
+-- Show visitors a preview page with the destination before redirecting
+ALTER TABLE urls ADD COLUMN interstitial BOOLEAN NOT NULL DEFAULT 0;
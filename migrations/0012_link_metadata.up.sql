@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS link_metadata (
+    url_id INTEGER PRIMARY KEY REFERENCES urls(id) ON DELETE CASCADE,
+    title TEXT,
+    description TEXT,
+    image_url TEXT,
+    error TEXT,
+    fetched_at TIMESTAMP NOT NULL
+);
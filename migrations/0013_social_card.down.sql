@@ -0,0 +1,3 @@
+ALTER TABLE urls DROP COLUMN social_image_url;
+ALTER TABLE urls DROP COLUMN social_description;
+ALTER TABLE urls DROP COLUMN social_title;
@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN social_title TEXT;
+ALTER TABLE urls ADD COLUMN social_description TEXT;
+ALTER TABLE urls ADD COLUMN social_image_url TEXT;
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN device_rules TEXT;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS click_countries;
+ALTER TABLE urls DROP COLUMN geo_rules;
@@ -0,0 +1,7 @@
+ALTER TABLE urls ADD COLUMN geo_rules TEXT;
+CREATE TABLE IF NOT EXISTS click_countries (
+    url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    country TEXT NOT NULL,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, country)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS link_variants (
+    url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    url TEXT NOT NULL,
+    weight INTEGER NOT NULL,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, name)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS url_folders;
+DROP TABLE IF EXISTS folders;
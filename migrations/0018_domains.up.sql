@@ -0,0 +1,72 @@
+-- Branded short domains. Links on the default domain, the host of BASE_URL,
+-- have an empty domain and no row here.
+CREATE TABLE IF NOT EXISTS domains (
+    host TEXT PRIMARY KEY,
+    not_found_url TEXT,
+    redirect_status INTEGER,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Codes become unique per domain, which needs the urls table rebuilt.
+-- Dropping urls cascades to the tables referencing it, so their rows are
+-- kept aside and restored afterwards.
+CREATE TEMP TABLE saved_url_tags AS SELECT * FROM url_tags;
+CREATE TEMP TABLE saved_link_metadata AS SELECT * FROM link_metadata;
+CREATE TEMP TABLE saved_click_countries AS SELECT * FROM click_countries;
+CREATE TEMP TABLE saved_link_variants AS SELECT * FROM link_variants;
+CREATE TEMP TABLE saved_url_folders AS SELECT * FROM url_folders;
+
+CREATE TABLE urls_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain TEXT NOT NULL DEFAULT '',
+    original_url TEXT NOT NULL,
+    code TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    last_clicked_at TIMESTAMP,
+    url_hash TEXT,
+    redirect_status INTEGER,
+    pass_query BOOLEAN NOT NULL DEFAULT 0,
+    pass_path BOOLEAN NOT NULL DEFAULT 0,
+    password_hash TEXT,
+    max_clicks INTEGER,
+    active_from TIMESTAMP,
+    active_until TIMESTAMP,
+    fallback_url TEXT,
+    interstitial BOOLEAN NOT NULL DEFAULT 0,
+    social_title TEXT,
+    social_description TEXT,
+    social_image_url TEXT,
+    device_rules TEXT,
+    geo_rules TEXT,
+    UNIQUE (domain, code)
+);
+
+INSERT INTO urls_new (id, original_url, code, created_at, clicks, last_clicked_at, url_hash,
+    redirect_status, pass_query, pass_path, password_hash, max_clicks, active_from, active_until,
+    fallback_url, interstitial, social_title, social_description, social_image_url, device_rules, geo_rules)
+SELECT id, original_url, code, created_at, clicks, last_clicked_at, url_hash,
+    redirect_status, pass_query, pass_path, password_hash, max_clicks, active_from, active_until,
+    fallback_url, interstitial, social_title, social_description, social_image_url, device_rules, geo_rules
+FROM urls;
+
+-- Keep the id sequence, so that ids of deleted links are not reused
+UPDATE sqlite_sequence SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'urls')
+WHERE name = 'urls_new';
+
+DROP TABLE urls;
+ALTER TABLE urls_new RENAME TO urls;
+
+CREATE INDEX IF NOT EXISTS idx_urls_url_hash ON urls(url_hash);
+
+INSERT INTO url_tags SELECT * FROM saved_url_tags;
+INSERT INTO link_metadata SELECT * FROM saved_link_metadata;
+INSERT INTO click_countries SELECT * FROM saved_click_countries;
+INSERT INTO link_variants SELECT * FROM saved_link_variants;
+INSERT INTO url_folders SELECT * FROM saved_url_folders;
+
+DROP TABLE saved_url_tags;
+DROP TABLE saved_link_metadata;
+DROP TABLE saved_click_countries;
+DROP TABLE saved_link_variants;
+DROP TABLE saved_url_folders;
@@ -0,0 +1,77 @@
+-- Only the default workspace survives a downgrade
+DELETE FROM urls WHERE workspace != '';
+DELETE FROM domains WHERE workspace != '';
+DELETE FROM folders WHERE workspace != '';
+DELETE FROM tags WHERE workspace != '';
+
+CREATE TEMP TABLE saved_url_folders AS SELECT * FROM url_folders;
+CREATE TEMP TABLE saved_url_tags AS SELECT * FROM url_tags;
+
+CREATE TABLE folders_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+INSERT INTO folders_old (id, name, created_at) SELECT id, name, created_at FROM folders;
+DELETE FROM sqlite_sequence WHERE name = 'folders_old';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'folders_old', seq FROM sqlite_sequence WHERE name = 'folders';
+DROP TABLE folders;
+ALTER TABLE folders_old RENAME TO folders;
+
+CREATE TABLE tags_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);
+INSERT INTO tags_old (id, name) SELECT id, name FROM tags;
+DELETE FROM sqlite_sequence WHERE name = 'tags_old';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'tags_old', seq FROM sqlite_sequence WHERE name = 'tags';
+DROP TABLE tags;
+ALTER TABLE tags_old RENAME TO tags;
+
+INSERT INTO url_folders SELECT * FROM saved_url_folders;
+INSERT INTO url_tags SELECT * FROM saved_url_tags;
+DROP TABLE saved_url_folders;
+DROP TABLE saved_url_tags;
+
+CREATE TABLE utm_templates_old (
+    team TEXT NOT NULL,
+    name TEXT NOT NULL,
+    utm_source TEXT,
+    utm_medium TEXT,
+    utm_campaign TEXT,
+    utm_term TEXT,
+    utm_content TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (team, name)
+);
+INSERT INTO utm_templates_old
+    SELECT team, name, utm_source, utm_medium, utm_campaign, utm_term, utm_content, created_at, updated_at
+    FROM utm_templates WHERE workspace = '';
+DROP TABLE utm_templates;
+ALTER TABLE utm_templates_old RENAME TO utm_templates;
+
+CREATE TABLE idempotency_keys_old (
+    idempotency_key TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    content_type TEXT,
+    body BLOB,
+    created_at TIMESTAMP NOT NULL
+);
+INSERT INTO idempotency_keys_old
+    SELECT idempotency_key, fingerprint, status_code, content_type, body, created_at
+    FROM idempotency_keys WHERE workspace = '';
+DROP TABLE idempotency_keys;
+ALTER TABLE idempotency_keys_old RENAME TO idempotency_keys;
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+DROP INDEX IF EXISTS idx_urls_url_hash;
+DROP INDEX IF EXISTS idx_urls_workspace;
+ALTER TABLE urls DROP COLUMN workspace;
+CREATE INDEX IF NOT EXISTS idx_urls_url_hash ON urls(url_hash);
+ALTER TABLE domains DROP COLUMN workspace;
+
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
@@ -0,0 +1,111 @@
+-- Workspaces own links, domains, folders, tags, UTM templates and
+-- idempotency keys. Everything that existed before belongs to the default
+-- workspace, which has an empty slug and no row here.
+CREATE TABLE IF NOT EXISTS workspaces (
+    slug TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace TEXT NOT NULL REFERENCES workspaces(slug) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (workspace, email)
+);
+
+-- Only a hash of each key is stored. A key acts for the member it was
+-- issued to and goes with them.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL,
+    member TEXT NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    FOREIGN KEY (workspace, member) REFERENCES workspace_members(workspace, email) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_workspace ON api_keys(workspace);
+
+ALTER TABLE urls ADD COLUMN workspace TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_urls_workspace ON urls(workspace, id);
+DROP INDEX IF EXISTS idx_urls_url_hash;
+CREATE INDEX IF NOT EXISTS idx_urls_url_hash ON urls(workspace, domain, url_hash);
+
+ALTER TABLE domains ADD COLUMN workspace TEXT NOT NULL DEFAULT '';
+
+-- Folder and tag names become unique per workspace, which needs both
+-- tables rebuilt. Dropping them cascades to the links' assignments, so
+-- those are kept aside and restored afterwards.
+CREATE TEMP TABLE saved_url_folders AS SELECT * FROM url_folders;
+CREATE TEMP TABLE saved_url_tags AS SELECT * FROM url_tags;
+
+CREATE TABLE folders_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (workspace, name)
+);
+-- Ids are kept, along with their sequences, so that the saved rows still
+-- point at the right folders and tags
+INSERT INTO folders_new (id, name, created_at) SELECT id, name, created_at FROM folders;
+DELETE FROM sqlite_sequence WHERE name = 'folders_new';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'folders_new', seq FROM sqlite_sequence WHERE name = 'folders';
+DROP TABLE folders;
+ALTER TABLE folders_new RENAME TO folders;
+
+CREATE TABLE tags_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    UNIQUE (workspace, name)
+);
+INSERT INTO tags_new (id, name) SELECT id, name FROM tags;
+DELETE FROM sqlite_sequence WHERE name = 'tags_new';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'tags_new', seq FROM sqlite_sequence WHERE name = 'tags';
+DROP TABLE tags;
+ALTER TABLE tags_new RENAME TO tags;
+
+INSERT INTO url_folders SELECT * FROM saved_url_folders;
+INSERT INTO url_tags SELECT * FROM saved_url_tags;
+DROP TABLE saved_url_folders;
+DROP TABLE saved_url_tags;
+
+CREATE TABLE utm_templates_new (
+    workspace TEXT NOT NULL DEFAULT '',
+    team TEXT NOT NULL,
+    name TEXT NOT NULL,
+    utm_source TEXT,
+    utm_medium TEXT,
+    utm_campaign TEXT,
+    utm_term TEXT,
+    utm_content TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (workspace, team, name)
+);
+INSERT INTO utm_templates_new (team, name, utm_source, utm_medium, utm_campaign, utm_term, utm_content, created_at, updated_at)
+    SELECT team, name, utm_source, utm_medium, utm_campaign, utm_term, utm_content, created_at, updated_at FROM utm_templates;
+DROP TABLE utm_templates;
+ALTER TABLE utm_templates_new RENAME TO utm_templates;
+
+CREATE TABLE idempotency_keys_new (
+    workspace TEXT NOT NULL DEFAULT '',
+    idempotency_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    content_type TEXT,
+    body BLOB,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (workspace, idempotency_key)
+);
+INSERT INTO idempotency_keys_new (idempotency_key, fingerprint, status_code, content_type, body, created_at)
+    SELECT idempotency_key, fingerprint, status_code, content_type, body, created_at FROM idempotency_keys;
+DROP TABLE idempotency_keys;
+ALTER TABLE idempotency_keys_new RENAME TO idempotency_keys;
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS quotas;
+DROP INDEX IF EXISTS idx_urls_created_by;
+ALTER TABLE urls DROP COLUMN created_by;
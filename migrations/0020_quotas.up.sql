@@ -0,0 +1,16 @@
+-- Links remember the member whose API key created them, so that quotas can
+-- count each member's links. Other links have an empty creator.
+ALTER TABLE urls ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_urls_created_by ON urls(workspace, created_by);
+
+-- Quotas cap the links created in a workspace, or by one of its members
+-- when member is set. A zero limit is no limit.
+CREATE TABLE IF NOT EXISTS quotas (
+    workspace TEXT NOT NULL,
+    member TEXT NOT NULL DEFAULT '',
+    links_per_day INTEGER NOT NULL DEFAULT 0,
+    links_per_month INTEGER NOT NULL DEFAULT 0,
+    active_links INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (workspace, member)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS deleted_links;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
@@ -11,7 +11,10 @@ func main() {
 	config := configs.LoadConfig()
 	fmt.Printf("Trying to connect to database at: %s\n", config.DBPath)
 	
-	_, err := repo.NewSQLiteRepository(config.DBPath, metrics.NewMetrics())
+	_, err := repo.NewSQLiteRepository(config.DBPath, metrics.NewMetrics(), repo.Timeouts{
+		Read:  config.DBReadTimeout,
+		Write: config.DBWriteTimeout,
+	})
 	if err != nil {
 		fmt.Printf("Database error: %v\n", err)
 	} else {
@@ -25,6 +25,7 @@ document.addEventListener('DOMContentLoaded', function() {
 
     // Load history from localStorage
     loadHistory();
+    refreshHistoryMetadata();
     
     // Initialize analytics
     updateAnalytics();
@@ -144,7 +145,7 @@ document.addEventListener('DOMContentLoaded', function() {
                 // Try to parse JSON error response
                 try {
                     const errorData = await response.json();
-                    const errorMsg = errorData.error || 'Failed to shorten URL';
+                    const errorMsg = errorData.detail || errorData.title || 'Failed to shorten URL';
                     showError(errorMsg);
                     showToast(errorMsg, 'error');
                     trackUrlShortening(false);
@@ -166,10 +167,15 @@ document.addEventListener('DOMContentLoaded', function() {
             
             // Add to history
             addToHistory({
+                code: data.code,
                 original: url,
                 shortened: data.short_url
             });
             
+            // The destination is described in the background; look again
+            // once it has had time to be fetched
+            setTimeout(refreshHistoryMetadata, 3000);
+            
             // Track successful shortening
             trackUrlShortening(true);
             
@@ -255,6 +261,56 @@ document.addEventListener('DOMContentLoaded', function() {
         loadHistory();
     }
     
+    // Function to fetch destination metadata for history items lacking it
+    async function refreshHistoryMetadata() {
+        const history = JSON.parse(localStorage.getItem('urlHistory') || '[]');
+        const pending = history.filter(item => item.code && !item.metadata);
+        if (pending.length === 0) {
+            return;
+        }
+        
+        let changed = false;
+        await Promise.all(pending.map(async item => {
+            try {
+                const response = await fetch(`/api/v1/links/${encodeURIComponent(item.code)}/metadata`);
+                if (response.status === 404) {
+                    // Deleted, protected, or fetching is disabled; don't ask again
+                    item.metadata = { status: 'unavailable' };
+                    changed = true;
+                    return;
+                }
+                if (!response.ok) {
+                    return;
+                }
+                const data = await response.json();
+                if (data.status === 'pending') {
+                    return;
+                }
+                item.metadata = {
+                    status: data.status,
+                    title: data.title || '',
+                    description: data.description || ''
+                };
+                changed = true;
+            } catch (error) {
+                console.error('Failed to fetch link metadata:', error);
+            }
+        }));
+        
+        if (changed) {
+            // Items may have been added while fetching; update them in place
+            const current = JSON.parse(localStorage.getItem('urlHistory') || '[]');
+            current.forEach(item => {
+                const updated = pending.find(p => p.code === item.code && p.metadata);
+                if (updated) {
+                    item.metadata = updated.metadata;
+                }
+            });
+            localStorage.setItem('urlHistory', JSON.stringify(current));
+            loadHistory();
+        }
+    }
+    
     // Function to load history from localStorage
     function loadHistory() {
         const history = JSON.parse(localStorage.getItem('urlHistory') || '[]');
@@ -274,9 +330,29 @@ document.addEventListener('DOMContentLoaded', function() {
         history.forEach(item => {
             const li = document.createElement('li');
             
+            const details = document.createElement('div');
+            details.className = 'history-item-details';
+            
+            // Show what the destination page calls itself, when known
+            const metadata = item.metadata || {};
+            if (metadata.title) {
+                const title = document.createElement('span');
+                title.className = 'history-item-title';
+                title.textContent = metadata.title;
+                title.title = item.original;
+                details.appendChild(title);
+            }
+            if (metadata.description) {
+                const description = document.createElement('span');
+                description.className = 'history-item-description';
+                description.textContent = metadata.description;
+                details.appendChild(description);
+            }
+            
             const urlSpan = document.createElement('span');
             urlSpan.className = 'history-item-url';
             urlSpan.textContent = item.shortened;
+            details.appendChild(urlSpan);
             
             const copyButton = document.createElement('button');
             copyButton.className = 'history-item-copy';
@@ -323,7 +399,7 @@ document.addEventListener('DOMContentLoaded', function() {
                 }
             });
             
-            li.appendChild(urlSpan);
+            li.appendChild(details);
             li.appendChild(copyButton);
             historyList.appendChild(li);
         });